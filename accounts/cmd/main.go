@@ -3,87 +3,158 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
 	"github.com/go-chi/chi/v5"
 )
 
-// Account represents account data
+// Account represents account data, the totals are calculated from the unit
+// price, quantity and tax rates, with inclusive taxes the price includes the taxes
+// and the subtotal does not
 type Account struct {
-	ProductDescription string  `json:"productDescription"`
-	Quantity           int     `json:"quantity"`
-	UnitPrice          float64 `json:"unitPrice"`
-	Price              float64 `json:"price"`
-	SubTotal           float64 `json:"subTotal"`
-	Tax                int     `json:"tax"`
-	TaxAmount          float64 `json:"taxAmount"`
-	GrandTotal         float64 `json:"grandTotal"`
-	Currency           string  `json:"currency"`
-	CurrencySymbol     string  `json:"currencySymbol"`
+	ProductDescription string        `json:"productDescription"`
+	Quantity           int           `json:"quantity"`
+	UnitPrice          money.Amount  `json:"unitPrice"`
+	TaxRates           []tax.Rate    `json:"taxRates"`
+	TaxInclusive       bool          `json:"taxInclusive"`
+	Price              money.Amount  `json:"price"`
+	SubTotal           money.Amount  `json:"subTotal"`
+	Tax                float64       `json:"tax"`
+	Taxes              tax.Breakdown `json:"taxes"`
+	TaxAmount          money.Amount  `json:"taxAmount"`
+	GrandTotal         money.Amount  `json:"grandTotal"`
+	Currency           string        `json:"currency"`
+	// CurrencySymbol is the ISO 4217 symbol of the currency
+	CurrencySymbol string `json:"currencySymbol"`
+	// Metered prices the usage of the product charged on top of the price
+	Metered []metering.Price `json:"metered,omitempty"`
 }
 
+// calculate fills the price, taxes and totals of the account
+func (a *Account) calculate() error {
+	price, err := a.UnitPrice.Mul(int64(a.Quantity))
+	if err != nil {
+		return err
+	}
+
+	res, err := tax.Calculate(tax.Line{Price: price, Rates: a.TaxRates, Inclusive: a.TaxInclusive})
+	if err != nil {
+		return err
+	}
+
+	a.Price = price
+	a.SubTotal = res.Net
+	a.Tax = tax.EffectiveRate(a.TaxRates)
+	a.Taxes = res.Taxes
+	a.TaxAmount = res.Tax
+	a.GrandTotal = res.Total
+	a.CurrencySymbol = money.Symbol(a.Currency)
+	return nil
+}
+
+// apiCallPrice is the price of 1,000 API calls
+var apiCallPrice = money.MustParse("0.40", "USD")
+
 // Map to store account data
 var accountData = map[string]map[string]Account{
 	"CUSTOMER-0001": {
 		"PRD-160": {
 			ProductDescription: "Product 1",
 			Quantity:           1,
-			UnitPrice:          103.00,
-			Price:              103.00,
-			SubTotal:           103.00,
-			Tax:                10,
-			TaxAmount:          10.3,
-			GrandTotal:         113.3,
+			UnitPrice:          money.MustParse("103.00", "EUR"),
+			TaxRates:           []tax.Rate{{Name: "Sales tax", Percent: 8.875}},
 			Currency:           "EUR",
-			CurrencySymbol:     "€",
 		},
 	},
 	"CUSTOMER-0002": {
 		"PRD-160": {
 			ProductDescription: "Product 1",
 			Quantity:           1,
-			UnitPrice:          103.00,
-			Price:              103.00,
-			SubTotal:           103.00,
-			Tax:                10,
-			TaxAmount:          10.3,
-			GrandTotal:         113.3,
+			UnitPrice:          money.MustParse("103.00", "EUR"),
+			TaxRates:           []tax.Rate{{Name: "Sales tax", Percent: 7.25}},
 			Currency:           "EUR",
-			CurrencySymbol:     "€",
 		},
 	},
 	"CUSTOMER-0003": {
 		"PRD-400": {
 			ProductDescription: "Product 2",
 			Quantity:           2,
-			UnitPrice:          10.50,
-			Price:              21.00,
-			SubTotal:           21.00,
-			Tax:                5,
-			TaxAmount:          1.05,
-			GrandTotal:         22.05,
+			UnitPrice:          money.MustParse("10.50", "USD"),
+			TaxRates:           []tax.Rate{{Name: "State sales tax", Percent: 6.25}, {Name: "Local sales tax", Percent: 2}},
 			Currency:           "USD",
-			CurrencySymbol:     "$",
+			Metered: []metering.Price{
+				{
+					Metric:      "api_calls",
+					Description: "API calls per 1,000",
+					Model:       metering.PerUnit,
+					PerUnits:    1000,
+					UnitPrice:   &apiCallPrice,
+				},
+				{
+					Metric:      "storage_gb",
+					Description: "Storage (GB)",
+					Model:       metering.Tiered,
+					Tiers: []metering.Tier{
+						{UpTo: 10, UnitPrice: money.MustParse("0.00", "USD")},
+						{UpTo: 100, UnitPrice: money.MustParse("0.25", "USD")},
+						{UnitPrice: money.MustParse("0.20", "USD")},
+					},
+				},
+			},
 		},
 	},
 	"CUSTOMER-0004": {
 		"PRD-799": {
 			ProductDescription: "Product 3",
 			Quantity:           1,
-			UnitPrice:          10.50,
-			Price:              10.50,
-			SubTotal:           10.50,
-			Tax:                10,
-			TaxAmount:          1.05,
-			GrandTotal:         11.05,
+			UnitPrice:          money.MustParse("12.60", "GBP"),
+			TaxRates:           []tax.Rate{{Name: "VAT", Percent: 20}},
+			TaxInclusive:       true,
 			Currency:           "GBP",
-			CurrencySymbol:     "£",
+		},
+	},
+	"CUSTOMER-0005": {
+		"PRD-160": {
+			ProductDescription: "Product 1",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("103.00", "EUR"),
+			TaxRates:           []tax.Rate{{Name: "VAT", Percent: 19}},
+			Currency:           "EUR",
+		},
+	},
+	"CUSTOMER-0006": {
+		"PRD-160": {
+			ProductDescription: "Product 1",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("103.00", "CAD"),
+			TaxRates:           []tax.Rate{{Name: "GST", Percent: 5}, {Name: "QST", Percent: 9.975}},
+			Currency:           "CAD",
 		},
 	},
 }
 
 func main() {
+	for customerID, products := range accountData {
+		for productID, account := range products {
+			if err := tax.Validate(account.TaxRates); err != nil {
+				log.Fatalf("Invalid tax rates of %s %s: %v", customerID, productID, err)
+			}
+			for _, p := range account.Metered {
+				if err := p.Validate(); err != nil {
+					log.Fatalf("Invalid metered price of %s %s: %v", customerID, productID, err)
+				}
+				if p.Currency() != account.Currency {
+					log.Fatalf("Metered price %s of %s %s is not in %s", p.Metric, customerID, productID, account.Currency)
+				}
+			}
+		}
+	}
+
 	r := chi.NewRouter()
 
 	r.Get("/api/accounts/{customerID}/{productID}", func(w http.ResponseWriter, r *http.Request) {
@@ -95,6 +166,11 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
+		if err := account.calculate(); err != nil {
+			log.Printf("Error calculating account %s %s: %v\n", customerID, productID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(account)
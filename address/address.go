@@ -0,0 +1,245 @@
+package address
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var countryRe = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Address represents a structured postal address
+type Address struct {
+	Lines      []string `json:"lines"`
+	City       string   `json:"city"`
+	Region     string   `json:"region,omitempty"`
+	PostalCode string   `json:"postalCode,omitempty"`
+	Country    string   `json:"country"`
+}
+
+// layout is the order of the locality line elements of a country
+type layout int
+
+const (
+	// layoutPostalCodeCity prints "8001 Zürich"
+	layoutPostalCodeCity layout = iota
+	// layoutCityRegionPostalCode prints "Anytown, NY 12345"
+	layoutCityRegionPostalCode
+	// layoutCityThenPostalCode prints the city and the postal code on separate lines
+	layoutCityThenPostalCode
+	// layoutCityPostalCode prints "Dublin D02 X285"
+	layoutCityPostalCode
+)
+
+// country holds the name and address layout of a country
+type country struct {
+	name   string
+	layout layout
+	eu     bool
+}
+
+// countries holds the countries we bill to, keyed by ISO 3166-1 alpha-2 code
+var countries = map[string]country{
+	"AT": {"Austria", layoutPostalCodeCity, true},
+	"AU": {"Australia", layoutCityRegionPostalCode, false},
+	"BD": {"Bangladesh", layoutCityPostalCode, false},
+	"BE": {"Belgium", layoutPostalCodeCity, true},
+	"BG": {"Bulgaria", layoutPostalCodeCity, true},
+	"CA": {"Canada", layoutCityRegionPostalCode, false},
+	"CH": {"Switzerland", layoutPostalCodeCity, false},
+	"CY": {"Cyprus", layoutPostalCodeCity, true},
+	"CZ": {"Czech Republic", layoutPostalCodeCity, true},
+	"DE": {"Germany", layoutPostalCodeCity, true},
+	"DK": {"Denmark", layoutPostalCodeCity, true},
+	"EE": {"Estonia", layoutPostalCodeCity, true},
+	"ES": {"Spain", layoutPostalCodeCity, true},
+	"FI": {"Finland", layoutPostalCodeCity, true},
+	"FR": {"France", layoutPostalCodeCity, true},
+	"GB": {"United Kingdom", layoutCityThenPostalCode, false},
+	"GR": {"Greece", layoutPostalCodeCity, true},
+	"HR": {"Croatia", layoutPostalCodeCity, true},
+	"HU": {"Hungary", layoutPostalCodeCity, true},
+	"IE": {"Ireland", layoutCityPostalCode, true},
+	"IN": {"India", layoutCityPostalCode, false},
+	"IT": {"Italy", layoutPostalCodeCity, true},
+	"JP": {"Japan", layoutCityPostalCode, false},
+	"LI": {"Liechtenstein", layoutPostalCodeCity, false},
+	"LT": {"Lithuania", layoutPostalCodeCity, true},
+	"LU": {"Luxembourg", layoutPostalCodeCity, true},
+	"LV": {"Latvia", layoutCityPostalCode, true},
+	"MT": {"Malta", layoutCityPostalCode, true},
+	"NL": {"Netherlands", layoutPostalCodeCity, true},
+	"NO": {"Norway", layoutPostalCodeCity, false},
+	"PL": {"Poland", layoutPostalCodeCity, true},
+	"PT": {"Portugal", layoutPostalCodeCity, true},
+	"RO": {"Romania", layoutPostalCodeCity, true},
+	"SE": {"Sweden", layoutPostalCodeCity, true},
+	"SG": {"Singapore", layoutCityPostalCode, false},
+	"SI": {"Slovenia", layoutPostalCodeCity, true},
+	"SK": {"Slovakia", layoutPostalCodeCity, true},
+	"US": {"United States", layoutCityRegionPostalCode, false},
+}
+
+// CountryName returns the English name of the country, or the code itself when
+// the country is unknown.
+func CountryName(code string) string {
+	if c, ok := countries[code]; ok {
+		return c.name
+	}
+	return code
+}
+
+// InEU reports whether the country is a member state of the European Union.
+func InEU(code string) bool {
+	return countries[code].eu
+}
+
+// Validate checks that the address has the fields needed to deliver mail to it.
+func (a Address) Validate() error {
+	if len(nonEmpty(a.Lines)) == 0 {
+		return errors.New("empty address lines")
+	}
+
+	if a.City == "" {
+		return errors.New("empty city")
+	}
+
+	if !countryRe.MatchString(a.Country) {
+		return fmt.Errorf("invalid country code: %q", a.Country)
+	}
+
+	if c, ok := countries[a.Country]; ok && c.layout == layoutCityRegionPostalCode && a.Region == "" {
+		return errors.New("empty region")
+	}
+
+	return nil
+}
+
+// Format returns the lines of the address in the order used by its country,
+// ending with the country name.
+func (a Address) Format() []string {
+	lines := nonEmpty(a.Lines)
+
+	l := layoutPostalCodeCity
+	if c, ok := countries[a.Country]; ok {
+		l = c.layout
+	}
+
+	switch l {
+	case layoutCityRegionPostalCode:
+		locality := a.City
+		if a.Region != "" {
+			locality += ", " + a.Region
+		}
+		lines = append(lines, join(" ", locality, a.PostalCode))
+	case layoutCityThenPostalCode:
+		lines = append(lines, a.City)
+		if a.Region != "" {
+			lines = append(lines, a.Region)
+		}
+		if a.PostalCode != "" {
+			lines = append(lines, a.PostalCode)
+		}
+	case layoutCityPostalCode:
+		lines = append(lines, join(" ", a.City, a.PostalCode))
+		if a.Region != "" {
+			lines = append(lines, a.Region)
+		}
+	default:
+		lines = append(lines, join(" ", a.PostalCode, a.City))
+		if a.Region != "" {
+			lines = append(lines, a.Region)
+		}
+	}
+
+	if a.Country != "" {
+		lines = append(lines, CountryName(a.Country))
+	}
+
+	return nonEmpty(lines)
+}
+
+// countryAliases holds the names used for countries in free-form addresses
+// which are neither their code nor their name.
+var countryAliases = map[string]string{
+	"USA":           "US",
+	"U.S.A.":        "US",
+	"U.S.":          "US",
+	"UK":            "GB",
+	"U.K.":          "GB",
+	"GREAT BRITAIN": "GB",
+	"ENGLAND":       "GB",
+}
+
+// countryCode returns the code of a country given by its code, name or alias.
+func countryCode(s string) (string, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if _, ok := countries[s]; ok {
+		return s, true
+	}
+	if code, ok := countryAliases[s]; ok {
+		return code, true
+	}
+	for code, c := range countries {
+		if strings.ToUpper(c.name) == s {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// ParseLine makes a best effort structured address of a free-form address on a
+// single line such as "123 Main Street, Anytown, USA". The last part is the
+// country when it names one and the part before it the city, the other parts
+// are the address lines. Region and postal code are not recognised, so the
+// address may need fixing by hand before it validates.
+func ParseLine(s string) Address {
+	parts := nonEmpty(strings.Split(s, ","))
+
+	var a Address
+	if n := len(parts); n > 1 {
+		if code, ok := countryCode(parts[n-1]); ok {
+			a.Country = code
+			parts = parts[:n-1]
+		}
+	}
+	if n := len(parts); n > 1 {
+		a.City = parts[n-1]
+		parts = parts[:n-1]
+	}
+	a.Lines = parts
+
+	return a
+}
+
+// String returns the formatted address on a single line.
+func (a Address) String() string {
+	return strings.Join(a.Format(), ", ")
+}
+
+// JoinLines joins the address lines for storage in a single column.
+func (a Address) JoinLines() string {
+	return strings.Join(nonEmpty(a.Lines), "\n")
+}
+
+// SplitLines splits address lines stored with JoinLines.
+func SplitLines(s string) []string {
+	return nonEmpty(strings.Split(s, "\n"))
+}
+
+// nonEmpty returns the trimmed lines which are not empty.
+func nonEmpty(lines []string) []string {
+	var out []string
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// join joins the non empty parts with sep.
+func join(sep string, parts ...string) string {
+	return strings.Join(nonEmpty(parts), sep)
+}
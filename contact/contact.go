@@ -0,0 +1,142 @@
+package contact
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Role decides which emails a billing contact receives and how
+type Role string
+
+const (
+	// AccountsPayable receives the invoices, addressed to it
+	AccountsPayable Role = "accounts_payable"
+	// Billing is a named contact copied on the invoices
+	Billing Role = "billing"
+)
+
+// Contact is a billing contact of a customer
+type Contact struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  Role   `json:"role"`
+}
+
+// Validate checks the contact has a known role and a valid email address.
+func (c Contact) Validate() error {
+	switch c.Role {
+	case AccountsPayable, Billing:
+	default:
+		return fmt.Errorf("unknown contact role: %q", c.Role)
+	}
+	if _, err := mail.ParseAddress(c.Email); err != nil {
+		return fmt.Errorf("invalid email of contact %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+// address returns the contact as an email address, with the name when set
+func (c Contact) address() string {
+	if c.Name == "" {
+		return c.Email
+	}
+	return (&mail.Address{Name: c.Name, Address: c.Email}).String()
+}
+
+// Recipients are the addresses an email is sent to, copied to and blind
+// copied to, with the address replies go to. It is stored as JSON in the
+// database.
+type Recipients struct {
+	To      []string `json:"to"`
+	CC      []string `json:"cc,omitempty"`
+	BCC     []string `json:"bcc,omitempty"`
+	ReplyTo string   `json:"replyTo,omitempty"`
+}
+
+// ForInvoices returns the recipients of the invoices of a customer, the
+// accounts payable contacts in To and the billing contacts in CC. Customers
+// without an accounts payable contact receive the invoices at their email.
+func ForInvoices(email string, contacts []Contact) Recipients {
+	var r Recipients
+	for _, c := range contacts {
+		switch c.Role {
+		case AccountsPayable:
+			r.To = append(r.To, c.address())
+		case Billing:
+			r.CC = append(r.CC, c.address())
+		}
+	}
+	if len(r.To) == 0 && email != "" {
+		r.To = []string{email}
+	}
+	return r
+}
+
+// Validate checks there is a recipient and every address is valid.
+func (r Recipients) Validate() error {
+	if len(r.To) == 0 {
+		return errors.New("no recipient")
+	}
+
+	for _, list := range [][]string{r.To, r.CC, r.BCC} {
+		for _, a := range list {
+			if _, err := mail.ParseAddress(a); err != nil {
+				return fmt.Errorf("invalid recipient %q: %v", a, err)
+			}
+		}
+	}
+	if r.ReplyTo != "" {
+		if _, err := mail.ParseAddress(r.ReplyTo); err != nil {
+			return fmt.Errorf("invalid reply to %q: %v", r.ReplyTo, err)
+		}
+	}
+
+	return nil
+}
+
+// IsZero tells whether there are no recipients.
+func (r Recipients) IsZero() bool {
+	return len(r.To) == 0 && len(r.CC) == 0 && len(r.BCC) == 0 && r.ReplyTo == ""
+}
+
+// String lists the addresses of To and CC, BCC is left out.
+func (r Recipients) String() string {
+	return strings.Join(append(append([]string(nil), r.To...), r.CC...), ", ")
+}
+
+// Value implements the driver.Valuer interface.
+func (r Recipients) Value() (driver.Value, error) {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(v), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (r *Recipients) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = Recipients{}
+		return nil
+	case []byte:
+		if len(v) == 0 {
+			*r = Recipients{}
+			return nil
+		}
+		return json.Unmarshal(v, r)
+	case string:
+		if v == "" {
+			*r = Recipients{}
+			return nil
+		}
+		return json.Unmarshal([]byte(v), r)
+	default:
+		return fmt.Errorf("unsupported type for Recipients: %T", value)
+	}
+}
@@ -3,49 +3,124 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/contact"
 	"github.com/go-chi/chi/v5"
 )
 
 // Customer represents customer data
 type Customer struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Address string `json:"address"`
-	Contact string `json:"contact"`
+	Name    string          `json:"name"`
+	Email   string          `json:"email"`
+	Address address.Address `json:"address"`
+	Contact string          `json:"contact"`
+	VATID   string          `json:"vatID,omitempty"`
+	// Contacts are the billing contacts the invoices are sent to instead of
+	// the email, by their role
+	Contacts []contact.Contact `json:"contacts,omitempty"`
+	// Locale is the language the customer is emailed in, such as fr or de-AT
+	Locale string `json:"locale,omitempty"`
 }
 
 // Map to store customer data
 var customerData = map[string]Customer{
 	"CUSTOMER-0001": {
-		Name:    "Samantha Johnson",
-		Email:   "samantha.johnson@example.com",
-		Address: "123 Main Street, Anytown, USA",
+		Name:  "Samantha Johnson",
+		Email: "samantha.johnson@example.com",
+		Address: address.Address{
+			Lines:      []string{"123 Main Street"},
+			City:       "Anytown",
+			Region:     "NY",
+			PostalCode: "12345",
+			Country:    "US",
+		},
 		Contact: "+1 (555) 123-4567",
 	},
 	"CUSTOMER-0002": {
-		Name:    "Michael Thompson",
-		Email:   "michael.thompson@example.com",
-		Address: "456 Elm Street, Anycity, USA",
+		Name:  "Michael Thompson",
+		Email: "michael.thompson@example.com",
+		Address: address.Address{
+			Lines:      []string{"456 Elm Street"},
+			City:       "Anycity",
+			Region:     "CA",
+			PostalCode: "90210",
+			Country:    "US",
+		},
 		Contact: "+1 (555) 987-6543",
+		Contacts: []contact.Contact{
+			{Name: "Accounts Payable", Email: "ap@thompson-consulting.example.com", Role: contact.AccountsPayable},
+			{Name: "Michael Thompson", Email: "michael.thompson@example.com", Role: contact.Billing},
+		},
 	},
 	"CUSTOMER-0003": {
-		Name:    "Emily Rodriguez",
-		Email:   "emily.rodriguez@example.com",
-		Address: "789 Oak Avenue, Anyville, USA",
+		Name:  "Emily Rodriguez",
+		Email: "emily.rodriguez@example.com",
+		Address: address.Address{
+			Lines:      []string{"789 Oak Avenue"},
+			City:       "Anyville",
+			Region:     "TX",
+			PostalCode: "73301",
+			Country:    "US",
+		},
 		Contact: "+1 (555) 321-7890",
 	},
 	"CUSTOMER-0004": {
-		Name:    "David Lee",
-		Email:   "david.lee@example.com",
-		Address: "101 Pine Road, Anystate, USA",
+		Name:  "David Lee",
+		Email: "david.lee@example.com",
+		Address: address.Address{
+			Lines:      []string{"101 Pine Road"},
+			City:       "Anystate",
+			Region:     "WA",
+			PostalCode: "98101",
+			Country:    "US",
+		},
 		Contact: "+1 (555) 876-5432",
 	},
+	"CUSTOMER-0005": {
+		Name:  "Exemple SARL",
+		Email: "comptabilite@exemple.fr",
+		Address: address.Address{
+			Lines:      []string{"12 rue de la Paix"},
+			City:       "Paris",
+			PostalCode: "75002",
+			Country:    "FR",
+		},
+		Contact: "+33 1 23 45 67 89",
+		VATID:   "FR40303265045",
+		Contacts: []contact.Contact{
+			{Name: "Service Comptabilité", Email: "comptabilite@exemple.fr", Role: contact.AccountsPayable},
+			{Name: "Claire Martin", Email: "claire.martin@exemple.fr", Role: contact.Billing},
+		},
+		Locale: "fr",
+	},
+	"CUSTOMER-0006": {
+		Name:  "Sophie Tremblay",
+		Email: "sophie.tremblay@example.ca",
+		Address: address.Address{
+			Lines:      []string{"350 rue Saint-Paul Est"},
+			City:       "Montréal",
+			Region:     "QC",
+			PostalCode: "H2Y 1H2",
+			Country:    "CA",
+		},
+		Contact: "+1 (514) 555-0142",
+	},
 }
 
 func main() {
+	// Check the billing contacts
+	for id, c := range customerData {
+		for _, bc := range c.Contacts {
+			if err := bc.Validate(); err != nil {
+				log.Fatalf("Invalid contact of %s: %v", id, err)
+			}
+		}
+	}
+
 	r := chi.NewRouter()
 
 	r.Get("/api/customers/{customerID}", func(w http.ResponseWriter, r *http.Request) {
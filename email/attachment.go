@@ -0,0 +1,94 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// Attachment is a file attached to an email message. Its content is Data,
+// read from Reader, or read from the file of Path, in that order.
+type Attachment struct {
+	// Name is the file name of the attachment, the base name of Path when empty
+	Name string
+	// ContentType is the MIME type of the attachment, from the extension of
+	// the name when empty
+	ContentType string
+	Data        []byte
+	Reader      io.Reader
+	Path        string
+	// Inline attachments are shown in the HTML body where it refers to them by
+	// their name, such as <img src="cid:logo.png">, rather than listed as
+	// attachments
+	Inline bool
+}
+
+// String returns the name, content type and size of the attachment rather than
+// its content, for logging messages. The size of an attachment read from a
+// Reader or a Path is only known once it is loaded.
+func (a Attachment) String() string {
+	if a.Data == nil {
+		return fmt.Sprintf("%s (%s)", a.Name, a.ContentType)
+	}
+	return fmt.Sprintf("%s (%s, %d bytes)", a.Name, a.ContentType, len(a.Data))
+}
+
+// load returns the attachment with its name, content type and content, which
+// is read once so the attachment can be sent again
+func (a Attachment) load() (Attachment, error) {
+	if a.Name == "" {
+		a.Name = filepath.Base(a.Path)
+	}
+	if a.Name == "" || a.Name == "." || a.Name == string(filepath.Separator) {
+		return a, errors.New("attachment without a name")
+	}
+	if a.ContentType == "" {
+		a.ContentType = mime.TypeByExtension(filepath.Ext(a.Name))
+		if a.ContentType == "" {
+			a.ContentType = "application/octet-stream"
+		}
+	}
+
+	var err error
+	switch {
+	case a.Data != nil:
+	case a.Reader != nil:
+		if a.Data, err = io.ReadAll(a.Reader); err != nil {
+			return a, fmt.Errorf("error reading attachment %s: %v", a.Name, err)
+		}
+	case a.Path != "":
+		if a.Data, err = os.ReadFile(a.Path); err != nil {
+			return a, fmt.Errorf("error reading attachment %s: %v", a.Name, err)
+		}
+	default:
+		return a, fmt.Errorf("attachment %s without content", a.Name)
+	}
+	a.Reader, a.Path = nil, ""
+
+	return a, nil
+}
+
+// loadAttachments loads the attachments of a message, inline attachments must
+// have names of their own as the HTML body refers to them by name
+func loadAttachments(attachments []Attachment) ([]Attachment, error) {
+	loaded := make([]Attachment, 0, len(attachments))
+	inline := make(map[string]bool)
+	for _, a := range attachments {
+		a, err := a.load()
+		if err != nil {
+			return nil, err
+		}
+		if a.Inline {
+			if inline[a.Name] {
+				return nil, fmt.Errorf("inline attachments named %s twice", a.Name)
+			}
+			inline[a.Name] = true
+		}
+		loaded = append(loaded, a)
+	}
+
+	return loaded, nil
+}
@@ -0,0 +1,27 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttachmentString(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment Attachment
+		want       string
+	}{
+		{"data", Attachment{Name: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}, "invoice.pdf (application/pdf, 8 bytes)"},
+		{"empty data", Attachment{Name: "empty.txt", ContentType: "text/plain", Data: []byte{}}, "empty.txt (text/plain, 0 bytes)"},
+		{"reader before load", Attachment{Name: "invoice.pdf", ContentType: "application/pdf", Reader: strings.NewReader("%PDF-1.4")}, "invoice.pdf (application/pdf)"},
+		{"path before load", Attachment{Name: "invoice.pdf", ContentType: "application/pdf", Path: "/tmp/invoice.pdf"}, "invoice.pdf (application/pdf)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.attachment.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/email"
+)
+
+// parseBounceInterval parses how often the bounce mailbox is read, every minute
+// when empty
+func parseBounceInterval(s string) (time.Duration, error) {
+	if s == "" {
+		return time.Minute, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid bounce interval: %q", s)
+	}
+	return d, nil
+}
+
+// checkBounceMaildir checks the bounce mailbox is a Maildir, with the new
+// directory the mail server delivers to and the cur directory read messages
+// are moved to
+func checkBounceMaildir(dir string) error {
+	for _, sub := range []string{"new", "cur"} {
+		info, err := os.Stat(filepath.Join(dir, sub))
+		if err != nil {
+			return fmt.Errorf("error reading Maildir %s: %v", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s of Maildir %s is not a directory", sub, dir)
+		}
+	}
+	return nil
+}
+
+// watchBounces reads the new messages of the bounce mailbox every interval until
+// stop is closed
+func watchBounces(dir string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		entries, err := os.ReadDir(filepath.Join(dir, "new"))
+		if err != nil {
+			log.Printf("Error reading bounce mailbox %s: %v\n", dir, err)
+			continue
+		}
+		for _, e := range entries {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			processBounce(dir, e.Name())
+		}
+	}
+}
+
+// processBounce records the events of a message of the bounce mailbox and moves
+// it to cur as read. Messages which are not reports or cannot be parsed are
+// logged and moved as well, a message whose events cannot be recorded is kept
+// in new to be read again.
+func processBounce(dir, name string) {
+	path := filepath.Join(dir, "new", name)
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error opening bounce %s: %v\n", path, err)
+		return
+	}
+	events, err := email.ParseReport(f)
+	f.Close()
+
+	switch {
+	case errors.Is(err, email.ErrNotReport):
+		log.Printf("Skipping bounce %s: %v\n", name, err)
+	case err != nil:
+		log.Printf("Error parsing bounce %s: %v\n", name, err)
+	}
+
+	for _, e := range events {
+		if err := e.Validate(); err != nil {
+			log.Printf("Skipping the %s event of %s in bounce %s: %v\n", e.Type, e.Recipient, name, err)
+			continue
+		}
+		matched, err := recordEvent(e, "dsn")
+		if err != nil {
+			log.Printf("Error calling recordEvent for bounce %s: %v\n", name, err)
+			return
+		}
+		if !matched {
+			log.Printf("No email of message %s for the %s event of %s\n", e.MessageID, e.Type, e.Recipient)
+		}
+	}
+
+	// Messages read are moved to cur with the seen flag
+	if err := os.Rename(path, filepath.Join(dir, "cur", name+":2,S")); err != nil {
+		log.Printf("Error moving bounce %s to cur: %v\n", name, err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/email"
+	"github.com/go-chi/chi/v5"
+)
+
+// webhookSecret is the key the email API signs the delivery events it posts
+// with, the webhook is disabled when empty
+var webhookSecret string
+
+// bounceURL is where hard bounces of invoice emails are reported, {invoiceID}
+// is replaced with the ID of the invoice; empty when they are not reported
+var bounceURL string
+
+// EmailEvent is a delivery event in the history of an email
+type EmailEvent struct {
+	ID      int `json:"id"`
+	EmailID int `json:"emailID"`
+	email.Event
+	// Source is where the event came from, webhook or dsn
+	Source string `json:"source"`
+}
+
+// parseBounceURL checks the bounce URL is an absolute URL, empty when hard
+// bounces are not reported.
+func parseBounceURL(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(strings.ReplaceAll(s, "{invoiceID}", "0"))
+	if err != nil || !u.IsAbs() {
+		return "", fmt.Errorf("invalid bounce URL: %q", s)
+	}
+	return s, nil
+}
+
+// recordEvent adds the event to the history of the email with its Message-ID
+// and reports whether there is such an email. The status of the email is its
+// latest event, a hard bounce or a complaint is kept. An event recorded before
+// is ignored, so events sent again are only counted once.
+func recordEvent(e email.Event, source string) (bool, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM emails WHERE messageID = ?", e.MessageID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error finding email of message %s: %v", e.MessageID, err)
+	}
+
+	occurredAt := e.Time.UTC().Format(time.DateTime)
+	result, err := db.Exec("INSERT IGNORE INTO email_events (emailID, messageID, recipient, event, status, diagnostic, source, occurredAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id, e.MessageID, e.Recipient, e.Type, e.Status, e.Diagnostic, source, occurredAt)
+	if err != nil {
+		return true, fmt.Errorf("error inserting event: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return true, fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rows == 0 {
+		return true, nil
+	}
+
+	_, err = db.Exec(`UPDATE emails SET deliveryStatus = ?, deliveryStatusAt = ? WHERE id = ?
+		AND (? OR (deliveryStatus NOT IN (?, ?) AND (deliveryStatusAt IS NULL OR deliveryStatusAt <= ?)))`,
+		e.Type, occurredAt, id, e.Type.Final(), email.EventHardBounce, email.EventComplained, occurredAt)
+	if err != nil {
+		return true, fmt.Errorf("error updating delivery status: %v", err)
+	}
+	log.Printf("Recorded %s of email %d to %s from %s\n", e.Type, id, e.Recipient, source)
+
+	return true, nil
+}
+
+// bounce is a hard bounce of an invoice email which is not reported yet
+type bounce struct {
+	eventID    int
+	emailID    int
+	invoiceID  string
+	recipient  string
+	status     string
+	diagnostic string
+	occurredAt string
+}
+
+// reportHardBounces reports the hard bounces which are not reported yet to the
+// invoice service, the oldest first. It stops at the first report which fails,
+// the bounces left are reported again by the next call.
+func reportHardBounces() error {
+	if bounceURL == "" {
+		return nil
+	}
+
+	bounces, err := unreportedBounces()
+	if err != nil {
+		return err
+	}
+	for _, b := range bounces {
+		if err := reportHardBounce(b); err != nil {
+			return fmt.Errorf("error reporting the hard bounce of email %d: %v", b.emailID, err)
+		}
+		reportedAt := time.Now().UTC().Format(time.DateTime)
+		if _, err := db.Exec("UPDATE email_events SET bounceReportedAt = ? WHERE id = ?", reportedAt, b.eventID); err != nil {
+			return fmt.Errorf("error updating bounceReportedAt: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// unreportedBounces returns the hard bounces which are not reported yet, the
+// oldest first
+func unreportedBounces() ([]bounce, error) {
+	rows, err := db.Query(`SELECT ev.id, ev.emailID, e.invoiceID, ev.recipient, ev.status, ev.diagnostic, ev.occurredAt
+		FROM email_events ev JOIN emails e ON e.id = ev.emailID
+		WHERE ev.event = ? AND ev.bounceReportedAt IS NULL ORDER BY ev.id ASC LIMIT ?`, email.EventHardBounce, queueBatch)
+	if err != nil {
+		return nil, fmt.Errorf("error getting unreported bounces: %v", err)
+	}
+	defer rows.Close()
+
+	var bounces []bounce
+	for rows.Next() {
+		var b bounce
+		if err := rows.Scan(&b.eventID, &b.emailID, &b.invoiceID, &b.recipient, &b.status, &b.diagnostic, &b.occurredAt); err != nil {
+			return nil, fmt.Errorf("error scanning bounce: %v", err)
+		}
+		bounces = append(bounces, b)
+	}
+
+	return bounces, rows.Err()
+}
+
+// reportHardBounce tells the invoice service the invoice could not be delivered
+// to the recipient, so the billing contact of the customer can be fixed
+func reportHardBounce(b bounce) error {
+	u := strings.ReplaceAll(bounceURL, "{invoiceID}", url.PathEscape(b.invoiceID))
+	x := map[string]interface{}{
+		"emailID":    b.emailID,
+		"recipient":  b.recipient,
+		"status":     b.status,
+		"diagnostic": b.diagnostic,
+		"bouncedAt":  b.occurredAt,
+	}
+
+	return callDoneURL(u, x)
+}
+
+// verifyWebhookSignature checks the X-Webhook-Signature header is the hex
+// encoded HMAC-SHA256 of the body with the webhook secret
+func verifyWebhookSignature(body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// emailEventsHandler records the delivery events posted by the email API.
+// Events of emails which are not known, such as notices, are acknowledged and
+// counted as unmatched so the API does not send them again.
+func emailEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if webhookSecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
+	}
+	if !verifyWebhookSignature(body, r.Header.Get("X-Webhook-Signature")) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var events []email.Event
+	if err := json.Unmarshal(body, &events); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	for i, e := range events {
+		// Some APIs send the Message-ID with its angle brackets
+		events[i].MessageID = strings.Trim(strings.TrimSpace(e.MessageID), "<>")
+		if err := events[i].Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("event %d: %v", i+1, err), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	var unmatched int
+	for _, e := range events {
+		matched, err := recordEvent(e, "webhook")
+		if err != nil {
+			log.Printf("Error calling recordEvent: %v\n", err)
+			http.Error(w, "Error calling recordEvent", http.StatusInternalServerError)
+			return
+		}
+		if !matched {
+			log.Printf("No email of message %s for the %s event of %s\n", e.MessageID, e.Type, e.Recipient)
+			unmatched++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"received": len(events), "unmatched": unmatched})
+}
+
+// getEmailEventsHandler returns the delivery events of an email, the oldest
+// first
+func getEmailEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	em, err := retrieveRecord(id)
+	if err != nil {
+		http.Error(w, "Error retrieving record", http.StatusInternalServerError)
+		return
+	}
+	if em == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	events, err := getEmailEvents(em.ID)
+	if err != nil {
+		log.Printf("Error calling getEmailEvents: %v\n", err)
+		http.Error(w, "Error calling getEmailEvents", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// getEmailEvents returns the delivery events of the email, the oldest first
+func getEmailEvents(id int) ([]EmailEvent, error) {
+	rows, err := db.Query("SELECT id, messageID, recipient, event, status, diagnostic, source, occurredAt FROM email_events WHERE emailID = ? ORDER BY occurredAt ASC, id ASC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []EmailEvent{}
+	for rows.Next() {
+		e := EmailEvent{EmailID: id}
+		var occurredAt string
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.Recipient, &e.Type, &e.Status, &e.Diagnostic, &e.Source, &occurredAt); err != nil {
+			return nil, err
+		}
+		if e.Time, err = time.Parse(time.DateTime, occurredAt); err != nil {
+			return nil, fmt.Errorf("error parsing occurredAt: %v", err)
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
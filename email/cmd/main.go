@@ -6,9 +6,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,29 +16,51 @@ import (
 	"sync"
 	"time"
 
+	"github.com/arifmahmudrana/invoice/contact"
 	"github.com/arifmahmudrana/invoice/email"
+	"github.com/arifmahmudrana/invoice/migrate"
+	"github.com/arifmahmudrana/invoice/pdf"
 	"github.com/go-chi/chi/v5"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var db *sql.DB
+
+// maxInvoiceSize is the size of the largest invoice PDF accepted
+const maxInvoiceSize = 10 << 20
+
+// mutex serializes the writes of the invoice email requests, it is not held
+// while emails are sent
 var mutex sync.Mutex
 
 type Email struct {
-	ID            int          `json:"id"`
-	ProductCode   string       `json:"productCode"`
-	CustomerID    string       `json:"customerID"`
-	InvoiceID     string       `json:"invoiceID"`
-	EmailTo       string       `json:"emailTo"`
-	FileHash      string       `json:"fileHash"`
-	DoneURL       string       `json:"doneURL"`
-	InvoiceSentAt sql.NullTime `json:"invoiceSentAt"`
-	FailedAt      sql.NullTime `json:"failedAt"` // New column
+	ID          int    `json:"id"`
+	ProductCode string `json:"productCode"`
+	CustomerID  string `json:"customerID"`
+	InvoiceID   string `json:"invoiceID"`
+	EmailTo     string `json:"emailTo"`
+	// Recipients are the addresses the invoice is sent to, EmailTo when empty
+	Recipients contact.Recipients `json:"recipients"`
+	// Context is the invoice the email is rendered with
+	Context       email.InvoiceContext `json:"context"`
+	FileHash      string               `json:"fileHash"`
+	DoneURL       string               `json:"doneURL"`
+	InvoiceSentAt sql.NullTime         `json:"invoiceSentAt"`
+	FailedAt      sql.NullTime         `json:"failedAt"` // New column
+	// DeferredUntil is when an email deferred by the rate limits is sent again
+	DeferredUntil sql.NullTime `json:"deferredUntil"`
+	// MessageID is the Message-ID of the email last sent, which delivery
+	// events refer to
+	MessageID string `json:"messageID"`
+	// DeliveryStatus is the type of the latest delivery event, empty before
+	// there is one
+	DeliveryStatus   string       `json:"deliveryStatus"`
+	DeliveryStatusAt sql.NullTime `json:"deliveryStatusAt"`
 }
 
 // main function
 // to run the project use something like
-// MYSQL_DSN='root:root@tcp(127.0.0.1:3306)/dbname' PORT=8080 PDF_PATH=full-path/ SMTP_PORT=2525 SMTP_HOST='sandbox.smtp.mailtrap.io' SMTP_USER_NAME=user_name SMTP_PASSWORD=password FROM_EMAIL='amrana83@gmail.com' FROM_NAME='Arif Mahmud Rana' EMAIL_SUBJECT='Invoice for the next the next billing' EMAIL_TEMPLATE_PATH=/home/rana/Desktop/invoice/email/templates go run email/cmd/*.go
+// MYSQL_DSN='root:root@tcp(127.0.0.1:3306)/dbname' PORT=8080 SMTP_PORT=2525 SMTP_HOST='sandbox.smtp.mailtrap.io' SMTP_USER_NAME=user_name SMTP_PASSWORD=password FROM_EMAIL='amrana83@gmail.com' FROM_NAME='Arif Mahmud Rana' EMAIL_SUBJECT='Invoice for the next the next billing' EMAIL_TEMPLATE_PATH=/home/rana/Desktop/invoice/email/templates go run email/cmd/*.go
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	// Initialize MySQL database connection
@@ -61,17 +83,140 @@ func main() {
         customerID varchar(255) NOT NULL,
         invoiceID varchar(255) NOT NULL,
         emailTo varchar(255) NOT NULL,
+        recipients text,
+        invoiceContext text,
         fileHash varchar(255) NOT NULL,
+        invoicePDF longblob,
         doneURL varchar(255) NOT NULL,
         invoiceSentAt datetime DEFAULT NULL,
         failedAt datetime DEFAULT NULL,
+        deferredUntil datetime DEFAULT NULL,
+        messageID varchar(255) NOT NULL DEFAULT '',
+        deliveryStatus varchar(32) NOT NULL DEFAULT '',
+        deliveryStatusAt datetime DEFAULT NULL,
         PRIMARY KEY (id),
-        INDEX invoiceID (invoiceID)
+        INDEX invoiceID (invoiceID),
+        INDEX deferredUntil (deferredUntil),
+        INDEX messageID (messageID)
       ) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
 	if err != nil {
 		log.Fatalf("Error creating table emails: %v", err)
 	}
 
+	// The delivery events of the emails, an event is only recorded once
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS email_events (
+        id int NOT NULL AUTO_INCREMENT,
+        emailID int NOT NULL,
+        messageID varchar(255) NOT NULL,
+        recipient varchar(255) NOT NULL,
+        event varchar(32) NOT NULL,
+        status varchar(32) NOT NULL DEFAULT '',
+        diagnostic text,
+        source varchar(32) NOT NULL,
+        occurredAt datetime NOT NULL,
+        bounceReportedAt datetime DEFAULT NULL,
+        PRIMARY KEY (id),
+        UNIQUE INDEX event (emailID, recipient, event, occurredAt)
+      ) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+	if err != nil {
+		log.Fatalf("Error creating table email_events: %v", err)
+	}
+
+	// Upgrade the tables created by older versions before serving
+	if err := migrate.Run(db, migrations); err != nil {
+		log.Fatalf("Error migrating tables: %v", err)
+	}
+
+	// Select how the emails are delivered
+	transport, err = newTransport(os.Getenv("EMAIL_TRANSPORT"))
+	if err != nil {
+		log.Fatalf("Error configuring EMAIL_TRANSPORT: %v", err)
+	}
+	mailer = email.Mail{Domain: os.Getenv("DKIM_DOMAIN"), FromAddress: os.Getenv("FROM_EMAIL"), FromName: os.Getenv("FROM_NAME")}
+
+	if err := checkRecipientDefaults(); err != nil {
+		log.Fatalf("Error checking recipients: %v", err)
+	}
+
+	// Parse and check the templates once, a broken template or a wrong
+	// EMAIL_TEMPLATE_PATH stops the service here rather than failing the emails
+	templateRegistry, err = email.NewRegistry(os.Getenv("EMAIL_TEMPLATE_PATH"))
+	if err != nil {
+		log.Fatalf("Error loading EMAIL_TEMPLATE_PATH: %v", err)
+	}
+	mailer.Templates = templateRegistry
+	templateReload, err := parseTemplateReload(os.Getenv("EMAIL_TEMPLATE_RELOAD"))
+	if err != nil {
+		log.Fatalf("Error parsing EMAIL_TEMPLATE_RELOAD: %v", err)
+	}
+
+	// The logo is attached inline to the emails
+	logo, err = parseLogo(os.Getenv("EMAIL_LOGO_PATH"))
+	if err != nil {
+		log.Fatalf("Error parsing EMAIL_LOGO_PATH: %v", err)
+	}
+
+	// The subject of the invoice emails is rendered with the invoice
+	subjectTemplate, err = parseSubject(os.Getenv("EMAIL_SUBJECT"))
+	if err != nil {
+		log.Fatalf("Error parsing EMAIL_SUBJECT: %v", err)
+	}
+
+	// Sign the emails with DKIM, an unusable key stops the service here rather
+	// than failing every email
+	if keyPath := os.Getenv("DKIM_PRIVATE_KEY_PATH"); keyPath != "" {
+		if err := mailer.LoadDKIM(os.Getenv("DKIM_SELECTOR"), keyPath); err != nil {
+			log.Fatalf("Error loading DKIM_PRIVATE_KEY_PATH: %v", err)
+		}
+		log.Printf("Signing emails with DKIM selector %s of %s\n", os.Getenv("DKIM_SELECTOR"), mailer.Domain)
+	}
+
+	// Record the delivery events posted by the email API and read from the
+	// bounce mailbox, and report hard bounces to the invoice service
+	webhookSecret = os.Getenv("EMAIL_WEBHOOK_SECRET")
+	bounceURL, err = parseBounceURL(os.Getenv("BOUNCE_URL"))
+	if err != nil {
+		log.Fatalf("Error parsing BOUNCE_URL: %v", err)
+	}
+	bounceMaildir := os.Getenv("EMAIL_BOUNCE_MAILDIR")
+	if bounceMaildir != "" {
+		if err := checkBounceMaildir(bounceMaildir); err != nil {
+			log.Fatalf("Error checking EMAIL_BOUNCE_MAILDIR: %v", err)
+		}
+	}
+	bounceInterval, err := parseBounceInterval(os.Getenv("EMAIL_BOUNCE_INTERVAL"))
+	if err != nil {
+		log.Fatalf("Error parsing EMAIL_BOUNCE_INTERVAL: %v", err)
+	}
+
+	// Send the emails deferred by the rate limits when they are due
+	stopQueue := make(chan struct{})
+	queueDone := make(chan struct{})
+	go func() {
+		processDeferredEmails(stopQueue)
+		close(queueDone)
+	}()
+
+	// Reload the templates of EMAIL_TEMPLATE_PATH when they are changed
+	stopTemplates := make(chan struct{})
+	templatesDone := make(chan struct{})
+	go func() {
+		if templateReload > 0 && templateRegistry.Dir() != "" {
+			watchTemplates(templateReload, stopTemplates)
+		}
+		close(templatesDone)
+	}()
+
+	// Read the bounce reports of EMAIL_BOUNCE_MAILDIR
+	stopBounces := make(chan struct{})
+	bouncesDone := make(chan struct{})
+	go func() {
+		if bounceMaildir != "" {
+			watchBounces(bounceMaildir, bounceInterval, stopBounces)
+		}
+		close(bouncesDone)
+	}()
+
 	r := chi.NewRouter()
 
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -83,6 +228,11 @@ func main() {
 	// Add route /api/email-invoice/{id} using GET method
 	r.Get("/api/email-invoice/{id}", getEmailInvoiceHandler)
 
+	r.Post("/api/email-notice", emailNoticeHandler)
+
+	r.Get("/api/email-invoice/{id}/events", getEmailEventsHandler)
+	r.Post("/api/email-events", emailEventsHandler)
+
 	srv := &http.Server{
 		Addr:    ":" + os.Getenv("PORT"), // 8080
 		Handler: r,
@@ -114,6 +264,21 @@ func main() {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
 
+	// Stop sending deferred emails, reloading the templates and reading bounces
+	close(stopQueue)
+	<-queueDone
+	close(stopTemplates)
+	<-templatesDone
+	close(stopBounces)
+	<-bouncesDone
+
+	// Close the pooled connections of the transport
+	if c, ok := transport.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("Error closing email transport: %v\n", err)
+		}
+	}
+
 	// Close the database connection
 	if err := db.Close(); err != nil {
 		log.Fatalf("Error closing database connection: %v", err)
@@ -124,7 +289,7 @@ func main() {
 
 func emailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse multipart form
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // Max file size 10MB
+	if err := r.ParseMultipartForm(maxInvoiceSize); err != nil {
 		http.Error(w, "Unable to parse form", http.StatusBadRequest)
 		return
 	}
@@ -134,8 +299,18 @@ func emailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 	customerID := r.FormValue("customerID")
 	invoiceID := r.FormValue("invoiceID")
 	emailTo := r.FormValue("emailTo")
+	recipients, err := parseRecipients(r.FormValue("recipients"), emailTo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	fileHash := r.FormValue("fileHash")
 	doneURL := r.FormValue("doneURL")
+	invoiceContext, err := parseInvoiceContext(r.FormValue("context"), Email{ProductCode: productCode, CustomerID: customerID, InvoiceID: invoiceID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Get the file from the request
 	file, _, err := r.FormFile("invoiceFile")
@@ -155,42 +330,19 @@ func emailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If record with the same invoiceID doesn't exist or fileHash doesn't match, delete previous file and create a new one
+	// If record with the same invoiceID doesn't exist or fileHash doesn't match, store the new invoice
 	var emailInvoice bool
-	assetPath := os.Getenv("PDF_PATH")
 	if dbErr == sql.ErrNoRows || existingFileHash != fileHash {
-		if existingFileHash != "" {
-			if err := os.RemoveAll(assetPath + existingFileHash); err != nil {
-				log.Printf("Error deleting previous file: %v\n", err)
-				http.Error(w, "Error deleting previous file", http.StatusInternalServerError)
-				return
-			}
-		}
-
-		// Create directory with fileHash name
-		dirPath := assetPath + fileHash
-		log.Printf("Directory path for the PDF: %s\n", dirPath)
-		if err := os.Mkdir(dirPath, 0755); err != nil {
-			log.Printf("Error creating directory: %v\n", err)
-			http.Error(w, "Error creating directory", http.StatusInternalServerError)
-			return
-		}
-
-		// Create the file in the directory and move the uploaded file there
-		filePath := dirPath + "/invoice.pdf"
-		out, err := os.Create(filePath)
+		// The invoice is kept in the record, so the email can be sent or sent again
+		// from any instance of the service without a shared directory
+		invoicePDF, err := io.ReadAll(io.LimitReader(file, maxInvoiceSize+1))
 		if err != nil {
-			log.Printf("Error creating file: %v\n", err)
-			http.Error(w, "Error creating file", http.StatusInternalServerError)
+			log.Printf("Error reading file: %v\n", err)
+			http.Error(w, "Error reading file", http.StatusBadRequest)
 			return
 		}
-		defer out.Close()
-
-		// Write the file to disk
-		_, err = io.Copy(out, file)
-		if err != nil {
-			log.Printf("Error writing file to disk: %v\n", err)
-			http.Error(w, "Error writing file to disk", http.StatusInternalServerError)
+		if len(invoicePDF) > maxInvoiceSize {
+			http.Error(w, "Invoice file too large", http.StatusRequestEntityTooLarge)
 			return
 		}
 
@@ -201,11 +353,12 @@ func emailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 		var created bool
 		if dbErr == sql.ErrNoRows {
 			// Insert a new record into the database
-			result, err = db.Exec("INSERT INTO emails (productCode, customerID, invoiceID, emailTo, fileHash, doneURL) VALUES (?, ?, ?, ?, ?, ?)", productCode, customerID, invoiceID, emailTo, fileHash, doneURL)
+			result, err = db.Exec("INSERT INTO emails (productCode, customerID, invoiceID, emailTo, recipients, invoiceContext, fileHash, invoicePDF, doneURL) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", productCode, customerID, invoiceID, emailTo, recipients, invoiceContext, fileHash, invoicePDF, doneURL)
 			created = true
 		} else {
-			// Update existing record in the database with fileHash and set invoiceSentAt to null
-			_, err = db.Exec("UPDATE emails SET fileHash = ?, invoiceSentAt = NULL WHERE invoiceID = ?", fileHash, invoiceID)
+			// Update existing record in the database with fileHash and set invoiceSentAt to null, the new
+			// invoice is sent right away rather than by the queue
+			_, err = db.Exec("UPDATE emails SET fileHash = ?, invoicePDF = ?, recipients = ?, invoiceContext = ?, invoiceSentAt = NULL, deferredUntil = NULL WHERE invoiceID = ?", fileHash, invoicePDF, recipients, invoiceContext, invoiceID)
 		}
 		if err != nil {
 			log.Printf("Error while database operation: %v\n", err)
@@ -236,64 +389,125 @@ func emailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Call the retrieveRecord function as a goroutine
 	go func(id int, emailInvoice bool) {
-		mutex.Lock()
-		defer mutex.Unlock()
-
 		if emailInvoice {
-			// Retrieve database record for invoiceID with invoiceSentAt null
-			em, err := retrieveRecord(id)
-			if err != nil {
-				return
-			}
-			if em == nil {
-				log.Printf("No record found for id %d\n", id)
-				return
-			}
+			deliverInvoiceEmail(id, false)
+		}
+	}(id, emailInvoice)
+}
 
-			// sent email invoice
-			if err := sendEmail(*em); err != nil {
-				failedAt := time.Now().UTC().Format(time.DateTime)
-				// update database set failedAt
-				if _, err := db.Exec("UPDATE emails SET failedAt = ?, invoiceSentAt = NULL WHERE id = ?", failedAt, id); err != nil {
-					log.Printf("Error while `UPDATE emails SET failedAt = %s, invoiceSentAt = NULL WHERE id = %d`: %+v\n", failedAt, id, err)
-				}
-
-				// call the doneURL with a failedMessage, status
-				x := map[string]interface{}{
-					"failedMessage": "Failed to process the request",
-					"status":        http.StatusInternalServerError,
-					"failedAt":      failedAt,
-				}
-				if err := callDoneURL(em.DoneURL, x); err != nil {
-					log.Printf("Error while calling doneURL %s with parameter %#v: %+v\n", em.DoneURL, x, err)
-				}
-				return
-			}
+// recordLocks serialize the deliveries of a record so its email is not sent
+// twice at once, the emails of other records are sent concurrently
+var recordLocks = struct {
+	sync.Mutex
+	m map[int]*recordLock
+}{m: map[int]*recordLock{}}
+
+// recordLock is the lock of a record, it is dropped once no delivery holds or
+// waits for it
+type recordLock struct {
+	sync.Mutex
+	refs int
+}
 
-			// update database set failedAt null and invoiceSentAt now
-			invoiceSentAt := time.Now().UTC().Format(time.DateTime)
-			if _, err := db.Exec("UPDATE emails SET invoiceSentAt = ?, failedAt = NULL WHERE id = ?", invoiceSentAt, id); err != nil {
-				log.Printf("Error while `UPDATE emails SET invoiceSentAt = %s, failedAt = NULL WHERE id = %d`: %+v\n", invoiceSentAt, id, err)
-			}
+// lockRecord waits until no other delivery of the record runs and returns the
+// function releasing the record
+func lockRecord(id int) func() {
+	recordLocks.Lock()
+	l, ok := recordLocks.m[id]
+	if !ok {
+		l = &recordLock{}
+		recordLocks.m[id] = l
+	}
+	l.refs++
+	recordLocks.Unlock()
 
-			// call the doneURL with a successMessage, status, invoiceSentAt and id of emails table record
-			x := map[string]interface{}{
-				"successMessage": "Successfully processed the request",
-				"status":         http.StatusOK,
-				"invoiceSentAt":  invoiceSentAt,
-				"ID":             id,
-			}
-			if err := callDoneURL(em.DoneURL, x); err != nil {
-				log.Printf("Error while calling doneURL %s with parameter %#v: %+v\n", em.DoneURL, x, err)
-			}
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		recordLocks.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(recordLocks.m, id)
 		}
-	}(id, emailInvoice)
+		recordLocks.Unlock()
+	}
+}
+
+// deliverInvoiceEmail sends the invoice email of the record and calls its done
+// URL, an email throttled by the rate limits is deferred to the queue instead.
+// The record is read again once it is locked as another delivery may have sent
+// it meanwhile: an email already sent is not sent again, nor is a queued email
+// which is no longer deferred or deferred again.
+func deliverInvoiceEmail(id int, queued bool) {
+	unlock := lockRecord(id)
+	defer unlock()
+
+	em, err := retrieveRecord(id)
+	if err != nil {
+		return
+	}
+	if em == nil {
+		log.Printf("No record found for id %d\n", id)
+		return
+	}
+	if em.InvoiceSentAt.Valid {
+		log.Printf("Email %d was already sent at %s\n", id, em.InvoiceSentAt.Time.Format(time.DateTime))
+		return
+	}
+	if queued && (!em.DeferredUntil.Valid || em.DeferredUntil.Time.After(time.Now())) {
+		log.Printf("Email %d is no longer due\n", id)
+		return
+	}
+
+	// sent email invoice
+	messageID, err := sendEmail(*em)
+	if err != nil {
+		if t, ok := email.IsThrottled(err); ok {
+			deferEmail(id, t.RetryAfter)
+			return
+		}
+
+		failedAt := time.Now().UTC().Format(time.DateTime)
+		// update database set failedAt
+		if _, err := db.Exec("UPDATE emails SET failedAt = ?, invoiceSentAt = NULL, deferredUntil = NULL WHERE id = ?", failedAt, id); err != nil {
+			log.Printf("Error while `UPDATE emails SET failedAt = %s, invoiceSentAt = NULL, deferredUntil = NULL WHERE id = %d`: %+v\n", failedAt, id, err)
+		}
+
+		// call the doneURL with a failedMessage, status
+		x := map[string]interface{}{
+			"failedMessage": "Failed to process the request",
+			"status":        http.StatusInternalServerError,
+			"failedAt":      failedAt,
+		}
+		if err := callDoneURL(em.DoneURL, x); err != nil {
+			log.Printf("Error while calling doneURL %s with parameter %#v: %+v\n", em.DoneURL, x, err)
+		}
+		return
+	}
+
+	// update database set failedAt null and invoiceSentAt now
+	invoiceSentAt := time.Now().UTC().Format(time.DateTime)
+	if _, err := db.Exec("UPDATE emails SET invoiceSentAt = ?, messageID = ?, deliveryStatus = '', deliveryStatusAt = NULL, failedAt = NULL, deferredUntil = NULL WHERE id = ?", invoiceSentAt, messageID, id); err != nil {
+		log.Printf("Error while `UPDATE emails SET invoiceSentAt = %s, messageID = %s, deliveryStatus = '', deliveryStatusAt = NULL, failedAt = NULL, deferredUntil = NULL WHERE id = %d`: %+v\n", invoiceSentAt, messageID, id, err)
+	}
+
+	// call the doneURL with a successMessage, status, invoiceSentAt and id of emails table record
+	x := map[string]interface{}{
+		"successMessage": "Successfully processed the request",
+		"status":         http.StatusOK,
+		"invoiceSentAt":  invoiceSentAt,
+		"ID":             id,
+	}
+	if err := callDoneURL(em.DoneURL, x); err != nil {
+		log.Printf("Error while calling doneURL %s with parameter %#v: %+v\n", em.DoneURL, x, err)
+	}
 }
 
 func retrieveRecord(id int) (*Email, error) {
 	// Retrieve database record for invoiceID with invoiceSentAt null
 	var em Email
-	err := db.QueryRow("SELECT * FROM emails WHERE id = ?", id).Scan(&em.ID, &em.ProductCode, &em.CustomerID, &em.InvoiceID, &em.EmailTo, &em.FileHash, &em.DoneURL, &em.InvoiceSentAt, &em.FailedAt)
+	err := db.QueryRow("SELECT id, productCode, customerID, invoiceID, emailTo, recipients, invoiceContext, fileHash, doneURL, invoiceSentAt, failedAt, deferredUntil, messageID, deliveryStatus, deliveryStatusAt FROM emails WHERE id = ?", id).Scan(&em.ID, &em.ProductCode, &em.CustomerID, &em.InvoiceID, &em.EmailTo, &em.Recipients, &em.Context, &em.FileHash, &em.DoneURL, &em.InvoiceSentAt, &em.FailedAt, &em.DeferredUntil, &em.MessageID, &em.DeliveryStatus, &em.DeliveryStatusAt)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			log.Printf("Error retrieving record for id %d: %v\n", id, err)
@@ -307,39 +521,143 @@ func retrieveRecord(id int) (*Email, error) {
 	return &em, nil
 }
 
-func sendEmail(em Email) error {
-	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+// getInvoicePDF returns the invoice PDF stored with the email, it is not read by
+// retrieveRecord as the record is also served as JSON
+func getInvoicePDF(id int) ([]byte, error) {
+	var b []byte
+	if err := db.QueryRow("SELECT invoicePDF FROM emails WHERE id = ?", id).Scan(&b); err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("email %d has no invoice PDF", id)
+	}
+
+	return b, nil
+}
+
+// sendEmail sends the invoice email of the record and returns the Message-ID
+// it was sent with
+func sendEmail(em Email) (string, error) {
+	// Read the invoice once, it is verified and attached from memory
+	invoice, err := getInvoicePDF(em.ID)
 	if err != nil {
-		log.Printf("Error while converting SMTP_PORT environment variable to int: %+v\n", err)
-		return err
+		log.Printf("Error while reading the invoice of email %d: %+v\n", em.ID, err)
+		return "", err
+	}
+	if os.Getenv("VERIFY_PDF_SIGNATURE") == "true" {
+		if err := verifyInvoiceSignature(em.InvoiceID, invoice); err != nil {
+			log.Printf("Error while verifying the invoice signature: %+v\n", err)
+			return "", err
+		}
 	}
-	mail := email.Mail{
-		Host:     os.Getenv("SMTP_HOST"),
-		Port:     smtpPort,
-		Username: os.Getenv("SMTP_USER_NAME"),
-		Password: os.Getenv("SMTP_PASSWORD"),
+
+	// Render the email with the invoice and the templates of its product or seller
+	setInvoiceContextDefaults(&em.Context, em)
+	subject, err := invoiceSubject(em.Context)
+	if err != nil {
+		log.Printf("Error calling invoiceSubject: %v\n", err)
+		return "", err
 	}
-	log.Printf("mail struct constructed: %v\n", mail)
 
+	recipients := invoiceRecipients(em.Recipients, em.EmailTo)
 	x := email.Message{
 		From:     os.Getenv("FROM_EMAIL"),
 		FromName: os.Getenv("FROM_NAME"),
-		To:       em.EmailTo,
-		Subject:  os.Getenv("EMAIL_SUBJECT"),
-		Attachments: []string{
-			os.Getenv("PDF_PATH") + em.FileHash + "/invoice.pdf",
+		To:       recipients.To,
+		CC:       recipients.CC,
+		BCC:      recipients.BCC,
+		ReplyTo:  recipients.ReplyTo,
+		Subject:  subject,
+		Attachments: []email.Attachment{
+			{Name: "invoice.pdf", ContentType: "application/pdf", Data: invoice},
 		},
-		Data:    template.HTML("<p>Thank you for using our services.</p>"), // HTML for invoice email
-		DataMap: nil,
+		Invoice:   &em.Context,
+		Template:  "invoice",
+		Locale:    em.Context.Locale,
+		MessageID: email.NewMessageID(os.Getenv("FROM_EMAIL")),
 	}
+	x = withLogo(x)
 	log.Printf("email.Message struct constructed: %v\n", x)
-	err = mail.SendSMTPMessage(x, os.Getenv("EMAIL_TEMPLATE_PATH"))
-	if err != nil {
-		log.Printf("Error while sending email using SendSMTPMessage: %+v\n", err)
+	if err := mailer.Send(transport, x, invoiceTemplateSet(em.Context)); err != nil {
+		log.Printf("Error while sending email: %+v\n", err)
 
-		return err
+		return "", err
 	}
 
+	return x.MessageID, nil
+}
+
+// emailNoticeHandler sends a notice without attachments to a customer, such as
+// the end of a trial
+func emailNoticeHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		CustomerID  string             `json:"customerID"`
+		ProductCode string             `json:"productCode"`
+		EmailTo     string             `json:"emailTo"`
+		Recipients  contact.Recipients `json:"recipients"`
+		Subject     string             `json:"subject"`
+		Message     string             `json:"message"`
+		Locale      string             `json:"locale"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Unable to parse request body", http.StatusBadRequest)
+		return
+	}
+	if requestBody.EmailTo == "" || requestBody.Subject == "" || requestBody.Message == "" {
+		http.Error(w, "emailTo, subject and message are required", http.StatusBadRequest)
+		return
+	}
+	if requestBody.Recipients.IsZero() {
+		requestBody.Recipients.To = []string{requestBody.EmailTo}
+	}
+	if err := requestBody.Recipients.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := email.ParseLocale(requestBody.Locale); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	x := email.Message{
+		From:     os.Getenv("FROM_EMAIL"),
+		FromName: os.Getenv("FROM_NAME"),
+		To:       requestBody.Recipients.To,
+		CC:       requestBody.Recipients.CC,
+		BCC:      requestBody.Recipients.BCC,
+		ReplyTo:  requestBody.Recipients.ReplyTo,
+		Subject:  requestBody.Subject,
+		Data:     requestBody.Message,
+		Template: "notice",
+		Locale:   requestBody.Locale,
+	}
+	x = withLogo(x)
+	if err := mailer.Send(transport, x, ""); err != nil {
+		// Notices are not stored, the caller sends them again later
+		if t, ok := email.IsThrottled(err); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(t.RetryAfter.Seconds()))))
+			http.Error(w, "Notice deferred by the rate limits", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Error while sending notice to customer %s for product %s: %+v\n", requestBody.CustomerID, requestBody.ProductCode, err)
+		http.Error(w, "Error sending notice", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Sent notice %q to customer %s for product %s\n", requestBody.Subject, requestBody.CustomerID, requestBody.ProductCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Notice sent"})
+}
+
+// verifyInvoiceSignature refuses invoices which are not signed or whose signature
+// no longer matches the content
+func verifyInvoiceSignature(invoiceID string, b []byte) error {
+	cert, err := pdf.VerifySignature(b)
+	if err != nil {
+		return fmt.Errorf("invalid invoice signature: %v", err)
+	}
+	log.Printf("Invoice %s signed by %s\n", invoiceID, cert.Subject)
+
 	return nil
 }
 
@@ -362,8 +680,8 @@ func callDoneURL(url string, payload map[string]interface{}) error {
 	// Set request headers
 	req.Header.Set("Content-Type", "application/json")
 
-	// Create HTTP client
-	client := &http.Client{}
+	// Create HTTP client, a service which does not answer does not hold up the caller
+	client := &http.Client{Timeout: 10 * time.Second}
 
 	// Send HTTP request
 	resp, err := client.Do(req)
@@ -393,6 +711,10 @@ func getEmailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The email is sent again on request, but not while another delivery of it runs
+	unlock := lockRecord(id)
+	defer unlock()
+
 	// Retrieve the database record for the provided id
 	em, err := retrieveRecord(id)
 	if err != nil {
@@ -407,12 +729,21 @@ func getEmailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// sent email invoice
-	if err := sendEmail(*em); err != nil {
+	messageID, err := sendEmail(*em)
+	if err != nil {
+		if t, ok := email.IsThrottled(err); ok {
+			deferEmail(id, t.RetryAfter)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Invoice email deferred by the rate limits"})
+			return
+		}
+
 		log.Printf("Error calling sendEmail: %v\n", err)
 		failedAt := time.Now().UTC().Format(time.DateTime)
 		// update database set failedAt
-		if _, err := db.Exec("UPDATE emails SET failedAt = ?, invoiceSentAt = NULL WHERE id = ?", failedAt, id); err != nil {
-			log.Printf("Error while `UPDATE emails SET failedAt = %s, invoiceSentAt = NULL WHERE id = %d`: %+v\n", failedAt, id, err)
+		if _, err := db.Exec("UPDATE emails SET failedAt = ?, invoiceSentAt = NULL, deferredUntil = NULL WHERE id = ?", failedAt, id); err != nil {
+			log.Printf("Error while `UPDATE emails SET failedAt = %s, invoiceSentAt = NULL, deferredUntil = NULL WHERE id = %d`: %+v\n", failedAt, id, err)
 		}
 
 		// call the doneURL with a failedMessage, status
@@ -430,8 +761,8 @@ func getEmailInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 
 	// update database set failedAt null and invoiceSentAt now
 	invoiceSentAt := time.Now().UTC().Format(time.DateTime)
-	if _, err := db.Exec("UPDATE emails SET invoiceSentAt = ?, failedAt = NULL WHERE id = ?", invoiceSentAt, id); err != nil {
-		log.Printf("Error while `UPDATE emails SET invoiceSentAt = %s, failedAt = NULL WHERE id = %d`: %+v\n", invoiceSentAt, id, err)
+	if _, err := db.Exec("UPDATE emails SET invoiceSentAt = ?, messageID = ?, deliveryStatus = '', deliveryStatusAt = NULL, failedAt = NULL, deferredUntil = NULL WHERE id = ?", invoiceSentAt, messageID, id); err != nil {
+		log.Printf("Error while `UPDATE emails SET invoiceSentAt = %s, messageID = %s, deliveryStatus = '', deliveryStatusAt = NULL, failedAt = NULL, deferredUntil = NULL WHERE id = %d`: %+v\n", invoiceSentAt, messageID, id, err)
 
 		http.Error(w, "", http.StatusInternalServerError)
 		return
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/arifmahmudrana/invoice/migrate"
+)
+
+// migrations upgrade the tables created by older versions of the service to
+// the schema created in main, they are run at startup before serving
+var migrations = []migrate.Migration{
+	{
+		Version:     1,
+		Description: "deferred sends",
+		Steps: []migrate.Step{
+			migrate.AddColumn("emails", "deferredUntil", "datetime DEFAULT NULL AFTER failedAt"),
+			migrate.AddIndex("emails", "deferredUntil", "deferredUntil"),
+		},
+	},
+	{
+		Version:     2,
+		Description: "billing contacts",
+		Steps: []migrate.Step{
+			migrate.AddColumn("emails", "recipients", "text AFTER emailTo"),
+		},
+	},
+	{
+		Version:     3,
+		Description: "email template context",
+		Steps: []migrate.Step{
+			migrate.AddColumn("emails", "invoiceContext", "text AFTER recipients"),
+		},
+	},
+	{
+		Version:     4,
+		Description: "delivery tracking",
+		Steps: []migrate.Step{
+			migrate.AddColumn("emails", "messageID", "varchar(255) NOT NULL DEFAULT '' AFTER deferredUntil"),
+			migrate.AddColumn("emails", "deliveryStatus", "varchar(32) NOT NULL DEFAULT '' AFTER messageID"),
+			migrate.AddColumn("emails", "deliveryStatusAt", "datetime DEFAULT NULL AFTER deliveryStatus"),
+			migrate.AddIndex("emails", "messageID", "messageID"),
+		},
+	},
+	{
+		Version:     5,
+		Description: "invoice PDFs in the database",
+		Steps: []migrate.Step{
+			migrate.AddColumn("emails", "invoicePDF", "longblob AFTER fileHash"),
+			loadInvoicePDFs,
+		},
+	},
+	{
+		Version:     6,
+		Description: "retried bounce reports",
+		Steps: []migrate.Step{
+			migrate.AddColumn("email_events", "bounceReportedAt", "datetime DEFAULT NULL AFTER occurredAt"),
+			// the hard bounces recorded before were reported when they were recorded
+			migrate.Exec("UPDATE email_events SET bounceReportedAt = occurredAt WHERE event = 'hard_bounce' AND bounceReportedAt IS NULL"),
+		},
+	},
+}
+
+// loadInvoicePDFs stores the invoice PDFs older versions of the service kept in
+// PDF_PATH in the emails table, it does nothing when PDF_PATH is not set and
+// skips emails whose PDF is gone
+func loadInvoicePDFs(db *sql.DB) error {
+	dir := os.Getenv("PDF_PATH")
+	if dir == "" {
+		return nil
+	}
+
+	rows, err := db.Query("SELECT id, fileHash FROM emails WHERE invoicePDF IS NULL")
+	if err != nil {
+		return fmt.Errorf("error getting emails without invoice PDF: %v", err)
+	}
+	hashes := make(map[int]string)
+	for rows.Next() {
+		var (
+			id       int
+			fileHash string
+		)
+		if err := rows.Scan(&id, &fileHash); err != nil {
+			rows.Close()
+			return fmt.Errorf("error getting emails without invoice PDF: %v", err)
+		}
+		hashes[id] = fileHash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error getting emails without invoice PDF: %v", err)
+	}
+
+	for id, fileHash := range hashes {
+		b, err := os.ReadFile(filepath.Join(dir, fileHash, "invoice.pdf"))
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Invoice PDF of email %d not found in %s\n", id, dir)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading invoice PDF of email %d: %v", id, err)
+		}
+		if _, err := db.Exec("UPDATE emails SET invoicePDF = ? WHERE id = ?", b, id); err != nil {
+			return fmt.Errorf("error storing invoice PDF of email %d: %v", id, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// queueInterval is how often the queue looks for deferred emails which are due
+const queueInterval = 5 * time.Second
+
+// queueBatch is the number of deferred emails sent at most per interval
+const queueBatch = 100
+
+// deferEmail queues the email of the record to be sent again after the delay
+func deferEmail(id int, after time.Duration) {
+	deferredUntil := time.Now().UTC().Add(after).Format(time.DateTime)
+	if _, err := db.Exec("UPDATE emails SET deferredUntil = ?, failedAt = NULL WHERE id = ?", deferredUntil, id); err != nil {
+		log.Printf("Error while `UPDATE emails SET deferredUntil = %s, failedAt = NULL WHERE id = %d`: %+v\n", deferredUntil, id, err)
+		return
+	}
+	log.Printf("Deferred email %d until %s\n", id, deferredUntil)
+}
+
+// bounceRetry is how long the reports of hard bounces wait after one failed
+const bounceRetry = time.Minute
+
+// processDeferredEmails sends the deferred emails which are due and reports the
+// hard bounces until stop is closed, emails throttled again are deferred again
+func processDeferredEmails(stop <-chan struct{}) {
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+
+	var reportBouncesAt time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if time.Now().After(reportBouncesAt) {
+			if err := reportHardBounces(); err != nil {
+				log.Printf("Error calling reportHardBounces: %v\n", err)
+				reportBouncesAt = time.Now().Add(bounceRetry)
+			}
+		}
+
+		ids, err := dueEmails()
+		if err != nil {
+			log.Printf("Error calling dueEmails: %v\n", err)
+			continue
+		}
+		for _, id := range ids {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			deliverInvoiceEmail(id, true)
+		}
+	}
+}
+
+// dueEmails returns the IDs of the deferred emails which are due, the longest
+// deferred first
+func dueEmails() ([]int, error) {
+	now := time.Now().UTC().Format(time.DateTime)
+	rows, err := db.Query("SELECT id FROM emails WHERE deferredUntil <= ? AND invoiceSentAt IS NULL ORDER BY deferredUntil ASC LIMIT ?", now, queueBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/arifmahmudrana/invoice/contact"
+)
+
+// parseRecipients parses the JSON recipients of an invoice email, the email is
+// the recipient when there are none
+func parseRecipients(s, emailTo string) (contact.Recipients, error) {
+	var r contact.Recipients
+	if s != "" {
+		if err := json.Unmarshal([]byte(s), &r); err != nil {
+			return r, fmt.Errorf("invalid recipients: %v", err)
+		}
+	}
+	if r.IsZero() {
+		r.To = []string{emailTo}
+	}
+
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// invoiceRecipients returns the recipients of an invoice email with the archive
+// copy of EMAIL_ARCHIVE_BCC and the EMAIL_REPLY_TO address when the recipients
+// do not set one. Records stored before recipients were kept are sent to their
+// email.
+func invoiceRecipients(r contact.Recipients, emailTo string) contact.Recipients {
+	if len(r.To) == 0 {
+		r.To = []string{emailTo}
+	}
+	for _, a := range strings.Split(os.Getenv("EMAIL_ARCHIVE_BCC"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			r.BCC = append(r.BCC[:len(r.BCC):len(r.BCC)], a)
+		}
+	}
+	if r.ReplyTo == "" {
+		r.ReplyTo = os.Getenv("EMAIL_REPLY_TO")
+	}
+	return r
+}
+
+// checkRecipientDefaults checks the addresses of EMAIL_ARCHIVE_BCC and
+// EMAIL_REPLY_TO
+func checkRecipientDefaults() error {
+	if s := os.Getenv("EMAIL_ARCHIVE_BCC"); s != "" {
+		if _, err := mail.ParseAddressList(s); err != nil {
+			return fmt.Errorf("invalid EMAIL_ARCHIVE_BCC: %v", err)
+		}
+	}
+	if s := os.Getenv("EMAIL_REPLY_TO"); s != "" {
+		if _, err := mail.ParseAddress(s); err != nil {
+			return fmt.Errorf("invalid EMAIL_REPLY_TO: %v", err)
+		}
+	}
+	return nil
+}
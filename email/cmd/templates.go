@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/email"
+)
+
+// templateRegistry holds the parsed default templates and the templates of
+// EMAIL_TEMPLATE_PATH layered over them
+var templateRegistry *email.Registry
+
+// logo is the image of EMAIL_LOGO_PATH shown inline at the top of the emails,
+// nil when the emails have no logo
+var logo *email.Attachment
+
+// subjectTemplate renders the subject of the invoice emails from EMAIL_SUBJECT
+// with the invoice as .invoice
+var subjectTemplate *template.Template
+
+// parseSubject parses the subject of the invoice emails as a template
+func parseSubject(s string) (*template.Template, error) {
+	t, err := template.New("subject").Option("missingkey=zero").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %v", err)
+	}
+	return t, nil
+}
+
+// parseLogo reads the logo of the emails, which the templates refer to by the
+// name of the inline attachment as .logo
+func parseLogo(path string) (*email.Attachment, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	contentType := mime.TypeByExtension(ext)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("logo %s is not an image", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading logo: %v", err)
+	}
+
+	return &email.Attachment{Name: "logo" + ext, ContentType: contentType, Data: b, Inline: true}, nil
+}
+
+// withLogo adds the logo to the attachments and the template data of a message
+func withLogo(msg email.Message) email.Message {
+	if logo == nil {
+		return msg
+	}
+
+	msg.Attachments = append(msg.Attachments, *logo)
+	if msg.DataMap == nil {
+		msg.DataMap = make(map[string]any)
+	}
+	msg.DataMap["logo"] = logo.Name
+	return msg
+}
+
+// parseInvoiceContext parses the JSON invoice of an invoice email, records of
+// callers which do not send one are rendered with the fields of the request
+func parseInvoiceContext(s string, em Email) (email.InvoiceContext, error) {
+	var c email.InvoiceContext
+	if s != "" {
+		if err := json.Unmarshal([]byte(s), &c); err != nil {
+			return c, fmt.Errorf("invalid context: %v", err)
+		}
+	}
+	setInvoiceContextDefaults(&c, em)
+
+	locale, err := email.ParseLocale(c.Locale)
+	if err != nil {
+		return c, err
+	}
+	c.Locale = locale
+	return c, nil
+}
+
+// setInvoiceContextDefaults fills the invoice with the fields of the record,
+// which are kept by records stored before invoices were
+func setInvoiceContextDefaults(c *email.InvoiceContext, em Email) {
+	if c.InvoiceID == "" {
+		c.InvoiceID = em.InvoiceID
+	}
+	if c.ProductCode == "" {
+		c.ProductCode = em.ProductCode
+	}
+	if c.CustomerID == "" {
+		c.CustomerID = em.CustomerID
+	}
+}
+
+// invoiceSubject renders the subject of the invoice email
+func invoiceSubject(c email.InvoiceContext) (string, error) {
+	var b bytes.Buffer
+	if err := subjectTemplate.Execute(&b, map[string]any{"invoice": c}); err != nil {
+		return "", fmt.Errorf("error rendering subject: %v", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// invoiceTemplateSet returns the templates the invoice email is rendered with,
+// the most specific loaded template set of the seller and the product
+func invoiceTemplateSet(c email.InvoiceContext) string {
+	var names []string
+	if c.SellerID != "" && c.ProductCode != "" {
+		names = append(names, path.Join(c.SellerID, c.ProductCode))
+	}
+	return templateRegistry.TemplateSet(append(names, c.ProductCode, c.SellerID)...)
+}
+
+// parseTemplateReload parses how often the templates are checked for changes,
+// 0 when empty as they are not reloaded
+func parseTemplateReload(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid template reload interval: %q", s)
+	}
+	return d, nil
+}
+
+// watchTemplates reloads the templates every interval when their files
+// changed, templates which fail to load are logged and the loaded templates
+// are kept
+func watchTemplates(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := templateRegistry.Changed()
+		if err != nil {
+			log.Printf("Error checking templates of %s: %v\n", templateRegistry.Dir(), err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if err := templateRegistry.Reload(); err != nil {
+			log.Printf("Error reloading templates of %s, keeping the loaded templates: %v\n", templateRegistry.Dir(), err)
+			continue
+		}
+		log.Printf("Reloaded templates of %s\n", templateRegistry.Dir())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/email"
+)
+
+// transport delivers the emails of the service
+var transport email.Transport
+
+// mailer renders the emails of the service from the templates
+var mailer email.Mail
+
+// newTransport returns the transport selected by EMAIL_TRANSPORT limited to the
+// rate limits of the transport and of the sender domains
+func newTransport(kind string) (email.Transport, error) {
+	if kind == "" {
+		kind = "smtp"
+	}
+
+	t, err := selectTransport(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := email.ParseLimit(os.Getenv("EMAIL_RATE_LIMIT_" + strings.ToUpper(kind)))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EMAIL_RATE_LIMIT_%s: %v", strings.ToUpper(kind), err)
+	}
+	domains, err := email.ParseDomainLimits(os.Getenv("EMAIL_DOMAIN_RATE_LIMITS"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing EMAIL_DOMAIN_RATE_LIMITS: %v", err)
+	}
+
+	return email.NewRateLimited(t, limit, domains), nil
+}
+
+// selectTransport returns the transport of the kind, smtp to send the emails
+// to the SMTP server, file to write them to EMAIL_DROP_DIR or http to post them
+// to EMAIL_API_URL
+func selectTransport(kind string) (email.Transport, error) {
+	switch kind {
+	case "smtp":
+		mail, err := smtpMail()
+		if err != nil {
+			return nil, err
+		}
+		config, err := smtpPoolConfig()
+		if err != nil {
+			return nil, err
+		}
+		return email.NewSMTPPool(mail, config), nil
+	case "file":
+		return email.NewFileTransport(os.Getenv("EMAIL_DROP_DIR"))
+	case "http":
+		return email.NewHTTPTransport(os.Getenv("EMAIL_API_URL"), os.Getenv("EMAIL_API_KEY"))
+	default:
+		return nil, fmt.Errorf("unknown email transport: %q", kind)
+	}
+}
+
+// smtpMail returns the SMTP server configured by the environment
+func smtpMail() (email.Mail, error) {
+	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		log.Printf("Error while converting SMTP_PORT environment variable to int: %+v\n", err)
+		return email.Mail{}, err
+	}
+	mail := email.Mail{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     smtpPort,
+		Username: os.Getenv("SMTP_USER_NAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}
+	log.Printf("SMTP server: %s:%d\n", mail.Host, mail.Port)
+
+	return mail, nil
+}
+
+// smtpPoolConfig returns the SMTP pool configured by the environment, 4
+// connections closed after 30 seconds idle or 100 messages by default
+func smtpPoolConfig() (email.PoolConfig, error) {
+	config := email.PoolConfig{Size: 4, IdleTimeout: 30 * time.Second, MaxMessages: 100}
+
+	if s := os.Getenv("SMTP_POOL_SIZE"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 {
+			return config, fmt.Errorf("invalid SMTP_POOL_SIZE: %q", s)
+		}
+		config.Size = size
+	}
+	if s := os.Getenv("SMTP_IDLE_TIMEOUT"); s != "" {
+		timeout, err := time.ParseDuration(s)
+		if err != nil || timeout < 0 {
+			return config, fmt.Errorf("invalid SMTP_IDLE_TIMEOUT: %q", s)
+		}
+		config.IdleTimeout = timeout
+	}
+	if s := os.Getenv("SMTP_MAX_MESSAGES_PER_CONN"); s != "" {
+		max, err := strconv.Atoi(s)
+		if err != nil || max < 0 {
+			return config, fmt.Errorf("invalid SMTP_MAX_MESSAGES_PER_CONN: %q", s)
+		}
+		config.MaxMessages = max
+	}
+
+	return config, nil
+}
@@ -0,0 +1,80 @@
+package email
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// InvoiceContext is the invoice an email is rendered for, it is available to
+// the templates as .invoice
+type InvoiceContext struct {
+	InvoiceID    string `json:"invoiceID"`
+	ProductCode  string `json:"productCode"`
+	SellerID     string `json:"sellerID"`
+	CustomerID   string `json:"customerID"`
+	CustomerName string `json:"customerName"`
+	Description  string `json:"description"`
+	// The dates are formatted as on the invoice, such as "Jan 02, 2006"
+	InvoiceDate string       `json:"invoiceDate"`
+	PeriodStart string       `json:"periodStart"`
+	PeriodEnd   string       `json:"periodEnd"`
+	AmountDue   money.Amount `json:"amountDue"`
+	DueDate     string       `json:"dueDate"`
+	// PayURL is the link the invoice is paid at, empty when it is not paid online
+	PayURL string `json:"payURL,omitempty"`
+	// Locale is the language tag of the customer, such as fr or de-AT
+	Locale string `json:"locale,omitempty"`
+}
+
+// Period returns the billing period of the invoice, such as "Jan 01, 2024 - Jan 31, 2024",
+// empty when it is not known
+func (c InvoiceContext) Period() string {
+	if c.PeriodStart == "" || c.PeriodEnd == "" {
+		return ""
+	}
+	return c.PeriodStart + " - " + c.PeriodEnd
+}
+
+// Amount returns the amount due with the symbol of its currency, such as "€10.50"
+func (c InvoiceContext) Amount() string {
+	if c.AmountDue.Currency() == "" {
+		return c.AmountDue.Decimal()
+	}
+	return money.Symbol(c.AmountDue.Currency()) + c.AmountDue.Decimal()
+}
+
+// Value implements the driver.Valuer interface.
+func (c InvoiceContext) Value() (driver.Value, error) {
+	v, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(v), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (c *InvoiceContext) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*c = InvoiceContext{}
+		return nil
+	case []byte:
+		if len(v) == 0 {
+			*c = InvoiceContext{}
+			return nil
+		}
+		return json.Unmarshal(v, c)
+	case string:
+		if v == "" {
+			*c = InvoiceContext{}
+			return nil
+		}
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("unsupported type for InvoiceContext: %T", value)
+	}
+}
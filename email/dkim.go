@@ -0,0 +1,78 @@
+package email
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/toorop/go-dkim"
+)
+
+// dkimHeaders are the headers covered by the DKIM signature
+var dkimHeaders = []string{"from", "to", "subject", "date", "mime-version", "content-type"}
+
+// DKIM signs the messages of a domain with the private key of a selector, the
+// public key is published in the TXT record <selector>._domainkey.<domain>
+type DKIM struct {
+	Domain   string
+	Selector string
+	key      []byte
+}
+
+// NewDKIM returns the signer of the domain with the PEM encoded RSA private key,
+// PKCS #1 or PKCS #8. The key is checked by signing a test message.
+func NewDKIM(domain, selector string, key []byte) (*DKIM, error) {
+	if domain == "" {
+		return nil, errors.New("empty DKIM domain")
+	}
+	if selector == "" {
+		return nil, errors.New("empty DKIM selector")
+	}
+
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("DKIM private key is not PEM encoded")
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing DKIM private key: %v", err)
+		}
+		if _, ok := k.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("DKIM private key is a %T, not an RSA key", k)
+		}
+	}
+
+	d := &DKIM{Domain: domain, Selector: selector, key: key}
+	msg := []byte("From: dkim@" + domain + "\r\nSubject: test\r\n\r\ntest\r\n")
+	if err := dkim.Sign(&msg, d.options()); err != nil {
+		return nil, fmt.Errorf("error signing with DKIM private key: %v", err)
+	}
+
+	return d, nil
+}
+
+// LoadDKIM is like NewDKIM with the private key read from the file
+func LoadDKIM(domain, selector, keyPath string) (*DKIM, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading DKIM private key: %v", err)
+	}
+
+	return NewDKIM(domain, selector, key)
+}
+
+// options returns the signature options, relaxed canonicalization survives the
+// whitespace changes of relaying servers
+func (d *DKIM) options() dkim.SigOptions {
+	options := dkim.NewSigOptions()
+	options.PrivateKey = d.key
+	options.Domain = d.Domain
+	options.Selector = d.Selector
+	options.Canonicalization = "relaxed/relaxed"
+	options.Headers = append([]string(nil), dkimHeaders...)
+	return options
+}
@@ -0,0 +1,195 @@
+package email
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ErrNotReport is returned by ParseReport for messages which are neither a
+// delivery status notification nor a feedback report, such as auto replies
+var ErrNotReport = errors.New("not a delivery status notification or feedback report")
+
+// ParseReport parses the delivery events of a bounce message, a delivery status
+// notification of RFC 3464 or a spam complaint in the feedback report format of
+// RFC 5965. The events refer to the Message-ID of the returned email, which is
+// empty when the report does not include its headers.
+func ParseReport(r io.Reader) ([]Event, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return nil, ErrNotReport
+	}
+	reportType := strings.ToLower(params["report-type"])
+	if reportType != "delivery-status" && reportType != "feedback-report" {
+		return nil, ErrNotReport
+	}
+
+	// Events without a date of their own happened when the report was sent,
+	// or when it is read when it has no date
+	received := time.Now().UTC()
+	if t, err := msg.Header.Date(); err == nil {
+		received = t.UTC()
+	}
+
+	var (
+		fields   []textproto.MIMEHeader
+		original textproto.MIMEHeader
+	)
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading report: %v", err)
+		}
+
+		var body io.Reader = p
+		if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+			body = base64.NewDecoder(base64.StdEncoding, p)
+		}
+
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status", "message/feedback-report":
+			if fields, err = readFieldGroups(body); err != nil {
+				return nil, fmt.Errorf("error reading %s: %v", partType, err)
+			}
+		case "message/rfc822", "text/rfc822-headers", "message/rfc822-headers", "message/global", "message/global-headers":
+			// Only the headers of the returned email are read, it may be cut off
+			original, _ = textproto.NewReader(bufio.NewReader(body)).ReadMIMEHeader()
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("report without delivery status or feedback report")
+	}
+
+	var messageID string
+	if original != nil {
+		messageID = trimMessageID(original.Get("Message-Id"))
+	}
+
+	if reportType == "feedback-report" {
+		return feedbackEvents(fields[0], original, messageID, received), nil
+	}
+	return deliveryStatusEvents(fields, messageID, received), nil
+}
+
+// deliveryStatusEvents returns the events of the recipients of a delivery
+// status, the fields of the message followed by the fields of every recipient
+func deliveryStatusEvents(fields []textproto.MIMEHeader, messageID string, received time.Time) []Event {
+	if t, ok := parseReportDate(fields[0].Get("Arrival-Date")); ok {
+		received = t
+	}
+
+	var events []Event
+	for _, f := range fields[1:] {
+		e := Event{
+			MessageID:  messageID,
+			Recipient:  addressOf(f.Get("Final-Recipient")),
+			Status:     strings.TrimSpace(f.Get("Status")),
+			Diagnostic: strings.TrimSpace(f.Get("Diagnostic-Code")),
+			Time:       received,
+		}
+		if e.Recipient == "" {
+			e.Recipient = addressOf(f.Get("Original-Recipient"))
+		}
+		if t, ok := parseReportDate(f.Get("Last-Attempt-Date")); ok {
+			e.Time = t
+		}
+		// The type of the diagnostic, such as smtp, is not part of the diagnostic
+		if i := strings.Index(e.Diagnostic, ";"); i >= 0 {
+			e.Diagnostic = strings.TrimSpace(e.Diagnostic[i+1:])
+		}
+
+		switch strings.ToLower(strings.TrimSpace(f.Get("Action"))) {
+		case "failed":
+			e.Type = bounceType(e.Status)
+		case "delayed":
+			e.Type = EventSoftBounce
+		case "delivered", "relayed", "expanded":
+			e.Type = EventDelivered
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+
+	return events
+}
+
+// feedbackEvents returns the complaint of a feedback report, the recipient is
+// the one of the returned email when the report does not name it
+func feedbackEvents(fields, original textproto.MIMEHeader, messageID string, received time.Time) []Event {
+	e := Event{
+		Type:       EventComplained,
+		MessageID:  messageID,
+		Recipient:  addressOf(fields.Get("Original-Rcpt-To")),
+		Diagnostic: strings.TrimSpace(fields.Get("Feedback-Type")),
+		Time:       received,
+	}
+	if e.Recipient == "" && original != nil {
+		if a, err := mail.ParseAddress(original.Get("To")); err == nil {
+			e.Recipient = a.Address
+		}
+	}
+	if t, ok := parseReportDate(fields.Get("Arrival-Date")); ok {
+		e.Time = t
+	}
+
+	return []Event{e}
+}
+
+// readFieldGroups reads the groups of header fields of a delivery status or
+// feedback report, which are separated by empty lines
+func readFieldGroups(r io.Reader) ([]textproto.MIMEHeader, error) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	var groups []textproto.MIMEHeader
+	for {
+		h, err := tp.ReadMIMEHeader()
+		if len(h) > 0 {
+			groups = append(groups, h)
+		}
+		if err == io.EOF {
+			return groups, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// addressOf returns the address of a recipient field such as
+// "rfc822; jane@example.com"
+func addressOf(s string) string {
+	if i := strings.Index(s, ";"); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.Trim(strings.TrimSpace(s), "<>")
+}
+
+// parseReportDate parses a date of a report, which are dates of RFC 5322
+func parseReportDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := mail.ParseDate(s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
@@ -0,0 +1,191 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// report returns a multipart/report of the type with the part, followed by
+// the headers of the returned email
+func report(reportType, partType, part string) string {
+	return strings.ReplaceAll(`From: Mail Delivery System <MAILER-DAEMON@mx.example.net>
+To: billing@example.com
+Subject: Delivery Status Notification
+Date: Mon, 18 Mar 2024 10:00:00 +0000
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=`+reportType+`; boundary="BOUNDARY"
+
+--BOUNDARY
+Content-Type: text/plain
+
+This is an automatically generated report.
+
+--BOUNDARY
+Content-Type: `+partType+`
+
+`+part+`
+--BOUNDARY
+Content-Type: text/rfc822-headers
+
+From: billing@example.com
+To: Jane Doe <jane@example.com>
+Subject: Invoice INV:1
+Message-ID: <1@example.com>
+
+--BOUNDARY--
+`, "\n", "\r\n")
+}
+
+// deliveryStatus returns the delivery status of RFC 3464 of a recipient with
+// the action and status
+func deliveryStatus(action, status, diagnostic string) string {
+	s := `Reporting-MTA: dns; mx.example.net
+Arrival-Date: Mon, 18 Mar 2024 09:59:00 +0000
+
+Final-Recipient: rfc822; jane@example.com
+Action: ` + action + `
+Status: ` + status + `
+`
+	if diagnostic != "" {
+		s += "Diagnostic-Code: smtp; " + diagnostic + "\n"
+	}
+	return s + "Last-Attempt-Date: Mon, 18 Mar 2024 09:59:30 +0000\n"
+}
+
+func TestParseReport(t *testing.T) {
+	attempt := time.Date(2024, 3, 18, 9, 59, 30, 0, time.UTC)
+	tests := []struct {
+		name           string
+		msg            string
+		wantType       EventType
+		wantStatus     string
+		wantDiagnostic string
+		wantTime       time.Time
+	}{
+		{
+			name:     "delivered",
+			msg:      report("delivery-status", "message/delivery-status", deliveryStatus("delivered", "2.0.0", "")),
+			wantType: EventDelivered, wantStatus: "2.0.0", wantTime: attempt,
+		},
+		{
+			name:     "delayed",
+			msg:      report("delivery-status", "message/delivery-status", deliveryStatus("delayed", "4.4.7", "451 4.4.7 Delivery time expired")),
+			wantType: EventSoftBounce, wantStatus: "4.4.7", wantDiagnostic: "451 4.4.7 Delivery time expired", wantTime: attempt,
+		},
+		{
+			name:     "failed unknown user",
+			msg:      report("delivery-status", "message/delivery-status", deliveryStatus("failed", "5.1.1", "550 5.1.1 User unknown")),
+			wantType: EventHardBounce, wantStatus: "5.1.1", wantDiagnostic: "550 5.1.1 User unknown", wantTime: attempt,
+		},
+		{
+			name:     "failed mailbox full",
+			msg:      report("delivery-status", "message/delivery-status", deliveryStatus("failed", "5.2.2", "552 5.2.2 Mailbox full")),
+			wantType: EventSoftBounce, wantStatus: "5.2.2", wantDiagnostic: "552 5.2.2 Mailbox full", wantTime: attempt,
+		},
+		{
+			name: "feedback",
+			msg: report("feedback-report", "message/feedback-report", `Feedback-Type: abuse
+User-Agent: ExampleFBL/1.0
+Version: 1
+Original-Rcpt-To: <jane@example.com>
+Arrival-Date: Mon, 18 Mar 2024 09:00:00 +0000
+`),
+			wantType: EventComplained, wantDiagnostic: "abuse", wantTime: time.Date(2024, 3, 18, 9, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := ParseReport(strings.NewReader(tt.msg))
+			if err != nil {
+				t.Fatalf("ParseReport() error = %v", err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1: %+v", len(events), events)
+			}
+
+			e := events[0]
+			if e.Type != tt.wantType {
+				t.Errorf("type = %s, want %s", e.Type, tt.wantType)
+			}
+			if e.MessageID != "1@example.com" {
+				t.Errorf("message ID = %q, want 1@example.com", e.MessageID)
+			}
+			if e.Recipient != "jane@example.com" {
+				t.Errorf("recipient = %q, want jane@example.com", e.Recipient)
+			}
+			if e.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", e.Status, tt.wantStatus)
+			}
+			if e.Diagnostic != tt.wantDiagnostic {
+				t.Errorf("diagnostic = %q, want %q", e.Diagnostic, tt.wantDiagnostic)
+			}
+			if !e.Time.Equal(tt.wantTime) {
+				t.Errorf("time = %v, want %v", e.Time, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestParseReportRecipients(t *testing.T) {
+	status := `Reporting-MTA: dns; mx.example.net
+
+Final-Recipient: rfc822; jane@example.com
+Action: failed
+Status: 5.1.1
+
+Original-Recipient: rfc822; john@example.com
+Final-Recipient: rfc822; <>
+Action: delivered
+Status: 2.0.0
+
+Final-Recipient: rfc822; ops@example.com
+Action: unknown
+Status: 2.0.0
+`
+	events, err := ParseReport(strings.NewReader(report("delivery-status", "message/delivery-status", status)))
+	if err != nil {
+		t.Fatalf("ParseReport() error = %v", err)
+	}
+
+	// recipients with an unknown action are skipped, an empty final recipient
+	// falls back to the original one, events without a date have the report's
+	want := []struct {
+		recipient string
+		typ       EventType
+	}{
+		{"jane@example.com", EventHardBounce},
+		{"john@example.com", EventDelivered},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		if events[i].Recipient != w.recipient || events[i].Type != w.typ {
+			t.Errorf("events[%d] = %s %s, want %s %s", i, events[i].Recipient, events[i].Type, w.recipient, w.typ)
+		}
+		if !events[i].Time.Equal(time.Date(2024, 3, 18, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("events[%d] time = %v, want the date of the report", i, events[i].Time)
+		}
+	}
+}
+
+func TestParseReportNotReport(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"plain message", "From: jane@example.com\r\nSubject: Out of office\r\nContent-Type: text/plain\r\n\r\nI am away.\r\n"},
+		{"other report type", report("disposition-notification", "message/disposition-notification", "Disposition: manual-action/MDN-sent-manually; displayed\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseReport(strings.NewReader(tt.msg)); !errors.Is(err, ErrNotReport) {
+				t.Errorf("ParseReport() error = %v, want ErrNotReport", err)
+			}
+		})
+	}
+}
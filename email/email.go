@@ -3,7 +3,7 @@ package email
 import (
 	"bytes"
 	"html/template"
-	"time"
+	texttemplate "text/template"
 
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
@@ -11,6 +11,7 @@ import (
 
 // Mail holds the information necessary to connect to an SMTP server
 type Mail struct {
+	// Domain is the domain messages are signed for with LoadDKIM
 	Domain      string
 	Host        string
 	Port        int
@@ -19,22 +20,73 @@ type Mail struct {
 	Encryption  string
 	FromAddress string
 	FromName    string
+	// Templates are the parsed templates messages are rendered with, the
+	// templates are parsed from the template directory of every message when nil
+	Templates *Registry
+	// dkim signs the messages when set
+	dkim *DKIM
+}
+
+// LoadDKIM signs the messages of the mail for its domain with the selector and
+// the private key of the file
+func (m *Mail) LoadDKIM(selector, keyPath string) error {
+	d, err := LoadDKIM(m.Domain, selector, keyPath)
+	if err != nil {
+		return err
+	}
+
+	m.dkim = d
+	return nil
 }
 
 // Message is the type for an email message
 type Message struct {
-	From        string
-	FromName    string
-	To          string
+	From     string
+	FromName string
+	To       []string
+	CC       []string
+	BCC      []string
+	// ReplyTo is the address replies go to, From when not set
+	ReplyTo     string
 	Subject     string
-	Attachments []string
+	Attachments []Attachment
 	Data        any
 	DataMap     map[string]any
+	// Invoice is the invoice the email is sent for, nil for other emails
+	Invoice *InvoiceContext
+	// Template is the name of the template the email is rendered with,
+	// DefaultTemplate when empty
+	Template string
+	// Locale is the language tag of the recipient, such as de-AT, the default
+	// templates are used when there is no template for it
+	Locale string
+	// MessageID is the Message-ID of the email without angle brackets, which
+	// delivery events refer to, see NewMessageID. None is set when empty.
+	MessageID string
 }
 
 // SendSMTPMessage builds and sends an email message using SMTP. This is called by ListenForMail,
 // and can also be called directly when necessary
 func (m *Mail) SendSMTPMessage(msg Message, tmpPath string) error {
+	return m.Send(NewSMTPTransport(*m), msg, tmpPath)
+}
+
+// Send builds the email message and sends it with the transport, see Build for
+// the template path
+func (m *Mail) Send(t Transport, msg Message, tmpPath string) error {
+	r, err := m.Build(msg, tmpPath)
+	if err != nil {
+		return err
+	}
+
+	return t.Send(r)
+}
+
+// Build renders the templates of the email message, the sender defaults to the
+// sender of the mail. The template path is the template set of the registry of
+// the mail, or the template directory layered over the default templates when
+// the mail has no registry.
+func (m *Mail) Build(msg Message, tmpPath string) (Rendered, error) {
 	if msg.From == "" {
 		msg.From = m.FromAddress
 	}
@@ -47,87 +99,78 @@ func (m *Mail) SendSMTPMessage(msg Message, tmpPath string) error {
 		msg.DataMap = make(map[string]any)
 	}
 	msg.DataMap["message"] = msg.Data
+	if msg.Invoice != nil {
+		msg.DataMap["invoice"] = *msg.Invoice
+	}
 
-	formattedMessage, err := m.buildHTMLMessage(msg, tmpPath)
+	t, err := m.templates(msg, tmpPath)
 	if err != nil {
-		return err
+		return Rendered{}, err
 	}
 
-	plainMessage, err := m.buildPlainTextMessage(msg, tmpPath)
+	formattedMessage, err := m.buildHTMLMessage(msg, t.html)
 	if err != nil {
-		return err
+		return Rendered{}, err
 	}
 
-	server := mail.NewSMTPClient()
-	server.Host = m.Host
-	server.Port = m.Port
-	server.Username = m.Username
-	server.Password = m.Password
-	server.Encryption = m.getEncryption(m.Encryption)
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-
-	smtpClient, err := server.Connect()
+	plainMessage, err := m.buildPlainTextMessage(msg, t.plain)
 	if err != nil {
-		return err
+		return Rendered{}, err
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(msg.From).
-		AddTo(msg.To).
-		SetSubject(msg.Subject)
-
-	email.SetBody(mail.TextPlain, plainMessage)
-	email.AddAlternative(mail.TextHTML, formattedMessage)
+	attachments, err := loadAttachments(msg.Attachments)
+	if err != nil {
+		return Rendered{}, err
+	}
+
+	return Rendered{
+		From:        msg.From,
+		FromName:    msg.FromName,
+		To:          msg.To,
+		CC:          msg.CC,
+		BCC:         msg.BCC,
+		ReplyTo:     msg.ReplyTo,
+		Subject:     msg.Subject,
+		MessageID:   msg.MessageID,
+		PlainBody:   plainMessage,
+		HTMLBody:    formattedMessage,
+		Attachments: attachments,
+		dkim:        m.dkim,
+	}, nil
+}
 
-	// add attachments, if any
-	if len(msg.Attachments) > 0 {
-		for _, x := range msg.Attachments {
-			email.AddAttachment(x)
-		}
+// templates returns the template of the message from the template path
+func (m *Mail) templates(msg Message, tmpPath string) (templates, error) {
+	if m.Templates != nil {
+		return m.Templates.lookup(tmpPath, msg.Template, msg.Locale)
 	}
 
-	err = email.Send(smtpClient)
+	r, err := NewRegistry(tmpPath)
 	if err != nil {
-		return err
+		return templates{}, err
 	}
-
-	return nil
+	return r.lookup("", msg.Template, msg.Locale)
 }
 
 // buildHTMLMessage creates the html version of the message
-func (m *Mail) buildHTMLMessage(msg Message, tmpPath string) (string, error) {
-	templateToRender := tmpPath + "/mail.html.tmpl"
-
-	t, err := template.New("email-html").ParseFiles(templateToRender)
-	if err != nil {
-		return "", err
-	}
-
+func (m *Mail) buildHTMLMessage(msg Message, t *template.Template) (string, error) {
 	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
+	if err := t.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
 		return "", err
 	}
 
-	formattedMessage := tpl.String()
-	formattedMessage, err = m.inlineCSS(formattedMessage)
+	formattedMessage, err := m.inlineCSS(tpl.String())
 	if err != nil {
 		return "", err
 	}
 	return formattedMessage, nil
 }
 
-// buildPlainTextMessage creates the plaintext version of the message
-func (m *Mail) buildPlainTextMessage(msg Message, tmpPath string) (string, error) {
-	templateToRender := tmpPath + "/mail.plain.tmpl"
-	t, err := template.New("email-plain").ParseFiles(templateToRender)
-	if err != nil {
-		return "", err
-	}
-
+// buildPlainTextMessage creates the plaintext version of the message, it is not
+// HTML escaped
+func (m *Mail) buildPlainTextMessage(msg Message, t *texttemplate.Template) (string, error) {
 	var tplPlain bytes.Buffer
-	if err = t.ExecuteTemplate(&tplPlain, "body", msg.DataMap); err != nil {
+	if err := t.ExecuteTemplate(&tplPlain, "body", msg.DataMap); err != nil {
 		return "", err
 	}
 
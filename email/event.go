@@ -0,0 +1,100 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventType is what happened to an email after the server accepted it
+type EventType string
+
+const (
+	// EventDelivered is an email delivered to the mailbox of the recipient
+	EventDelivered EventType = "delivered"
+	// EventSoftBounce is an email which was not delivered for now, such as to
+	// a full mailbox, and may be delivered later
+	EventSoftBounce EventType = "soft_bounce"
+	// EventHardBounce is an email which cannot be delivered, such as to an
+	// unknown mailbox
+	EventHardBounce EventType = "hard_bounce"
+	// EventComplained is an email the recipient reported as spam
+	EventComplained EventType = "complained"
+)
+
+// ParseEventType parses the type of a delivery event
+func ParseEventType(s string) (EventType, error) {
+	switch t := EventType(s); t {
+	case EventDelivered, EventSoftBounce, EventHardBounce, EventComplained:
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid event type: %q", s)
+}
+
+// Final reports whether the event is the last word on the recipient, later
+// deliveries or soft bounces do not replace it as the status of the email
+func (t EventType) Final() bool {
+	return t == EventHardBounce || t == EventComplained
+}
+
+// Event is a delivery event of an email, reported by the email API or by a
+// bounce report of a mail server
+type Event struct {
+	Type EventType `json:"type"`
+	// MessageID is the Message-ID of the email without angle brackets
+	MessageID string `json:"messageID"`
+	Recipient string `json:"recipient"`
+	// Status is the enhanced status code of RFC 3463 of bounces, such as 5.1.1
+	Status     string    `json:"status,omitempty"`
+	Diagnostic string    `json:"diagnostic,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Validate checks the event can be matched to an email
+func (e Event) Validate() error {
+	if _, err := ParseEventType(string(e.Type)); err != nil {
+		return err
+	}
+	if e.MessageID == "" {
+		return errors.New("empty message ID")
+	}
+	if e.Recipient == "" {
+		return errors.New("empty recipient")
+	}
+	if e.Time.IsZero() {
+		return errors.New("empty time")
+	}
+	return nil
+}
+
+// bounceType classifies a failed delivery by its enhanced status code, a
+// permanent failure is a hard bounce except for a full mailbox
+func bounceType(status string) EventType {
+	if strings.HasPrefix(status, "5.") && !strings.HasSuffix(status, ".2.2") {
+		return EventHardBounce
+	}
+	return EventSoftBounce
+}
+
+// NewMessageID returns a unique Message-ID, without angle brackets, in the
+// domain of the sender address
+func NewMessageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		domain = strings.TrimSuffix(from[i+1:], ">")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b) + "@" + domain
+}
+
+// trimMessageID returns the Message-ID of a header without angle brackets
+func trimMessageID(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "<"), ">")
+}
@@ -0,0 +1,69 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fileSeq tells apart the files of messages written in the same nanosecond
+var fileSeq atomic.Uint64
+
+// FileTransport writes every email message as an .eml file to a directory, to
+// look at the messages locally without sending them
+type FileTransport struct {
+	Dir string
+}
+
+// NewFileTransport returns a transport writing to the directory, which is
+// created when it does not exist
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("empty email directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating email directory: %v", err)
+	}
+
+	return &FileTransport{Dir: dir}, nil
+}
+
+// Send implements the Transport interface, the file is named after the time it
+// is written and the first recipient. BCC recipients are not in the file.
+func (t *FileTransport) Send(r Rendered) error {
+	b, err := r.MIME()
+	if err != nil {
+		return err
+	}
+
+	var to string
+	if len(r.To) > 0 {
+		to = r.To[0]
+		if a, err := mail.ParseAddress(to); err == nil {
+			to = a.Address
+		}
+	}
+	to = strings.Map(func(c rune) rune {
+		if c == '/' || c == '\\' || c == os.PathSeparator {
+			return '_'
+		}
+		return c
+	}, to)
+	name := fmt.Sprintf("%d-%d-%s.eml", time.Now().UnixNano(), fileSeq.Add(1), to)
+
+	// Write to a temporary file first so readers of the directory never see a
+	// partial message
+	path := filepath.Join(t.Dir, name)
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return fmt.Errorf("error writing email: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error writing email: %v", err)
+	}
+
+	return nil
+}
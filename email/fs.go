@@ -0,0 +1,69 @@
+package email
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// defaultTemplates are the templates the email package is built with
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// DefaultTemplates returns the templates the email package is built with
+func DefaultTemplates() fs.FS {
+	sub, err := fs.Sub(defaultTemplates, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// overlayFS layers the files of the upper file system over the files of the
+// lower one, a file of the upper file system hides the file of the same name
+// in the lower one and the directories of both are merged
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+// Open implements the fs.FS interface.
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.lower.Open(name)
+}
+
+// ReadDir implements the fs.ReadDirFS interface.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, errUpper := fs.ReadDir(o.upper, name)
+	if errUpper != nil && !errors.Is(errUpper, fs.ErrNotExist) {
+		return nil, errUpper
+	}
+	lower, errLower := fs.ReadDir(o.lower, name)
+	if errLower != nil && !errors.Is(errLower, fs.ErrNotExist) {
+		return nil, errLower
+	}
+	if errUpper != nil && errLower != nil {
+		return nil, errUpper
+	}
+
+	entries := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, e := range lower {
+		entries[e.Name()] = e
+	}
+	for _, e := range upper {
+		entries[e.Name()] = e
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
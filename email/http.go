@@ -0,0 +1,118 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPTransport posts email messages as JSON to the send endpoint of an email
+// API, or of a local stand-in of it
+type HTTPTransport struct {
+	URL string
+	// APIKey is sent as a bearer token when set
+	APIKey string
+	Client *http.Client
+}
+
+// NewHTTPTransport returns a transport posting to the URL
+func NewHTTPTransport(url, apiKey string) (*HTTPTransport, error) {
+	if url == "" {
+		return nil, fmt.Errorf("empty email API URL")
+	}
+
+	return &HTTPTransport{
+		URL:    url,
+		APIKey: apiKey,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// httpAttachment is an attachment in the body of the request, its content is
+// base64 encoded. The HTML body refers to inline attachments by their content
+// ID, which is their file name.
+type httpAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Content     []byte `json:"content"`
+	ContentID   string `json:"contentID,omitempty"`
+}
+
+// httpMessage is the body of the request
+type httpMessage struct {
+	From        string           `json:"from"`
+	FromName    string           `json:"fromName,omitempty"`
+	To          []string         `json:"to"`
+	CC          []string         `json:"cc,omitempty"`
+	BCC         []string         `json:"bcc,omitempty"`
+	ReplyTo     string           `json:"replyTo,omitempty"`
+	Subject     string           `json:"subject"`
+	MessageID   string           `json:"messageID,omitempty"`
+	Text        string           `json:"text"`
+	HTML        string           `json:"html"`
+	Attachments []httpAttachment `json:"attachments,omitempty"`
+}
+
+// Send implements the Transport interface, any status other than 2xx fails
+func (t *HTTPTransport) Send(r Rendered) error {
+	body := httpMessage{
+		From:      r.From,
+		FromName:  r.FromName,
+		To:        r.To,
+		CC:        r.CC,
+		BCC:       r.BCC,
+		ReplyTo:   r.ReplyTo,
+		Subject:   r.Subject,
+		MessageID: r.MessageID,
+		Text:      r.PlainBody,
+		HTML:      r.HTMLBody,
+	}
+	for _, a := range r.Attachments {
+		x := httpAttachment{Filename: a.Name, ContentType: a.ContentType, Content: a.Data}
+		if a.Inline {
+			x.ContentID = a.Name
+		}
+		body.Attachments = append(body.Attachments, x)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error marshaling email: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.APIKey)
+	}
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The API throttles the sends
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &ThrottledError{RetryAfter: time.Duration(retryAfter) * time.Second, Reason: "email API returned 429"}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package email
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// localeRe matches a BCP 47 language tag such as de, de-at or zh-hant-tw
+var localeRe = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// ParseLocale parses a language tag such as de-AT or de_AT, the locale is
+// returned in lower case with hyphens. An empty locale is the default locale.
+func ParseLocale(s string) (string, error) {
+	l := strings.ToLower(strings.ReplaceAll(s, "_", "-"))
+	if l != "" && !localeRe.MatchString(l) {
+		return "", fmt.Errorf("invalid locale: %q", s)
+	}
+	return l, nil
+}
+
+// localeFallbacks returns the locales templates are looked up by, from the
+// locale to the default locale, such as de-at, de and the default locale
+func localeFallbacks(locale string) []string {
+	var locales []string
+	for l := locale; l != ""; {
+		locales = append(locales, l)
+		i := strings.LastIndex(l, "-")
+		if i < 0 {
+			break
+		}
+		l = l[:i]
+	}
+	return append(locales, "")
+}
@@ -0,0 +1,55 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLocale(t *testing.T) {
+	tests := []struct {
+		locale  string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"de", "de", false},
+		{"de-AT", "de-at", false},
+		{"de_AT", "de-at", false},
+		{"zh-Hant-TW", "zh-hant-tw", false},
+		{"d", "", true},
+		{"de-", "", true},
+		{"../de", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			got, err := ParseLocale(tt.locale)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLocale() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLocale() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocaleFallbacks(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   []string
+	}{
+		{"", []string{""}},
+		{"de", []string{"de", ""}},
+		{"de-at", []string{"de-at", "de", ""}},
+		{"zh-hant-tw", []string{"zh-hant-tw", "zh-hant", "zh", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			if got := localeFallbacks(tt.locale); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("localeFallbacks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package email
+
+import "sync"
+
+// MemoryTransport keeps the email messages sent in memory instead of delivering
+// them, for tests
+type MemoryTransport struct {
+	mu   sync.Mutex
+	sent []Rendered
+	// Err is returned by Send when set, the message is not kept
+	Err error
+}
+
+// Send implements the Transport interface
+func (t *MemoryTransport) Send(r Rendered) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Err != nil {
+		return t.Err
+	}
+	t.sent = append(t.sent, r)
+	return nil
+}
+
+// Sent returns the messages sent so far in the order they were sent
+func (t *MemoryTransport) Sent() []Rendered {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Rendered(nil), t.sent...)
+}
+
+// Reset forgets the messages sent so far
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = nil
+}
@@ -0,0 +1,280 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// ErrPoolClosed is returned by the sends of a closed SMTP pool
+var ErrPoolClosed = errors.New("smtp pool closed")
+
+// PoolConfig configures an SMTP pool
+type PoolConfig struct {
+	// Size is the maximum number of open connections, 1 when not set
+	Size int
+	// IdleTimeout closes connections unused for longer, never when not set
+	IdleTimeout time.Duration
+	// MaxMessages is the number of messages sent over a connection before it
+	// is replaced by a new one, unlimited when not set
+	MaxMessages int
+}
+
+// pooledConn is an open SMTP connection of a pool
+type pooledConn struct {
+	client   *mail.SMTPClient
+	conn     *countingConn
+	sent     int
+	lastUsed time.Time
+}
+
+// countingConn counts the bytes read from the server, so a send can tell
+// whether the server answered any of its commands
+type countingConn struct {
+	net.Conn
+	read atomic.Int64
+}
+
+// Read implements the net.Conn interface
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// SMTPPool sends email messages to the SMTP server of the mail over a pool of
+// kept alive connections, it is safe for concurrent use
+type SMTPPool struct {
+	mail   Mail
+	config PoolConfig
+	// slots limits the number of open connections
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*pooledConn
+	closed bool
+	done   chan struct{}
+}
+
+// NewSMTPPool returns a pool of connections to the SMTP server of the mail,
+// connections are opened when needed
+func NewSMTPPool(m Mail, config PoolConfig) *SMTPPool {
+	if config.Size <= 0 {
+		config.Size = 1
+	}
+
+	p := &SMTPPool{
+		mail:   m,
+		config: config,
+		slots:  make(chan struct{}, config.Size),
+		done:   make(chan struct{}),
+	}
+	if config.IdleTimeout > 0 {
+		go p.closeIdle()
+	}
+
+	return p
+}
+
+// Send implements the Transport interface. A kept alive connection which no
+// longer answers is replaced by a new one before the message is sent. When the
+// connection is dropped before the server answered the first command of the
+// message, which was therefore not sent, it is sent once more over a new one.
+func (p *SMTPPool) Send(r Rendered) error {
+	email, err := r.message()
+	if err != nil {
+		return err
+	}
+
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	conn, err := p.get()
+	if err != nil {
+		return err
+	}
+
+	read := conn.conn.read.Load()
+	err = email.Send(conn.client)
+	if err != nil && conn.conn.read.Load() == read && dropped(err) {
+		conn.client.Close()
+		if conn, err = p.dial(); err != nil {
+			return err
+		}
+		err = email.Send(conn.client)
+	}
+	if err != nil {
+		// The state of the connection is unknown after a failed send
+		conn.client.Close()
+		return fmt.Errorf("error sending to %s: %w", strings.Join(r.To, ", "), err)
+	}
+
+	conn.sent++
+	p.put(conn)
+	return nil
+}
+
+// Close closes the idle connections and fails later sends, sends in progress
+// close their connection when they are done.
+func (p *SMTPPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	close(p.done)
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		p.discard(conn)
+	}
+	return nil
+}
+
+// get returns an idle connection which still answers or opens a new one
+func (p *SMTPPool) get() (*pooledConn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.expired(conn, time.Now()) {
+			p.discard(conn)
+			continue
+		}
+		// The server may have dropped the connection while it was idle
+		if err := conn.client.Noop(); err != nil {
+			conn.client.Close()
+			continue
+		}
+		return conn, nil
+	}
+
+	return p.dial()
+}
+
+// put returns the connection to the pool, or closes it once it sent its
+// maximum number of messages
+func (p *SMTPPool) put(conn *pooledConn) {
+	if p.config.MaxMessages > 0 && conn.sent >= p.config.MaxMessages {
+		p.discard(conn)
+		return
+	}
+	conn.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.discard(conn)
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+// dial opens and authenticates a new connection
+func (p *SMTPPool) dial() (*pooledConn, error) {
+	address := net.JoinHostPort(p.mail.Host, strconv.Itoa(p.mail.Port))
+	nc, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn := &countingConn{Conn: nc}
+
+	server := mail.NewSMTPClient()
+	server.Host = p.mail.Host
+	server.Port = p.mail.Port
+	server.Username = p.mail.Username
+	server.Password = p.mail.Password
+	server.Encryption = p.mail.getEncryption(p.mail.Encryption)
+	server.KeepAlive = true
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+	// The bytes are counted below TLS, the client only starts TLS over its own
+	// connections
+	server.CustomConn = conn
+	if server.Encryption == mail.EncryptionSSLTLS {
+		server.CustomConn = tls.Client(conn, &tls.Config{ServerName: p.mail.Host})
+	}
+
+	client, err := server.Connect()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return &pooledConn{client: client, conn: conn, lastUsed: time.Now()}, nil
+}
+
+// dropped tells whether the send failed as the connection was closed or broken
+func dropped(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// discard says goodbye to the server and closes the connection
+func (p *SMTPPool) discard(conn *pooledConn) {
+	conn.client.Quit()
+	conn.client.Close()
+}
+
+// expired tells whether the connection was idle for longer than the timeout
+func (p *SMTPPool) expired(conn *pooledConn, now time.Time) bool {
+	return p.config.IdleTimeout > 0 && now.Sub(conn.lastUsed) > p.config.IdleTimeout
+}
+
+// closeIdle closes the connections idle for longer than the timeout until the
+// pool is closed
+func (p *SMTPPool) closeIdle() {
+	interval := p.config.IdleTimeout / 2
+	if interval <= 0 {
+		interval = p.config.IdleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			var expired []*pooledConn
+			p.mu.Lock()
+			idle := p.idle[:0]
+			for _, conn := range p.idle {
+				if p.expired(conn, now) {
+					expired = append(expired, conn)
+				} else {
+					idle = append(idle, conn)
+				}
+			}
+			p.idle = idle
+			p.mu.Unlock()
+
+			for _, conn := range expired {
+				p.discard(conn)
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package email
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is an SMTP server which accepts every message, it counts the
+// connections, messages and goodbyes of its clients
+type smtpServer struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	conns    int
+	messages int
+	quits    int
+	// dropMail is the number of MAIL commands the connection is closed at
+	// instead of answering them
+	dropMail int
+	// dropData is the number of messages the connection is closed at once they
+	// are received instead of accepting them
+	dropData int
+}
+
+// newSMTPServer starts an SMTP server which is stopped when the test is done
+func newSMTPServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpServer{ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+// mail returns a mail sending to the server
+func (s *smtpServer) mail() Mail {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return Mail{Host: addr.IP.String(), Port: addr.Port}
+}
+
+// counts returns the number of connections, messages and goodbyes
+func (s *smtpServer) counts() (conns, messages, quits int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, s.messages, s.quits
+}
+
+// drop tells whether the connection is closed at the command and counts it
+func (s *smtpServer) drop(n *int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *n == 0 {
+		return false
+	}
+	*n--
+	return true
+}
+
+func (s *smtpServer) serve(c net.Conn) {
+	defer c.Close()
+	tp := textproto.NewConn(c)
+
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(line); {
+		case strings.HasPrefix(cmd, "EHLO"):
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 8BITMIME")
+		case strings.HasPrefix(cmd, "MAIL"):
+			if s.drop(&s.dropMail) {
+				return
+			}
+			tp.PrintfLine("250 2.1.0 OK")
+		case strings.HasPrefix(cmd, "RCPT"):
+			tp.PrintfLine("250 2.1.5 OK")
+		case cmd == "DATA":
+			tp.PrintfLine("354 Go ahead")
+			if _, err := tp.ReadDotBytes(); err != nil {
+				return
+			}
+			if s.drop(&s.dropData) {
+				return
+			}
+			s.mu.Lock()
+			s.messages++
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.0.0 Queued")
+		case cmd == "RSET", cmd == "NOOP":
+			tp.PrintfLine("250 2.0.0 OK")
+		case cmd == "QUIT":
+			s.mu.Lock()
+			s.quits++
+			s.mu.Unlock()
+			tp.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			tp.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// waitFor waits a second at most for the condition
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSMTPPoolReuse(t *testing.T) {
+	s := newSMTPServer(t)
+	p := NewSMTPPool(s.mail(), PoolConfig{Size: 1})
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Send(rendered()); err != nil {
+			t.Fatalf("Send() %d error = %v", i, err)
+		}
+	}
+	if conns, messages, _ := s.counts(); conns != 1 || messages != 3 {
+		t.Errorf("got %d connections and %d messages, want 1 and 3", conns, messages)
+	}
+
+	p.Close()
+	waitFor(t, "the connection to be closed", func() bool {
+		_, _, quits := s.counts()
+		return quits == 1
+	})
+}
+
+func TestSMTPPoolMaxMessages(t *testing.T) {
+	s := newSMTPServer(t)
+	p := NewSMTPPool(s.mail(), PoolConfig{Size: 1, MaxMessages: 2})
+	defer p.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := p.Send(rendered()); err != nil {
+			t.Fatalf("Send() %d error = %v", i, err)
+		}
+	}
+	// the connections which sent 2 messages were closed
+	if conns, messages, quits := s.counts(); conns != 3 || messages != 5 || quits != 2 {
+		t.Errorf("got %d connections, %d messages and %d goodbyes, want 3, 5 and 2", conns, messages, quits)
+	}
+}
+
+func TestSMTPPoolIdleTimeout(t *testing.T) {
+	s := newSMTPServer(t)
+	p := NewSMTPPool(s.mail(), PoolConfig{Size: 1, IdleTimeout: 50 * time.Millisecond})
+	defer p.Close()
+
+	if err := p.Send(rendered()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	waitFor(t, "the idle connection to be closed", func() bool {
+		_, _, quits := s.counts()
+		return quits == 1
+	})
+
+	if err := p.Send(rendered()); err != nil {
+		t.Fatalf("Send() after the idle timeout error = %v", err)
+	}
+	if conns, messages, _ := s.counts(); conns != 2 || messages != 2 {
+		t.Errorf("got %d connections and %d messages, want 2 and 2", conns, messages)
+	}
+}
+
+func TestSMTPPoolDroppedConnection(t *testing.T) {
+	tests := []struct {
+		name         string
+		dropMail     int
+		dropData     int
+		wantErr      bool
+		wantConns    int
+		wantMessages int
+	}{
+		// the message was not sent, it is sent over a new connection
+		{name: "before the message", dropMail: 1, wantConns: 2, wantMessages: 2},
+		// it is only sent once more
+		{name: "before the message again", dropMail: 2, wantErr: true, wantConns: 2, wantMessages: 1},
+		// the server may have received the message, it is not sent again
+		{name: "after the message", dropData: 1, wantErr: true, wantConns: 1, wantMessages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSMTPServer(t)
+			p := NewSMTPPool(s.mail(), PoolConfig{Size: 1})
+			defer p.Close()
+
+			if err := p.Send(rendered()); err != nil {
+				t.Fatalf("first Send() error = %v", err)
+			}
+
+			s.mu.Lock()
+			s.dropMail, s.dropData = tt.dropMail, tt.dropData
+			s.mu.Unlock()
+			if err := p.Send(rendered()); (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, want error %v", err, tt.wantErr)
+			}
+			if conns, messages, _ := s.counts(); conns != tt.wantConns || messages != tt.wantMessages {
+				t.Errorf("got %d connections and %d messages, want %d and %d", conns, messages, tt.wantConns, tt.wantMessages)
+			}
+		})
+	}
+}
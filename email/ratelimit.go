@@ -0,0 +1,296 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minBackoff is the first pause after the provider throttled a message
+	minBackoff = time.Second
+	// maxBackoff limits the pause after repeated throttling
+	maxBackoff = 10 * time.Minute
+	// transientRetry is the pause before a message which got a transient reply
+	// other than throttling is sent again, other messages are still sent
+	transientRetry = time.Minute
+)
+
+// ThrottledError is returned for a message which was not sent because of a rate
+// limit, because the provider throttled it or because of a transient reply, it
+// can be sent again after RetryAfter
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Reason     string
+}
+
+// Error implements the error interface
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("email throttled: %s, retry after %s", e.Reason, e.RetryAfter)
+}
+
+// IsThrottled returns the throttling error err wraps, if any
+func IsThrottled(err error) (*ThrottledError, bool) {
+	var t *ThrottledError
+	if errors.As(err, &t) {
+		return t, true
+	}
+	return nil, false
+}
+
+// Rate is a number of messages per period, such as 10 per second
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// Limit holds the rates messages are sent at, all of them apply
+type Limit []Rate
+
+// ParseLimit parses a comma separated list of rates such as "10/s,3600/h", the
+// periods are s, m and h. An empty string has no limit.
+func ParseLimit(s string) (Limit, error) {
+	var limit Limit
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		count, unit, ok := strings.Cut(part, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate: %q", part)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid rate: %q", part)
+		}
+
+		var per time.Duration
+		switch unit {
+		case "s":
+			per = time.Second
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return nil, fmt.Errorf("invalid rate period: %q", part)
+		}
+		limit = append(limit, Rate{Count: n, Per: per})
+	}
+
+	return limit, nil
+}
+
+// ParseDomainLimits parses the limits of sender domains separated by semicolons,
+// such as "example.com=2/s,500/h;example.org=10/s"
+func ParseDomainLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		domain, rates, ok := strings.Cut(part, "=")
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("invalid domain limit: %q", part)
+		}
+		limit, err := ParseLimit(rates)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit of %s: %v", domain, err)
+		}
+		limits[domain] = limit
+	}
+
+	return limits, nil
+}
+
+// bucket is a token bucket holding up to the count of the rate, refilled at the
+// rate
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// newBuckets returns full buckets of the rates of the limit
+func newBuckets(limit Limit, now time.Time) []*bucket {
+	buckets := make([]*bucket, 0, len(limit))
+	for _, r := range limit {
+		buckets = append(buckets, &bucket{rate: r, tokens: float64(r.Count), last: now})
+	}
+	return buckets
+}
+
+// wait refills the bucket and returns how long until it holds a token
+func (b *bucket) wait(now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() / b.rate.Per.Seconds() * float64(b.rate.Count)
+		if b.tokens > float64(b.rate.Count) {
+			b.tokens = float64(b.rate.Count)
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / float64(b.rate.Count) * float64(b.rate.Per))
+}
+
+// RateLimited sends email messages with a transport within the limits of the
+// transport and of the sender domains, it is safe for concurrent use. Messages
+// over a limit are not sent and fail with a ThrottledError, as do messages
+// throttled by the provider, after which sends are paused with an exponential
+// back off. Messages with another transient reply fail with a ThrottledError
+// too, without pausing the other sends.
+type RateLimited struct {
+	transport Transport
+	limit     Limit
+	domains   map[string]Limit
+
+	mu          sync.Mutex
+	buckets     []*bucket
+	domain      map[string][]*bucket
+	backoff     time.Duration
+	pausedUntil time.Time
+}
+
+// NewRateLimited returns the transport limited to the limit and the limits of
+// the sender domains
+func NewRateLimited(t Transport, limit Limit, domains map[string]Limit) *RateLimited {
+	now := time.Now()
+	return &RateLimited{
+		transport: t,
+		limit:     limit,
+		domains:   domains,
+		buckets:   newBuckets(limit, now),
+		domain:    make(map[string][]*bucket),
+	}
+}
+
+// Send implements the Transport interface
+func (t *RateLimited) Send(r Rendered) error {
+	if err := t.take(r, time.Now()); err != nil {
+		return err
+	}
+
+	err := t.transport.Send(r)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	retryAfter, throttled := throttling(err)
+	if !throttled {
+		if transient(err) {
+			return &ThrottledError{RetryAfter: transientRetry, Reason: err.Error()}
+		}
+		t.backoff = 0
+		return err
+	}
+
+	t.backoff *= 2
+	if t.backoff < minBackoff {
+		t.backoff = minBackoff
+	}
+	if t.backoff > maxBackoff {
+		t.backoff = maxBackoff
+	}
+	if retryAfter < t.backoff {
+		retryAfter = t.backoff
+	}
+	t.pausedUntil = time.Now().Add(retryAfter)
+
+	return &ThrottledError{RetryAfter: retryAfter, Reason: err.Error()}
+}
+
+// Close closes the transport when it can be closed
+func (t *RateLimited) Close() error {
+	if c, ok := t.transport.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// take takes a token of every bucket the message is limited by, or none of them
+// when one of them is empty
+func (t *RateLimited) take(r Rendered, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Before(t.pausedUntil) {
+		return &ThrottledError{RetryAfter: t.pausedUntil.Sub(now), Reason: "backing off from provider throttling"}
+	}
+
+	buckets := t.buckets
+	domain := senderDomain(r.From)
+	if limit, ok := t.domains[domain]; ok {
+		if _, ok := t.domain[domain]; !ok {
+			t.domain[domain] = newBuckets(limit, now)
+		}
+		buckets = append(buckets[:len(buckets):len(buckets)], t.domain[domain]...)
+	}
+
+	var wait time.Duration
+	for _, b := range buckets {
+		if w := b.wait(now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return &ThrottledError{RetryAfter: wait, Reason: "rate limit reached"}
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return nil
+}
+
+// throttling tells whether the provider throttled the sender, with a 421 SMTP
+// reply, a 451 reply with a 4.7.x policy status or a ThrottledError of the
+// transport, and how long it asked to wait
+func throttling(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	if t, ok := IsThrottled(err); ok {
+		return t.RetryAfter, true
+	}
+
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return 0, false
+	}
+	switch reply.Code {
+	case 421:
+		return 0, true
+	case 451:
+		return 0, strings.HasPrefix(strings.TrimSpace(reply.Msg), "4.7.")
+	}
+	return 0, false
+}
+
+// transient tells whether the message got a transient 4xx SMTP reply, such as
+// a full mailbox, which defers the message alone
+func transient(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 400 && reply.Code < 500
+}
+
+// senderDomain returns the lower case domain of the address
+func senderDomain(address string) string {
+	address = strings.TrimSuffix(strings.TrimSpace(address), ">")
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.ToLower(address[i+1:])
+	}
+	return ""
+}
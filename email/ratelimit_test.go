@@ -0,0 +1,89 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedReplies(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantRetry time.Duration
+		wantPause bool
+	}{
+		{"sent", nil, 0, false},
+		{"permanent", &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"}, 0, false},
+		{"service unavailable", &textproto.Error{Code: 421, Msg: "4.7.0 Too many connections"}, minBackoff, true},
+		{"policy", fmt.Errorf("error sending: %w", &textproto.Error{Code: 451, Msg: "4.7.1 Rate limited, try again later"}), minBackoff, true},
+		{"local error", &textproto.Error{Code: 451, Msg: "4.3.0 Local error in processing"}, transientRetry, false},
+		{"mailbox busy", &textproto.Error{Code: 450, Msg: "4.2.1 Mailbox busy"}, transientRetry, false},
+		{"mailbox full", &textproto.Error{Code: 452, Msg: "4.2.2 Mailbox full"}, transientRetry, false},
+		{"email API", &ThrottledError{RetryAfter: 30 * time.Second, Reason: "email API returned 429"}, 30 * time.Second, true},
+		{"other error", errors.New("connection refused"), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewRateLimited(&MemoryTransport{Err: tt.err}, nil, nil)
+
+			err := tr.Send(rendered())
+			throttled, ok := IsThrottled(err)
+			if ok != (tt.wantRetry > 0) {
+				t.Fatalf("Send() error = %v, want throttled %v", err, tt.wantRetry > 0)
+			}
+			if ok && throttled.RetryAfter != tt.wantRetry {
+				t.Errorf("RetryAfter = %v, want %v", throttled.RetryAfter, tt.wantRetry)
+			}
+			if tt.err != nil && !ok && err != tt.err {
+				t.Errorf("Send() error = %v, want %v", err, tt.err)
+			}
+
+			// only throttling of the sender pauses the other sends
+			tr.transport = &MemoryTransport{}
+			err = tr.Send(rendered())
+			if _, paused := IsThrottled(err); paused != tt.wantPause {
+				t.Errorf("next Send() error = %v, want paused %v", err, tt.wantPause)
+			}
+		})
+	}
+}
+
+func TestRateLimitedBackoff(t *testing.T) {
+	tr := NewRateLimited(&MemoryTransport{Err: &textproto.Error{Code: 421, Msg: "4.7.0 Try again later"}}, nil, nil)
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		tr.pausedUntil = time.Time{}
+		throttled, ok := IsThrottled(tr.Send(rendered()))
+		if !ok || throttled.RetryAfter != want {
+			t.Fatalf("Send() = %v, want a back off of %v", throttled, want)
+		}
+	}
+
+	// a successful send resets the back off
+	tr.pausedUntil = time.Time{}
+	tr.transport = &MemoryTransport{}
+	if err := tr.Send(rendered()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if tr.backoff != 0 {
+		t.Errorf("back off = %v after a successful send, want 0", tr.backoff)
+	}
+}
+
+func TestRateLimitedLimit(t *testing.T) {
+	tr := NewRateLimited(&MemoryTransport{}, Limit{{Count: 2, Per: time.Minute}}, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := tr.Send(rendered()); err != nil {
+			t.Fatalf("Send() %d error = %v", i, err)
+		}
+	}
+	throttled, ok := IsThrottled(tr.Send(rendered()))
+	if !ok || throttled.RetryAfter <= 0 || throttled.RetryAfter > 30*time.Second {
+		t.Errorf("Send() over the limit = %v, want throttled for up to 30s", throttled)
+	}
+}
@@ -0,0 +1,322 @@
+package email
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	texttemplate "text/template"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// DefaultTemplate is the name of the template emails fall back to
+const DefaultTemplate = "mail"
+
+// Registry holds the parsed templates of the template sets. The default
+// templates of the package are the root template set, the templates of a
+// template directory are layered over them and its subdirectories are
+// template sets of their own. The templates are parsed and checked once, and
+// replaced as a whole by Reload.
+//
+// A template is a pair of files named <name>.html.tmpl and <name>.plain.tmpl,
+// or <name>.<locale>.html.tmpl and <name>.<locale>.plain.tmpl for a locale,
+// which define the body template.
+type Registry struct {
+	dir   string
+	state atomic.Pointer[registryState]
+	// tried identifies the template files of the last load, whether it
+	// succeeded or failed
+	tried atomic.Pointer[string]
+}
+
+// registryState is a loaded version of the templates
+type registryState struct {
+	// sets are the templates of the template sets by their directory, the
+	// root template set is ""
+	sets map[string]templateSet
+}
+
+// templateSet holds the templates of a template set by their name and locale
+type templateSet map[string]templates
+
+// templates are the parsed templates of a template
+type templates struct {
+	html  *template.Template
+	plain *texttemplate.Template
+}
+
+// templateKey returns the key of the template of the name and locale in its
+// template set
+func templateKey(name, locale string) string {
+	if locale == "" {
+		return name
+	}
+	return name + "." + locale
+}
+
+// NewRegistry parses and checks the default templates and the templates of
+// the directory layered over them, the default templates only when the
+// directory is empty
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{}
+	if dir != "" {
+		r.dir = filepath.Clean(dir)
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Dir returns the template directory of the registry, empty when it only has
+// the default templates
+func (r *Registry) Dir() string {
+	return r.dir
+}
+
+// templateFS returns the default templates with the template directory layered
+// over them
+func (r *Registry) templateFS() fs.FS {
+	if r.dir == "" {
+		return DefaultTemplates()
+	}
+	return overlayFS{upper: os.DirFS(r.dir), lower: DefaultTemplates()}
+}
+
+// Reload parses the templates again and replaces the loaded templates with
+// them. The loaded templates are kept when any template fails.
+func (r *Registry) Reload() error {
+	stamp, err := r.stamp()
+	if err != nil {
+		return err
+	}
+	r.tried.Store(&stamp)
+
+	fsys := r.templateFS()
+	sets := make(map[string]templateSet)
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		set, err := parseTemplateSet(fsys, p)
+		if err != nil {
+			return fmt.Errorf("error parsing template set %s: %v", p, err)
+		}
+		if len(set) > 0 {
+			if p == "." {
+				p = ""
+			}
+			sets[p] = set
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, ok := sets[""][DefaultTemplate]; !ok {
+		return fmt.Errorf("no %s template", DefaultTemplate)
+	}
+
+	r.state.Store(&registryState{sets: sets})
+	return nil
+}
+
+// Changed reports whether template files of the template directory were
+// added, removed or modified since the templates were last loaded or failed
+// to load
+func (r *Registry) Changed() (bool, error) {
+	stamp, err := r.stamp()
+	if err != nil {
+		return false, err
+	}
+	return stamp != *r.tried.Load(), nil
+}
+
+// TemplateSet returns the first template set of the names, the root template
+// set "" when there is none. Names which are empty or leave the template
+// directory are skipped.
+func (r *Registry) TemplateSet(names ...string) string {
+	sets := r.state.Load().sets
+	for _, name := range names {
+		if name == "" || !filepath.IsLocal(name) {
+			continue
+		}
+
+		name = filepath.ToSlash(filepath.Clean(name))
+		if _, ok := sets[name]; ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// lookup returns the template of the name and the locale from the template
+// set. The template set falls back to the root template set, in every template
+// set the name falls back to the default template and for every name the
+// locale falls back from de-at to de to the default locale. A template of the
+// name is used before a localized default template, invoice before mail.de.
+func (r *Registry) lookup(set, name, locale string) (templates, error) {
+	if name == "" {
+		name = DefaultTemplate
+	}
+	locale, err := ParseLocale(locale)
+	if err != nil {
+		return templates{}, err
+	}
+
+	sets := []string{set}
+	if set != "" {
+		sets = append(sets, "")
+	}
+	names := []string{name}
+	if name != DefaultTemplate {
+		names = append(names, DefaultTemplate)
+	}
+
+	state := r.state.Load()
+	for _, s := range sets {
+		for _, n := range names {
+			for _, l := range localeFallbacks(locale) {
+				if t, ok := state.sets[s][templateKey(n, l)]; ok {
+					return t, nil
+				}
+			}
+		}
+	}
+
+	return templates{}, fmt.Errorf("no template %s in template set %q", name, set)
+}
+
+// parseTemplateSet parses the templates of the directory, a directory without
+// templates is an empty template set
+func parseTemplateSet(fsys fs.FS, dir string) (templateSet, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the files of every template by its key and kind
+	files := make(map[string]map[string]string)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".tmpl") {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSuffix(e.Name(), ".tmpl"), ".")
+		kind := parts[len(parts)-1]
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || (kind != "html" && kind != "plain") {
+			return nil, fmt.Errorf("unknown template file %s, expected <name>[.<locale>].html.tmpl or <name>[.<locale>].plain.tmpl", e.Name())
+		}
+		var locale string
+		if len(parts) == 3 {
+			if locale, err = ParseLocale(parts[1]); err != nil || locale == "" {
+				return nil, fmt.Errorf("invalid locale of template file %s", e.Name())
+			}
+		}
+
+		key := templateKey(parts[0], locale)
+		if files[key] == nil {
+			files[key] = make(map[string]string)
+		}
+		files[key][kind] = path.Join(dir, e.Name())
+	}
+
+	set := make(templateSet, len(files))
+	for key, f := range files {
+		if f["html"] == "" || f["plain"] == "" {
+			return nil, fmt.Errorf("template %s needs both an html and a plain file", key)
+		}
+
+		t, err := parseTemplates(fsys, f["html"], f["plain"])
+		if err != nil {
+			return nil, err
+		}
+		set[key] = t
+	}
+
+	return set, nil
+}
+
+// parseTemplates parses the html and plain files of a template and renders them
+// with sample data, so unknown fields of the invoice are found when they are
+// loaded rather than when an email is sent
+func parseTemplates(fsys fs.FS, htmlFile, plainFile string) (templates, error) {
+	var (
+		t   templates
+		err error
+	)
+	if t.html, err = template.New("email-html").ParseFS(fsys, htmlFile); err != nil {
+		return t, err
+	}
+	if t.plain, err = texttemplate.New("email-plain").ParseFS(fsys, plainFile); err != nil {
+		return t, err
+	}
+	if t.html.Lookup("body") == nil {
+		return t, fmt.Errorf("%s does not define body", htmlFile)
+	}
+	if t.plain.Lookup("body") == nil {
+		return t, fmt.Errorf("%s does not define body", plainFile)
+	}
+
+	sample := InvoiceContext{
+		InvoiceID:    "INV-0001",
+		CustomerName: "Sample Customer",
+		InvoiceDate:  "Jan 01, 2024",
+		PeriodStart:  "Jan 01, 2024",
+		PeriodEnd:    "Jan 31, 2024",
+		AmountDue:    money.MustParse("10.00", "USD"),
+		DueDate:      "Jan 15, 2024",
+		PayURL:       "https://example.com/pay",
+	}
+	for _, data := range []map[string]any{{"message": "Sample message"}, {"message": nil, "invoice": sample}} {
+		if err := t.html.ExecuteTemplate(io.Discard, "body", data); err != nil {
+			return t, err
+		}
+		if err := t.plain.ExecuteTemplate(io.Discard, "body", data); err != nil {
+			return t, err
+		}
+	}
+
+	return t, nil
+}
+
+// stamp returns the names, sizes and modification times of the template files
+// of the template directory, the default templates do not change
+func (r *Registry) stamp() (string, error) {
+	if r.dir == "" {
+		return "", nil
+	}
+
+	var files []string
+	err := fs.WalkDir(os.DirFS(r.dir), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".tmpl") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, fmt.Sprintf("%s %d %d", p, info.Size(), info.ModTime().UnixNano()))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error reading templates of %s: %v", r.dir, err)
+	}
+
+	return strings.Join(files, "\n"), nil
+}
@@ -0,0 +1,278 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemplate writes the html and plain files of the template with the body
+// to the directory
+func writeTemplate(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"html", "plain"} {
+		if err := os.WriteFile(filepath.Join(dir, name+"."+kind+".tmpl"), []byte(`{{define "body"}}`+body+`{{end}}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// body renders the plain body of the template the registry looks up
+func body(t *testing.T, r *Registry, set, name, locale string) string {
+	t.Helper()
+	tmpl, err := r.lookup(set, name, locale)
+	if err != nil {
+		t.Fatalf("lookup(%q, %q, %q) error = %v", set, name, locale, err)
+	}
+	var b strings.Builder
+	if err := tmpl.plain.ExecuteTemplate(&b, "body", map[string]any{"message": "Trial ends"}); err != nil {
+		t.Fatalf("rendering %q of %q error = %v", name, set, err)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func TestNewRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{name: "default templates"},
+		{name: "templates", files: map[string]string{
+			"invoice.html.tmpl":       `{{define "body"}}{{.invoice.InvoiceID}}{{end}}`,
+			"invoice.plain.tmpl":      `{{define "body"}}{{.invoice.InvoiceID}}{{end}}`,
+			"acme/mail.de.html.tmpl":  `{{define "body"}}Hallo{{end}}`,
+			"acme/mail.de.plain.tmpl": `{{define "body"}}Hallo{{end}}`,
+		}},
+		{name: "parse error", files: map[string]string{
+			"mail.html.tmpl":  `{{define "body"}}{{.message}{{end}}`,
+			"mail.plain.tmpl": `{{define "body"}}{{.message}}{{end}}`,
+		}, wantErr: "mail.html.tmpl"},
+		{name: "no body", files: map[string]string{
+			"mail.html.tmpl":  `{{define "content"}}{{.message}}{{end}}`,
+			"mail.plain.tmpl": `{{define "body"}}{{.message}}{{end}}`,
+		}, wantErr: "does not define body"},
+		{name: "unknown field", files: map[string]string{
+			"invoice.html.tmpl":  `{{define "body"}}{{with .invoice}}{{.Total}}{{end}}{{end}}`,
+			"invoice.plain.tmpl": `{{define "body"}}{{.message}}{{end}}`,
+		}, wantErr: "Total"},
+		{name: "no plain file", files: map[string]string{
+			"invoice.html.tmpl": `{{define "body"}}{{.message}}{{end}}`,
+		}, wantErr: "needs both an html and a plain file"},
+		{name: "unknown file", files: map[string]string{
+			"invoice.txt.tmpl": `{{define "body"}}{{.message}}{{end}}`,
+		}, wantErr: "unknown template file"},
+		{name: "invalid locale", files: map[string]string{
+			"mail.d.html.tmpl":  `{{define "body"}}{{.message}}{{end}}`,
+			"mail.d.plain.tmpl": `{{define "body"}}{{.message}}{{end}}`,
+		}, wantErr: "invalid locale"},
+		{name: "invalid template set", files: map[string]string{
+			"acme/invoice.html.tmpl": `{{define "body"}}{{.message}}{{end}}`,
+		}, wantErr: "template set acme"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dir string
+			if tt.files != nil {
+				dir = t.TempDir()
+				for name, content := range tt.files {
+					p := filepath.Join(dir, filepath.FromSlash(name))
+					if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			r, err := NewRegistry(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewRegistry() error = %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewRegistry() error = %v", err)
+			}
+			if got := body(t, r, "", "", ""); got != "Trial ends" {
+				t.Errorf("default template = %q, want the message", got)
+			}
+		})
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "invoice", "v1")
+
+	r, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	if got := body(t, r, "", "invoice", ""); got != "v1" {
+		t.Fatalf("invoice = %q, want v1", got)
+	}
+
+	writeTemplate(t, dir, "invoice", "v2")
+	writeTemplate(t, filepath.Join(dir, "acme"), "invoice", "acme v1")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := body(t, r, "", "invoice", ""); got != "v2" {
+		t.Errorf("invoice after Reload() = %q, want v2", got)
+	}
+	if got := r.TemplateSet("acme"); got != "acme" {
+		t.Errorf("TemplateSet(acme) after Reload() = %q, want acme", got)
+	}
+
+	// a broken template leaves the templates loaded before, of every set
+	writeTemplate(t, dir, "invoice", "v3")
+	writeTemplate(t, filepath.Join(dir, "acme"), "invoice", "{{.message")
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload() of a broken template succeeded")
+	}
+	if got := body(t, r, "", "invoice", ""); got != "v2" {
+		t.Errorf("invoice after a failed Reload() = %q, want v2", got)
+	}
+	if got := body(t, r, "acme", "invoice", ""); got != "acme v1" {
+		t.Errorf("acme invoice after a failed Reload() = %q, want acme v1", got)
+	}
+}
+
+func TestRegistryChanged(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "invoice", "v1")
+
+	r, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	changed := func(want bool, after string) {
+		t.Helper()
+		got, err := r.Changed()
+		if err != nil {
+			t.Fatalf("Changed() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("Changed() %s = %v, want %v", after, got, want)
+		}
+	}
+
+	changed(false, "after loading")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a template"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed(false, "after adding a file which is not a template")
+
+	writeTemplate(t, dir, "invoice", "version 2")
+	changed(true, "after modifying a template")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	changed(false, "after Reload()")
+
+	// a failed load is not tried again until the templates change again
+	writeTemplate(t, dir, "invoice", "{{.message")
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload() of a broken template succeeded")
+	}
+	changed(false, "after a failed Reload()")
+
+	if err := os.Remove(filepath.Join(dir, "invoice.plain.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	changed(true, "after removing a template")
+
+	// the default templates do not change
+	r, err = NewRegistry("")
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	changed(false, "of the default templates")
+}
+
+func TestRegistryLookup(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "mail.de", "mail.de")
+	writeTemplate(t, dir, "invoice", "invoice")
+	writeTemplate(t, dir, "invoice.de", "invoice.de")
+	writeTemplate(t, dir, "receipt", "receipt")
+	writeTemplate(t, filepath.Join(dir, "acme"), "invoice.de-at", "acme invoice.de-at")
+	writeTemplate(t, filepath.Join(dir, "acme"), "mail", "acme mail")
+
+	r, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	tests := []struct {
+		set, name, locale string
+		want              string
+	}{
+		{"", "", "", "Trial ends"},
+		{"", "", "de-at", "mail.de"},
+		{"", "", "fr", "Trial ends"},
+		{"", "invoice", "de-at", "invoice.de"},
+		{"", "invoice", "DE_AT", "invoice.de"},
+		{"", "invoice", "de", "invoice.de"},
+		{"", "invoice", "", "invoice"},
+		{"", "invoice", "fr-ch", "invoice"},
+		// the template of the name is used before the localized default one
+		{"", "receipt", "de", "receipt"},
+		{"", "notice", "de-ch", "mail.de"},
+		{"", "notice", "fr", "Trial ends"},
+		{"acme", "invoice", "de-at", "acme invoice.de-at"},
+		// the template set is searched before the root template set
+		{"acme", "invoice", "de", "acme mail"},
+		{"acme", "notice", "de-at", "acme mail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.set+"/"+tt.name+"."+tt.locale, func(t *testing.T) {
+			if got := body(t, r, tt.set, tt.name, tt.locale); got != tt.want {
+				t.Errorf("lookup(%q, %q, %q) = %q, want %q", tt.set, tt.name, tt.locale, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := r.lookup("", "invoice", "d"); err == nil {
+		t.Error("lookup() of an invalid locale succeeded")
+	}
+}
+
+func TestRegistryOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mail.html.tmpl"), []byte(`{{define "body"}}<p>{{.message}}</p>{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	tmpl, err := r.lookup("", "", "")
+	if err != nil {
+		t.Fatalf("lookup() error = %v", err)
+	}
+
+	// the html file of the directory shadows the default one, the default
+	// plain file is still used
+	var html strings.Builder
+	if err := tmpl.html.ExecuteTemplate(&html, "body", map[string]any{"message": "Trial ends"}); err != nil {
+		t.Fatalf("rendering html error = %v", err)
+	}
+	if got := html.String(); got != "<p>Trial ends</p>" {
+		t.Errorf("html = %q, want the template of the directory", got)
+	}
+	if got := body(t, r, "", "", ""); got != "Trial ends" {
+		t.Errorf("plain = %q, want the default template", got)
+	}
+}
@@ -0,0 +1,126 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Transport delivers rendered email messages, over SMTP, to a directory, to an
+// HTTP API or into memory
+type Transport interface {
+	Send(r Rendered) error
+}
+
+// Rendered is an email message with its templates rendered, ready to be sent
+type Rendered struct {
+	From      string
+	FromName  string
+	To        []string
+	CC        []string
+	BCC       []string
+	ReplyTo   string
+	Subject   string
+	MessageID string
+	PlainBody string
+	HTMLBody  string
+	// Attachments are loaded, their content is in Data
+	Attachments []Attachment
+	// dkim signs the message when set
+	dkim *DKIM
+}
+
+// message builds the MIME message of the rendered email
+func (r Rendered) message() (*mail.Email, error) {
+	email := mail.NewMSG()
+	email.SetFrom(r.From).
+		AddTo(r.To...).
+		SetSubject(r.Subject)
+	if len(r.CC) > 0 {
+		email.AddCc(r.CC...)
+	}
+	// BCC addresses are only given to the server, they are not in the headers
+	if len(r.BCC) > 0 {
+		email.AddBcc(r.BCC...)
+	}
+	if r.ReplyTo != "" {
+		email.SetReplyTo(r.ReplyTo)
+	}
+	if r.MessageID != "" {
+		email.AddHeader("Message-ID", "<"+r.MessageID+">")
+	}
+
+	email.SetBody(mail.TextPlain, r.PlainBody)
+	email.AddAlternative(mail.TextHTML, r.HTMLBody)
+
+	// add attachments, if any, the HTML body refers to the inline ones by
+	// cid:<name> which is replaced with their content ID
+	for _, x := range r.Attachments {
+		email.Attach(&mail.File{Name: x.Name, MimeType: x.ContentType, Data: x.Data, Inline: x.Inline})
+	}
+
+	if r.dkim != nil {
+		email.SetDkim(r.dkim.options())
+	}
+
+	if err := email.GetError(); err != nil {
+		return nil, err
+	}
+
+	return email, nil
+}
+
+// MIME returns the RFC 822 message of the rendered email
+func (r Rendered) MIME() ([]byte, error) {
+	email, err := r.message()
+	if err != nil {
+		return nil, err
+	}
+
+	if email.DkimMsg != "" {
+		return []byte(email.DkimMsg), nil
+	}
+	return []byte(email.GetMessage()), nil
+}
+
+// SMTPTransport sends email messages to the SMTP server of the mail, opening a
+// connection for every message
+type SMTPTransport struct {
+	mail Mail
+}
+
+// NewSMTPTransport returns a transport sending to the SMTP server of the mail
+func NewSMTPTransport(m Mail) *SMTPTransport {
+	return &SMTPTransport{mail: m}
+}
+
+// Send implements the Transport interface
+func (t *SMTPTransport) Send(r Rendered) error {
+	email, err := r.message()
+	if err != nil {
+		return err
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = t.mail.Host
+	server.Port = t.mail.Port
+	server.Username = t.mail.Username
+	server.Password = t.mail.Password
+	server.Encryption = t.mail.getEncryption(t.mail.Encryption)
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	smtpClient, err := server.Connect()
+	if err != nil {
+		return err
+	}
+
+	if err := email.Send(smtpClient); err != nil {
+		return fmt.Errorf("error sending to %s: %w", strings.Join(r.To, ", "), err)
+	}
+
+	return nil
+}
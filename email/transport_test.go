@@ -0,0 +1,214 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rendered is a message with a CC, a BCC and an inline attachment
+func rendered() Rendered {
+	return Rendered{
+		From:      "billing@example.com",
+		FromName:  "Example Ltd",
+		To:        []string{"Jane Doe <jane@example.com>"},
+		CC:        []string{"accounts@example.com"},
+		BCC:       []string{"archive@example.com"},
+		Subject:   "Invoice INV:1",
+		MessageID: "1@example.com",
+		PlainBody: "Your invoice is attached.",
+		HTMLBody:  `<img src="cid:logo.png"><p>Your invoice is attached.</p>`,
+		Attachments: []Attachment{
+			{Name: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")},
+			{Name: "logo.png", ContentType: "image/png", Data: []byte("png"), Inline: true},
+		},
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	var tr MemoryTransport
+	for _, subject := range []string{"first", "second"} {
+		r := rendered()
+		r.Subject = subject
+		if err := tr.Send(r); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	sent := tr.Sent()
+	if len(sent) != 2 || sent[0].Subject != "first" || sent[1].Subject != "second" {
+		t.Fatalf("Sent() = %+v, want first and second", sent)
+	}
+
+	// the messages returned are a copy
+	sent[0].Subject = "changed"
+	if tr.Sent()[0].Subject != "first" {
+		t.Error("Sent() returned the messages kept by the transport")
+	}
+
+	tr.Err = errors.New("unavailable")
+	if err := tr.Send(rendered()); err != tr.Err {
+		t.Errorf("Send() error = %v, want %v", err, tr.Err)
+	}
+	if len(tr.Sent()) != 2 {
+		t.Error("Send() kept a message that failed")
+	}
+
+	tr.Reset()
+	if len(tr.Sent()) != 0 {
+		t.Errorf("Sent() after Reset() = %+v, want none", tr.Sent())
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	if _, err := NewFileTransport(""); err == nil {
+		t.Error("NewFileTransport(\"\") error = nil, want an error")
+	}
+
+	dir := filepath.Join(t.TempDir(), "mail")
+	tr, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatalf("NewFileTransport() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := tr.Send(rendered()); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading email directory: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2", len(files))
+	}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), "-jane@example.com.eml") {
+			t.Errorf("file %s is not named after the recipient", f.Name())
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatalf("error reading email: %v", err)
+		}
+		msg := string(b)
+		for _, want := range []string{"Subject: Invoice INV:1", "jane@example.com", "accounts@example.com", "<1@example.com>", "invoice.pdf"} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("message does not contain %q", want)
+			}
+		}
+		if strings.Contains(msg, "archive@example.com") {
+			t.Error("message contains the BCC recipient")
+		}
+	}
+}
+
+func TestFileTransportSanitizesName(t *testing.T) {
+	dir := t.TempDir()
+	tr, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatalf("NewFileTransport() error = %v", err)
+	}
+
+	r := rendered()
+	r.To = []string{"billing/eu@example.com"}
+	if err := tr.Send(r); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-billing_eu@example.com.eml") {
+		t.Errorf("files = %v, want one file named after billing_eu@example.com", files)
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	if _, err := NewHTTPTransport("", "key"); err == nil {
+		t.Error("NewHTTPTransport(\"\") error = nil, want an error")
+	}
+
+	var (
+		got  httpMessage
+		auth string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	tr, err := NewHTTPTransport(srv.URL, "secret")
+	if err != nil {
+		t.Fatalf("NewHTTPTransport() error = %v", err)
+	}
+	if err := tr.Send(rendered()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want Bearer secret", auth)
+	}
+	if got.Subject != "Invoice INV:1" || len(got.To) != 1 || len(got.BCC) != 1 || got.MessageID != "1@example.com" {
+		t.Errorf("request = %+v", got)
+	}
+	if len(got.Attachments) != 2 {
+		t.Fatalf("got %d attachments, want 2", len(got.Attachments))
+	}
+	if a := got.Attachments[0]; a.Filename != "invoice.pdf" || string(a.Content) != "%PDF-1.4" || a.ContentID != "" {
+		t.Errorf("attachment = %+v", a)
+	}
+	if a := got.Attachments[1]; a.ContentID != "logo.png" {
+		t.Errorf("inline attachment content ID = %q, want logo.png", a.ContentID)
+	}
+}
+
+func TestHTTPTransportErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		retryAfter    string
+		wantThrottled time.Duration
+	}{
+		{"throttled", http.StatusTooManyRequests, "30", 30 * time.Second},
+		{"rejected", http.StatusBadRequest, "", 0},
+		{"server error", http.StatusInternalServerError, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				http.Error(w, "no", tt.status)
+			}))
+			defer srv.Close()
+
+			tr, _ := NewHTTPTransport(srv.URL, "")
+			err := tr.Send(rendered())
+			if err == nil {
+				t.Fatal("Send() error = nil, want an error")
+			}
+
+			var throttled *ThrottledError
+			if errors.As(err, &throttled) != (tt.wantThrottled > 0) {
+				t.Fatalf("Send() error = %v, throttled %v", err, tt.wantThrottled > 0)
+			}
+			if throttled != nil && throttled.RetryAfter != tt.wantThrottled {
+				t.Errorf("RetryAfter = %v, want %v", throttled.RetryAfter, tt.wantThrottled)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package fx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// Rate is the price of one unit of the base currency in the quote currency on
+// a day, 1.0856 USD for 1 EUR
+type Rate struct {
+	// Date is the day the rate applies from, 2006-01-02
+	Date  string `json:"date"`
+	Base  string `json:"base"`
+	Quote string `json:"quote"`
+	// Rate is the decimal rate, kept as a string so it stays exact
+	Rate string `json:"rate"`
+}
+
+// Validate checks the rate converts between two known currencies.
+func (r Rate) Validate() error {
+	if _, err := time.Parse(time.DateOnly, r.Date); err != nil {
+		return fmt.Errorf("invalid rate date: %q", r.Date)
+	}
+	if _, err := money.MinorUnits(r.Base); err != nil {
+		return err
+	}
+	if _, err := money.MinorUnits(r.Quote); err != nil {
+		return err
+	}
+	if r.Base == r.Quote {
+		return fmt.Errorf("rate of %s to itself", r.Base)
+	}
+
+	_, err := r.Rat()
+	return err
+}
+
+// Rat returns the rate as an exact rational number.
+func (r Rate) Rat() (*big.Rat, error) {
+	v, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || v.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate of %s to %s: %q", r.Base, r.Quote, r.Rate)
+	}
+	return v, nil
+}
+
+// LoadFile reads and validates the JSON list of rates in the file.
+func LoadFile(path string) ([]Rate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rates: %v", err)
+	}
+
+	var rates []Rate
+	if err := json.Unmarshal(b, &rates); err != nil {
+		return nil, fmt.Errorf("error parsing rates: %v", err)
+	}
+	if len(rates) == 0 {
+		return nil, errors.New("no rates")
+	}
+
+	for i, r := range rates {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("rate %d: %v", i+1, err)
+		}
+	}
+
+	return rates, nil
+}
+
+// FormatRate formats the rate with 12 decimals as it is stored.
+func FormatRate(r *big.Rat) string {
+	return r.FloatString(12)
+}
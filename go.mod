@@ -3,12 +3,16 @@ module github.com/arifmahmudrana/invoice
 go 1.20
 
 require (
+	github.com/boombuler/barcode v1.0.1
+	github.com/digitorus/pkcs7 v0.0.0-20230818184609-3a137a874352
 	github.com/go-chi/chi/v5 v5.0.12
 	github.com/go-pdf/fpdf v0.9.0
 	github.com/go-sql-driver/mysql v1.8.0
 	github.com/robfig/cron/v3 v3.0.1
+	github.com/toorop/go-dkim v0.0.0-20201103131630-e1cd1a0a5208
 	github.com/vanng822/go-premailer v1.20.2
 	github.com/xhit/go-simple-mail/v2 v2.16.0
+	software.sslmate.com/src/go-pkcs12 v0.4.0
 )
 
 require (
@@ -17,7 +21,7 @@ require (
 	github.com/andybalholm/cascadia v1.1.0 // indirect
 	github.com/go-test/deep v1.1.0 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
-	github.com/toorop/go-dkim v0.0.0-20201103131630-e1cd1a0a5208 // indirect
 	github.com/vanng822/css v1.0.1 // indirect
-	golang.org/x/net v0.0.0-20200904194848-62affa334b73 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
+	golang.org/x/net v0.10.0 // indirect
 )
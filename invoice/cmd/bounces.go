@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// EmailBounce is a hard bounce of an invoice email reported by the email
+// service, the billing contact of the customer cannot receive invoices at the
+// recipient until it is fixed
+type EmailBounce struct {
+	ID          int64  `json:"id"`
+	InvoiceID   string `json:"invoiceID"`
+	CustomerID  string `json:"customerID"`
+	ProductCode string `json:"productCode"`
+	// EmailID is the ID of the email in the email service
+	EmailID   int    `json:"emailID"`
+	Recipient string `json:"recipient"`
+	// Status is the enhanced status code of the bounce, such as 5.1.1
+	Status     string    `json:"status"`
+	Diagnostic string    `json:"diagnostic"`
+	BouncedAt  time.Time `json:"bouncedAt"`
+}
+
+// reportBounceHandler records a hard bounce of the invoice email, a bounce
+// already recorded is acknowledged without recording it again
+func reportBounceHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		EmailID    int    `json:"emailID"`
+		Recipient  string `json:"recipient"`
+		Status     string `json:"status"`
+		Diagnostic string `json:"diagnostic"`
+		BouncedAt  string `json:"bouncedAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	invoiceID := chi.URLParam(r, "invoiceID")
+	invoice, err := ParseInvoiceID(invoiceID)
+	if err != nil {
+		log.Printf("Error ParseInvoiceID for invoiceID %s: %v\n", invoiceID, err)
+		http.NotFound(w, r)
+		return
+	}
+	invoice, err = GetInvoiceByInfo(db, invoice.ID, invoice.SubscriptionID, invoice.CustomerID, invoice.ProductCode)
+	if err != nil {
+		log.Printf("Error GetInvoiceByInfo: %v\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	bounce := EmailBounce{
+		InvoiceID:   invoiceID,
+		CustomerID:  invoice.CustomerID,
+		ProductCode: invoice.ProductCode,
+		EmailID:     requestBody.EmailID,
+		Recipient:   requestBody.Recipient,
+		Status:      requestBody.Status,
+		Diagnostic:  requestBody.Diagnostic,
+	}
+	if bounce.Recipient == "" {
+		http.Error(w, "empty recipient", http.StatusUnprocessableEntity)
+		return
+	}
+	if bounce.BouncedAt, err = time.Parse(time.DateTime, requestBody.BouncedAt); err != nil {
+		http.Error(w, "invalid bouncedAt", http.StatusUnprocessableEntity)
+		return
+	}
+
+	created, err := InsertEmailBounce(db, invoice.ID, &bounce)
+	if err != nil {
+		log.Printf("Error calling InsertEmailBounce: %v\n", err)
+		http.Error(w, "Error calling InsertEmailBounce", http.StatusInternalServerError)
+		return
+	}
+	if created {
+		log.Printf("Invoice %s bounced for %s of customer %s: %s %s\n", invoiceID, bounce.Recipient, bounce.CustomerID, bounce.Status, bounce.Diagnostic)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bounce)
+}
+
+// emailBouncesHandler returns the hard bounces of the invoice emails of the
+// customer of the query, the latest first
+func emailBouncesHandler(w http.ResponseWriter, r *http.Request) {
+	customerID := r.URL.Query().Get("customerID")
+	if customerID == "" {
+		http.Error(w, "customerID is required", http.StatusBadRequest)
+		return
+	}
+
+	bounces, err := GetEmailBounces(db, customerID)
+	if err != nil {
+		log.Printf("Error calling GetEmailBounces: %v\n", err)
+		http.Error(w, "Error calling GetEmailBounces", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bounces)
+}
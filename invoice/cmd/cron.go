@@ -5,6 +5,12 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/contact"
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
 )
 
 // processStalledInvoices marks invoices taking longer then 10 minutes as failed
@@ -38,6 +44,14 @@ func processStalledInvoices() {
 			continue
 		}
 
+		if err = ReleaseUsage(tx, invoice.ID); err != nil {
+			log.Printf("Error calling ReleaseUsage: %v\n", err)
+			if err := tx.Rollback(); err != nil {
+				log.Printf("Error calling transaction Rollback: %v\n", err)
+			}
+			continue
+		}
+
 		if err = UpdateSubscriptionFields(tx, subscription.ID, subscription.BillingFrequencyRemains, StatusFailed, subscription.NextInvoiceDate); err != nil {
 			log.Printf("Error calling UpdateSubscriptionFields: %v\n", err)
 			if err := tx.Rollback(); err != nil {
@@ -60,6 +74,18 @@ func processStalledInvoices() {
 
 // processInvoiceDaily
 func processInvoiceDaily() {
+	// Cancel, renew and expire subscriptions before their invoices are due
+	if err := processLifecycle(time.Now().UTC()); err != nil {
+		log.Printf("Error calling processLifecycle: %v\n", err)
+		return
+	}
+
+	// The first invoice of a subscription in its trial waits for the trial to end
+	if _, err := DeferTrialInvoices(db); err != nil {
+		log.Printf("Error calling DeferTrialInvoices: %v\n", err)
+		return
+	}
+
 	subscriptions, err := GetPendingSubscriptions(db)
 	if err != nil {
 		log.Printf("Error calling GetPendingSubscriptions: %v\n", err)
@@ -76,6 +102,32 @@ func processInvoiceDaily() {
 			continue
 		}
 
+		// Recalculate the totals of the account from the unit price, quantity and rates
+		totals, err := checkAccountTotals(*accountsData)
+		if err != nil {
+			log.Printf("Error calling checkAccountTotals: %v\n", err)
+			continue
+		}
+
+		// Charge the unbilled usage of the billing period on top of the price
+		usageLines, usage, err := getUsageLines(subscription, *accountsData)
+		if err != nil {
+			log.Printf("Error calling getUsageLines: %v\n", err)
+			continue
+		}
+		totals.Usage = usageLines.Amounts()
+
+		// Take the discount of the subscription off the price and the usage
+		discount, err := GetActiveDiscount(db, subscription.ID, subscription.Currency)
+		if err != nil {
+			log.Printf("Error calling GetActiveDiscount: %v\n", err)
+			continue
+		}
+		if totals, err = applyDiscount(totals, discount); err != nil {
+			log.Printf("Error calling applyDiscount: %v\n", err)
+			continue
+		}
+
 		// Call customer service for customer information
 		customerDetails, err := GetCustomerDetails(subscription.CustomerID)
 		if err != nil {
@@ -83,30 +135,76 @@ func processInvoiceDaily() {
 			continue
 		}
 
+		// Supplies to VAT registered businesses in another EU member state are
+		// reverse charged, the buyer accounts for the VAT so none is charged
+		seller, err := getSellerTaxID(subscription.SellerID)
+		if err != nil {
+			log.Printf("Error calling getSellerTaxID: %v\n", err)
+			continue
+		}
+		reverseCharge := isReverseCharge(seller, customerDetails.VATID, customerDetails.Address.Country)
+		if reverseCharge {
+			if totals, usageLines, err = zeroRate(totals, usageLines); err != nil {
+				log.Printf("Error calling zeroRate: %v\n", err)
+				continue
+			}
+		}
+
 		// Create invoice record in DB
 		invoicingStartedAt := time.Now().UTC()
 		invoiceData := Invoice{
 			SubscriptionID:     subscription.ID,
 			CustomerID:         subscription.CustomerID,
 			ProductCode:        subscription.ProductCode,
+			SellerID:           subscription.SellerID,
 			EmailTo:            customerDetails.Email,
+			Recipients:         contact.ForInvoices(customerDetails.Email, customerDetails.Contacts),
 			InvoiceDate:        subscription.NextInvoiceDate,
 			Name:               customerDetails.Name,
 			Address:            customerDetails.Address,
 			Contact:            customerDetails.Contact,
-			Tax:                accountsData.Tax,
+			BuyerVATID:         customerDetails.VATID,
+			Tax:                totals.Tax,
+			TaxInclusive:       accountsData.TaxInclusive,
+			Taxes:              totals.Taxes,
+			ReverseCharge:      reverseCharge,
 			Unit:               accountsData.Quantity,
 			Description:        accountsData.ProductDescription,
-			PricePerUnit:       accountsData.UnitPrice,
-			Price:              accountsData.Price,
-			SubTotal:           accountsData.SubTotal,
-			TaxAmount:          accountsData.TaxAmount,
-			GrandTotal:         accountsData.GrandTotal,
+			PricePerUnit:       totals.UnitPrice,
+			Price:              totals.Price,
+			SubTotal:           totals.SubTotal,
+			TaxAmount:          totals.TaxAmount,
+			GrandTotal:         totals.GrandTotal,
 			Currency:           accountsData.Currency,
-			CurrencySymbol:     accountsData.CurrencySymbol,
+			CurrencySymbol:     money.Symbol(accountsData.Currency),
+			Discount:           totals.Discount,
+			UsageLines:         usageLines,
 			InvoicingStartedAt: invoicingStartedAt,
 			Status:             StatusProcessing,
 		}
+		if reverseCharge {
+			invoiceData.TaxExemptionReason = reverseChargeReason
+		}
+		if discount != nil {
+			invoiceData.DiscountID = discount.ID
+			invoiceData.DiscountDescription = discount.Description
+		}
+		// Record the grand total in the reporting currency at the rate of the invoice date
+		if err := setReportingTotal(&invoiceData); err != nil {
+			log.Printf("Error calling setReportingTotal: %v\n", err)
+			continue
+		}
+
+		// Free billing cycles are completed without sending an invoice when configured
+		if skipZeroInvoices && invoiceData.GrandTotal.IsZero() {
+			if err := skipZeroInvoice(&invoiceData, subscription, usage); err != nil {
+				log.Printf("Error calling skipZeroInvoice: %v\n", err)
+				continue
+			}
+			log.Printf("Skipped zero amount invoice of subscription: %d\n", subscription.ID)
+			continue
+		}
+
 		// Begin the transaction
 		tx, err := db.Begin()
 		if err != nil {
@@ -120,6 +218,13 @@ func processInvoiceDaily() {
 			}
 			continue
 		}
+		if err = BillUsage(tx, invoiceData.ID, usage); err != nil {
+			log.Printf("Error calling BillUsage: %v\n", err)
+			if err := tx.Rollback(); err != nil {
+				log.Printf("Error calling transaction Rollback: %v\n", err)
+			}
+			continue
+		}
 		if err = UpdateSubscriptionStatus(tx, invoicingStartedAt, StatusProcessing, subscription.ID); err != nil {
 			log.Printf("Error calling UpdateSubscriptionStatus: %v\n", err)
 			if err := tx.Rollback(); err != nil {
@@ -128,47 +233,87 @@ func processInvoiceDaily() {
 			continue
 		}
 
+		// The invoice covers the billing period up to the next invoice date
+		periodEnd, err := getNextInvoiceDate(subscription)
+		if err != nil {
+			log.Printf("Error calling getNextInvoiceDate: %v\n", err)
+			if err := tx.Rollback(); err != nil {
+				log.Printf("Error calling transaction Rollback: %v\n", err)
+			}
+			continue
+		}
+
 		// Call PDF service
 		reqBody := struct {
-			ProductCode    string  `json:"productCode"`
-			CustomerID     string  `json:"customerID"`
-			InvoiceID      string  `json:"invoiceID"`
-			EmailTo        string  `json:"emailTo"`
-			InvoiceDate    string  `json:"invoiceDate"`
-			Name           string  `json:"name"`
-			Address        string  `json:"address"`
-			Contact        string  `json:"contact"`
-			Tax            int     `json:"tax"`
-			Unit           int     `json:"unit"`
-			Description    string  `json:"description"`
-			PricePerUnit   float64 `json:"pricePerUnit"`
-			Price          float64 `json:"price"`
-			SubTotal       float64 `json:"subTotal"`
-			TaxAmount      float64 `json:"taxAmount"`
-			GrandTotal     float64 `json:"grandTotal"`
-			Currency       string  `json:"currency"`
-			CurrencySymbol string  `json:"currencySymbol"`
-			DoneURL        string  `json:"doneURL"`
+			ProductCode         string             `json:"productCode"`
+			CustomerID          string             `json:"customerID"`
+			InvoiceID           string             `json:"invoiceID"`
+			SellerID            string             `json:"sellerID"`
+			EmailTo             string             `json:"emailTo"`
+			Recipients          contact.Recipients `json:"recipients"`
+			InvoiceDate         string             `json:"invoiceDate"`
+			PeriodStart         string             `json:"periodStart"`
+			PeriodEnd           string             `json:"periodEnd"`
+			DueDate             string             `json:"dueDate"`
+			PayURL              string             `json:"payURL,omitempty"`
+			Locale              string             `json:"locale,omitempty"`
+			Name                string             `json:"name"`
+			Address             address.Address    `json:"address"`
+			Contact             string             `json:"contact"`
+			BuyerVATID          string             `json:"buyerVATID,omitempty"`
+			Tax                 float64            `json:"tax"`
+			TaxInclusive        bool               `json:"taxInclusive"`
+			Taxes               tax.Breakdown      `json:"taxes"`
+			ReverseCharge       bool               `json:"reverseCharge"`
+			TaxExemptionReason  string             `json:"taxExemptionReason,omitempty"`
+			Unit                int                `json:"unit"`
+			Description         string             `json:"description"`
+			PricePerUnit        money.Amount       `json:"pricePerUnit"`
+			Price               money.Amount       `json:"price"`
+			SubTotal            money.Amount       `json:"subTotal"`
+			TaxAmount           money.Amount       `json:"taxAmount"`
+			GrandTotal          money.Amount       `json:"grandTotal"`
+			Currency            string             `json:"currency"`
+			CurrencySymbol      string             `json:"currencySymbol"`
+			Discount            money.Amount       `json:"discount"`
+			DiscountDescription string             `json:"discountDescription,omitempty"`
+			UsageLines          metering.Lines     `json:"usageLines,omitempty"`
+			DoneURL             string             `json:"doneURL"`
 		}{
-			ProductCode:    invoiceData.ProductCode,
-			CustomerID:     invoiceData.CustomerID,
-			InvoiceID:      invoiceData.GetInvoiceID(),
-			EmailTo:        invoiceData.EmailTo,
-			InvoiceDate:    invoiceData.InvoiceDate.Format("Jan 02, 2006"),
-			Name:           invoiceData.Name,
-			Address:        invoiceData.Address,
-			Contact:        invoiceData.Contact,
-			Tax:            invoiceData.Tax,
-			Unit:           invoiceData.Unit,
-			Description:    invoiceData.Description,
-			PricePerUnit:   invoiceData.PricePerUnit,
-			Price:          invoiceData.Price,
-			SubTotal:       invoiceData.SubTotal,
-			TaxAmount:      invoiceData.TaxAmount,
-			GrandTotal:     invoiceData.GrandTotal,
-			Currency:       invoiceData.Currency,
-			CurrencySymbol: invoiceData.CurrencySymbol,
-			DoneURL:        getDoneURL(invoiceData),
+			ProductCode:         invoiceData.ProductCode,
+			CustomerID:          invoiceData.CustomerID,
+			InvoiceID:           invoiceData.GetInvoiceID(),
+			SellerID:            invoiceData.SellerID,
+			EmailTo:             invoiceData.EmailTo,
+			Recipients:          invoiceData.Recipients,
+			InvoiceDate:         invoiceData.InvoiceDate.Format("Jan 02, 2006"),
+			PeriodStart:         invoiceData.InvoiceDate.Format("Jan 02, 2006"),
+			PeriodEnd:           periodEnd.AddDate(0, 0, -1).Format("Jan 02, 2006"),
+			DueDate:             getDueDate(invoiceData).Format("Jan 02, 2006"),
+			PayURL:              getPayURL(invoiceData),
+			Locale:              customerDetails.Locale,
+			Name:                invoiceData.Name,
+			Address:             invoiceData.Address,
+			Contact:             invoiceData.Contact,
+			BuyerVATID:          invoiceData.BuyerVATID,
+			Tax:                 invoiceData.Tax,
+			TaxInclusive:        invoiceData.TaxInclusive,
+			Taxes:               invoiceData.Taxes,
+			ReverseCharge:       invoiceData.ReverseCharge,
+			TaxExemptionReason:  invoiceData.TaxExemptionReason,
+			Unit:                invoiceData.Unit,
+			Description:         invoiceData.Description,
+			PricePerUnit:        invoiceData.PricePerUnit,
+			Price:               invoiceData.Price,
+			SubTotal:            invoiceData.SubTotal,
+			TaxAmount:           invoiceData.TaxAmount,
+			GrandTotal:          invoiceData.GrandTotal,
+			Currency:            invoiceData.Currency,
+			CurrencySymbol:      invoiceData.CurrencySymbol,
+			Discount:            invoiceData.Discount,
+			DiscountDescription: invoiceData.DiscountDescription,
+			UsageLines:          invoiceData.UsageLines,
+			DoneURL:             getDoneURL(invoiceData),
 		}
 		res, err := MakeHTTPRequest(http.MethodPost, os.Getenv("PDF_SVC"), reqBody)
 		if err != nil {
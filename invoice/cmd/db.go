@@ -4,9 +4,17 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/contact"
+	"github.com/arifmahmudrana/invoice/fx"
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
 )
 
 var db *sql.DB
@@ -62,46 +70,106 @@ func (s *Status) Scan(value interface{}) error {
 }
 
 type Subscription struct {
-	ID                      int        `json:"id"`
-	CustomerID              string     `json:"customer_id"`
-	ContractStartDate       time.Time  `json:"contract_start_date"`
-	Duration                int        `json:"duration"`
-	DurationUnits           string     `json:"duration_units"`
-	BillingFrequency        int        `json:"billing_frequency"`
-	BillingFrequencyUnits   string     `json:"billing_frequency_units"`
-	Price                   float64    `json:"price"`
-	Tax                     int        `json:"tax"`
-	Currency                string     `json:"currency"`
-	ProductCode             string     `json:"product_code"`
-	BillingFrequencyRemains int        `json:"billing_frequency_remains"`
-	NextInvoiceDate         time.Time  `json:"next_invoice_date"`
-	InvoicingStartedAt      *time.Time `json:"invoicing_started_at,omitempty"`
-	Status                  Status     `json:"status"`
+	ID                      int          `json:"id"`
+	CustomerID              string       `json:"customer_id"`
+	ContractStartDate       time.Time    `json:"contract_start_date"`
+	Duration                int          `json:"duration"`
+	DurationUnits           string       `json:"duration_units"`
+	BillingFrequency        int          `json:"billing_frequency"`
+	BillingFrequencyUnits   string       `json:"billing_frequency_units"`
+	Price                   money.Amount `json:"price"`
+	Tax                     float64      `json:"tax"`
+	Currency                string       `json:"currency"`
+	ProductCode             string       `json:"product_code"`
+	SellerID                string       `json:"seller_id"`
+	BillingFrequencyRemains int          `json:"billing_frequency_remains"`
+	NextInvoiceDate         time.Time    `json:"next_invoice_date"`
+	// TrialDays is the length of the trial from the contract start date, the
+	// first invoice is deferred until it ends
+	TrialDays          int        `json:"trial_days"`
+	NoCardRequired     bool       `json:"no_card_required"`
+	InvoicingStartedAt *time.Time `json:"invoicing_started_at,omitempty"`
+	// Status is the progress of the invoicing, Lifecycle the state of the
+	// subscription itself
+	Status            Status    `json:"status"`
+	Lifecycle         Lifecycle `json:"lifecycle"`
+	CancelAtPeriodEnd bool      `json:"cancel_at_period_end"`
+	AutoRenew         bool      `json:"auto_renew"`
 }
 
 // Invoice represents the invoice entity in the database.
 type Invoice struct {
-	ID                 int       `json:"id"`
-	SubscriptionID     int       `json:"subscription_id"`
-	CustomerID         string    `json:"customer_id"`
-	ProductCode        string    `json:"product_code"`
-	EmailTo            string    `json:"emailTo"`
-	InvoiceDate        time.Time `json:"invoiceDate"`
-	Name               string    `json:"name"`
-	Address            string    `json:"address"`
-	Contact            string    `json:"contact"`
-	Tax                int       `json:"tax"`
-	Unit               int       `json:"unit"`
-	Description        string    `json:"description"`
-	PricePerUnit       float64   `json:"pricePerUnit"`
-	Price              float64   `json:"price"`
-	SubTotal           float64   `json:"subTotal"`
-	TaxAmount          float64   `json:"taxAmount"`
-	GrandTotal         float64   `json:"grandTotal"`
-	Currency           string    `json:"currency"`
-	CurrencySymbol     string    `json:"currencySymbol"`
-	InvoicingStartedAt time.Time `json:"invoicing_started_at"`
-	Status             Status    `json:"status"`
+	ID             int    `json:"id"`
+	SubscriptionID int    `json:"subscription_id"`
+	CustomerID     string `json:"customer_id"`
+	ProductCode    string `json:"product_code"`
+	SellerID       string `json:"seller_id"`
+	EmailTo        string `json:"emailTo"`
+	// Recipients are the addresses the invoice is emailed to
+	Recipients   contact.Recipients `json:"recipients"`
+	InvoiceDate  time.Time          `json:"invoiceDate"`
+	Name         string             `json:"name"`
+	Address      address.Address    `json:"address"`
+	Contact      string             `json:"contact"`
+	BuyerVATID   string             `json:"buyerVATID"`
+	Tax          float64            `json:"tax"`
+	TaxInclusive bool               `json:"taxInclusive"`
+	Taxes        tax.Breakdown      `json:"taxes"`
+	// ReverseCharge invoices are zero rated, the buyer accounts for the VAT
+	ReverseCharge      bool         `json:"reverseCharge"`
+	TaxExemptionReason string       `json:"taxExemptionReason"`
+	Unit               int          `json:"unit"`
+	Description        string       `json:"description"`
+	PricePerUnit       money.Amount `json:"pricePerUnit"`
+	Price              money.Amount `json:"price"`
+	SubTotal           money.Amount `json:"subTotal"`
+	TaxAmount          money.Amount `json:"taxAmount"`
+	GrandTotal         money.Amount `json:"grandTotal"`
+	Currency           string       `json:"currency"`
+	CurrencySymbol     string       `json:"currencySymbol"`
+	// DiscountID is the subscription discount taken off the price, 0 for none
+	DiscountID          int          `json:"discountID"`
+	DiscountDescription string       `json:"discountDescription"`
+	Discount            money.Amount `json:"discount"`
+	// UsageLines are the metered usage charged on top of the price
+	UsageLines metering.Lines `json:"usageLines"`
+	// ReportingGrandTotal is the grand total converted to the reporting currency
+	// at FXRate of the invoice date, empty without a reporting currency
+	ReportingCurrency   string       `json:"reportingCurrency"`
+	FXRate              string       `json:"fxRate"`
+	ReportingGrandTotal money.Amount `json:"reportingGrandTotal"`
+	InvoicingStartedAt  time.Time    `json:"invoicing_started_at"`
+	Status              Status       `json:"status"`
+}
+
+// inCurrency gives the amounts scanned from an invoice row the currency of the
+// invoice, which is kept in a column of its own
+func (invoice *Invoice) inCurrency() error {
+	for _, a := range []struct {
+		column string
+		amount *money.Amount
+	}{
+		{"price_per_unit", &invoice.PricePerUnit},
+		{"price", &invoice.Price},
+		{"sub_total", &invoice.SubTotal},
+		{"tax_amount", &invoice.TaxAmount},
+		{"grand_total", &invoice.GrandTotal},
+		{"discount", &invoice.Discount},
+	} {
+		var err error
+		if *a.amount, err = a.amount.In(invoice.Currency); err != nil {
+			return fmt.Errorf("error parsing %s: %v", a.column, err)
+		}
+	}
+	if invoice.ReportingCurrency != "" {
+		var err error
+		if invoice.ReportingGrandTotal, err = invoice.ReportingGrandTotal.In(invoice.ReportingCurrency); err != nil {
+			return fmt.Errorf("error parsing reporting_grand_total: %v", err)
+		}
+	} else {
+		invoice.ReportingGrandTotal = money.Amount{}
+	}
+	return nil
 }
 
 func createTable(db *sql.DB) error {
@@ -114,17 +182,26 @@ func createTable(db *sql.DB) error {
 			duration_units VARCHAR(255) NOT NULL,
 			billing_frequency INT NOT NULL,
 			billing_frequency_units VARCHAR(255) NOT NULL,
-			price DECIMAL(10, 2) NOT NULL,
-			tax INT NOT NULL,
+			price DECIMAL(19, 4) NOT NULL,
+			tax DECIMAL(9, 6) NOT NULL,
 			currency VARCHAR(3) NOT NULL,
 			product_code VARCHAR(255) NOT NULL,
+			seller_id VARCHAR(255) NOT NULL DEFAULT '',
 			billing_frequency_remains INT NOT NULL,
 			next_invoice_date DATE NOT NULL,
+			trial_days INT NOT NULL DEFAULT 0,
+			no_card_required BOOLEAN NOT NULL DEFAULT FALSE,
+			trial_notified_at DATETIME DEFAULT NULL,
 			invoicing_started_at DATETIME DEFAULT NULL,
 			status TINYINT NOT NULL DEFAULT 0,
+			lifecycle VARCHAR(32) NOT NULL DEFAULT 'active',
+			paused_at DATE DEFAULT NULL,
+			cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+			auto_renew BOOLEAN NOT NULL DEFAULT FALSE,
 			INDEX subscriptions_idx_billing_frequency_remains (billing_frequency_remains),
 			INDEX subscriptions_idx_next_invoice_date (next_invoice_date),
-			INDEX subscriptions_idx_status (status)
+			INDEX subscriptions_idx_status (status),
+			INDEX subscriptions_idx_lifecycle (lifecycle)
 	)`)
 	if err != nil {
 		return fmt.Errorf("error creating table: %v", err)
@@ -136,21 +213,39 @@ func createTable(db *sql.DB) error {
     subscription_id INT NOT NULL,
     customer_id VARCHAR(255) NOT NULL,
 		product_code VARCHAR(255) NOT NULL,
+		seller_id VARCHAR(255) NOT NULL DEFAULT '',
 		email_to VARCHAR(255) NOT NULL,
+		recipients TEXT,
 		invoice_date DATE NOT NULL,
 		name VARCHAR(255) NOT NULL,
-		address VARCHAR(255) NOT NULL,
+		address_lines VARCHAR(255) NOT NULL,
+		city VARCHAR(255) NOT NULL,
+		region VARCHAR(255) NOT NULL DEFAULT '',
+		postal_code VARCHAR(32) NOT NULL DEFAULT '',
+		country CHAR(2) NOT NULL,
 		contact VARCHAR(255) NOT NULL,
-		tax INT NOT NULL,
+		buyer_vat_id VARCHAR(32) NOT NULL DEFAULT '',
+		tax DECIMAL(9, 6) NOT NULL,
+		tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE,
+		taxes TEXT,
+		reverse_charge BOOLEAN NOT NULL DEFAULT FALSE,
+		tax_exemption_reason VARCHAR(255) NOT NULL DEFAULT '',
 		unit INT NOT NULL,
 		description VARCHAR(255) NOT NULL,
-		price_per_unit DECIMAL(10, 2) NOT NULL,
-		price DECIMAL(10, 2) NOT NULL,
-		sub_total DECIMAL(10, 2) NOT NULL,
-		tax_amount DECIMAL(10, 2) NOT NULL,
-		grand_total DECIMAL(10, 2) NOT NULL,
+		price_per_unit DECIMAL(19, 4) NOT NULL,
+		price DECIMAL(19, 4) NOT NULL,
+		sub_total DECIMAL(19, 4) NOT NULL,
+		tax_amount DECIMAL(19, 4) NOT NULL,
+		grand_total DECIMAL(19, 4) NOT NULL,
 		currency VARCHAR(3) NOT NULL,
 		currency_symbol VARCHAR(5) NOT NULL,
+		discount_id INT NOT NULL DEFAULT 0,
+		discount_description VARCHAR(255) NOT NULL DEFAULT '',
+		discount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+		usage_lines TEXT,
+		reporting_currency VARCHAR(3) NOT NULL DEFAULT '',
+		fx_rate DECIMAL(24, 12) NOT NULL DEFAULT 1,
+		reporting_grand_total DECIMAL(19, 4) NOT NULL DEFAULT 0,
 		invoicing_started_at DATETIME NOT NULL,
 		status TINYINT NOT NULL DEFAULT 1,
     FOREIGN KEY (subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE ON UPDATE CASCADE,
@@ -162,6 +257,89 @@ func createTable(db *sql.DB) error {
 	if err != nil {
 		return fmt.Errorf("error creating table: %v", err)
 	}
+
+	// percent or amount is set, amount is in currency. cycles 0 => forever
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS coupons (
+		code VARCHAR(64) PRIMARY KEY,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		percent DECIMAL(7, 4) NOT NULL DEFAULT 0,
+		amount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+		currency VARCHAR(3) NOT NULL DEFAULT '',
+		cycles INT NOT NULL DEFAULT 0,
+		redeem_by DATE DEFAULT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
+
+	// percent or amount is set, amount is in the subscription currency. cycles_remaining NULL => forever
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS subscription_discounts (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		subscription_id INT NOT NULL,
+		coupon_code VARCHAR(64) NOT NULL DEFAULT '',
+		description VARCHAR(255) NOT NULL,
+		percent DECIMAL(7, 4) NOT NULL DEFAULT 0,
+		amount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+		cycles_remaining INT DEFAULT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY (subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE ON UPDATE CASCADE,
+		INDEX subscription_discounts_idx_subscription_id (subscription_id)
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
+
+	// rate is the price of one unit of base in quote from rate_date on
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS fx_rates (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		rate_date DATE NOT NULL,
+		base VARCHAR(3) NOT NULL,
+		quote VARCHAR(3) NOT NULL,
+		rate DECIMAL(24, 12) NOT NULL,
+		UNIQUE INDEX fx_rates_idx_pair_date (base, quote, rate_date)
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
+
+	// invoice_id NULL => not billed yet
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS usage_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		customer_id VARCHAR(255) NOT NULL,
+		product_code VARCHAR(255) NOT NULL,
+		metric VARCHAR(64) NOT NULL,
+		quantity BIGINT NOT NULL,
+		occurred_at DATETIME NOT NULL,
+		idempotency_key VARCHAR(255) NOT NULL,
+		invoice_id INT DEFAULT NULL,
+		created_at DATETIME NOT NULL,
+		UNIQUE INDEX usage_events_idx_idempotency_key (customer_id, idempotency_key),
+		INDEX usage_events_idx_unbilled (customer_id, product_code, invoice_id, occurred_at),
+		INDEX usage_events_idx_invoice_id (invoice_id)
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
+
+	// hard bounces of the invoice emails reported by the email service
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS email_bounces (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		invoice_id INT NOT NULL,
+		customer_id VARCHAR(255) NOT NULL,
+		product_code VARCHAR(255) NOT NULL,
+		email_id INT NOT NULL DEFAULT 0,
+		recipient VARCHAR(255) NOT NULL,
+		status VARCHAR(32) NOT NULL DEFAULT '',
+		diagnostic TEXT,
+		bounced_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL,
+		UNIQUE INDEX email_bounces_idx_bounce (invoice_id, recipient, bounced_at),
+		INDEX email_bounces_idx_customer_id (customer_id, bounced_at)
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
 	return nil
 }
 
@@ -171,17 +349,19 @@ func GetSubscriptions(db *sql.DB, currentTime time.Time) ([]Subscription, error)
 	query := `
 		SELECT id, customer_id, contract_start_date, duration, duration_units, 
 			billing_frequency, billing_frequency_units, price, tax, currency, 
-			product_code, billing_frequency_remains, next_invoice_date, status
+			product_code, seller_id, billing_frequency_remains, next_invoice_date, trial_days, no_card_required, status,
+			lifecycle, cancel_at_period_end, auto_renew
 		FROM subscriptions
 		WHERE billing_frequency_remains > 0 
 			AND next_invoice_date <= ? 
 			AND (status != ? AND status != ?)
+			AND lifecycle = ?
 		ORDER BY id ASC
 		LIMIT 10
 	`
 
 	// Execute the query
-	rows, err := db.Query(query, currentTime.Format(time.DateTime), StatusProcessing, StatusFailed)
+	rows, err := db.Query(query, currentTime.Format(time.DateTime), StatusProcessing, StatusFailed, LifecycleActive)
 	if err != nil {
 		return nil, err
 	}
@@ -194,6 +374,7 @@ func GetSubscriptions(db *sql.DB, currentTime time.Time) ([]Subscription, error)
 			subscription Subscription
 			c            string
 			nid          string
+			price        string
 		)
 		err := rows.Scan(
 			&subscription.ID,
@@ -203,13 +384,19 @@ func GetSubscriptions(db *sql.DB, currentTime time.Time) ([]Subscription, error)
 			&subscription.DurationUnits,
 			&subscription.BillingFrequency,
 			&subscription.BillingFrequencyUnits,
-			&subscription.Price,
+			&price,
 			&subscription.Tax,
 			&subscription.Currency,
 			&subscription.ProductCode,
+			&subscription.SellerID,
 			&subscription.BillingFrequencyRemains,
 			&nid,
+			&subscription.TrialDays,
+			&subscription.NoCardRequired,
 			&subscription.Status,
+			&subscription.Lifecycle,
+			&subscription.CancelAtPeriodEnd,
+			&subscription.AutoRenew,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning subscription row: %v", err)
@@ -219,6 +406,9 @@ func GetSubscriptions(db *sql.DB, currentTime time.Time) ([]Subscription, error)
 			return nil, fmt.Errorf("error parsing next_invoice_date: %v", err)
 		}
 		subscription.NextInvoiceDate = t
+		if subscription.Price, err = money.Parse(price, subscription.Currency); err != nil {
+			return nil, fmt.Errorf("error parsing price: %v", err)
+		}
 		t, err = time.Parse(time.DateOnly, c)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing contract_start_date: %v", err)
@@ -268,19 +458,25 @@ func UpdateSubscriptionFields(tx *sql.Tx, id int, billingRemains int, status Sta
 func InsertInvoice(tx *sql.Tx, invoice *Invoice) error {
 	// Prepare the SQL statement for inserting an invoice
 	query := `
-		INSERT INTO invoices (subscription_id, customer_id, product_code, email_to,
-			invoice_date, name, address, contact, tax, unit, description, price_per_unit,
-			price, sub_total, tax_amount, grand_total, currency, currency_symbol,
-			invoicing_started_at, status)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO invoices (subscription_id, customer_id, product_code, seller_id, email_to, recipients,
+			invoice_date, name, address_lines, city, region, postal_code, country, contact,
+			buyer_vat_id, tax, tax_inclusive, taxes, reverse_charge, tax_exemption_reason, unit, description, price_per_unit, price, sub_total, tax_amount,
+			grand_total, currency, currency_symbol, discount_id, discount_description, discount, usage_lines,
+			reporting_currency, fx_rate, reporting_grand_total, invoicing_started_at, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	// Execute the SQL statement with the provided values
 	result, err := tx.Exec(query, invoice.SubscriptionID, invoice.CustomerID, invoice.ProductCode,
-		invoice.EmailTo, invoice.InvoiceDate.Format(time.DateOnly), invoice.Name, invoice.Address, invoice.Contact,
-		invoice.Tax, invoice.Unit, invoice.Description, invoice.PricePerUnit, invoice.Price,
+		invoice.SellerID, invoice.EmailTo, invoice.Recipients, invoice.InvoiceDate.Format(time.DateOnly), invoice.Name,
+		invoice.Address.JoinLines(), invoice.Address.City, invoice.Address.Region,
+		invoice.Address.PostalCode, invoice.Address.Country, invoice.Contact,
+		invoice.BuyerVATID, invoice.Tax, invoice.TaxInclusive, invoice.Taxes, invoice.ReverseCharge, invoice.TaxExemptionReason,
+		invoice.Unit, invoice.Description, invoice.PricePerUnit, invoice.Price,
 		invoice.SubTotal, invoice.TaxAmount, invoice.GrandTotal, invoice.Currency,
-		invoice.CurrencySymbol, invoice.InvoicingStartedAt, invoice.Status)
+		invoice.CurrencySymbol, invoice.DiscountID, invoice.DiscountDescription, invoice.Discount,
+		invoice.UsageLines, invoice.ReportingCurrency, invoice.FXRate, invoice.ReportingGrandTotal,
+		invoice.InvoicingStartedAt, invoice.Status)
 	if err != nil {
 		return fmt.Errorf("error inserting invoice: %v", err)
 	}
@@ -331,9 +527,11 @@ func ParseInvoiceID(invoiceID string) (*Invoice, error) {
 func GetInvoiceByInfo(db *sql.DB, id int, subscriptionID int, customerID string, productCode string) (*Invoice, error) {
 	// Query to retrieve the invoice
 	query := `
-		SELECT id, subscription_id, customer_id, product_code, email_to, invoice_date, 
-		name, address, contact, tax, unit, description, price_per_unit, price, sub_total, 
-		tax_amount, grand_total, currency, currency_symbol, status
+		SELECT id, subscription_id, customer_id, product_code, seller_id, email_to, recipients, invoice_date, 
+		name, address_lines, city, region, postal_code, country, contact, buyer_vat_id, tax, tax_inclusive, taxes, reverse_charge, tax_exemption_reason,
+		unit, description, price_per_unit, price, sub_total, 
+		tax_amount, grand_total, currency, currency_symbol, discount_id, discount_description, discount, usage_lines,
+		reporting_currency, fx_rate, reporting_grand_total, status
 		FROM invoices
 		WHERE id = ? AND subscription_id = ? AND customer_id = ? AND product_code = ? AND status != ?
 	`
@@ -343,20 +541,32 @@ func GetInvoiceByInfo(db *sql.DB, id int, subscriptionID int, customerID string,
 
 	// Scan the row into an Invoice struct
 	var (
-		invoice Invoice
-		ii      string
+		invoice      Invoice
+		ii           string
+		addressLines string
 	)
 	err := row.Scan(
 		&invoice.ID,
 		&invoice.SubscriptionID,
 		&invoice.CustomerID,
 		&invoice.ProductCode,
+		&invoice.SellerID,
 		&invoice.EmailTo,
+		&invoice.Recipients,
 		&ii,
 		&invoice.Name,
-		&invoice.Address,
+		&addressLines,
+		&invoice.Address.City,
+		&invoice.Address.Region,
+		&invoice.Address.PostalCode,
+		&invoice.Address.Country,
 		&invoice.Contact,
+		&invoice.BuyerVATID,
 		&invoice.Tax,
+		&invoice.TaxInclusive,
+		&invoice.Taxes,
+		&invoice.ReverseCharge,
+		&invoice.TaxExemptionReason,
 		&invoice.Unit,
 		&invoice.Description,
 		&invoice.PricePerUnit,
@@ -366,6 +576,13 @@ func GetInvoiceByInfo(db *sql.DB, id int, subscriptionID int, customerID string,
 		&invoice.GrandTotal,
 		&invoice.Currency,
 		&invoice.CurrencySymbol,
+		&invoice.DiscountID,
+		&invoice.DiscountDescription,
+		&invoice.Discount,
+		&invoice.UsageLines,
+		&invoice.ReportingCurrency,
+		&invoice.FXRate,
+		&invoice.ReportingGrandTotal,
 		&invoice.Status,
 	)
 	if err != nil {
@@ -376,6 +593,10 @@ func GetInvoiceByInfo(db *sql.DB, id int, subscriptionID int, customerID string,
 		return nil, fmt.Errorf("error parsing invoice_date: %v", err)
 	}
 	invoice.InvoiceDate = t
+	invoice.Address.Lines = address.SplitLines(addressLines)
+	if err := invoice.inCurrency(); err != nil {
+		return nil, err
+	}
 
 	return &invoice, nil
 }
@@ -386,7 +607,8 @@ func GetSubscriptionByIDCustomerIDProductCode(db *sql.DB, subscriptionID int, cu
 	query := `
 			SELECT id, customer_id, contract_start_date, duration, duration_units, 
 					billing_frequency, billing_frequency_units, price, tax, currency, 
-					product_code, billing_frequency_remains, next_invoice_date, status
+					product_code, seller_id, billing_frequency_remains, next_invoice_date, trial_days, no_card_required, status,
+					lifecycle, cancel_at_period_end, auto_renew
 			FROM subscriptions
 			WHERE id = ? AND customer_id = ? AND product_code = ? AND status != ?
 	`
@@ -399,6 +621,7 @@ func GetSubscriptionByIDCustomerIDProductCode(db *sql.DB, subscriptionID int, cu
 		subscription Subscription
 		ss           string
 		s            string
+		price        string
 	)
 	err := row.Scan(
 		&subscription.ID,
@@ -408,13 +631,19 @@ func GetSubscriptionByIDCustomerIDProductCode(db *sql.DB, subscriptionID int, cu
 		&subscription.DurationUnits,
 		&subscription.BillingFrequency,
 		&subscription.BillingFrequencyUnits,
-		&subscription.Price,
+		&price,
 		&subscription.Tax,
 		&subscription.Currency,
 		&subscription.ProductCode,
+		&subscription.SellerID,
 		&subscription.BillingFrequencyRemains,
 		&s,
+		&subscription.TrialDays,
+		&subscription.NoCardRequired,
 		&subscription.Status,
+		&subscription.Lifecycle,
+		&subscription.CancelAtPeriodEnd,
+		&subscription.AutoRenew,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -435,6 +664,10 @@ func GetSubscriptionByIDCustomerIDProductCode(db *sql.DB, subscriptionID int, cu
 	}
 	subscription.NextInvoiceDate = tt
 
+	if subscription.Price, err = money.Parse(price, subscription.Currency); err != nil {
+		return nil, fmt.Errorf("error parsing price: %v", err)
+	}
+
 	return &subscription, nil
 }
 
@@ -453,9 +686,11 @@ func SetStatusInvoice(tx *sql.Tx, id int, status Status) error {
 
 func GetInvoices(db *sql.DB, InvoicingStartedAt time.Time) ([]Invoice, error) {
 	query := `
-			SELECT id, subscription_id, customer_id, product_code, email_to, invoice_date, 
-						 name, address, contact, tax, unit, description, price_per_unit, price, 
-						 sub_total, tax_amount, grand_total, currency, currency_symbol, status
+			SELECT id, subscription_id, customer_id, product_code, seller_id, email_to, recipients, invoice_date, 
+						 name, address_lines, city, region, postal_code, country, contact, buyer_vat_id, tax, tax_inclusive, taxes, reverse_charge,
+						 tax_exemption_reason, unit, description, price_per_unit, price, 
+						 sub_total, tax_amount, grand_total, currency, currency_symbol, discount_id, discount_description,
+						 discount, usage_lines, reporting_currency, fx_rate, reporting_grand_total, status
 			FROM invoices
 			WHERE invoicing_started_at <= ? AND status = ?
 			LIMIT 100
@@ -470,20 +705,32 @@ func GetInvoices(db *sql.DB, InvoicingStartedAt time.Time) ([]Invoice, error) {
 	var invoices []Invoice
 	for rows.Next() {
 		var (
-			invoice Invoice
-			ss      string
+			invoice      Invoice
+			ss           string
+			addressLines string
 		)
 		if err := rows.Scan(
 			&invoice.ID,
 			&invoice.SubscriptionID,
 			&invoice.CustomerID,
 			&invoice.ProductCode,
+			&invoice.SellerID,
 			&invoice.EmailTo,
+			&invoice.Recipients,
 			&ss,
 			&invoice.Name,
-			&invoice.Address,
+			&addressLines,
+			&invoice.Address.City,
+			&invoice.Address.Region,
+			&invoice.Address.PostalCode,
+			&invoice.Address.Country,
 			&invoice.Contact,
+			&invoice.BuyerVATID,
 			&invoice.Tax,
+			&invoice.TaxInclusive,
+			&invoice.Taxes,
+			&invoice.ReverseCharge,
+			&invoice.TaxExemptionReason,
 			&invoice.Unit,
 			&invoice.Description,
 			&invoice.PricePerUnit,
@@ -493,6 +740,13 @@ func GetInvoices(db *sql.DB, InvoicingStartedAt time.Time) ([]Invoice, error) {
 			&invoice.GrandTotal,
 			&invoice.Currency,
 			&invoice.CurrencySymbol,
+			&invoice.DiscountID,
+			&invoice.DiscountDescription,
+			&invoice.Discount,
+			&invoice.UsageLines,
+			&invoice.ReportingCurrency,
+			&invoice.FXRate,
+			&invoice.ReportingGrandTotal,
 			&invoice.Status,
 		); err != nil {
 			return nil, fmt.Errorf("error scanning invoice row: %w", err)
@@ -502,6 +756,10 @@ func GetInvoices(db *sql.DB, InvoicingStartedAt time.Time) ([]Invoice, error) {
 			return nil, fmt.Errorf("error parsing invoice_date: %v", err)
 		}
 		invoice.InvoiceDate = t
+		invoice.Address.Lines = address.SplitLines(addressLines)
+		if err := invoice.inCurrency(); err != nil {
+			return nil, err
+		}
 		invoices = append(invoices, invoice)
 	}
 
@@ -511,3 +769,684 @@ func GetInvoices(db *sql.DB, InvoicingStartedAt time.Time) ([]Invoice, error) {
 
 	return invoices, nil
 }
+
+// GetSubscriptionCurrency returns the currency of the subscription.
+func GetSubscriptionCurrency(db *sql.DB, subscriptionID int) (string, error) {
+	var currency string
+	err := db.QueryRow("SELECT currency FROM subscriptions WHERE id = ?", subscriptionID).Scan(&currency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("subscription not found")
+		}
+		return "", fmt.Errorf("error retrieving subscription: %v", err)
+	}
+	return currency, nil
+}
+
+// GetCoupon retrieves the coupon with the code, it returns sql.ErrNoRows when
+// there is none.
+func GetCoupon(db *sql.DB, code string) (*Coupon, error) {
+	query := `
+		SELECT code, description, percent, amount, currency, cycles, redeem_by, active
+		FROM coupons
+		WHERE code = ?
+	`
+
+	var (
+		coupon   Coupon
+		amount   string
+		currency string
+		redeemBy sql.NullString
+	)
+	err := db.QueryRow(query, code).Scan(
+		&coupon.Code,
+		&coupon.Description,
+		&coupon.Percent,
+		&amount,
+		&currency,
+		&coupon.Cycles,
+		&redeemBy,
+		&coupon.Active,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// percentage coupons have no currency
+	if currency != "" {
+		if coupon.Amount, err = money.Parse(amount, currency); err != nil {
+			return nil, fmt.Errorf("error parsing amount: %v", err)
+		}
+	}
+	if redeemBy.Valid {
+		t, err := time.Parse(time.DateOnly, redeemBy.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing redeem_by: %v", err)
+		}
+		// the coupon can be redeemed until the end of the day
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		coupon.RedeemBy = &t
+	}
+
+	return &coupon, nil
+}
+
+// GetActiveDiscount retrieves the discount applying to the next invoice of the
+// subscription, amounts are in the currency. It returns nil when there is none.
+func GetActiveDiscount(db *sql.DB, subscriptionID int, currency string) (*Discount, error) {
+	query := `
+		SELECT id, subscription_id, coupon_code, description, percent, amount, cycles_remaining
+		FROM subscription_discounts
+		WHERE subscription_id = ? AND (cycles_remaining IS NULL OR cycles_remaining > 0)
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	var (
+		discount        Discount
+		amount          string
+		cyclesRemaining sql.NullInt64
+	)
+	err := db.QueryRow(query, subscriptionID).Scan(
+		&discount.ID,
+		&discount.SubscriptionID,
+		&discount.CouponCode,
+		&discount.Description,
+		&discount.Percent,
+		&amount,
+		&cyclesRemaining,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving discount: %v", err)
+	}
+
+	if discount.Amount, err = money.Parse(amount, currency); err != nil {
+		return nil, fmt.Errorf("error parsing amount: %v", err)
+	}
+	if cyclesRemaining.Valid {
+		cycles := int(cyclesRemaining.Int64)
+		discount.CyclesRemaining = &cycles
+	}
+
+	return &discount, nil
+}
+
+// InsertDiscount inserts a new discount of a subscription into the database.
+func InsertDiscount(db *sql.DB, discount *Discount) error {
+	query := `
+		INSERT INTO subscription_discounts (subscription_id, coupon_code, description, percent, amount,
+			cycles_remaining, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := db.Exec(query, discount.SubscriptionID, discount.CouponCode, discount.Description,
+		discount.Percent, discount.Amount, discount.CyclesRemaining, time.Now().UTC().Format(time.DateTime))
+	if err != nil {
+		return fmt.Errorf("error inserting discount: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting last inserted ID: %v", err)
+	}
+	discount.ID = int(id)
+
+	return nil
+}
+
+// UseDiscountCycle counts a billing cycle of the discount as used, discounts
+// applying forever are left as they are.
+func UseDiscountCycle(tx *sql.Tx, id int) error {
+	query := `
+		UPDATE subscription_discounts
+		SET cycles_remaining = cycles_remaining - 1
+		WHERE id = ? AND cycles_remaining > 0
+	`
+	_, err := tx.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("error using discount cycle: %v", err)
+	}
+	return nil
+}
+
+// InsertUsageEvent records a usage event and reports whether it was created, an
+// event already recorded with the same idempotency key for the customer is read
+// back into event instead.
+func InsertUsageEvent(db *sql.DB, event *UsageEvent) (bool, error) {
+	query := `
+		INSERT IGNORE INTO usage_events (customer_id, product_code, metric, quantity, occurred_at,
+			idempotency_key, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := db.Exec(query, event.CustomerID, event.ProductCode, event.Metric, event.Quantity,
+		event.Timestamp.UTC().Format(time.DateTime), event.IdempotencyKey, time.Now().UTC().Format(time.DateTime))
+	if err != nil {
+		return false, fmt.Errorf("error inserting usage event: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rows == 0 {
+		return false, getUsageEventByKey(db, event)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("error getting last inserted ID: %v", err)
+	}
+	event.ID = id
+
+	return true, nil
+}
+
+// getUsageEventByKey reads the event recorded with the idempotency key of the
+// customer into event.
+func getUsageEventByKey(db *sql.DB, event *UsageEvent) error {
+	query := `
+		SELECT id, product_code, metric, quantity, occurred_at
+		FROM usage_events
+		WHERE customer_id = ? AND idempotency_key = ?
+	`
+
+	var occurredAt string
+	err := db.QueryRow(query, event.CustomerID, event.IdempotencyKey).Scan(
+		&event.ID,
+		&event.ProductCode,
+		&event.Metric,
+		&event.Quantity,
+		&occurredAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error getting usage event: %v", err)
+	}
+
+	event.Timestamp, err = time.Parse(time.DateTime, occurredAt)
+	if err != nil {
+		return fmt.Errorf("error parsing occurred_at: %v", err)
+	}
+
+	return nil
+}
+
+// usageFilter returns the condition and arguments selecting the unbilled events
+// of the selection.
+func usageFilter(sel usageSelection) (string, []interface{}) {
+	cond := `customer_id = ? AND product_code = ? AND invoice_id IS NULL AND occurred_at < ?
+		AND metric IN (?` + strings.Repeat(", ?", len(sel.Metrics)-1) + `)`
+
+	args := []interface{}{sel.CustomerID, sel.ProductCode, sel.Before.UTC().Format(time.DateTime)}
+	for _, m := range sel.Metrics {
+		args = append(args, m)
+	}
+
+	return cond, args
+}
+
+// GetUnbilledUsage returns the unbilled quantity of every metric of the selection
+// and the ID of the last event counted.
+func GetUnbilledUsage(db *sql.DB, sel usageSelection) (map[string]int64, int64, error) {
+	cond, args := usageFilter(sel)
+	query := `
+		SELECT metric, SUM(quantity), MAX(id)
+		FROM usage_events
+		WHERE ` + cond + `
+		GROUP BY metric
+	`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting unbilled usage: %v", err)
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int64)
+	var upTo int64
+	for rows.Next() {
+		var (
+			metric   string
+			quantity int64
+			lastID   int64
+		)
+		if err := rows.Scan(&metric, &quantity, &lastID); err != nil {
+			return nil, 0, fmt.Errorf("error scanning unbilled usage: %v", err)
+		}
+		usage[metric] = quantity
+		if lastID > upTo {
+			upTo = lastID
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating unbilled usage: %v", err)
+	}
+
+	return usage, upTo, nil
+}
+
+// BillUsage marks the events of the selection counted on the invoice as billed,
+// events recorded after the usage was read are left for the next invoice.
+func BillUsage(tx *sql.Tx, invoiceID int, sel usageSelection) error {
+	if len(sel.Metrics) == 0 || sel.UpTo == 0 {
+		return nil
+	}
+
+	cond, args := usageFilter(sel)
+	query := `UPDATE usage_events SET invoice_id = ? WHERE ` + cond + ` AND id <= ?`
+
+	args = append([]interface{}{invoiceID}, args...)
+	if _, err := tx.Exec(query, append(args, sel.UpTo)...); err != nil {
+		return fmt.Errorf("error billing usage: %v", err)
+	}
+	return nil
+}
+
+// ReleaseUsage marks the events billed on a failed invoice as unbilled again so
+// they are charged on the next invoice.
+func ReleaseUsage(tx *sql.Tx, invoiceID int) error {
+	_, err := tx.Exec(`UPDATE usage_events SET invoice_id = NULL WHERE invoice_id = ?`, invoiceID)
+	if err != nil {
+		return fmt.Errorf("error releasing usage: %v", err)
+	}
+	return nil
+}
+
+// StartTrial gives the subscription a trial of days from its contract start
+// date and defers its first invoice until the trial ends. It reports whether the
+// subscription was found without an invoice yet.
+func StartTrial(db *sql.DB, id int, days int, noCardRequired bool) (bool, error) {
+	query := `
+		UPDATE subscriptions
+		SET trial_days = ?,
+			no_card_required = ?,
+			trial_notified_at = NULL,
+			next_invoice_date = GREATEST(next_invoice_date, DATE_ADD(contract_start_date, INTERVAL ? DAY))
+		WHERE id = ? AND status = ? AND invoicing_started_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM invoices WHERE subscription_id = ?)
+	`
+	result, err := db.Exec(query, days, noCardRequired, days, id, StatusNotStarted, id)
+	if err != nil {
+		return false, fmt.Errorf("error starting trial: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %v", err)
+	}
+	return rows > 0, nil
+}
+
+// DeferTrialInvoices moves the next invoice date of subscriptions still in
+// their trial to the end of the trial.
+func DeferTrialInvoices(db *sql.DB) (int64, error) {
+	query := `
+		UPDATE subscriptions
+		SET next_invoice_date = DATE_ADD(contract_start_date, INTERVAL trial_days DAY)
+		WHERE trial_days > 0 AND next_invoice_date < DATE_ADD(contract_start_date, INTERVAL trial_days DAY)
+	`
+	result, err := db.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("error deferring trial invoices: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %v", err)
+	}
+	return rows, nil
+}
+
+// GetTrialsEnding retrieves the subscriptions whose trial ends between from and
+// until and whose customer was not notified yet.
+func GetTrialsEnding(db *sql.DB, from, until time.Time) ([]Subscription, error) {
+	query := `
+		SELECT id, customer_id, product_code, contract_start_date, trial_days, no_card_required
+		FROM subscriptions
+		WHERE trial_days > 0 AND trial_notified_at IS NULL AND status != ? AND lifecycle = ?
+			AND DATE_ADD(contract_start_date, INTERVAL trial_days DAY) BETWEEN ? AND ?
+		ORDER BY id ASC
+	`
+
+	rows, err := db.Query(query, StatusFailed, LifecycleActive, from.Format(time.DateOnly), until.Format(time.DateOnly))
+	if err != nil {
+		return nil, fmt.Errorf("error getting trials ending: %v", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		var (
+			subscription Subscription
+			c            string
+		)
+		err := rows.Scan(
+			&subscription.ID,
+			&subscription.CustomerID,
+			&subscription.ProductCode,
+			&c,
+			&subscription.TrialDays,
+			&subscription.NoCardRequired,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning subscription row: %v", err)
+		}
+		if subscription.ContractStartDate, err = time.Parse(time.DateOnly, c); err != nil {
+			return nil, fmt.Errorf("error parsing contract_start_date: %v", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// SetTrialNotified records the customer was told the trial of the subscription ends.
+func SetTrialNotified(db *sql.DB, id int, notifiedAt time.Time) error {
+	_, err := db.Exec(`UPDATE subscriptions SET trial_notified_at = ? WHERE id = ?`, notifiedAt.Format(time.DateTime), id)
+	if err != nil {
+		return fmt.Errorf("error setting trial notified: %v", err)
+	}
+	return nil
+}
+
+// GetSubscriptionLifecycle returns the lifecycle state of the subscription,
+// sql.ErrNoRows when it does not exist.
+func GetSubscriptionLifecycle(db *sql.DB, id int) (Lifecycle, error) {
+	var lifecycle Lifecycle
+	err := db.QueryRow(`SELECT lifecycle FROM subscriptions WHERE id = ?`, id).Scan(&lifecycle)
+	if err != nil {
+		return "", err
+	}
+	return lifecycle, nil
+}
+
+// TransitionSubscription moves the subscription from one of the states from to
+// the state to and applies the assignments in set with args, as a single
+// statement so concurrent transitions cannot both succeed. It reports whether
+// the subscription was in one of the states from.
+func TransitionSubscription(db *sql.DB, id int, from []Lifecycle, to Lifecycle, set string, args ...interface{}) (bool, error) {
+	query := `UPDATE subscriptions SET lifecycle = ?`
+	if set != "" {
+		query += ", " + set
+	}
+	query += ` WHERE id = ? AND lifecycle IN (?` + strings.Repeat(", ?", len(from)-1) + `)`
+
+	args = append([]interface{}{to}, args...)
+	args = append(args, id)
+	for _, l := range from {
+		args = append(args, l)
+	}
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("error moving subscription to %s: %v", to, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %v", err)
+	}
+	return rows > 0, nil
+}
+
+// SetAutoRenew sets whether the subscription renews at the end of its term.
+func SetAutoRenew(db *sql.DB, id int, autoRenew bool) error {
+	_, err := db.Exec(`UPDATE subscriptions SET auto_renew = ? WHERE id = ?`, autoRenew, id)
+	if err != nil {
+		return fmt.Errorf("error setting auto renew: %v", err)
+	}
+	return nil
+}
+
+// CancelAtPeriodEnd cancels the subscriptions cancelled at the end of the
+// period whose next invoice is due, instead of invoicing them.
+func CancelAtPeriodEnd(db *sql.DB, today time.Time) (int64, error) {
+	query := `
+		UPDATE subscriptions
+		SET lifecycle = ?
+		WHERE cancel_at_period_end = TRUE AND lifecycle IN (?, ?) AND next_invoice_date <= ? AND status != ?
+	`
+	result, err := db.Exec(query, LifecycleCancelled, LifecycleActive, LifecyclePendingRenewal, today.Format(time.DateOnly), StatusProcessing)
+	if err != nil {
+		return 0, fmt.Errorf("error cancelling subscriptions at period end: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// EndTerms moves the active subscriptions whose term is fully invoiced to
+// pending renewal.
+func EndTerms(db *sql.DB) (int64, error) {
+	query := `
+		UPDATE subscriptions
+		SET lifecycle = ?
+		WHERE lifecycle = ? AND billing_frequency_remains = 0 AND status != ?
+	`
+	result, err := db.Exec(query, LifecyclePendingRenewal, LifecycleActive, StatusProcessing)
+	if err != nil {
+		return 0, fmt.Errorf("error ending terms: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// AutoRenew starts a new term of the subscriptions pending renewal with auto
+// renew once their next invoice is due.
+func AutoRenew(db *sql.DB, today time.Time) (int64, error) {
+	query := `
+		UPDATE subscriptions
+		SET lifecycle = ?, billing_frequency_remains = billing_frequency
+		WHERE lifecycle = ? AND auto_renew = TRUE AND next_invoice_date <= ?
+	`
+	result, err := db.Exec(query, LifecycleActive, LifecyclePendingRenewal, today.Format(time.DateOnly))
+	if err != nil {
+		return 0, fmt.Errorf("error renewing subscriptions: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// ExpireTerms expires the subscriptions pending renewal without auto renew
+// which were not renewed within the grace days after their term ended.
+func ExpireTerms(db *sql.DB, today time.Time, graceDays int) (int64, error) {
+	query := `
+		UPDATE subscriptions
+		SET lifecycle = ?
+		WHERE lifecycle = ? AND auto_renew = FALSE AND DATE_ADD(next_invoice_date, INTERVAL ? DAY) <= ?
+	`
+	result, err := db.Exec(query, LifecycleExpired, LifecyclePendingRenewal, graceDays, today.Format(time.DateOnly))
+	if err != nil {
+		return 0, fmt.Errorf("error expiring subscriptions: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// UpsertFXRates stores the rates, replacing the rate of a pair already stored
+// for the day.
+func UpsertFXRates(db *sql.DB, rates []fx.Rate) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error calling Begin for transaction: %v", err)
+	}
+
+	query := `
+		INSERT INTO fx_rates (rate_date, base, quote, rate)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE rate = VALUES(rate)
+	`
+	for _, r := range rates {
+		rate, err := r.Rat()
+		if err == nil {
+			_, err = tx.Exec(query, r.Date, r.Base, r.Quote, fx.FormatRate(rate))
+		}
+		if err != nil {
+			if err := tx.Rollback(); err != nil {
+				return fmt.Errorf("error calling transaction Rollback: %v", err)
+			}
+			return fmt.Errorf("error storing rate of %s to %s: %v", r.Base, r.Quote, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error calling transaction Commit: %v", err)
+	}
+	return nil
+}
+
+// GetFXRate returns the latest rate of base in quote on or before the date, the
+// inverse of the rate of quote in base when only that is stored. It returns
+// sql.ErrNoRows when neither is stored.
+func GetFXRate(db *sql.DB, base, quote string, date time.Time) (*big.Rat, error) {
+	if base == quote {
+		return big.NewRat(1, 1), nil
+	}
+
+	query := `
+		SELECT base, rate
+		FROM fx_rates
+		WHERE ((base = ? AND quote = ?) OR (base = ? AND quote = ?)) AND rate_date <= ?
+		ORDER BY rate_date DESC, base = ? DESC
+		LIMIT 1
+	`
+
+	var from, rate string
+	err := db.QueryRow(query, base, quote, quote, base, date.Format(time.DateOnly), base).Scan(&from, &rate)
+	if err != nil {
+		return nil, err
+	}
+
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate of %s to %s: %q", base, quote, rate)
+	}
+	if from != base {
+		r.Inv(r)
+	}
+
+	return r, nil
+}
+
+// Revenue is the revenue of the sent invoices in a currency
+type Revenue struct {
+	Currency       string       `json:"currency"`
+	Invoices       int          `json:"invoices"`
+	GrandTotal     money.Amount `json:"grandTotal"`
+	ReportingTotal money.Amount `json:"reportingTotal"`
+}
+
+// GetRevenue returns the revenue per currency of the invoices sent with their
+// grand total converted to the reporting currency, dated from from to to.
+func GetRevenue(db *sql.DB, reportingCurrency string, from, to time.Time) ([]Revenue, error) {
+	query := `
+		SELECT currency, COUNT(*), SUM(grand_total), SUM(reporting_grand_total)
+		FROM invoices
+		WHERE status = ? AND reporting_currency = ? AND invoice_date BETWEEN ? AND ?
+		GROUP BY currency
+		ORDER BY currency ASC
+	`
+
+	rows, err := db.Query(query, StatusDone, reportingCurrency, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return nil, fmt.Errorf("error getting revenue: %v", err)
+	}
+	defer rows.Close()
+
+	var revenue []Revenue
+	for rows.Next() {
+		var (
+			r                          Revenue
+			grandTotal, reportingTotal string
+		)
+		if err := rows.Scan(&r.Currency, &r.Invoices, &grandTotal, &reportingTotal); err != nil {
+			return nil, fmt.Errorf("error scanning revenue: %v", err)
+		}
+		if r.GrandTotal, err = money.Parse(grandTotal, r.Currency); err != nil {
+			return nil, fmt.Errorf("error parsing grand_total: %v", err)
+		}
+		if r.ReportingTotal, err = money.Parse(reportingTotal, reportingCurrency); err != nil {
+			return nil, fmt.Errorf("error parsing reporting_grand_total: %v", err)
+		}
+		revenue = append(revenue, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revenue, nil
+}
+
+// InsertEmailBounce records a hard bounce of the invoice email and reports
+// whether it was created, a bounce of the recipient already recorded for the
+// invoice at the same time is not recorded again.
+func InsertEmailBounce(db *sql.DB, invoiceID int, bounce *EmailBounce) (bool, error) {
+	query := `
+		INSERT IGNORE INTO email_bounces (invoice_id, customer_id, product_code, email_id, recipient,
+			status, diagnostic, bounced_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := db.Exec(query, invoiceID, bounce.CustomerID, bounce.ProductCode, bounce.EmailID, bounce.Recipient,
+		bounce.Status, bounce.Diagnostic, bounce.BouncedAt.UTC().Format(time.DateTime), time.Now().UTC().Format(time.DateTime))
+	if err != nil {
+		return false, fmt.Errorf("error inserting email bounce: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rows == 0 {
+		return false, nil
+	}
+
+	bounce.ID, err = result.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("error getting last inserted ID: %v", err)
+	}
+
+	return true, nil
+}
+
+// GetEmailBounces returns the hard bounces of the invoice emails of the
+// customer, the latest first.
+func GetEmailBounces(db *sql.DB, customerID string) ([]EmailBounce, error) {
+	query := `
+		SELECT b.id, b.invoice_id, i.subscription_id, b.product_code, b.email_id, b.recipient,
+			b.status, COALESCE(b.diagnostic, ''), b.bounced_at
+		FROM email_bounces b
+		JOIN invoices i ON i.id = b.invoice_id
+		WHERE b.customer_id = ?
+		ORDER BY b.bounced_at DESC, b.id DESC
+	`
+
+	rows, err := db.Query(query, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting email bounces: %v", err)
+	}
+	defer rows.Close()
+
+	bounces := []EmailBounce{}
+	for rows.Next() {
+		var (
+			b         = EmailBounce{CustomerID: customerID}
+			invoice   Invoice
+			bouncedAt string
+		)
+		err := rows.Scan(&b.ID, &invoice.ID, &invoice.SubscriptionID, &b.ProductCode, &b.EmailID, &b.Recipient,
+			&b.Status, &b.Diagnostic, &bouncedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning email bounce: %v", err)
+		}
+		if b.BouncedAt, err = time.Parse(time.DateTime, bouncedAt); err != nil {
+			return nil, fmt.Errorf("error parsing bounced_at: %v", err)
+		}
+		invoice.CustomerID, invoice.ProductCode = customerID, b.ProductCode
+		b.InvoiceID = invoice.GetInvoiceID()
+		bounces = append(bounces, b)
+	}
+
+	return bounces, rows.Err()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
+	"github.com/go-chi/chi/v5"
+)
+
+// Coupon is a promotional discount customers redeem on a subscription by its code
+type Coupon struct {
+	Code        string       `json:"code"`
+	Description string       `json:"description"`
+	Percent     float64      `json:"percent"`
+	Amount      money.Amount `json:"amount"`
+	// Cycles is the number of billing cycles the discount applies for, 0 is forever
+	Cycles   int        `json:"cycles"`
+	RedeemBy *time.Time `json:"redeemBy,omitempty"`
+	Active   bool       `json:"active"`
+}
+
+// Discount is a percentage or fixed amount taken off the price of the invoices
+// of a subscription
+type Discount struct {
+	ID             int    `json:"id"`
+	SubscriptionID int    `json:"subscriptionID"`
+	CouponCode     string `json:"couponCode,omitempty"`
+	Description    string `json:"description"`
+	// Percent is the percentage of the price taken off, Amount the fixed amount
+	// taken off, only one of them is set
+	Percent float64      `json:"percent"`
+	Amount  money.Amount `json:"amount"`
+	// CyclesRemaining is the number of billing cycles the discount still applies
+	// for, nil applies forever
+	CyclesRemaining *int `json:"cyclesRemaining"`
+}
+
+// Validate checks the discount takes either a percentage or a fixed amount off.
+func (d Discount) Validate() error {
+	if d.Description == "" {
+		return errors.New("empty discount description")
+	}
+	if d.Percent < 0 || d.Percent > 100 || math.IsNaN(d.Percent) {
+		return fmt.Errorf("invalid discount percent: %v", d.Percent)
+	}
+	if d.Amount.Sign() < 0 {
+		return fmt.Errorf("invalid discount amount: %s", d.Amount)
+	}
+	if (d.Percent == 0) == d.Amount.IsZero() {
+		return errors.New("discount must have either a percent or an amount")
+	}
+	if d.CyclesRemaining != nil && *d.CyclesRemaining <= 0 {
+		return fmt.Errorf("invalid discount cycles: %d", *d.CyclesRemaining)
+	}
+
+	return nil
+}
+
+// Apply returns the amount taken off the price, percentages are rounded half up
+// and fixed amounts are limited to the price.
+func (d Discount) Apply(price money.Amount) (money.Amount, error) {
+	if d.Percent != 0 {
+		r, _ := new(big.Rat).SetString(strconv.FormatFloat(d.Percent, 'f', -1, 64))
+		return price.MulRat(r.Quo(r, big.NewRat(100, 1)), money.HalfUp)
+	}
+
+	c, err := d.Amount.Cmp(price)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	if c > 0 {
+		return price, nil
+	}
+	return d.Amount, nil
+}
+
+// applyDiscount recalculates the totals with the discount taken off the price
+// and the usage, a nil discount takes nothing off.
+func applyDiscount(totals tax.Totals, d *Discount) (tax.Totals, error) {
+	currency := totals.Price.Currency()
+	if d == nil {
+		var err error
+		if totals.Discount, err = money.Zero(currency); err != nil {
+			return tax.Totals{}, err
+		}
+		return totals.Recalculate()
+	}
+
+	charged, err := money.Sum(currency, append([]money.Amount{totals.Price}, totals.Usage...)...)
+	if err != nil {
+		return tax.Totals{}, err
+	}
+
+	amount, err := d.Apply(charged)
+	if err != nil {
+		return tax.Totals{}, fmt.Errorf("error applying discount %d: %v", d.ID, err)
+	}
+
+	totals.Discount = amount
+	return totals.Recalculate()
+}
+
+// discountFromCoupon returns the discount a coupon gives on a subscription in the
+// currency, the coupon must be active and fixed amounts must be in the currency.
+func discountFromCoupon(coupon Coupon, subscriptionID int, currency string, now time.Time) (Discount, error) {
+	if !coupon.Active {
+		return Discount{}, fmt.Errorf("coupon %s is not active", coupon.Code)
+	}
+	if coupon.RedeemBy != nil && now.After(*coupon.RedeemBy) {
+		return Discount{}, fmt.Errorf("coupon %s expired on %s", coupon.Code, coupon.RedeemBy.Format(time.DateOnly))
+	}
+	if !coupon.Amount.IsZero() && coupon.Amount.Currency() != currency {
+		return Discount{}, fmt.Errorf("coupon %s is in %s, not in the subscription currency %s", coupon.Code, coupon.Amount.Currency(), currency)
+	}
+
+	d := Discount{
+		SubscriptionID: subscriptionID,
+		CouponCode:     coupon.Code,
+		Description:    coupon.Description,
+		Percent:        coupon.Percent,
+		Amount:         coupon.Amount,
+	}
+	if d.Description == "" {
+		d.Description = coupon.Code
+	}
+	if coupon.Cycles > 0 {
+		cycles := coupon.Cycles
+		d.CyclesRemaining = &cycles
+	}
+
+	return d, d.Validate()
+}
+
+// addDiscount adds the discount returned by newDiscount to the subscription of
+// the request, a subscription has at most one discount applying at a time.
+// newDiscount returns the status code of the response when it fails.
+func addDiscount(w http.ResponseWriter, r *http.Request, newDiscount func(subscriptionID int, currency string) (Discount, int, error)) {
+	subscriptionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	currency, err := GetSubscriptionCurrency(db, subscriptionID)
+	if err != nil {
+		log.Printf("Error calling GetSubscriptionCurrency: %v\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	active, err := GetActiveDiscount(db, subscriptionID, currency)
+	if err != nil {
+		log.Printf("Error calling GetActiveDiscount: %v\n", err)
+		http.Error(w, "Error calling GetActiveDiscount", http.StatusInternalServerError)
+		return
+	}
+	if active != nil {
+		http.Error(w, "Subscription already has a discount", http.StatusConflict)
+		return
+	}
+
+	discount, status, err := newDiscount(subscriptionID, currency)
+	if err != nil {
+		log.Printf("Error creating discount for subscription %d: %v\n", subscriptionID, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if discount.Amount.IsZero() {
+		discount.Amount, _ = money.Zero(currency)
+	}
+
+	if err := InsertDiscount(db, &discount); err != nil {
+		log.Printf("Error calling InsertDiscount: %v\n", err)
+		http.Error(w, "Error calling InsertDiscount", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(discount)
+}
+
+// redeemCouponHandler redeems a coupon on a subscription
+func redeemCouponHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	addDiscount(w, r, func(subscriptionID int, currency string) (Discount, int, error) {
+		coupon, err := GetCoupon(db, requestBody.Code)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return Discount{}, http.StatusNotFound, fmt.Errorf("coupon %s not found", requestBody.Code)
+			}
+			return Discount{}, http.StatusInternalServerError, err
+		}
+
+		d, err := discountFromCoupon(*coupon, subscriptionID, currency, time.Now())
+		if err != nil {
+			return Discount{}, http.StatusUnprocessableEntity, err
+		}
+		return d, 0, nil
+	})
+}
+
+// createDiscountHandler gives a subscription a percentage or fixed amount discount
+func createDiscountHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody Discount
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	addDiscount(w, r, func(subscriptionID int, currency string) (Discount, int, error) {
+		if !requestBody.Amount.IsZero() && requestBody.Amount.Currency() != currency {
+			return Discount{}, http.StatusUnprocessableEntity, fmt.Errorf("discount amount is not in the subscription currency %s", currency)
+		}
+
+		d := Discount{
+			SubscriptionID:  subscriptionID,
+			Description:     requestBody.Description,
+			Percent:         requestBody.Percent,
+			Amount:          requestBody.Amount,
+			CyclesRemaining: requestBody.CyclesRemaining,
+		}
+		if err := d.Validate(); err != nil {
+			return Discount{}, http.StatusUnprocessableEntity, err
+		}
+		return d, 0, nil
+	})
+}
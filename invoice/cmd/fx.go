@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/fx"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/go-chi/chi/v5"
+)
+
+// reportingCurrency is the currency the grand total of every invoice is
+// converted to for reporting, empty for none
+var reportingCurrency string
+
+// parseReportingCurrency checks the reporting currency is an ISO 4217 currency,
+// empty disables reporting.
+func parseReportingCurrency(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if _, err := money.MinorUnits(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// loadFXRates stores the rates of the file, an empty path loads nothing.
+func loadFXRates(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	rates, err := fx.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := UpsertFXRates(db, rates); err != nil {
+		return err
+	}
+
+	log.Printf("Loaded %d FX rates from %s\n", len(rates), path)
+	return nil
+}
+
+// setReportingTotal converts the grand total of the invoice to the reporting
+// currency at the rate of the invoice date.
+func setReportingTotal(invoice *Invoice) error {
+	invoice.FXRate = "1"
+	if reportingCurrency == "" {
+		return nil
+	}
+
+	rate, err := GetFXRate(db, invoice.Currency, reportingCurrency, invoice.InvoiceDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no FX rate of %s to %s on %s", invoice.Currency, reportingCurrency, invoice.InvoiceDate.Format(time.DateOnly))
+		}
+		return err
+	}
+
+	total, err := invoice.GrandTotal.Convert(rate, reportingCurrency, money.HalfUp)
+	if err != nil {
+		return err
+	}
+
+	invoice.ReportingCurrency = reportingCurrency
+	invoice.FXRate = fx.FormatRate(rate)
+	invoice.ReportingGrandTotal = total
+	return nil
+}
+
+// createFXRatesHandler stores a list of rates, rates of a pair already stored
+// for the day are replaced
+func createFXRatesHandler(w http.ResponseWriter, r *http.Request) {
+	var rates []fx.Rate
+	if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	for i, rate := range rates {
+		if err := rate.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("rate %d: %v", i+1, err), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if err := UpsertFXRates(db, rates); err != nil {
+		log.Printf("Error calling UpsertFXRates: %v\n", err)
+		http.Error(w, "Error calling UpsertFXRates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rates)
+}
+
+// getFXRateHandler returns the rate of a pair on the date of the query, today
+// by default
+func getFXRateHandler(w http.ResponseWriter, r *http.Request) {
+	rate := fx.Rate{
+		Date:  r.URL.Query().Get("date"),
+		Base:  chi.URLParam(r, "base"),
+		Quote: chi.URLParam(r, "quote"),
+	}
+	if rate.Date == "" {
+		rate.Date = time.Now().UTC().Format(time.DateOnly)
+	}
+	date, err := time.Parse(time.DateOnly, rate.Date)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid date: %q", rate.Date), http.StatusBadRequest)
+		return
+	}
+
+	v, err := GetFXRate(db, rate.Base, rate.Quote, date)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("Error calling GetFXRate: %v\n", err)
+		http.Error(w, "Error calling GetFXRate", http.StatusInternalServerError)
+		return
+	}
+	rate.Rate = fx.FormatRate(v)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rate)
+}
+
+// revenueReportHandler totals the revenue of the invoices sent between the from
+// and to dates of the query in the reporting currency
+func revenueReportHandler(w http.ResponseWriter, r *http.Request) {
+	if reportingCurrency == "" {
+		http.Error(w, "No reporting currency configured", http.StatusNotFound)
+		return
+	}
+
+	from, err := time.Parse(time.DateOnly, r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from date", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse(time.DateOnly, r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to date", http.StatusBadRequest)
+		return
+	}
+
+	revenue, err := GetRevenue(db, reportingCurrency, from, to)
+	if err != nil {
+		log.Printf("Error calling GetRevenue: %v\n", err)
+		http.Error(w, "Error calling GetRevenue", http.StatusInternalServerError)
+		return
+	}
+
+	total, _ := money.Zero(reportingCurrency)
+	for _, rev := range revenue {
+		if total, err = total.Add(rev.ReportingTotal); err != nil {
+			log.Printf("Error adding revenue: %v\n", err)
+			http.Error(w, "Error adding revenue", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ReportingCurrency string       `json:"reportingCurrency"`
+		From              string       `json:"from"`
+		To                string       `json:"to"`
+		Total             money.Amount `json:"total"`
+		Currencies        []Revenue    `json:"currencies"`
+	}{
+		ReportingCurrency: reportingCurrency,
+		From:              from.Format(time.DateOnly),
+		To:                to.Format(time.DateOnly),
+		Total:             total,
+		Currencies:        revenue,
+	})
+}
@@ -4,31 +4,51 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/contact"
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
 )
 
 type Account struct {
-	ProductDescription string  `json:"productDescription"`
-	Quantity           int     `json:"quantity"`
-	UnitPrice          float64 `json:"unitPrice"`
-	Price              float64 `json:"price"`
-	SubTotal           float64 `json:"subTotal"`
-	Tax                int     `json:"tax"`
-	TaxAmount          float64 `json:"taxAmount"`
-	GrandTotal         float64 `json:"grandTotal"`
-	Currency           string  `json:"currency"`
-	CurrencySymbol     string  `json:"currencySymbol"`
+	ProductDescription string        `json:"productDescription"`
+	Quantity           int           `json:"quantity"`
+	UnitPrice          money.Amount  `json:"unitPrice"`
+	TaxRates           []tax.Rate    `json:"taxRates"`
+	TaxInclusive       bool          `json:"taxInclusive"`
+	Price              money.Amount  `json:"price"`
+	SubTotal           money.Amount  `json:"subTotal"`
+	Tax                float64       `json:"tax"`
+	Taxes              tax.Breakdown `json:"taxes"`
+	TaxAmount          money.Amount  `json:"taxAmount"`
+	GrandTotal         money.Amount  `json:"grandTotal"`
+	Currency           string        `json:"currency"`
+	// Metered prices the usage of the product charged on top of the price
+	Metered []metering.Price `json:"metered"`
 }
 
+// totalsMode decides whether accounts with inconsistent totals are skipped or corrected
+var totalsMode tax.Mode
+
 type Customer struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Address string `json:"address"`
-	Contact string `json:"contact"`
+	Name    string          `json:"name"`
+	Email   string          `json:"email"`
+	Address address.Address `json:"address"`
+	Contact string          `json:"contact"`
+	VATID   string          `json:"vatID"`
+	// Contacts are the billing contacts of the customer
+	Contacts []contact.Contact `json:"contacts"`
+	// Locale is the language the customer is emailed in
+	Locale string `json:"locale"`
 }
 
 // GetPendingSubscriptions retrieves pending subscriptions from the database.
@@ -116,6 +136,41 @@ func parseCustomerResponse(body io.Reader) (*Customer, error) {
 	return &customer, nil
 }
 
+// checkAccountTotals recalculates the totals of the account from the unit price,
+// quantity and tax rates. In strict mode totals which differ are rejected with a
+// *tax.MismatchError, in correct mode the recalculated totals are returned.
+func checkAccountTotals(account Account) (tax.Totals, error) {
+	if err := tax.Validate(account.TaxRates); err != nil {
+		return tax.Totals{}, err
+	}
+
+	if account.UnitPrice.Currency() != account.Currency {
+		return tax.Totals{}, fmt.Errorf("unit price %s is not in the account currency %s", account.UnitPrice, account.Currency)
+	}
+
+	totals, err := tax.Check(tax.Totals{
+		UnitPrice:  account.UnitPrice,
+		Quantity:   account.Quantity,
+		Rates:      account.TaxRates,
+		Inclusive:  account.TaxInclusive,
+		Tax:        account.Tax,
+		Price:      account.Price,
+		SubTotal:   account.SubTotal,
+		Taxes:      account.Taxes,
+		TaxAmount:  account.TaxAmount,
+		GrandTotal: account.GrandTotal,
+	})
+	if err != nil {
+		var mErr *tax.MismatchError
+		if totalsMode != tax.Correct || !errors.As(err, &mErr) {
+			return tax.Totals{}, err
+		}
+		log.Printf("Correcting totals of account: %v\n", err)
+	}
+
+	return totals, nil
+}
+
 func getDoneURL(invoice Invoice) string {
 	return fmt.Sprintf("%s%s/%s", os.Getenv("BASE_URL"), cbURLPath, invoice.GetInvoiceID())
 }
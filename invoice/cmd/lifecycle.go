@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Lifecycle is the state of a subscription, independent of the progress of its
+// invoicing kept in Status
+type Lifecycle string
+
+const (
+	// LifecycleActive subscriptions are invoiced
+	LifecycleActive Lifecycle = "active"
+	// LifecyclePaused subscriptions are not invoiced, their next invoice is
+	// deferred by the time paused when they resume
+	LifecyclePaused Lifecycle = "paused"
+	// LifecycleCancelled subscriptions are never invoiced again
+	LifecycleCancelled Lifecycle = "cancelled"
+	// LifecycleExpired subscriptions ended their term without renewing, they
+	// can still be renewed
+	LifecycleExpired Lifecycle = "expired"
+	// LifecyclePendingRenewal subscriptions invoiced their whole term and are
+	// renewed or expire when it ends
+	LifecyclePendingRenewal Lifecycle = "pending_renewal"
+)
+
+// The states each transition starts from, any other state is refused
+var (
+	pauseFrom  = []Lifecycle{LifecycleActive}
+	resumeFrom = []Lifecycle{LifecyclePaused}
+	cancelFrom = []Lifecycle{LifecycleActive, LifecyclePaused, LifecyclePendingRenewal}
+	renewFrom  = []Lifecycle{LifecyclePendingRenewal, LifecycleExpired}
+)
+
+// renewalGraceDays is the number of days after the end of its term a
+// subscription without auto renew can still be renewed before it expires
+var renewalGraceDays int
+
+// parseRenewalGraceDays parses the renewal grace days, 0 when empty.
+func parseRenewalGraceDays(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid renewal grace days: %q", s)
+	}
+	return days, nil
+}
+
+// processLifecycle cancels the subscriptions cancelled at the end of their
+// period and renews or expires the subscriptions whose term ended, before the
+// due invoices are created.
+func processLifecycle(today time.Time) error {
+	if n, err := CancelAtPeriodEnd(db, today); err != nil {
+		return err
+	} else if n > 0 {
+		log.Printf("Cancelled %d subscriptions at period end\n", n)
+	}
+
+	if n, err := EndTerms(db); err != nil {
+		return err
+	} else if n > 0 {
+		log.Printf("Ended the term of %d subscriptions\n", n)
+	}
+
+	if n, err := AutoRenew(db, today); err != nil {
+		return err
+	} else if n > 0 {
+		log.Printf("Renewed %d subscriptions\n", n)
+	}
+
+	if n, err := ExpireTerms(db, today, renewalGraceDays); err != nil {
+		return err
+	} else if n > 0 {
+		log.Printf("Expired %d subscriptions\n", n)
+	}
+
+	return nil
+}
+
+// transition moves the subscription of the request from one of the states from
+// to the state to, see TransitionSubscription. It responds 409 when the
+// subscription is in another state.
+func transition(w http.ResponseWriter, r *http.Request, from []Lifecycle, to Lifecycle, set string, args ...interface{}) {
+	subscriptionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	moved, err := TransitionSubscription(db, subscriptionID, from, to, set, args...)
+	if err != nil {
+		log.Printf("Error calling TransitionSubscription: %v\n", err)
+		http.Error(w, "Error calling TransitionSubscription", http.StatusInternalServerError)
+		return
+	}
+
+	lifecycle, err := GetSubscriptionLifecycle(db, subscriptionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("Error calling GetSubscriptionLifecycle: %v\n", err)
+		http.Error(w, "Error calling GetSubscriptionLifecycle", http.StatusInternalServerError)
+		return
+	}
+	if !moved {
+		http.Error(w, fmt.Sprintf("Subscription is %s, it cannot become %s", lifecycle, to), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": subscriptionID, "lifecycle": lifecycle})
+}
+
+// today returns the date in UTC, the dates of the subscriptions are UTC dates
+// whatever the time zone of the database session
+func today() string {
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+// pauseSubscriptionHandler stops invoicing an active subscription
+func pauseSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	transition(w, r, pauseFrom, LifecyclePaused, "paused_at = ?", today())
+}
+
+// resumeSubscriptionHandler invoices a paused subscription again, the next
+// invoice date is shifted by the days it was paused
+func resumeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	transition(w, r, resumeFrom, LifecycleActive,
+		"next_invoice_date = DATE_ADD(next_invoice_date, INTERVAL GREATEST(DATEDIFF(?, paused_at), 0) DAY), paused_at = NULL", today())
+}
+
+// cancelSubscriptionHandler cancels a subscription right away or at the end of
+// the period already invoiced
+func cancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		AtPeriodEnd bool `json:"atPeriodEnd"`
+	}
+	// the body is optional, cancelling right away
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil && err != io.EOF {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if requestBody.AtPeriodEnd {
+		// the subscription stays active until its next invoice is due
+		transition(w, r, []Lifecycle{LifecycleActive}, LifecycleActive, "cancel_at_period_end = TRUE")
+		return
+	}
+	transition(w, r, cancelFrom, LifecycleCancelled, "")
+}
+
+// renewSubscriptionHandler starts a new term of a subscription pending renewal
+// or expired, an expired subscription is invoiced from today
+func renewSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	transition(w, r, renewFrom, LifecycleActive,
+		"billing_frequency_remains = billing_frequency, next_invoice_date = GREATEST(next_invoice_date, ?), cancel_at_period_end = FALSE", today())
+}
+
+// autoRenewHandler sets whether a subscription renews at the end of its term
+func autoRenewHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var requestBody struct {
+		AutoRenew bool `json:"autoRenew"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := GetSubscriptionLifecycle(db, subscriptionID); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("Error calling GetSubscriptionLifecycle: %v\n", err)
+		http.Error(w, "Error calling GetSubscriptionLifecycle", http.StatusInternalServerError)
+		return
+	}
+
+	if err := SetAutoRenew(db, subscriptionID, requestBody.AutoRenew); err != nil {
+		log.Printf("Error calling SetAutoRenew: %v\n", err)
+		http.Error(w, "Error calling SetAutoRenew", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requestBody)
+}
@@ -11,6 +11,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/arifmahmudrana/invoice/migrate"
+	"github.com/arifmahmudrana/invoice/tax"
 	"github.com/go-chi/chi/v5"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/robfig/cron/v3"
@@ -38,12 +40,63 @@ func main() {
 		log.Fatalf("Error creating table: %v", err)
 	}
 
+	// Upgrade the tables created by older versions before serving
+	if err := migrate.Run(db, migrations); err != nil {
+		log.Fatalf("Error migrating tables: %v", err)
+	}
+
+	// Load how accounts with inconsistent totals are handled
+	totalsMode, err = tax.ParseMode(os.Getenv("TOTALS_MODE"))
+	if err != nil {
+		log.Fatalf("Error parsing TOTALS_MODE: %v", err)
+	}
+
+	// Load how trials and zero amount invoices are handled
+	skipZeroInvoices, err = parseZeroAmountInvoices(os.Getenv("ZERO_AMOUNT_INVOICES"))
+	if err != nil {
+		log.Fatalf("Error parsing ZERO_AMOUNT_INVOICES: %v", err)
+	}
+	trialNoticeDays, err = parseTrialNoticeDays(os.Getenv("TRIAL_NOTICE_DAYS"))
+	if err != nil {
+		log.Fatalf("Error parsing TRIAL_NOTICE_DAYS: %v", err)
+	}
+	renewalGraceDays, err = parseRenewalGraceDays(os.Getenv("RENEWAL_GRACE_DAYS"))
+	if err != nil {
+		log.Fatalf("Error parsing RENEWAL_GRACE_DAYS: %v", err)
+	}
+
+	// Load the VAT identities of the sellers, which decide the reverse charge
+	sellerTaxIDs, defaultSellerID, err = loadSellerTaxIDs()
+	if err != nil {
+		log.Fatalf("Error loading sellers: %v", err)
+	}
+
+	// Load when invoices are due and where they are paid
+	paymentTermsDays, err = parsePaymentTermsDays(os.Getenv("PAYMENT_TERMS_DAYS"))
+	if err != nil {
+		log.Fatalf("Error parsing PAYMENT_TERMS_DAYS: %v", err)
+	}
+	payURL, err = parsePayURL(os.Getenv("PAY_URL"))
+	if err != nil {
+		log.Fatalf("Error parsing PAY_URL: %v", err)
+	}
+
+	// Load the reporting currency and the FX rates to convert to it
+	reportingCurrency, err = parseReportingCurrency(os.Getenv("REPORTING_CURRENCY"))
+	if err != nil {
+		log.Fatalf("Error parsing REPORTING_CURRENCY: %v", err)
+	}
+	if err := loadFXRates(os.Getenv("FX_RATES_FILE")); err != nil {
+		log.Fatalf("Error loading FX_RATES_FILE: %v", err)
+	}
+
 	// Create cron scheduler
 	c := cron.New()
 
 	// Add scheduled tasks to the cron scheduler
 	c.AddFunc("@hourly", processStalledInvoices)
 	c.AddFunc("@daily", processInvoiceDaily)
+	c.AddFunc("@daily", notifyTrialsEnding)
 
 	// Start cron scheduler in a separate goroutine
 	go c.Start()
@@ -137,6 +190,30 @@ func main() {
 			return
 		}
 
+		// A billing cycle of the discount is used once the invoice is sent
+		if status == StatusDone && invoice.DiscountID != 0 {
+			if err = UseDiscountCycle(tx, invoice.DiscountID); err != nil {
+				log.Printf("Error calling UseDiscountCycle: %v\n", err)
+				if err := tx.Rollback(); err != nil {
+					log.Printf("Error calling transaction Rollback: %v\n", err)
+				}
+				http.Error(w, "Error calling UseDiscountCycle", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// The usage of a failed invoice is charged on the next one
+		if status == StatusFailed {
+			if err = ReleaseUsage(tx, invoice.ID); err != nil {
+				log.Printf("Error calling ReleaseUsage: %v\n", err)
+				if err := tx.Rollback(); err != nil {
+					log.Printf("Error calling transaction Rollback: %v\n", err)
+				}
+				http.Error(w, "Error calling ReleaseUsage", http.StatusInternalServerError)
+				return
+			}
+		}
+
 		if err = tx.Commit(); err != nil {
 			// Rollback the transaction if commit fails and log the error
 			log.Printf("Error calling transaction Commit: %v\n", err)
@@ -150,6 +227,20 @@ func main() {
 		// Return success response
 		w.WriteHeader(http.StatusOK)
 	})
+	r.Post("/api/subscriptions/{id}/coupons", redeemCouponHandler)
+	r.Post("/api/subscriptions/{id}/discounts", createDiscountHandler)
+	r.Post("/api/usage", ingestUsageHandler)
+	r.Post("/api/subscriptions/{id}/trial", startTrialHandler)
+	r.Post("/api/subscriptions/{id}/pause", pauseSubscriptionHandler)
+	r.Post("/api/subscriptions/{id}/resume", resumeSubscriptionHandler)
+	r.Post("/api/subscriptions/{id}/cancel", cancelSubscriptionHandler)
+	r.Post("/api/subscriptions/{id}/renew", renewSubscriptionHandler)
+	r.Post("/api/subscriptions/{id}/auto-renew", autoRenewHandler)
+	r.Post("/api/fx-rates", createFXRatesHandler)
+	r.Get("/api/fx-rates/{base}/{quote}", getFXRateHandler)
+	r.Get("/api/reports/revenue", revenueReportHandler)
+	r.Post("/api/invoices/{invoiceID}/bounces", reportBounceHandler)
+	r.Get("/api/email-bounces", emailBouncesHandler)
 
 	// Start the HTTP server
 	srv := &http.Server{
@@ -0,0 +1,130 @@
+package main
+
+import "github.com/arifmahmudrana/invoice/migrate"
+
+// migrations upgrade the tables created by older versions of the service to
+// the schema of createTable, they are run at startup before serving
+var migrations = []migrate.Migration{
+	{
+		Version:     1,
+		Description: "structured addresses",
+		Steps: []migrate.Step{
+			migrate.AddColumn("invoices", "address_lines", "VARCHAR(255) NOT NULL DEFAULT '' AFTER name"),
+			migrate.AddColumn("invoices", "city", "VARCHAR(255) NOT NULL DEFAULT '' AFTER address_lines"),
+			migrate.AddColumn("invoices", "region", "VARCHAR(255) NOT NULL DEFAULT '' AFTER city"),
+			migrate.AddColumn("invoices", "postal_code", "VARCHAR(32) NOT NULL DEFAULT '' AFTER region"),
+			migrate.AddColumn("invoices", "country", "CHAR(2) NOT NULL DEFAULT '' AFTER postal_code"),
+			migrate.SplitAddress("invoices"),
+			migrate.DropColumn("invoices", "address"),
+			migrate.ModifyColumn("invoices", "address_lines", "VARCHAR(255) NOT NULL"),
+			migrate.ModifyColumn("invoices", "city", "VARCHAR(255) NOT NULL"),
+			migrate.ModifyColumn("invoices", "country", "CHAR(2) NOT NULL"),
+		},
+	},
+	{
+		Version:     2,
+		Description: "sellers",
+		Steps: []migrate.Step{
+			migrate.AddColumn("subscriptions", "seller_id", "VARCHAR(255) NOT NULL DEFAULT '' AFTER product_code"),
+			migrate.AddColumn("invoices", "seller_id", "VARCHAR(255) NOT NULL DEFAULT '' AFTER product_code"),
+		},
+	},
+	{
+		Version:     3,
+		Description: "buyer VAT IDs",
+		Steps: []migrate.Step{
+			migrate.AddColumn("invoices", "buyer_vat_id", "VARCHAR(32) NOT NULL DEFAULT '' AFTER contact"),
+		},
+	},
+	{
+		Version:     4,
+		Description: "decimal and compound tax rates",
+		Steps: []migrate.Step{
+			migrate.ModifyColumn("subscriptions", "tax", "DECIMAL(7, 4) NOT NULL"),
+			migrate.ModifyColumn("invoices", "tax", "DECIMAL(7, 4) NOT NULL"),
+			migrate.AddColumn("invoices", "tax_inclusive", "BOOLEAN NOT NULL DEFAULT FALSE AFTER tax"),
+			migrate.AddColumn("invoices", "taxes", "TEXT AFTER tax_inclusive"),
+		},
+	},
+	{
+		Version:     5,
+		Description: "exact amounts",
+		Steps: []migrate.Step{
+			migrate.ModifyColumn("subscriptions", "price", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("invoices", "price_per_unit", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("invoices", "price", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("invoices", "sub_total", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("invoices", "tax_amount", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("invoices", "grand_total", "DECIMAL(19, 4) NOT NULL"),
+		},
+	},
+	{
+		Version:     6,
+		Description: "discounts",
+		Steps: []migrate.Step{
+			migrate.AddColumn("invoices", "discount_id", "INT NOT NULL DEFAULT 0 AFTER currency_symbol"),
+			migrate.AddColumn("invoices", "discount_description", "VARCHAR(255) NOT NULL DEFAULT '' AFTER discount_id"),
+			migrate.AddColumn("invoices", "discount", "DECIMAL(19, 4) NOT NULL DEFAULT 0 AFTER discount_description"),
+		},
+	},
+	{
+		Version:     7,
+		Description: "metered usage",
+		Steps: []migrate.Step{
+			migrate.AddColumn("invoices", "usage_lines", "TEXT AFTER discount"),
+		},
+	},
+	{
+		Version:     8,
+		Description: "trials",
+		Steps: []migrate.Step{
+			migrate.AddColumn("subscriptions", "trial_days", "INT NOT NULL DEFAULT 0 AFTER next_invoice_date"),
+			migrate.AddColumn("subscriptions", "no_card_required", "BOOLEAN NOT NULL DEFAULT FALSE AFTER trial_days"),
+			migrate.AddColumn("subscriptions", "trial_notified_at", "DATETIME DEFAULT NULL AFTER no_card_required"),
+		},
+	},
+	{
+		Version:     9,
+		Description: "subscription lifecycle",
+		Steps: []migrate.Step{
+			migrate.AddColumn("subscriptions", "lifecycle", "VARCHAR(32) NOT NULL DEFAULT 'active' AFTER status"),
+			migrate.AddColumn("subscriptions", "paused_at", "DATE DEFAULT NULL AFTER lifecycle"),
+			migrate.AddColumn("subscriptions", "cancel_at_period_end", "BOOLEAN NOT NULL DEFAULT FALSE AFTER paused_at"),
+			migrate.AddColumn("subscriptions", "auto_renew", "BOOLEAN NOT NULL DEFAULT FALSE AFTER cancel_at_period_end"),
+			migrate.AddIndex("subscriptions", "subscriptions_idx_lifecycle", "lifecycle"),
+		},
+	},
+	{
+		Version:     10,
+		Description: "reporting currency totals",
+		Steps: []migrate.Step{
+			migrate.AddColumn("invoices", "reporting_currency", "VARCHAR(3) NOT NULL DEFAULT '' AFTER usage_lines"),
+			migrate.AddColumn("invoices", "fx_rate", "DECIMAL(24, 12) NOT NULL DEFAULT 1 AFTER reporting_currency"),
+			migrate.AddColumn("invoices", "reporting_grand_total", "DECIMAL(19, 4) NOT NULL DEFAULT 0 AFTER fx_rate"),
+		},
+	},
+	{
+		Version:     11,
+		Description: "billing contacts",
+		Steps: []migrate.Step{
+			migrate.AddColumn("invoices", "recipients", "TEXT AFTER email_to"),
+		},
+	},
+	{
+		Version:     12,
+		Description: "reverse charge",
+		Steps: []migrate.Step{
+			migrate.AddColumn("invoices", "reverse_charge", "BOOLEAN NOT NULL DEFAULT FALSE AFTER taxes"),
+			migrate.AddColumn("invoices", "tax_exemption_reason", "VARCHAR(255) NOT NULL DEFAULT '' AFTER reverse_charge"),
+		},
+	},
+	{
+		Version:     13,
+		Description: "effective tax rates",
+		Steps: []migrate.Step{
+			// compound rates such as 15.47375% need more than 4 decimals
+			migrate.ModifyColumn("subscriptions", "tax", "DECIMAL(9, 6) NOT NULL"),
+			migrate.ModifyColumn("invoices", "tax", "DECIMAL(9, 6) NOT NULL"),
+		},
+	},
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paymentTermsDays is the number of days after the invoice date the invoice is
+// due, 0 for payment on receipt
+var paymentTermsDays int
+
+// payURL is the link the customer pays an invoice at, {invoiceID} is replaced
+// with the ID of the invoice
+var payURL string
+
+// parsePaymentTermsDays parses the days an invoice is due after its date, 0
+// when empty.
+func parsePaymentTermsDays(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid payment terms days: %q", s)
+	}
+	return days, nil
+}
+
+// parsePayURL checks the pay link is an absolute URL, empty when invoices are
+// not paid online.
+func parsePayURL(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(strings.ReplaceAll(s, "{invoiceID}", "0"))
+	if err != nil || !u.IsAbs() {
+		return "", fmt.Errorf("invalid pay URL: %q", s)
+	}
+	return s, nil
+}
+
+// getDueDate returns the day the invoice is due
+func getDueDate(invoice Invoice) time.Time {
+	return invoice.InvoiceDate.AddDate(0, 0, paymentTermsDays)
+}
+
+// getPayURL returns the link the invoice is paid at, empty without a pay URL
+func getPayURL(invoice Invoice) string {
+	if payURL == "" {
+		return ""
+	}
+	return strings.ReplaceAll(payURL, "{invoiceID}", url.PathEscape(invoice.GetInvoiceID()))
+}
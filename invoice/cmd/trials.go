@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/contact"
+	"github.com/go-chi/chi/v5"
+)
+
+// skipZeroInvoices completes billing cycles without an invoice when the grand
+// total is zero instead of sending a zero amount invoice
+var skipZeroInvoices bool
+
+// trialNoticeDays is the number of days before the end of a trial the customer
+// is told it ends
+var trialNoticeDays int
+
+// parseZeroAmountInvoices parses how zero amount invoices are handled, send
+// (default) or skip.
+func parseZeroAmountInvoices(s string) (bool, error) {
+	switch s {
+	case "", "send":
+		return false, nil
+	case "skip":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown zero amount invoices mode: %q", s)
+}
+
+// parseTrialNoticeDays parses the days before the end of a trial the notice is
+// sent, 3 when empty.
+func parseTrialNoticeDays(s string) (int, error) {
+	if s == "" {
+		return 3, nil
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid trial notice days: %q", s)
+	}
+	return days, nil
+}
+
+// TrialEnd returns the day the trial of the subscription ends, the contract
+// start date for subscriptions without a trial.
+func (s Subscription) TrialEnd() time.Time {
+	return s.ContractStartDate.AddDate(0, 0, s.TrialDays)
+}
+
+// skipZeroInvoice records the invoice as done without sending it and completes
+// the billing cycle of the subscription as a sent invoice does.
+func skipZeroInvoice(invoice *Invoice, subscription Subscription, usage usageSelection) error {
+	nextInvoiceDate, err := getNextInvoiceDate(subscription)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error calling Begin for transaction: %v", err)
+	}
+
+	invoice.Status = StatusDone
+	err = InsertInvoice(tx, invoice)
+	if err == nil {
+		err = BillUsage(tx, invoice.ID, usage)
+	}
+	if err == nil && invoice.DiscountID != 0 {
+		err = UseDiscountCycle(tx, invoice.DiscountID)
+	}
+	if err == nil {
+		err = UpdateSubscriptionFields(tx, subscription.ID, subscription.BillingFrequencyRemains-1, StatusDone, nextInvoiceDate)
+	}
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Printf("Error calling transaction Rollback: %v\n", err)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// notifyTrialsEnding emails the customers whose trial ends within the notice
+// days through the email service, every trial is notified once
+func notifyTrialsEnding() {
+	noticeURL := os.Getenv("EMAIL_NOTICE_SVC")
+	if noticeURL == "" {
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	subscriptions, err := GetTrialsEnding(db, today, today.AddDate(0, 0, trialNoticeDays))
+	if err != nil {
+		log.Printf("Error calling GetTrialsEnding: %v\n", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		customerDetails, err := GetCustomerDetails(subscription.CustomerID)
+		if err != nil {
+			log.Printf("Error calling GetCustomerDetails: %v\n", err)
+			continue
+		}
+
+		message := fmt.Sprintf("Dear %s, your trial of %s ends on %s. Your first invoice follows on that day.",
+			customerDetails.Name, subscription.ProductCode, subscription.TrialEnd().Format("Jan 02, 2006"))
+		if subscription.NoCardRequired {
+			message += " Please add a payment method before then to keep your subscription."
+		}
+
+		reqBody := struct {
+			CustomerID  string             `json:"customerID"`
+			ProductCode string             `json:"productCode"`
+			EmailTo     string             `json:"emailTo"`
+			Recipients  contact.Recipients `json:"recipients"`
+			Subject     string             `json:"subject"`
+			Message     string             `json:"message"`
+			Locale      string             `json:"locale,omitempty"`
+		}{
+			CustomerID:  subscription.CustomerID,
+			ProductCode: subscription.ProductCode,
+			EmailTo:     customerDetails.Email,
+			Recipients:  contact.ForInvoices(customerDetails.Email, customerDetails.Contacts),
+			Subject:     "Your trial is ending",
+			Message:     message,
+			Locale:      customerDetails.Locale,
+		}
+		res, err := MakeHTTPRequest(http.MethodPost, noticeURL, reqBody)
+		if err != nil {
+			log.Printf("Error calling MakeHTTPRequest: %v\n", err)
+			continue
+		}
+		if err = res.Body.Close(); err != nil {
+			log.Printf("Error calling res.Body.Close(): %v\n", err)
+		}
+
+		if err = SetTrialNotified(db, subscription.ID, time.Now().UTC()); err != nil {
+			log.Printf("Error calling SetTrialNotified: %v\n", err)
+		}
+	}
+
+	log.Println("Processing trial notices daily task...")
+}
+
+// startTrialHandler gives a subscription without an invoice yet a trial
+func startTrialHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var requestBody struct {
+		Days           int  `json:"days"`
+		NoCardRequired bool `json:"noCardRequired"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	if requestBody.Days <= 0 {
+		http.Error(w, fmt.Sprintf("invalid trial days: %d", requestBody.Days), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if _, err := GetSubscriptionCurrency(db, subscriptionID); err != nil {
+		log.Printf("Error calling GetSubscriptionCurrency: %v\n", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	started, err := StartTrial(db, subscriptionID, requestBody.Days, requestBody.NoCardRequired)
+	if err != nil {
+		log.Printf("Error calling StartTrial: %v\n", err)
+		http.Error(w, "Error calling StartTrial", http.StatusInternalServerError)
+		return
+	}
+	if !started {
+		http.Error(w, "Subscription is already invoiced", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(requestBody)
+}
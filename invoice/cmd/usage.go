@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/metering"
+)
+
+// UsageEvent is a quantity of a metric a customer used of a product, such as
+// API calls or GB transferred
+type UsageEvent struct {
+	ID          int64     `json:"id"`
+	CustomerID  string    `json:"customerID"`
+	ProductCode string    `json:"productCode"`
+	Metric      string    `json:"metric"`
+	Quantity    int64     `json:"quantity"`
+	Timestamp   time.Time `json:"timestamp"`
+	// IdempotencyKey identifies the event for the customer, an event sent again
+	// with the same key is only counted once
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+// Validate checks the event can be counted.
+func (e UsageEvent) Validate() error {
+	if e.CustomerID == "" {
+		return errors.New("empty customer ID")
+	}
+	if e.ProductCode == "" {
+		return errors.New("empty product code")
+	}
+	if e.Metric == "" {
+		return errors.New("empty metric")
+	}
+	if e.Quantity < 0 {
+		return fmt.Errorf("invalid quantity: %d", e.Quantity)
+	}
+	if e.Timestamp.IsZero() {
+		return errors.New("empty timestamp")
+	}
+	if e.IdempotencyKey == "" {
+		return errors.New("empty idempotency key")
+	}
+	return nil
+}
+
+// usageSelection selects the unbilled usage of a subscription before the end of
+// its billing period
+type usageSelection struct {
+	CustomerID  string
+	ProductCode string
+	Metrics     []string
+	Before      time.Time
+	// UpTo is the ID of the last event counted
+	UpTo int64
+}
+
+// getUsageLines prices the unbilled usage of the subscription up to its invoice
+// date with the metered prices of the account. Only metrics priced by the
+// account are selected.
+func getUsageLines(subscription Subscription, account Account) (metering.Lines, usageSelection, error) {
+	sel := usageSelection{
+		CustomerID:  subscription.CustomerID,
+		ProductCode: subscription.ProductCode,
+		Before:      subscription.NextInvoiceDate,
+	}
+	for _, p := range account.Metered {
+		if err := p.Validate(); err != nil {
+			return nil, sel, err
+		}
+		if p.Currency() != account.Currency {
+			return nil, sel, fmt.Errorf("price of %s is not in the account currency %s", p.Metric, account.Currency)
+		}
+		sel.Metrics = append(sel.Metrics, p.Metric)
+	}
+	if len(sel.Metrics) == 0 {
+		return nil, sel, nil
+	}
+
+	usage, upTo, err := GetUnbilledUsage(db, sel)
+	if err != nil {
+		return nil, sel, err
+	}
+	sel.UpTo = upTo
+
+	var lines metering.Lines
+	for _, p := range account.Metered {
+		quantity, ok := usage[p.Metric]
+		if !ok {
+			continue
+		}
+
+		amount, err := p.Amount(quantity)
+		if err != nil {
+			return nil, sel, err
+		}
+		lines = append(lines, metering.Line{Metric: p.Metric, Description: p.Description, Quantity: quantity, Amount: amount})
+	}
+
+	return lines, sel, nil
+}
+
+// ingestUsageHandler records a usage event, an event with an idempotency key
+// already recorded for the customer is acknowledged without counting it again
+func ingestUsageHandler(w http.ResponseWriter, r *http.Request) {
+	var event UsageEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		log.Printf("Failed to parse request body: %v\n", err)
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := event.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	created, err := InsertUsageEvent(db, &event)
+	if err != nil {
+		log.Printf("Error calling InsertUsageEvent: %v\n", err)
+		http.Error(w, "Error calling InsertUsageEvent", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(event)
+}
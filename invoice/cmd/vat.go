@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/tax"
+)
+
+// reverseChargeReason is the tax exemption reason of reverse charged invoices
+const reverseChargeReason = "Reverse charge"
+
+// SellerTaxID is the VAT identity of a seller invoices are issued by, the other
+// fields of the sellers are only used by the pdf service
+type SellerTaxID struct {
+	ID      string          `json:"id"`
+	VATID   string          `json:"vatID"`
+	Address address.Address `json:"address"`
+}
+
+// sellerTaxIDs holds the VAT identities of the sellers keyed by seller ID,
+// defaultSellerID is the seller of subscriptions without one
+var (
+	sellerTaxIDs    map[string]SellerTaxID
+	defaultSellerID string
+)
+
+// loadSellerTaxIDs loads the VAT identities of the sellers from the sellers
+// file of the pdf service at SELLERS_PATH, without it the single seller is
+// configured by COMPANY_VAT_ID and COMPANY_COUNTRY_CODE
+func loadSellerTaxIDs() (map[string]SellerTaxID, string, error) {
+	path := os.Getenv("SELLERS_PATH")
+	if path == "" {
+		s := SellerTaxID{VATID: os.Getenv("COMPANY_VAT_ID"), Address: address.Address{Country: os.Getenv("COMPANY_COUNTRY_CODE")}}
+		return map[string]SellerTaxID{s.ID: s}, s.ID, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening sellers file: %v", err)
+	}
+	defer f.Close()
+
+	var list []SellerTaxID
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("error parsing sellers file: %v", err)
+	}
+
+	m := make(map[string]SellerTaxID, len(list))
+	for _, s := range list {
+		if s.ID == "" {
+			return nil, "", errors.New("seller with empty ID")
+		}
+		if _, ok := m[s.ID]; ok {
+			return nil, "", fmt.Errorf("duplicate seller ID: %s", s.ID)
+		}
+		m[s.ID] = s
+	}
+
+	id := os.Getenv("DEFAULT_SELLER_ID")
+	if _, ok := m[id]; id != "" && !ok {
+		return nil, "", fmt.Errorf("unknown default seller ID: %s", id)
+	}
+
+	return m, id, nil
+}
+
+// getSellerTaxID returns the VAT identity of the seller, the default seller
+// when the ID is empty
+func getSellerTaxID(id string) (SellerTaxID, error) {
+	if id == "" {
+		id = defaultSellerID
+	}
+
+	s, ok := sellerTaxIDs[id]
+	if !ok {
+		return SellerTaxID{}, fmt.Errorf("unknown seller ID: %s", id)
+	}
+
+	return s, nil
+}
+
+// isReverseCharge reports whether the buyer accounts for the VAT, which is the
+// case for supplies between VAT registered businesses in different EU member states.
+func isReverseCharge(seller SellerTaxID, buyerVATID, buyerCountry string) bool {
+	return seller.VATID != "" && buyerVATID != "" &&
+		address.InEU(seller.Address.Country) && address.InEU(buyerCountry) &&
+		seller.Address.Country != buyerCountry
+}
+
+// zeroRate returns the totals and usage lines of a reverse charged invoice,
+// which are charged without tax. Prices which include the tax are reduced to
+// their net amounts.
+func zeroRate(totals tax.Totals, usageLines metering.Lines) (tax.Totals, metering.Lines, error) {
+	if totals.Inclusive && len(usageLines) > 0 {
+		lines := make(metering.Lines, len(usageLines))
+		copy(lines, usageLines)
+		for i := range lines {
+			var err error
+			if lines[i].Amount, err = tax.Net(lines[i].Amount, totals.Rates); err != nil {
+				return tax.Totals{}, nil, err
+			}
+		}
+		usageLines = lines
+	}
+
+	totals, err := totals.ZeroRated()
+	if err != nil {
+		return tax.Totals{}, nil, err
+	}
+
+	return totals, usageLines, nil
+}
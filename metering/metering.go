@@ -0,0 +1,232 @@
+package metering
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// Model decides how the usage of a metric is priced
+type Model string
+
+const (
+	// PerUnit prices every unit at the unit price
+	PerUnit Model = "per_unit"
+	// Tiered prices the units within each tier at the unit price of that tier,
+	// 150 units with a first tier up to 100 are 100 units of the first tier and
+	// 50 of the second
+	Tiered Model = "tiered"
+	// Volume prices all units at the unit price of the tier the total falls in
+	Volume Model = "volume"
+)
+
+// Tier is a range of units priced at a unit price
+type Tier struct {
+	// UpTo is the last unit of the tier, 0 for the last tier without a limit
+	UpTo      int64        `json:"upTo,omitempty"`
+	UnitPrice money.Amount `json:"unitPrice"`
+	// FlatFee is charged once when the usage reaches the tier
+	FlatFee *money.Amount `json:"flatFee,omitempty"`
+}
+
+// Price is the price of the usage of a metric such as API calls or GB stored
+type Price struct {
+	Metric      string `json:"metric"`
+	Description string `json:"description"`
+	Model       Model  `json:"model"`
+	// PerUnits is the number of units the unit prices are for, 1000 prices API
+	// calls per thousand. 0 is taken as 1.
+	PerUnits int64 `json:"perUnits,omitempty"`
+	// UnitPrice is the price of the PerUnit model
+	UnitPrice *money.Amount `json:"unitPrice,omitempty"`
+	// Tiers are the ascending tiers of the Tiered and Volume models
+	Tiers []Tier `json:"tiers,omitempty"`
+}
+
+// Validate checks the price can be charged.
+func (p Price) Validate() error {
+	if p.Metric == "" {
+		return errors.New("empty metric")
+	}
+	if p.PerUnits < 0 {
+		return fmt.Errorf("invalid per units of %s: %d", p.Metric, p.PerUnits)
+	}
+
+	switch p.Model {
+	case PerUnit:
+		if p.UnitPrice == nil || p.UnitPrice.Sign() < 0 {
+			return fmt.Errorf("invalid unit price of %s", p.Metric)
+		}
+		if len(p.Tiers) > 0 {
+			return fmt.Errorf("tiers on per unit price of %s", p.Metric)
+		}
+		return nil
+	case Tiered, Volume:
+	default:
+		return fmt.Errorf("unknown pricing model of %s: %q", p.Metric, p.Model)
+	}
+
+	if p.UnitPrice != nil {
+		return fmt.Errorf("unit price on %s price of %s", p.Model, p.Metric)
+	}
+	if len(p.Tiers) == 0 {
+		return fmt.Errorf("no tiers of %s", p.Metric)
+	}
+
+	currency := p.Tiers[0].UnitPrice.Currency()
+	var last int64
+	for i, t := range p.Tiers {
+		if t.UnitPrice.Sign() < 0 || t.UnitPrice.Currency() != currency {
+			return fmt.Errorf("invalid unit price of tier %d of %s", i+1, p.Metric)
+		}
+		if t.FlatFee != nil && (t.FlatFee.Sign() < 0 || t.FlatFee.Currency() != currency) {
+			return fmt.Errorf("invalid flat fee of tier %d of %s", i+1, p.Metric)
+		}
+
+		if i == len(p.Tiers)-1 {
+			if t.UpTo != 0 {
+				return fmt.Errorf("last tier of %s must not have a limit", p.Metric)
+			}
+			break
+		}
+		if t.UpTo <= last {
+			return fmt.Errorf("tier %d of %s must end above %d", i+1, p.Metric, last)
+		}
+		last = t.UpTo
+	}
+
+	return nil
+}
+
+// Currency returns the currency of the price.
+func (p Price) Currency() string {
+	if p.UnitPrice != nil {
+		return p.UnitPrice.Currency()
+	}
+	if len(p.Tiers) > 0 {
+		return p.Tiers[0].UnitPrice.Currency()
+	}
+	return ""
+}
+
+// Amount returns the price of the quantity, the amount of every tier is rounded
+// half up to the minor units of the currency. No usage is not charged.
+func (p Price) Amount(quantity int64) (money.Amount, error) {
+	if err := p.Validate(); err != nil {
+		return money.Amount{}, err
+	}
+	if quantity < 0 {
+		return money.Amount{}, fmt.Errorf("invalid quantity of %s: %d", p.Metric, quantity)
+	}
+
+	total, err := money.Zero(p.Currency())
+	if err != nil || quantity == 0 {
+		return total, err
+	}
+
+	switch p.Model {
+	case PerUnit:
+		return p.units(*p.UnitPrice, quantity)
+	case Volume:
+		for _, t := range p.Tiers {
+			if t.UpTo == 0 || quantity <= t.UpTo {
+				return p.tier(t, quantity)
+			}
+		}
+	}
+
+	// Tiered
+	var from int64
+	for _, t := range p.Tiers {
+		n := quantity - from
+		if t.UpTo != 0 && t.UpTo < quantity {
+			n = t.UpTo - from
+		}
+
+		amount, err := p.tier(t, n)
+		if err != nil {
+			return money.Amount{}, err
+		}
+		if total, err = total.Add(amount); err != nil {
+			return money.Amount{}, err
+		}
+
+		if t.UpTo == 0 || quantity <= t.UpTo {
+			break
+		}
+		from = t.UpTo
+	}
+
+	return total, nil
+}
+
+// tier returns the price of n units of the tier with its flat fee.
+func (p Price) tier(t Tier, n int64) (money.Amount, error) {
+	amount, err := p.units(t.UnitPrice, n)
+	if err != nil || t.FlatFee == nil {
+		return amount, err
+	}
+	return amount.Add(*t.FlatFee)
+}
+
+// units returns the price of n units at the unit price.
+func (p Price) units(unitPrice money.Amount, n int64) (money.Amount, error) {
+	perUnits := p.PerUnits
+	if perUnits == 0 {
+		perUnits = 1
+	}
+	return unitPrice.MulRat(big.NewRat(n, perUnits), money.HalfUp)
+}
+
+// Line is the priced usage of a metric on an invoice
+type Line struct {
+	Metric      string       `json:"metric"`
+	Description string       `json:"description"`
+	Quantity    int64        `json:"quantity"`
+	Amount      money.Amount `json:"amount"`
+}
+
+// Lines lists the usage charged on an invoice, it is stored as JSON in the database
+type Lines []Line
+
+// Amounts returns the amounts of the lines.
+func (l Lines) Amounts() []money.Amount {
+	amounts := make([]money.Amount, 0, len(l))
+	for _, line := range l {
+		amounts = append(amounts, line.Amount)
+	}
+	return amounts
+}
+
+// Value implements the driver.Valuer interface.
+func (l Lines) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+
+	v, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(v), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (l *Lines) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("unsupported type for Lines: %T", value)
+	}
+}
@@ -0,0 +1,85 @@
+package metering
+
+import (
+	"testing"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// usd returns a pointer to the USD amount
+func usd(s string) *money.Amount {
+	a := money.MustParse(s, "USD")
+	return &a
+}
+
+func TestPriceAmount(t *testing.T) {
+	tiers := []Tier{
+		{UpTo: 100, UnitPrice: *usd("0.10")},
+		{UpTo: 1000, UnitPrice: *usd("0.05")},
+		{UnitPrice: *usd("0.01")},
+	}
+	withFees := []Tier{
+		{UpTo: 100, UnitPrice: *usd("0.10"), FlatFee: usd("1.00")},
+		{UnitPrice: *usd("0.05"), FlatFee: usd("2.00")},
+	}
+
+	tests := []struct {
+		name     string
+		price    Price
+		quantity int64
+		want     string
+		wantErr  bool
+	}{
+		{"per unit", Price{Metric: "seats", Model: PerUnit, UnitPrice: usd("4.99")}, 3, "14.97", false},
+		{"per unit zero usage", Price{Metric: "seats", Model: PerUnit, UnitPrice: usd("4.99")}, 0, "0.00", false},
+
+		// per thousand, rounded half up to cents
+		{"per units round up", Price{Metric: "calls", Model: PerUnit, PerUnits: 1000, UnitPrice: usd("0.05")}, 1500, "0.08", false},
+		{"per units round down", Price{Metric: "calls", Model: PerUnit, PerUnits: 1000, UnitPrice: usd("0.05")}, 1499, "0.07", false},
+		{"per units below a cent", Price{Metric: "calls", Model: PerUnit, PerUnits: 1000, UnitPrice: usd("0.05")}, 99, "0.00", false},
+
+		{"tiered zero usage", Price{Metric: "calls", Model: Tiered, Tiers: tiers}, 0, "0.00", false},
+		{"tiered first tier", Price{Metric: "calls", Model: Tiered, Tiers: tiers}, 50, "5.00", false},
+		{"tiered up to the first tier", Price{Metric: "calls", Model: Tiered, Tiers: tiers}, 100, "10.00", false},
+		{"tiered above the first tier", Price{Metric: "calls", Model: Tiered, Tiers: tiers}, 101, "10.05", false},
+		{"tiered up to the second tier", Price{Metric: "calls", Model: Tiered, Tiers: tiers}, 1000, "55.00", false},
+		{"tiered last tier", Price{Metric: "calls", Model: Tiered, Tiers: tiers}, 1001, "55.01", false},
+
+		{"volume zero usage", Price{Metric: "calls", Model: Volume, Tiers: tiers}, 0, "0.00", false},
+		{"volume up to the first tier", Price{Metric: "calls", Model: Volume, Tiers: tiers}, 100, "10.00", false},
+		{"volume above the first tier", Price{Metric: "calls", Model: Volume, Tiers: tiers}, 101, "5.05", false},
+		{"volume up to the second tier", Price{Metric: "calls", Model: Volume, Tiers: tiers}, 1000, "50.00", false},
+		{"volume last tier", Price{Metric: "calls", Model: Volume, Tiers: tiers}, 1001, "10.01", false},
+
+		// a flat fee is charged once the usage reaches its tier
+		{"tiered flat fee zero usage", Price{Metric: "calls", Model: Tiered, Tiers: withFees}, 0, "0.00", false},
+		{"tiered flat fee first tier", Price{Metric: "calls", Model: Tiered, Tiers: withFees}, 1, "1.10", false},
+		{"tiered flat fee up to the first tier", Price{Metric: "calls", Model: Tiered, Tiers: withFees}, 100, "11.00", false},
+		{"tiered flat fees of both tiers", Price{Metric: "calls", Model: Tiered, Tiers: withFees}, 101, "13.05", false},
+		{"volume flat fee of the tier", Price{Metric: "calls", Model: Volume, Tiers: withFees}, 101, "7.05", false},
+
+		// every tier is rounded on its own, 0.075 + 0.045 rather than 0.12
+		{"tiered per units", Price{Metric: "calls", Model: Tiered, PerUnits: 1000, Tiers: []Tier{
+			{UpTo: 1500, UnitPrice: *usd("0.05")},
+			{UnitPrice: *usd("0.03")},
+		}}, 3000, "0.13", false},
+
+		{"negative quantity", Price{Metric: "seats", Model: PerUnit, UnitPrice: usd("4.99")}, -1, "", true},
+		{"invalid price", Price{Metric: "calls", Model: Tiered, Tiers: []Tier{{UpTo: 100, UnitPrice: *usd("0.10")}}}, 10, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.price.Amount(tt.quantity)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Amount(%d) error = %v, wantErr %v", tt.quantity, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Currency() != "USD" || got.Decimal() != tt.want {
+				t.Errorf("Amount(%d) = %s, want USD %s", tt.quantity, got, tt.want)
+			}
+		})
+	}
+}
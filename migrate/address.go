@@ -0,0 +1,52 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/arifmahmudrana/invoice/address"
+)
+
+// SplitAddress fills the structured address columns of the rows of the table
+// from the free-form address column they had before, with address.ParseLine.
+// It does nothing when the table has no address column, and only fills the rows
+// whose address lines are still empty.
+func SplitAddress(table string) Step {
+	return func(db *sql.DB) error {
+		ok, err := ColumnExists(db, table, "address")
+		if err != nil || !ok {
+			return err
+		}
+
+		rows, err := db.Query(fmt.Sprintf("SELECT id, address FROM %s WHERE address_lines = ''", table))
+		if err != nil {
+			return fmt.Errorf("error getting addresses of %s: %v", table, err)
+		}
+		addresses := make(map[int]address.Address)
+		for rows.Next() {
+			var (
+				id   int
+				line string
+			)
+			if err := rows.Scan(&id, &line); err != nil {
+				rows.Close()
+				return fmt.Errorf("error getting addresses of %s: %v", table, err)
+			}
+			addresses[id] = address.ParseLine(line)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error getting addresses of %s: %v", table, err)
+		}
+
+		for id, a := range addresses {
+			_, err := db.Exec(fmt.Sprintf("UPDATE %s SET address_lines = ?, city = ?, region = ?, postal_code = ?, country = ? WHERE id = ?", table),
+				a.JoinLines(), a.City, a.Region, a.PostalCode, a.Country, id)
+			if err != nil {
+				return fmt.Errorf("error updating address of %s %d: %v", table, id, err)
+			}
+		}
+
+		return nil
+	}
+}
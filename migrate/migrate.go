@@ -0,0 +1,174 @@
+// Package migrate upgrades the tables of a service created by an older version
+// to its current schema. Every migration is applied once, the applied versions
+// are recorded in the schema_migrations table.
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration is a version of the schema, its steps are run in order
+type Migration struct {
+	Version     int
+	Description string
+	Steps       []Step
+}
+
+// Step changes the schema or the data of a migration. MySQL commits every
+// ALTER TABLE on its own, so steps check whether they are needed and a
+// migration stopped by an error can be run again. That also makes the steps
+// no-ops on tables just created with the current schema.
+type Step func(db *sql.DB) error
+
+// Run applies the migrations which were not applied yet, in the order of their
+// versions which must be increasing
+func Run(db *sql.DB, migrations []Migration) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating table schema_migrations: %v", err)
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	last := 0
+	for _, m := range migrations {
+		if m.Version <= last {
+			return fmt.Errorf("migration %d after migration %d", m.Version, last)
+		}
+		last = m.Version
+		if applied[m.Version] {
+			continue
+		}
+
+		log.Printf("Applying migration %d: %s\n", m.Version, m.Description)
+		for i, step := range m.Steps {
+			if err := step(db); err != nil {
+				return fmt.Errorf("error applying step %d of migration %d: %v", i+1, m.Version, err)
+			}
+		}
+		_, err := db.Exec("INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Description, time.Now().UTC().Format(time.DateTime))
+		if err != nil {
+			return fmt.Errorf("error recording migration %d: %v", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// appliedVersions returns the versions of the migrations applied before
+func appliedVersions(db *sql.DB) (map[int]bool, error) {
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error getting applied migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("error getting applied migrations: %v", err)
+		}
+		applied[v] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// ColumnExists reports whether the table of the current database has the column
+func ColumnExists(db *sql.DB, table, column string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`, table, column).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("error checking column %s.%s: %v", table, column, err)
+	}
+	return n > 0, nil
+}
+
+// indexExists reports whether the table of the current database has the index
+func indexExists(db *sql.DB, table, index string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`, table, index).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("error checking index %s.%s: %v", table, index, err)
+	}
+	return n > 0, nil
+}
+
+// AddColumn adds the column to the table unless it has it. The definition is
+// the one of CREATE TABLE and may end with AFTER to keep the column order.
+func AddColumn(table, column, definition string) Step {
+	return func(db *sql.DB) error {
+		ok, err := ColumnExists(db, table, column)
+		if err != nil || ok {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %v", table, column, err)
+		}
+		return nil
+	}
+}
+
+// ModifyColumn changes the type of the column of the table, which converts the
+// values it holds. Changing it to the type it has already changes nothing.
+func ModifyColumn(table, column, definition string) Step {
+	return func(db *sql.DB) error {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, column, definition)); err != nil {
+			return fmt.Errorf("error modifying column %s.%s: %v", table, column, err)
+		}
+		return nil
+	}
+}
+
+// DropColumn drops the column of the table if it has it
+func DropColumn(table, column string) Step {
+	return func(db *sql.DB) error {
+		ok, err := ColumnExists(db, table, column)
+		if err != nil || !ok {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column)); err != nil {
+			return fmt.Errorf("error dropping column %s.%s: %v", table, column, err)
+		}
+		return nil
+	}
+}
+
+// AddIndex adds the index on the columns of the table unless it has it
+func AddIndex(table, index, columns string) Step {
+	return func(db *sql.DB) error {
+		ok, err := indexExists(db, table, index)
+		if err != nil || ok {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s)", table, index, columns)); err != nil {
+			return fmt.Errorf("error adding index %s.%s: %v", table, index, err)
+		}
+		return nil
+	}
+}
+
+// Exec runs a statement which can be run more than once, such as an UPDATE
+// filling the rows a new column is not set for yet
+func Exec(query string, args ...interface{}) Step {
+	return func(db *sql.DB) error {
+		if _, err := db.Exec(query, args...); err != nil {
+			return fmt.Errorf("error running %q: %v", query, err)
+		}
+		return nil
+	}
+}
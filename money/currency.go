@@ -0,0 +1,76 @@
+package money
+
+import "fmt"
+
+// minorUnitsByCode holds the ISO 4217 minor units of the currencies which do
+// not use two decimals
+var minorUnitsByCode = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0,
+	"XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// twoDecimalCodes lists the ISO 4217 currencies with two decimals
+var twoDecimalCodes = []string{
+	"AED", "AFN", "ALL", "AMD", "ANG", "AOA", "ARS", "AUD", "AWG", "AZN",
+	"BAM", "BBD", "BDT", "BGN", "BMD", "BND", "BOB", "BRL", "BSD", "BTN",
+	"BWP", "BYN", "BZD", "CAD", "CDF", "CHF", "CNY", "COP", "CRC", "CUP",
+	"CVE", "CZK", "DKK", "DOP", "DZD", "EGP", "ERN", "ETB", "EUR", "FJD",
+	"FKP", "GBP", "GEL", "GHS", "GIP", "GMD", "GTQ", "GYD", "HKD", "HNL",
+	"HTG", "HUF", "IDR", "ILS", "INR", "IRR", "JMD", "KES", "KGS", "KHR",
+	"KPW", "KYD", "KZT", "LAK", "LBP", "LKR", "LRD", "LSL", "MAD", "MDL",
+	"MGA", "MKD", "MMK", "MNT", "MOP", "MRU", "MUR", "MVR", "MWK", "MXN",
+	"MYR", "MZN", "NAD", "NGN", "NIO", "NOK", "NPR", "NZD", "PAB", "PEN",
+	"PGK", "PHP", "PKR", "PLN", "QAR", "RON", "RSD", "RUB", "SAR", "SBD",
+	"SCR", "SDG", "SEK", "SGD", "SHP", "SLE", "SOS", "SRD", "SSP", "STN",
+	"SVC", "SYP", "SZL", "THB", "TJS", "TMT", "TOP", "TRY", "TTD", "TWD",
+	"TZS", "UAH", "USD", "UYU", "UZS", "VES", "WST", "XCD", "YER", "ZAR",
+	"ZMW", "ZWL",
+}
+
+// symbolsByCode holds the symbols of the ISO 4217 currencies printed on
+// invoices, currencies without a symbol are printed with their code
+var symbolsByCode = map[string]string{
+	"AUD": "$", "BRL": "R$", "CAD": "$", "CHF": "CHF", "CNY": "¥", "CZK": "Kč",
+	"DKK": "kr", "EUR": "€", "GBP": "£", "HKD": "$", "HUF": "Ft", "ILS": "₪",
+	"INR": "₹", "JPY": "¥", "KRW": "₩", "MXN": "$", "NGN": "₦", "NOK": "kr",
+	"NZD": "$", "PHP": "₱", "PLN": "zł", "RUB": "₽", "SEK": "kr", "SGD": "$",
+	"THB": "฿", "TRY": "₺", "UAH": "₴", "USD": "$", "VND": "₫", "ZAR": "R",
+}
+
+func init() {
+	for _, code := range twoDecimalCodes {
+		minorUnitsByCode[code] = 2
+	}
+}
+
+// MinorUnits returns the number of decimals of the ISO 4217 currency, 2 for EUR,
+// 0 for JPY and 3 for BHD.
+func MinorUnits(currency string) (int, error) {
+	units, ok := minorUnitsByCode[currency]
+	if !ok {
+		return 0, fmt.Errorf("unknown currency: %q", currency)
+	}
+
+	return units, nil
+}
+
+// minorUnits is like MinorUnits but formats amounts without a currency with
+// two decimals.
+func minorUnits(currency string) (int, error) {
+	if currency == "" {
+		return 2, nil
+	}
+	return MinorUnits(currency)
+}
+
+// Symbol returns the symbol of the ISO 4217 currency, "€" for EUR, or the code
+// itself for currencies without a symbol.
+func Symbol(currency string) string {
+	if symbol, ok := symbolsByCode[currency]; ok {
+		return symbol
+	}
+	return currency
+}
@@ -0,0 +1,434 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var amountRe = regexp.MustCompile(`^([+-]?)(\d+)(?:\.(\d+))?$`)
+
+// RoundingMode decides how a value between two minor units is rounded
+type RoundingMode int
+
+const (
+	// HalfUp rounds halves away from zero, 2.345 becomes 2.35
+	HalfUp RoundingMode = iota
+	// HalfEven rounds halves to the even neighbour, 2.345 becomes 2.34
+	HalfEven
+	// Down rounds towards zero
+	Down
+	// Up rounds away from zero
+	Up
+	// Floor rounds towards negative infinity
+	Floor
+	// Ceiling rounds towards positive infinity
+	Ceiling
+)
+
+// Amount is an exact amount of money, held as an integer number of minor units
+// of its currency. The zero value is a zero amount without a currency, which
+// takes the currency of the amounts it is added to.
+type Amount struct {
+	minor    int64
+	currency string
+	// scanned is the decimal Scan read into an amount without a currency, it
+	// is parsed once In gives the amount its currency
+	scanned string
+}
+
+// New returns the amount of minor units of the currency, 1050 EUR is 10.50 EUR.
+func New(minor int64, currency string) (Amount, error) {
+	if _, err := MinorUnits(currency); err != nil {
+		return Amount{}, err
+	}
+
+	return Amount{minor: minor, currency: currency}, nil
+}
+
+// Zero returns a zero amount of the currency.
+func Zero(currency string) (Amount, error) {
+	return New(0, currency)
+}
+
+// Parse parses a decimal amount of the currency such as "-10.50". Zero decimals
+// beyond the minor units of the currency are accepted, other digits are not.
+func Parse(s, currency string) (Amount, error) {
+	return parse(s, currency, nil)
+}
+
+// ParseRound parses a decimal amount of the currency, rounding decimals beyond
+// the minor units of the currency with the mode.
+func ParseRound(s, currency string, mode RoundingMode) (Amount, error) {
+	return parse(s, currency, &mode)
+}
+
+// MustParse is like Parse but panics when the amount cannot be parsed, it is
+// meant for amounts fixed in the code.
+func MustParse(s, currency string) Amount {
+	a, err := Parse(s, currency)
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+func parse(s, currency string, mode *RoundingMode) (Amount, error) {
+	units, err := MinorUnits(currency)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	m := amountRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Amount{}, fmt.Errorf("invalid amount: %q", s)
+	}
+
+	frac := m[3]
+	if len(frac) > units {
+		if mode == nil && strings.Trim(frac[units:], "0") != "" {
+			return Amount{}, fmt.Errorf("amount %s has more than %d decimals for %s", s, units, currency)
+		}
+	}
+
+	r, ok := new(big.Rat).SetString(m[1] + m[2] + "." + frac + "0")
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid amount: %q", s)
+	}
+
+	roundMode := HalfUp
+	if mode != nil {
+		roundMode = *mode
+	}
+	minor, err := toMinor(r, units, roundMode)
+	if err != nil {
+		return Amount{}, fmt.Errorf("invalid amount %s: %v", s, err)
+	}
+
+	return Amount{minor: minor, currency: currency}, nil
+}
+
+// Currency returns the ISO 4217 currency code of the amount.
+func (a Amount) Currency() string {
+	return a.currency
+}
+
+// Minor returns the amount in minor units of its currency.
+func (a Amount) Minor() int64 {
+	return a.minor
+}
+
+// IsZero reports whether the amount is zero.
+func (a Amount) IsZero() bool {
+	return a.minor == 0
+}
+
+// Sign returns -1, 0 or +1 for negative, zero and positive amounts.
+func (a Amount) Sign() int {
+	switch {
+	case a.minor < 0:
+		return -1
+	case a.minor > 0:
+		return 1
+	}
+	return 0
+}
+
+// Neg returns the amount with the opposite sign.
+func (a Amount) Neg() Amount {
+	return Amount{minor: -a.minor, currency: a.currency}
+}
+
+// Add returns the sum of the amounts, which must have the same currency.
+func (a Amount) Add(b Amount) (Amount, error) {
+	currency, err := a.common(b)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	sum := a.minor + b.minor
+	if (sum > a.minor) != (b.minor > 0) {
+		return Amount{}, errors.New("amount overflow")
+	}
+
+	return Amount{minor: sum, currency: currency}, nil
+}
+
+// Sub returns the difference of the amounts, which must have the same currency.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b.minor == math.MinInt64 {
+		return Amount{}, errors.New("amount overflow")
+	}
+	return a.Add(b.Neg())
+}
+
+// Mul returns the amount multiplied by n, such as a unit price by a quantity.
+func (a Amount) Mul(n int64) (Amount, error) {
+	// -MinInt64 wraps around to MinInt64, which the division below does not catch
+	if a.minor == math.MinInt64 && n == -1 || n == math.MinInt64 && a.minor == -1 {
+		return Amount{}, errors.New("amount overflow")
+	}
+	if a.minor != 0 && n != 0 {
+		p := a.minor * n
+		if p/n != a.minor {
+			return Amount{}, errors.New("amount overflow")
+		}
+		return Amount{minor: p, currency: a.currency}, nil
+	}
+
+	return Amount{currency: a.currency}, nil
+}
+
+// MulRat returns the amount multiplied by r, rounded to minor units with the mode.
+func (a Amount) MulRat(r *big.Rat, mode RoundingMode) (Amount, error) {
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(a.minor), r)
+	minor, err := toMinor(v, 0, mode)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	return Amount{minor: minor, currency: a.currency}, nil
+}
+
+// Convert returns the amount in the currency at the rate, the price of one unit
+// of the currency of a in the currency, rounded to minor units with the mode.
+func (a Amount) Convert(rate *big.Rat, currency string, mode RoundingMode) (Amount, error) {
+	units, err := MinorUnits(currency)
+	if err != nil {
+		return Amount{}, err
+	}
+	if rate.Sign() <= 0 {
+		return Amount{}, fmt.Errorf("invalid rate: %s", rate.FloatString(6))
+	}
+
+	minor, err := toMinor(new(big.Rat).Mul(a.Rat(), rate), units, mode)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	return Amount{minor: minor, currency: currency}, nil
+}
+
+// Cmp compares the amounts, which must have the same currency, and returns -1,
+// 0 or +1 when a is less than, equal to or greater than b.
+func (a Amount) Cmp(b Amount) (int, error) {
+	if _, err := a.common(b); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case a.minor < b.minor:
+		return -1, nil
+	case a.minor > b.minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Equal reports whether the amounts have the same value and currency.
+func (a Amount) Equal(b Amount) bool {
+	return a.minor == b.minor && a.currency == b.currency
+}
+
+// Rat returns the amount in major units as an exact rational number.
+func (a Amount) Rat() *big.Rat {
+	units, _ := minorUnits(a.currency)
+	return new(big.Rat).SetFrac(big.NewInt(a.minor), pow10(units))
+}
+
+// Decimal formats the amount with the minor units of its currency, "1234.50".
+func (a Amount) Decimal() string {
+	if a.scanned != "" {
+		return a.scanned
+	}
+	units, _ := minorUnits(a.currency)
+
+	var b strings.Builder
+	minor := a.minor
+	if minor < 0 {
+		b.WriteByte('-')
+	}
+
+	digits := new(big.Int).Abs(big.NewInt(minor)).String()
+	if len(digits) <= units {
+		digits = strings.Repeat("0", units-len(digits)+1) + digits
+	}
+	b.WriteString(digits[:len(digits)-units])
+	if units > 0 {
+		b.WriteByte('.')
+		b.WriteString(digits[len(digits)-units:])
+	}
+
+	return b.String()
+}
+
+// String formats the amount with its currency, "EUR 1234.50".
+func (a Amount) String() string {
+	if a.currency == "" {
+		return a.Decimal()
+	}
+	return a.currency + " " + a.Decimal()
+}
+
+// amountJSON is the JSON form of an amount
+type amountJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON implements the json.Marshaler interface, the amount is encoded
+// as {"amount": "10.50", "currency": "EUR"}.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(amountJSON{Amount: a.Decimal(), Currency: a.currency})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var v amountJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("invalid amount: %v", err)
+	}
+
+	amount, err := Parse(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+
+	*a = amount
+	return nil
+}
+
+// Value implements the driver.Valuer interface, the amount is stored as a
+// decimal and its currency in a separate column.
+func (a Amount) Value() (driver.Value, error) {
+	return a.Decimal(), nil
+}
+
+// Scan implements the sql.Scanner interface for decimal columns. An amount with
+// a currency reads the decimal in its currency. As the currency is usually kept
+// in a column of its own, an amount without a currency keeps the decimal until
+// In gives it its currency, it must not be used before.
+func (a *Amount) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		*a = Amount{currency: a.currency}
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	default:
+		return fmt.Errorf("unsupported type for Amount: %T", value)
+	}
+
+	s = strings.TrimSpace(s)
+	if !amountRe.MatchString(s) {
+		return fmt.Errorf("invalid amount: %q", s)
+	}
+	if a.currency != "" {
+		amount, err := Parse(s, a.currency)
+		if err != nil {
+			return err
+		}
+		*a = amount
+		return nil
+	}
+
+	*a = Amount{scanned: s}
+	return nil
+}
+
+// In returns the amount in the currency. An amount read by Scan is parsed in
+// the currency, its decimals must fit the minor units of the currency. An
+// amount in another currency is not converted, it is an error.
+func (a Amount) In(currency string) (Amount, error) {
+	if a.scanned != "" {
+		return Parse(a.scanned, currency)
+	}
+	if a.currency != "" && a.currency != currency {
+		return Amount{}, fmt.Errorf("currency mismatch: %s and %s", a.currency, currency)
+	}
+
+	return New(a.minor, currency)
+}
+
+// Sum returns the sum of the amounts, which must all be in the currency.
+func Sum(currency string, amounts ...Amount) (Amount, error) {
+	total, err := Zero(currency)
+	if err != nil {
+		return Amount{}, err
+	}
+
+	for _, a := range amounts {
+		if total, err = total.Add(a); err != nil {
+			return Amount{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// common returns the currency of two amounts, an amount without currency takes
+// the currency of the other.
+func (a Amount) common(b Amount) (string, error) {
+	switch {
+	case a.currency == b.currency, b.currency == "":
+		return a.currency, nil
+	case a.currency == "":
+		return b.currency, nil
+	}
+
+	return "", fmt.Errorf("currency mismatch: %s and %s", a.currency, b.currency)
+}
+
+// toMinor rounds v multiplied by 10^units to an integer with the mode.
+func toMinor(v *big.Rat, units int, mode RoundingMode) (int64, error) {
+	v = new(big.Rat).Mul(v, new(big.Rat).SetInt(pow10(units)))
+
+	q, r := new(big.Int).QuoRem(v.Num(), v.Denom(), new(big.Int))
+	if r.Sign() != 0 {
+		// the remainder has the sign of v, compare twice its size with the denominator
+		twice := new(big.Int).Abs(new(big.Int).Lsh(r, 1))
+		half := twice.Cmp(v.Denom())
+		away := false
+		switch mode {
+		case HalfUp:
+			away = half >= 0
+		case HalfEven:
+			away = half > 0 || half == 0 && q.Bit(0) == 1
+		case Down:
+		case Up:
+			away = true
+		case Floor:
+			away = v.Sign() < 0
+		case Ceiling:
+			away = v.Sign() > 0
+		default:
+			return 0, fmt.Errorf("unknown rounding mode: %d", mode)
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(v.Sign())))
+		}
+	}
+
+	if !q.IsInt64() {
+		return 0, errors.New("amount overflow")
+	}
+
+	return q.Int64(), nil
+}
+
+// pow10 returns 10^n.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
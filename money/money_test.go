@@ -0,0 +1,326 @@
+package money
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestMinorUnits(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+		wantErr  bool
+	}{
+		{"EUR", 2, false},
+		{"USD", 2, false},
+		{"JPY", 0, false},
+		{"BHD", 3, false},
+		{"CLF", 4, false},
+		{"XXX", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := MinorUnits(tt.currency)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("MinorUnits(%q) = %d, %v, want %d, wantErr %v", tt.currency, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{"10.50", "EUR", 1050, false},
+		{"-10.5", "EUR", -1050, false},
+		{"+7", "EUR", 700, false},
+		{" 1.00 ", "EUR", 100, false},
+		{"1.5000", "EUR", 150, false},
+		{"1005", "JPY", 1005, false},
+		{"1005.0", "JPY", 1005, false},
+		{"1.234", "BHD", 1234, false},
+		{"1.2345", "CLF", 12345, false},
+		{"1.234", "EUR", 0, true},
+		{"1.5", "JPY", 0, true},
+		{"1.2345", "BHD", 0, true},
+		{"1.23456", "CLF", 0, true},
+		{"", "EUR", 0, true},
+		{"1,50", "EUR", 0, true},
+		{".50", "EUR", 0, true},
+		{"1e3", "EUR", 0, true},
+		{"10.00", "XXX", 0, true},
+		{"92233720368547758.08", "EUR", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.in, tt.currency)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q, %s) error = %v, wantErr %v", tt.in, tt.currency, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (got.Minor() != tt.want || got.Currency() != tt.currency) {
+			t.Errorf("Parse(%q, %s) = %d %s, want %d %s", tt.in, tt.currency, got.Minor(), got.Currency(), tt.want, tt.currency)
+		}
+	}
+}
+
+func TestParseRound(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		mode     RoundingMode
+		want     string
+	}{
+		{"2.345", "EUR", HalfUp, "2.35"},
+		{"-2.345", "EUR", HalfUp, "-2.35"},
+		{"2.345", "EUR", HalfEven, "2.34"},
+		{"2.355", "EUR", HalfEven, "2.36"},
+		{"2.3451", "EUR", HalfEven, "2.35"},
+		{"2.349", "EUR", Down, "2.34"},
+		{"-2.349", "EUR", Down, "-2.34"},
+		{"2.341", "EUR", Up, "2.35"},
+		{"-2.341", "EUR", Up, "-2.35"},
+		{"2.349", "EUR", Floor, "2.34"},
+		{"-2.341", "EUR", Floor, "-2.35"},
+		{"2.341", "EUR", Ceiling, "2.35"},
+		{"-2.349", "EUR", Ceiling, "-2.34"},
+		{"2.34", "EUR", Up, "2.34"},
+		{"1004.5", "JPY", HalfUp, "1005"},
+		{"1004.5", "JPY", HalfEven, "1004"},
+		{"1.2345", "BHD", HalfUp, "1.235"},
+		{"1.23456", "CLF", Down, "1.2345"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRound(tt.in, tt.currency, tt.mode)
+		if err != nil {
+			t.Errorf("ParseRound(%q, %s, %d) error = %v", tt.in, tt.currency, tt.mode, err)
+			continue
+		}
+		if got.Decimal() != tt.want {
+			t.Errorf("ParseRound(%q, %s, %d) = %s, want %s", tt.in, tt.currency, tt.mode, got.Decimal(), tt.want)
+		}
+	}
+
+	if _, err := ParseRound("1.005", "EUR", RoundingMode(99)); err == nil {
+		t.Error("ParseRound() with an unknown mode error = nil, want an error")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount      Amount
+		wantDecimal string
+		wantString  string
+	}{
+		{MustParse("1234.5", "EUR"), "1234.50", "EUR 1234.50"},
+		{MustParse("-0.05", "EUR"), "-0.05", "EUR -0.05"},
+		{MustParse("1005", "JPY"), "1005", "JPY 1005"},
+		{MustParse("0.001", "BHD"), "0.001", "BHD 0.001"},
+		{MustParse("-1.0001", "CLF"), "-1.0001", "CLF -1.0001"},
+		{Amount{}, "0.00", "0.00"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.Decimal(); got != tt.wantDecimal {
+			t.Errorf("Decimal() = %s, want %s", got, tt.wantDecimal)
+		}
+		if got := tt.amount.String(); got != tt.wantString {
+			t.Errorf("String() = %s, want %s", got, tt.wantString)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a := MustParse("10.50", "EUR")
+	b := MustParse("0.75", "EUR")
+
+	if got, err := a.Add(b); err != nil || got.Decimal() != "11.25" {
+		t.Errorf("Add() = %s, %v, want 11.25", got, err)
+	}
+	if got, err := b.Sub(a); err != nil || got.Decimal() != "-9.75" {
+		t.Errorf("Sub() = %s, %v, want -9.75", got, err)
+	}
+	if got, err := a.Mul(3); err != nil || got.Decimal() != "31.50" {
+		t.Errorf("Mul() = %s, %v, want 31.50", got, err)
+	}
+	if got, err := (Amount{}).Add(a); err != nil || !got.Equal(a) {
+		t.Errorf("Add() to the zero value = %s, %v, want %s", got, err, a)
+	}
+	if _, err := a.Add(MustParse("1.00", "USD")); err == nil {
+		t.Error("Add() of another currency error = nil, want an error")
+	}
+	if _, err := a.Cmp(MustParse("1.00", "USD")); err == nil {
+		t.Error("Cmp() of another currency error = nil, want an error")
+	}
+	if c, err := a.Cmp(b); err != nil || c != 1 {
+		t.Errorf("Cmp() = %d, %v, want 1", c, err)
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	a := MustParse("9.99", "EUR")
+	// 9.99 / 1.19 is 8.394957...
+	r := big.NewRat(100, 119)
+
+	tests := []struct {
+		mode RoundingMode
+		want string
+	}{
+		{HalfUp, "8.39"},
+		{Down, "8.39"},
+		{Up, "8.40"},
+		{Ceiling, "8.40"},
+	}
+
+	for _, tt := range tests {
+		got, err := a.MulRat(r, tt.mode)
+		if err != nil || got.Decimal() != tt.want {
+			t.Errorf("MulRat(%d) = %s, %v, want %s", tt.mode, got, err, tt.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Amount
+		rate     *big.Rat
+		currency string
+		mode     RoundingMode
+		want     string
+		wantErr  bool
+	}{
+		{"to fewer decimals", MustParse("100.00", "EUR"), big.NewRat(16345, 100), "JPY", HalfUp, "JPY 16345", false},
+		{"rounded half up", MustParse("10.01", "EUR"), big.NewRat(1085, 1000), "USD", HalfUp, "USD 10.86", false},
+		{"rounded half even", MustParse("0.50", "EUR"), big.NewRat(1, 1), "JPY", HalfEven, "JPY 0", false},
+		{"to more decimals", MustParse("1005", "JPY"), big.NewRat(1, 400), "BHD", HalfUp, "BHD 2.513", false},
+		{"to CLF", MustParse("1000.00", "USD"), big.NewRat(25, 1000), "CLF", Down, "CLF 25.0000", false},
+		{"zero rate", MustParse("1.00", "EUR"), new(big.Rat), "USD", HalfUp, "", true},
+		{"negative rate", MustParse("1.00", "EUR"), big.NewRat(-1, 1), "USD", HalfUp, "", true},
+		{"unknown currency", MustParse("1.00", "EUR"), big.NewRat(1, 1), "XXX", HalfUp, "", true},
+		{"overflow", Amount{minor: math.MaxInt64, currency: "EUR"}, big.NewRat(2, 1), "USD", HalfUp, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Convert(tt.rate, tt.currency, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("Convert() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	max := Amount{minor: math.MaxInt64, currency: "EUR"}
+	min := Amount{minor: math.MinInt64, currency: "EUR"}
+	one := MustParse("0.01", "EUR")
+
+	tests := []struct {
+		name string
+		op   func() (Amount, error)
+	}{
+		{"add", func() (Amount, error) { return max.Add(one) }},
+		{"add negative", func() (Amount, error) { return min.Add(one.Neg()) }},
+		{"sub", func() (Amount, error) { return min.Sub(one) }},
+		{"sub min", func() (Amount, error) { return one.Sub(min) }},
+		{"mul", func() (Amount, error) { return max.Mul(2) }},
+		{"mul min by -1", func() (Amount, error) { return min.Mul(-1) }},
+		{"mul -1 by min", func() (Amount, error) { return one.Neg().Mul(math.MinInt64) }},
+		{"mul rat", func() (Amount, error) { return max.MulRat(big.NewRat(3, 2), HalfUp) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.op(); err == nil {
+				t.Errorf("got %s, want an overflow error", got)
+			}
+		})
+	}
+
+	if got, err := max.Mul(-1); err != nil || got.Minor() != -math.MaxInt64 {
+		t.Errorf("Mul(-1) of the largest amount = %s, %v, want %d", got, err, -math.MaxInt64)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	a := MustParse("10.50", "EUR")
+	data, err := a.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON() error = %v", err)
+	}
+	if string(data) != `{"amount":"10.50","currency":"EUR"}` {
+		t.Errorf("MarshalJSON() = %s", data)
+	}
+
+	var got Amount
+	if err := got.UnmarshalJSON(data); err != nil || !got.Equal(a) {
+		t.Errorf("UnmarshalJSON() = %s, %v, want %s", got, err, a)
+	}
+	if err := got.UnmarshalJSON([]byte(`{"amount":"1.005","currency":"EUR"}`)); err == nil {
+		t.Error("UnmarshalJSON() of too many decimals error = nil, want an error")
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		currency string
+		want     string
+		wantErr  bool
+	}{
+		{"decimal column", []byte("10.5000"), "EUR", "EUR 10.50", false},
+		{"string", "1005.0000", "JPY", "JPY 1005", false},
+		{"integer", int64(7), "BHD", "BHD 7.000", false},
+		{"null", nil, "EUR", "EUR 0.00", false},
+		{"too many decimals", []byte("10.5050"), "EUR", "", true},
+		{"float", 10.5, "EUR", "", true},
+		{"not a number", []byte("ten"), "EUR", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a Amount
+			err := a.Scan(tt.value)
+			if err == nil {
+				a, err = a.In(tt.currency)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan() and In() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && a.String() != tt.want {
+				t.Errorf("Scan() and In() = %s, want %s", a, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanWithCurrency(t *testing.T) {
+	a, _ := Zero("EUR")
+	if err := a.Scan([]byte("10.5000")); err != nil || a.String() != "EUR 10.50" {
+		t.Errorf("Scan() = %s, %v, want EUR 10.50", a, err)
+	}
+
+	// a scanned decimal is stored back unchanged until it has a currency
+	var b Amount
+	if err := b.Scan([]byte("10.5000")); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if v, _ := b.Value(); v != "10.5000" {
+		t.Errorf("Value() = %v, want 10.5000", v)
+	}
+
+	if _, err := a.In("USD"); err == nil {
+		t.Error("In() of another currency error = nil, want an error")
+	}
+}
@@ -4,35 +4,58 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/contact"
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
 )
 
 var db *sql.DB
 
 type Invoice struct {
-	ID                      int            `json:"id"`
-	ProductCode             string         `json:"productCode"`
-	CustomerID              string         `json:"customerID"`
-	InvoiceID               string         `json:"invoiceID"`
-	EmailTo                 string         `json:"emailTo"`
-	InvoiceDate             string         `json:"invoiceDate"`
-	Name                    string         `json:"name"`
-	Address                 string         `json:"address"`
-	Contact                 string         `json:"contact"`
-	Tax                     int            `json:"tax"`
-	Unit                    int            `json:"unit"`
-	Description             string         `json:"description"`
-	PricePerUnit            float64        `json:"pricePerUnit"`
-	Price                   float64        `json:"price"`
-	SubTotal                float64        `json:"subTotal"`
-	TaxAmount               float64        `json:"taxAmount"`
-	GrandTotal              float64        `json:"grandTotal"`
-	Currency                string         `json:"currency"`
-	CurrencySymbol          string         `json:"currencySymbol"`
-	DoneURL                 string         `json:"doneURL"`
-	EmailServiceID          sql.NullInt64  `json:"emailServiceID,omitempty"`
-	EmailServiceMessage     sql.NullString `json:"emailServiceMessage,omitempty"`
-	EmailServiceStatus      sql.NullInt16  `json:"emailServiceStatus,omitempty"`
-	EmailServiceTriggeredAt *time.Time     `json:"emailServiceTriggeredAt,omitempty"`
+	ID          int    `json:"id"`
+	ProductCode string `json:"productCode"`
+	CustomerID  string `json:"customerID"`
+	InvoiceID   string `json:"invoiceID"`
+	SellerID    string `json:"sellerID"`
+	EmailTo     string `json:"emailTo"`
+	// Recipients are the addresses the invoice is emailed to, EmailTo when
+	// left out
+	Recipients              contact.Recipients `json:"recipients"`
+	InvoiceDate             string             `json:"invoiceDate"`
+	PeriodStart             string             `json:"periodStart"`
+	PeriodEnd               string             `json:"periodEnd"`
+	DueDate                 string             `json:"dueDate"`
+	PayURL                  string             `json:"payURL"` // empty when the invoice is not paid online
+	Locale                  string             `json:"locale"`
+	Name                    string             `json:"name"`
+	Address                 address.Address    `json:"address"`
+	Contact                 string             `json:"contact"`
+	BuyerVATID              string             `json:"buyerVATID"`
+	Tax                     float64            `json:"tax"`
+	TaxInclusive            bool               `json:"taxInclusive"`
+	Taxes                   tax.Breakdown      `json:"taxes"`
+	ReverseCharge           bool               `json:"reverseCharge"`
+	TaxExemptionReason      string             `json:"taxExemptionReason"`
+	Unit                    int                `json:"unit"`
+	Description             string             `json:"description"`
+	PricePerUnit            money.Amount       `json:"pricePerUnit"`
+	Price                   money.Amount       `json:"price"`
+	SubTotal                money.Amount       `json:"subTotal"`
+	TaxAmount               money.Amount       `json:"taxAmount"`
+	GrandTotal              money.Amount       `json:"grandTotal"`
+	Currency                string             `json:"currency"`
+	CurrencySymbol          string             `json:"currencySymbol"`
+	Discount                money.Amount       `json:"discount"`
+	DiscountDescription     string             `json:"discountDescription"`
+	UsageLines              metering.Lines     `json:"usageLines"`
+	DoneURL                 string             `json:"doneURL"`
+	EmailServiceID          sql.NullInt64      `json:"emailServiceID,omitempty"`
+	EmailServiceMessage     sql.NullString     `json:"emailServiceMessage,omitempty"`
+	EmailServiceStatus      sql.NullInt16      `json:"emailServiceStatus,omitempty"`
+	EmailServiceTriggeredAt *time.Time         `json:"emailServiceTriggeredAt,omitempty"`
 }
 
 func createTable() error {
@@ -41,21 +64,40 @@ func createTable() error {
         product_code VARCHAR(255) NOT NULL,
         customer_id VARCHAR(255) NOT NULL,
         invoice_id VARCHAR(255) NOT NULL,
+        seller_id VARCHAR(255) NOT NULL DEFAULT '',
         email_to VARCHAR(255) NOT NULL,
+        recipients TEXT,
         invoice_date VARCHAR(255) NOT NULL,
+        period_start VARCHAR(255) NOT NULL DEFAULT '',
+        period_end VARCHAR(255) NOT NULL DEFAULT '',
+        due_date VARCHAR(255) NOT NULL DEFAULT '',
+        pay_url VARCHAR(255) NOT NULL DEFAULT '',
+        locale VARCHAR(35) NOT NULL DEFAULT '',
         name VARCHAR(255) NOT NULL,
-        address VARCHAR(255) NOT NULL,
+        address_lines VARCHAR(255) NOT NULL,
+        city VARCHAR(255) NOT NULL,
+        region VARCHAR(255) NOT NULL DEFAULT '',
+        postal_code VARCHAR(32) NOT NULL DEFAULT '',
+        country CHAR(2) NOT NULL,
         contact VARCHAR(255) NOT NULL,
-        tax INT NOT NULL,
+        buyer_vat_id VARCHAR(32) NOT NULL DEFAULT '',
+        tax DECIMAL(9, 6) NOT NULL,
+        tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE,
+        taxes TEXT,
+        reverse_charge BOOLEAN NOT NULL DEFAULT FALSE,
+        tax_exemption_reason VARCHAR(255) NOT NULL DEFAULT '',
         unit INT NOT NULL,
         description VARCHAR(255) NOT NULL,
-        price_per_unit DECIMAL(10, 2),
-				price DECIMAL(10, 2) NOT NULL,
-				sub_total DECIMAL(10, 2) NOT NULL,
-				tax_amount DECIMAL(10, 2) NOT NULL,
-				grand_total DECIMAL(10, 2) NOT NULL,
+        price_per_unit DECIMAL(19, 4) NOT NULL,
+				price DECIMAL(19, 4) NOT NULL,
+				sub_total DECIMAL(19, 4) NOT NULL,
+				tax_amount DECIMAL(19, 4) NOT NULL,
+				grand_total DECIMAL(19, 4) NOT NULL,
 				currency VARCHAR(3) NOT NULL,
 				currency_symbol VARCHAR(5) NOT NULL,
+        discount DECIMAL(19, 4) NOT NULL DEFAULT 0,
+        discount_description VARCHAR(255) NOT NULL DEFAULT '',
+        usage_lines TEXT,
         done_url VARCHAR(255) NOT NULL,
         email_service_id INT DEFAULT NULL,
         email_service_message VARCHAR(255) DEFAULT NULL,
@@ -71,11 +113,14 @@ func createTable() error {
 
 func insertInvoice(invoice *Invoice) error {
 	result, err := db.Exec(`INSERT INTO pdf_invoices 
-		(product_code, customer_id, invoice_id, email_to, invoice_date, name, address, contact, tax, unit, description, price_per_unit, done_url, price, sub_total, tax_amount, grand_total, currency, currency_symbol) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		invoice.ProductCode, invoice.CustomerID, invoice.InvoiceID, invoice.EmailTo, invoice.InvoiceDate,
-		invoice.Name, invoice.Address, invoice.Contact, invoice.Tax, invoice.Unit, invoice.Description,
-		invoice.PricePerUnit, invoice.DoneURL, invoice.Price, invoice.SubTotal, invoice.TaxAmount, invoice.GrandTotal, invoice.Currency, invoice.CurrencySymbol)
+		(product_code, customer_id, invoice_id, seller_id, email_to, recipients, invoice_date, period_start, period_end, due_date, pay_url, locale, name, address_lines, city, region, postal_code, country, contact, buyer_vat_id, tax, tax_inclusive, taxes, reverse_charge, tax_exemption_reason, unit, description, price_per_unit, done_url, price, sub_total, tax_amount, grand_total, currency, currency_symbol, discount, discount_description, usage_lines) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invoice.ProductCode, invoice.CustomerID, invoice.InvoiceID, invoice.SellerID, invoice.EmailTo, invoice.Recipients, invoice.InvoiceDate,
+		invoice.PeriodStart, invoice.PeriodEnd, invoice.DueDate, invoice.PayURL, invoice.Locale,
+		invoice.Name, invoice.Address.JoinLines(), invoice.Address.City, invoice.Address.Region, invoice.Address.PostalCode, invoice.Address.Country, invoice.Contact,
+		invoice.BuyerVATID, invoice.Tax, invoice.TaxInclusive, invoice.Taxes, invoice.ReverseCharge, invoice.TaxExemptionReason, invoice.Unit, invoice.Description,
+		invoice.PricePerUnit, invoice.DoneURL, invoice.Price, invoice.SubTotal, invoice.TaxAmount, invoice.GrandTotal, invoice.Currency, invoice.CurrencySymbol,
+		invoice.Discount, invoice.DiscountDescription, invoice.UsageLines)
 	if err != nil {
 		return fmt.Errorf("error inserting invoice into database: %v", err)
 	}
@@ -87,16 +132,42 @@ func insertInvoice(invoice *Invoice) error {
 	return nil
 }
 
+// inCurrency gives the amounts scanned from an invoice row the currency of the
+// invoice, which is kept in a column of its own
+func (invoice *Invoice) inCurrency() error {
+	for _, a := range []struct {
+		column string
+		amount *money.Amount
+	}{
+		{"price_per_unit", &invoice.PricePerUnit},
+		{"price", &invoice.Price},
+		{"sub_total", &invoice.SubTotal},
+		{"tax_amount", &invoice.TaxAmount},
+		{"grand_total", &invoice.GrandTotal},
+		{"discount", &invoice.Discount},
+	} {
+		var err error
+		if *a.amount, err = a.amount.In(invoice.Currency); err != nil {
+			return fmt.Errorf("error parsing %s: %v", a.column, err)
+		}
+	}
+	return nil
+}
+
 // Helper function to retrieve an invoice by invoice ID
 func getPdfInvoiceByInvoiceID(invoiceID string) (*Invoice, error) {
 	var (
 		invoice                 Invoice
+		addressLines            string
 		emailServiceTriggeredAt sql.NullString
 	)
 	err := db.QueryRow("SELECT * FROM pdf_invoices WHERE invoice_id = ?", invoiceID).Scan(
-		&invoice.ID, &invoice.ProductCode, &invoice.CustomerID, &invoice.InvoiceID, &invoice.EmailTo, &invoice.InvoiceDate,
-		&invoice.Name, &invoice.Address, &invoice.Contact, &invoice.Tax, &invoice.Unit, &invoice.Description,
-		&invoice.PricePerUnit, &invoice.Price, &invoice.SubTotal, &invoice.TaxAmount, &invoice.GrandTotal, &invoice.Currency, &invoice.CurrencySymbol, &invoice.DoneURL, &invoice.EmailServiceID, &invoice.EmailServiceMessage,
+		&invoice.ID, &invoice.ProductCode, &invoice.CustomerID, &invoice.InvoiceID, &invoice.SellerID, &invoice.EmailTo, &invoice.Recipients, &invoice.InvoiceDate,
+		&invoice.PeriodStart, &invoice.PeriodEnd, &invoice.DueDate, &invoice.PayURL, &invoice.Locale,
+		&invoice.Name, &addressLines, &invoice.Address.City, &invoice.Address.Region, &invoice.Address.PostalCode, &invoice.Address.Country,
+		&invoice.Contact, &invoice.BuyerVATID, &invoice.Tax, &invoice.TaxInclusive, &invoice.Taxes, &invoice.ReverseCharge, &invoice.TaxExemptionReason,
+		&invoice.Unit, &invoice.Description,
+		&invoice.PricePerUnit, &invoice.Price, &invoice.SubTotal, &invoice.TaxAmount, &invoice.GrandTotal, &invoice.Currency, &invoice.CurrencySymbol, &invoice.Discount, &invoice.DiscountDescription, &invoice.UsageLines, &invoice.DoneURL, &invoice.EmailServiceID, &invoice.EmailServiceMessage,
 		&invoice.EmailServiceStatus, &emailServiceTriggeredAt,
 	)
 	if err != nil && err != sql.ErrNoRows {
@@ -104,6 +175,10 @@ func getPdfInvoiceByInvoiceID(invoiceID string) (*Invoice, error) {
 	} else if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	invoice.Address.Lines = address.SplitLines(addressLines)
+	if err := invoice.inCurrency(); err != nil {
+		return nil, err
+	}
 
 	if emailServiceTriggeredAt.Valid {
 		t, err := time.Parse(time.DateTime, emailServiceTriggeredAt.String)
@@ -120,17 +195,20 @@ func getPdfInvoiceByInvoiceID(invoiceID string) (*Invoice, error) {
 func getPdfInvoiceByID(ID int) (*Invoice, error) {
 	var (
 		invoice                 Invoice
+		addressLines            string
 		emailServiceTriggeredAt sql.NullString
 	)
 	err := db.QueryRow("SELECT * FROM pdf_invoices WHERE id = ?", ID).Scan(
 		&invoice.ID,
 		&invoice.ProductCode, &invoice.CustomerID, &invoice.InvoiceID,
-		&invoice.EmailTo, &invoice.InvoiceDate,
-		&invoice.Name, &invoice.Address, &invoice.Contact,
-		&invoice.Tax, &invoice.Unit, &invoice.Description, &invoice.PricePerUnit,
+		&invoice.SellerID, &invoice.EmailTo, &invoice.Recipients, &invoice.InvoiceDate,
+		&invoice.PeriodStart, &invoice.PeriodEnd, &invoice.DueDate, &invoice.PayURL, &invoice.Locale,
+		&invoice.Name, &addressLines, &invoice.Address.City, &invoice.Address.Region,
+		&invoice.Address.PostalCode, &invoice.Address.Country, &invoice.Contact,
+		&invoice.BuyerVATID, &invoice.Tax, &invoice.TaxInclusive, &invoice.Taxes, &invoice.ReverseCharge, &invoice.TaxExemptionReason, &invoice.Unit, &invoice.Description, &invoice.PricePerUnit,
 		&invoice.Price, &invoice.SubTotal, &invoice.TaxAmount,
 		&invoice.GrandTotal, &invoice.Currency, &invoice.CurrencySymbol,
-		&invoice.DoneURL,
+		&invoice.Discount, &invoice.DiscountDescription, &invoice.UsageLines, &invoice.DoneURL,
 		&invoice.EmailServiceID, &invoice.EmailServiceMessage,
 		&invoice.EmailServiceStatus, &emailServiceTriggeredAt,
 	)
@@ -139,6 +217,10 @@ func getPdfInvoiceByID(ID int) (*Invoice, error) {
 	} else if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	invoice.Address.Lines = address.SplitLines(addressLines)
+	if err := invoice.inCurrency(); err != nil {
+		return nil, err
+	}
 
 	if emailServiceTriggeredAt.Valid {
 		t, err := time.Parse(time.DateTime, emailServiceTriggeredAt.String)
@@ -154,12 +236,17 @@ func getPdfInvoiceByID(ID int) (*Invoice, error) {
 // Helper function to update an existing invoice record
 func updateInvoice(invoice Invoice) error {
 	_, err := db.Exec(`UPDATE pdf_invoices SET 
-		product_code = ?, customer_id = ?, email_to = ?, invoice_date = ?, name = ?, address = ?, contact = ?, 
-		tax = ?, unit = ?, description = ?, price_per_unit = ?, price = ?, sub_total = ?, tax_amount = ?, grand_total = ?, currency = ?, currency_symbol = ?, done_url = ?
+		product_code = ?, customer_id = ?, seller_id = ?, email_to = ?, recipients = ?, invoice_date = ?,
+		period_start = ?, period_end = ?, due_date = ?, pay_url = ?, locale = ?, name = ?,
+		address_lines = ?, city = ?, region = ?, postal_code = ?, country = ?, contact = ?, 
+		buyer_vat_id = ?, tax = ?, tax_inclusive = ?, taxes = ?, reverse_charge = ?, tax_exemption_reason = ?, unit = ?, description = ?, price_per_unit = ?, price = ?, sub_total = ?, tax_amount = ?, grand_total = ?, currency = ?, currency_symbol = ?, discount = ?, discount_description = ?, usage_lines = ?, done_url = ?
 		WHERE id = ?`,
-		invoice.ProductCode, invoice.CustomerID, invoice.EmailTo, invoice.InvoiceDate,
-		invoice.Name, invoice.Address, invoice.Contact, invoice.Tax, invoice.Unit, invoice.Description,
-		invoice.PricePerUnit, invoice.Price, invoice.SubTotal, invoice.TaxAmount, invoice.GrandTotal, invoice.Currency, invoice.CurrencySymbol, invoice.DoneURL, invoice.ID,
+		invoice.ProductCode, invoice.CustomerID, invoice.SellerID, invoice.EmailTo, invoice.Recipients, invoice.InvoiceDate,
+		invoice.PeriodStart, invoice.PeriodEnd, invoice.DueDate, invoice.PayURL, invoice.Locale,
+		invoice.Name, invoice.Address.JoinLines(), invoice.Address.City, invoice.Address.Region,
+		invoice.Address.PostalCode, invoice.Address.Country, invoice.Contact,
+		invoice.BuyerVATID, invoice.Tax, invoice.TaxInclusive, invoice.Taxes, invoice.ReverseCharge, invoice.TaxExemptionReason, invoice.Unit, invoice.Description,
+		invoice.PricePerUnit, invoice.Price, invoice.SubTotal, invoice.TaxAmount, invoice.GrandTotal, invoice.Currency, invoice.CurrencySymbol, invoice.Discount, invoice.DiscountDescription, invoice.UsageLines, invoice.DoneURL, invoice.ID,
 	)
 	if err != nil {
 		return fmt.Errorf("error updating invoice in database: %v", err)
@@ -7,9 +7,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
+	"time"
 
+	"github.com/arifmahmudrana/invoice/email"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
+	"github.com/arifmahmudrana/invoice/ubl"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -29,6 +34,18 @@ func GenerateInvoicePDFHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := checkTotals(&invoice); err != nil {
+		var mErr *tax.MismatchError
+		if errors.As(err, &mErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(mErr)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Call the processInvoice function in a goroutine
 	go func(invoice Invoice) {
 		mutex.Lock()
@@ -70,18 +87,27 @@ func processInvoice(invoice *Invoice) error {
 }
 
 func generateAndSendInvoicePDF(invoice Invoice) error {
+	seller, err := getSeller(invoice.SellerID)
+	if err != nil {
+		return err
+	}
+
 	var b bytes.Buffer
-	if err := generatePDF(
-		invoice, &b,
-		os.Getenv("COMPANY_NO"), os.Getenv("COMPANY_NAME"),
-		os.Getenv("COMPANY_ADDRESS"), os.Getenv("COMPANY_CONTACT"),
-		os.Getenv("COMPANY_LOGO_PATH"), os.Getenv("COMPANY_LOGO_IMG_TYPE"),
-	); err != nil {
+	if err := generatePDF(invoice, &b, seller); err != nil {
 		return fmt.Errorf("failed to generate PDF: %v", err)
 	}
 
+	if signer != nil {
+		signed, err := signer.Sign(b.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to sign PDF: %v", err)
+		}
+		b.Reset()
+		b.Write(signed)
+	}
+
 	if err := createInvoiceAndSendAPIRequest(
-		b, invoice.ProductCode, invoice.CustomerID, invoice.InvoiceID, invoice.EmailTo, getDoneURL(invoice)); err != nil {
+		b, invoice.ProductCode, invoice.CustomerID, invoice.InvoiceID, invoice.EmailTo, invoice.Recipients, emailContext(invoice), getDoneURL(invoice)); err != nil {
 		return fmt.Errorf("failed to call email service: %v", err)
 	}
 
@@ -105,20 +131,58 @@ func validateInvoice(inv *Invoice) error {
 		return errors.New("empty invoice ID")
 	}
 
+	if _, err := getSeller(inv.SellerID); err != nil {
+		return err
+	}
+
 	if inv.EmailTo == "" {
 		return errors.New("empty email to")
 	}
 
+	// Invoices without recipients are emailed to the customer
+	if inv.Recipients.IsZero() {
+		inv.Recipients.To = []string{inv.EmailTo}
+	}
+	if err := inv.Recipients.Validate(); err != nil {
+		return err
+	}
+
 	if inv.InvoiceDate == "" {
 		return errors.New("empty invoice date")
 	}
 
+	// The billing period and the due date are optional
+	for _, d := range []string{inv.PeriodStart, inv.PeriodEnd, inv.DueDate} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse(invoiceDateLayout, d); err != nil {
+			return fmt.Errorf("invalid date %q: %v", d, err)
+		}
+	}
+	locale, err := email.ParseLocale(inv.Locale)
+	if err != nil {
+		return err
+	}
+	inv.Locale = locale
+	if inv.PayURL != "" {
+		if u, err := url.Parse(inv.PayURL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("invalid pay URL: %q", inv.PayURL)
+		}
+	}
+
 	if inv.Name == "" {
 		return errors.New("empty name")
 	}
 
-	if inv.Address == "" {
-		return errors.New("empty address")
+	if err := inv.Address.Validate(); err != nil {
+		return fmt.Errorf("invalid address: %v", err)
+	}
+
+	if inv.BuyerVATID != "" {
+		if err := validateVATID(inv.BuyerVATID, inv.Address.Country); err != nil {
+			return err
+		}
 	}
 
 	if inv.Contact == "" {
@@ -129,6 +193,20 @@ func validateInvoice(inv *Invoice) error {
 		return errors.New("invalid tax")
 	}
 
+	if inv.TaxExemptionReason != "" && inv.Tax != 0 {
+		return errors.New("tax exemption reason on a taxed invoice")
+	}
+
+	// The reverse charge is decided by the caller, the totals must be without tax
+	if inv.ReverseCharge {
+		if inv.BuyerVATID == "" {
+			return errors.New("reverse charge without buyer VAT ID")
+		}
+		if inv.Tax != 0 || inv.TaxInclusive || len(inv.Taxes) > 0 {
+			return errors.New("tax on a reverse charged invoice")
+		}
+	}
+
 	if inv.Unit <= 0 {
 		return errors.New("invalid unit")
 	}
@@ -137,10 +215,33 @@ func validateInvoice(inv *Invoice) error {
 		return errors.New("empty currency")
 	}
 
-	if inv.CurrencySymbol == "" {
-		return errors.New("empty currency symbol")
+	if _, err := money.MinorUnits(inv.Currency); err != nil {
+		return err
+	}
+
+	// invoices without a discount take nothing off
+	if inv.Discount.IsZero() && inv.Discount.Currency() == "" {
+		inv.Discount, _ = money.Zero(inv.Currency)
+	}
+
+	for _, a := range []money.Amount{inv.PricePerUnit, inv.Price, inv.SubTotal, inv.TaxAmount, inv.GrandTotal, inv.Discount} {
+		if a.Currency() != inv.Currency {
+			return fmt.Errorf("amount %s is not in the invoice currency %s", a, inv.Currency)
+		}
 	}
 
+	for _, l := range inv.UsageLines {
+		if l.Quantity < 0 {
+			return fmt.Errorf("invalid usage quantity of %s: %d", l.Metric, l.Quantity)
+		}
+		if l.Amount.Currency() != inv.Currency || l.Amount.Sign() < 0 {
+			return fmt.Errorf("invalid usage amount of %s: %s", l.Metric, l.Amount)
+		}
+	}
+
+	// The symbol printed on the PDF comes from the ISO 4217 currency
+	inv.CurrencySymbol = money.Symbol(inv.Currency)
+
 	if inv.DoneURL == "" {
 		return errors.New("empty done URL")
 	}
@@ -266,3 +367,57 @@ func InvoicePDFByIDHandler(w http.ResponseWriter, r *http.Request) {
 	// Return success response
 	w.WriteHeader(http.StatusOK)
 }
+
+// InvoiceUBLByIDHandler serves the invoice as a Peppol BIS Billing 3.0 UBL document, checked
+// against the business rules of ubl.CheckRules but not against the XSD or the schematron
+func InvoiceUBLByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	invoiceID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := getPdfInvoiceByID(invoiceID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if invoice == nil {
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+		return
+	}
+
+	seller, err := getSeller(invoice.SellerID)
+	if err != nil {
+		log.Printf("Failed to get seller for id %d: %v\n", invoiceID, err)
+		http.Error(w, "Unknown seller", http.StatusInternalServerError)
+		return
+	}
+
+	doc := generateUBL(*invoice, seller)
+	if err := doc.CheckRules(); err != nil {
+		log.Printf("UBL invoice for id %d breaks business rules: %v\n", invoiceID, err)
+		var vErr *ubl.RuleError
+		if errors.As(err, &vErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(vErr)
+			return
+		}
+		http.Error(w, "Failed to check UBL invoice", http.StatusInternalServerError)
+		return
+	}
+
+	var b bytes.Buffer
+	if err := doc.Encode(&b); err != nil {
+		log.Printf("Failed to encode UBL invoice: %v\n", err)
+		http.Error(w, "Failed to encode UBL invoice", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="invoice.xml"`)
+	w.Write(b.Bytes())
+}
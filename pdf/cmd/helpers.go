@@ -4,18 +4,30 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/contact"
+	"github.com/arifmahmudrana/invoice/email"
+	"github.com/arifmahmudrana/invoice/money"
 	"github.com/arifmahmudrana/invoice/pdf"
+	"github.com/arifmahmudrana/invoice/tax"
+	"github.com/arifmahmudrana/invoice/ubl"
 )
 
+// invoiceDateLayout is the layout of the invoice date sent by the invoice service
+const invoiceDateLayout = "Jan 02, 2006"
+
 // createInvoiceAndSendAPIRequest creates an invoice and sends API request with specified parameters
-func createInvoiceAndSendAPIRequest(b bytes.Buffer, productCode, customerID, invoiceID, emailTo, doneURL string) error {
+func createInvoiceAndSendAPIRequest(b bytes.Buffer, productCode, customerID, invoiceID, emailTo string, recipients contact.Recipients, invoiceContext email.InvoiceContext, doneURL string) error {
 	// Calculate hash of buffer
 	fileHash := calculateSHA1Hash(b.Bytes())
 
@@ -37,6 +49,16 @@ func createInvoiceAndSendAPIRequest(b bytes.Buffer, productCode, customerID, inv
 	writer.WriteField("customerID", customerID)
 	writer.WriteField("invoiceID", invoiceID)
 	writer.WriteField("emailTo", emailTo)
+	rb, err := json.Marshal(recipients)
+	if err != nil {
+		return err
+	}
+	writer.WriteField("recipients", string(rb))
+	cb, err := json.Marshal(invoiceContext)
+	if err != nil {
+		return err
+	}
+	writer.WriteField("context", string(cb))
 	writer.WriteField("doneURL", doneURL)
 	writer.WriteField("fileHash", fileHash)
 
@@ -77,43 +99,231 @@ func calculateSHA1Hash(data []byte) string {
 	return hex.EncodeToString(hashBytes)
 }
 
-func generatePDF(
-	invoice Invoice, w io.Writer,
-	comNo, frName, frAdd, frCon,
-	logo, logoType string) error {
+// loadSigner loads the signing certificate and key from a PKCS#12 file or PEM files,
+// it returns nil when neither is configured
+func loadSigner() (*pdf.Signer, error) {
+	var (
+		s   *pdf.Signer
+		err error
+	)
+	switch {
+	case os.Getenv("SIGNING_PKCS12_PATH") != "":
+		s, err = pdf.LoadSignerPKCS12(os.Getenv("SIGNING_PKCS12_PATH"), os.Getenv("SIGNING_PKCS12_PASSWORD"))
+	case os.Getenv("SIGNING_CERT_PATH") != "":
+		s, err = pdf.LoadSignerPEM(os.Getenv("SIGNING_CERT_PATH"), os.Getenv("SIGNING_KEY_PATH"))
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if reason := os.Getenv("SIGNING_REASON"); reason != "" {
+		s.Reason = reason
+	}
+	s.Location = os.Getenv("SIGNING_LOCATION")
+
+	return s, nil
+}
+
+// getCompanyAddress returns the seller's address
+func getCompanyAddress() address.Address {
+	return address.Address{
+		Lines:      []string{os.Getenv("COMPANY_ADDRESS_LINE1"), os.Getenv("COMPANY_ADDRESS_LINE2")},
+		City:       os.Getenv("COMPANY_CITY"),
+		Region:     os.Getenv("COMPANY_REGION"),
+		PostalCode: os.Getenv("COMPANY_POSTAL_CODE"),
+		Country:    os.Getenv("COMPANY_COUNTRY_CODE"),
+	}
+}
+
+// getBankDetails returns the seller's bank details used for payment codes
+func getBankDetails() pdf.BankDetails {
+	return pdf.BankDetails{
+		AccountHolder:  os.Getenv("BANK_ACCOUNT_HOLDER"),
+		IBAN:           os.Getenv("BANK_IBAN"),
+		BIC:            os.Getenv("BANK_BIC"),
+		Street:         os.Getenv("BANK_CREDITOR_STREET"),
+		BuildingNumber: os.Getenv("BANK_CREDITOR_BUILDING_NUMBER"),
+		PostalCode:     os.Getenv("BANK_CREDITOR_POSTAL_CODE"),
+		Town:           os.Getenv("BANK_CREDITOR_TOWN"),
+		Country:        os.Getenv("BANK_CREDITOR_COUNTRY"),
+	}
+}
+
+// generatePDF writes the invoice PDF issued by the seller to w
+func generatePDF(invoice Invoice, w io.Writer, seller Seller) error {
+	paymentCode, err := seller.paymentCode()
+	if err != nil {
+		return err
+	}
+
+	accent, title, err := seller.colors()
+	if err != nil {
+		return err
+	}
+
 	ig := pdf.NewInvoiceGenerator()
 	ig.SetInvoiceNo(invoice.InvoiceID)
 	ig.SetInvoiceDate(invoice.InvoiceDate)
-	ig.SetCompanyNo(comNo)
-	ig.SetFromName(frName)
-	ig.SetFromAddress(frAdd)
-	ig.SetFromContact(frCon)
+	ig.SetCompanyNo(seller.CompanyNo)
+	ig.SetFromVATID(seller.VATID)
+	ig.SetFromName(seller.Name)
+	ig.SetFromAddress(seller.Address)
+	ig.SetFromContact(seller.Contact)
 	ig.SetToName(invoice.Name)
 	ig.SetToAddress(invoice.Address)
 	ig.SetToContact(invoice.Contact)
+	ig.SetToVATID(invoice.BuyerVATID)
+	ig.SetReverseCharge(invoice.ReverseCharge)
+	ig.SetExemptionReason(invoice.TaxExemptionReason)
+	ig.SetPaymentCode(paymentCode)
+	ig.SetBankDetails(seller.Bank)
+	ig.SetFooter(seller.Footer)
+	if accent != nil {
+		ig.SetAccentColor(*accent)
+	}
+	if title != nil {
+		ig.SetTitleColor(*title)
+	}
 
 	// data := [][]string{
 	// 	{invoice.Unit, invoice.Description, invoice.PricePerUnit},
 	// }
 
 	if err := ig.GenerateInvoice(pdf.SubscriptionInfo{
-		ProductDescription: invoice.Description,
-		Quantity:           invoice.Unit,
-		UnitPrice:          invoice.PricePerUnit,
-		Price:              invoice.Price,
-		SubTotal:           invoice.SubTotal,
-		Tax:                invoice.Tax,
-		TaxAmount:          invoice.TaxAmount,
-		GrandTotal:         invoice.GrandTotal,
-		Currency:           invoice.Currency,
-		CurrencySymbol:     invoice.CurrencySymbol,
-	}, w, logo, logoType); err != nil {
+		ProductDescription:  invoice.Description,
+		Quantity:            invoice.Unit,
+		UnitPrice:           invoice.PricePerUnit,
+		Price:               invoice.Price,
+		Discount:            invoice.Discount,
+		DiscountDescription: invoice.DiscountDescription,
+		UsageLines:          invoice.UsageLines,
+		SubTotal:            invoice.SubTotal,
+		Tax:                 invoice.Tax,
+		TaxInclusive:        invoice.TaxInclusive,
+		Taxes:               invoice.Taxes,
+		TaxAmount:           invoice.TaxAmount,
+		GrandTotal:          invoice.GrandTotal,
+		Currency:            invoice.Currency,
+		CurrencySymbol:      invoice.CurrencySymbol,
+	}, w, seller.LogoPath, seller.LogoImgType); err != nil {
 		log.Printf("Error generating invoice: %v\n", err)
 		return err
 	}
 	return nil
 }
 
+// emailContext returns the invoice the email of the invoice is rendered with
+func emailContext(invoice Invoice) email.InvoiceContext {
+	return email.InvoiceContext{
+		InvoiceID:    invoice.InvoiceID,
+		ProductCode:  invoice.ProductCode,
+		SellerID:     invoice.SellerID,
+		CustomerID:   invoice.CustomerID,
+		CustomerName: invoice.Name,
+		Description:  invoice.Description,
+		InvoiceDate:  invoice.InvoiceDate,
+		PeriodStart:  invoice.PeriodStart,
+		PeriodEnd:    invoice.PeriodEnd,
+		AmountDue:    invoice.GrandTotal,
+		DueDate:      invoice.DueDate,
+		PayURL:       invoice.PayURL,
+		Locale:       invoice.Locale,
+	}
+}
+
+// generateUBL builds the Peppol BIS Billing 3.0 document for the invoice
+func generateUBL(invoice Invoice, seller Seller) *ubl.Invoice {
+	// invalid dates are left zero so validation reports them
+	issueDate, _ := time.Parse(invoiceDateLayout, invoice.InvoiceDate)
+	dueDate, _ := time.Parse(invoiceDateLayout, invoice.DueDate)
+	paymentTerms := "Payment due on receipt"
+	if invoice.DueDate != "" && invoice.DueDate != invoice.InvoiceDate {
+		paymentTerms = "Payment due by " + invoice.DueDate
+	}
+
+	// UBL line amounts and the discount exclude VAT
+	unitPrice, price, discount := invoice.PricePerUnit, invoice.Price, invoice.Discount
+	usage := make([]money.Amount, len(invoice.UsageLines))
+	for i, l := range invoice.UsageLines {
+		usage[i] = l.Amount
+	}
+	if invoice.TaxInclusive && invoice.Unit > 0 {
+		rates := invoiceTotals(invoice).Rates
+		price = invoice.SubTotal
+		if !invoice.Discount.IsZero() {
+			// the net amount before the discount, the discount is the difference to the subtotal
+			charged, _ := money.Sum(invoice.Currency, append([]money.Amount{invoice.Price}, usage...)...)
+			if res, err := tax.Calculate(tax.Line{Price: charged, Rates: rates, Inclusive: true}); err == nil {
+				price = res.Net
+				discount, _ = price.Sub(invoice.SubTotal)
+			}
+		}
+		// the usage lines are taken off net, the price line takes the rounding
+		for i, amount := range usage {
+			if res, err := tax.Calculate(tax.Line{Price: amount, Rates: rates, Inclusive: true}); err == nil {
+				usage[i] = res.Net
+				price, _ = price.Sub(res.Net)
+			}
+		}
+		unitPrice, _ = price.MulRat(big.NewRat(1, int64(invoice.Unit)), money.HalfUp)
+	}
+
+	lines := []ubl.Line{
+		{
+			Description: invoice.Description,
+			Quantity:    invoice.Unit,
+			UnitPrice:   unitPrice,
+			Price:       price,
+		},
+	}
+	// usage is invoiced as a single unit of its amount
+	for i, l := range invoice.UsageLines {
+		lines = append(lines, ubl.Line{
+			Description: fmt.Sprintf("%s: %d", l.Description, l.Quantity),
+			Quantity:    1,
+			UnitPrice:   usage[i],
+			Price:       usage[i],
+		})
+	}
+
+	return ubl.New(ubl.InvoiceInfo{
+		InvoiceNo:      invoice.InvoiceID,
+		IssueDate:      issueDate,
+		DueDate:        dueDate,
+		BuyerReference: invoice.CustomerID,
+		PaymentTerms:   paymentTerms,
+		Currency:       invoice.Currency,
+		Seller: ubl.Party{
+			Name:       seller.Name,
+			EndpointID: seller.Email,
+			CompanyID:  seller.CompanyNo,
+			VATID:      seller.VATID,
+			Address:    seller.Address,
+			Telephone:  seller.Contact,
+			Email:      seller.Email,
+		},
+		Buyer: ubl.Party{
+			Name:       invoice.Name,
+			EndpointID: invoice.EmailTo,
+			VATID:      invoice.BuyerVATID,
+			Address:    invoice.Address,
+			Telephone:  invoice.Contact,
+			Email:      invoice.EmailTo,
+		},
+		Lines:           lines,
+		Tax:             invoice.Tax,
+		ReverseCharge:   invoice.ReverseCharge,
+		ExemptionReason: invoice.TaxExemptionReason,
+		Discount:        discount,
+		DiscountReason:  invoice.DiscountDescription,
+		SubTotal:        invoice.SubTotal,
+		TaxAmount:       invoice.TaxAmount,
+		GrandTotal:      invoice.GrandTotal,
+	})
+}
+
 func getDoneURL(invoice Invoice) string {
 	return fmt.Sprintf("%s%s/%d", os.Getenv("BASE_URL"), cbURLPath, invoice.ID)
 }
@@ -11,12 +11,18 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/arifmahmudrana/invoice/migrate"
+	"github.com/arifmahmudrana/invoice/pdf"
+	"github.com/arifmahmudrana/invoice/tax"
 	"github.com/go-chi/chi/v5"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var mutex sync.Mutex
 
+// signer signs generated invoices, nil when signing is not configured
+var signer *pdf.Signer
+
 const cbURLPath = "/api/cb-invoice-pdf"
 
 func main() {
@@ -39,10 +45,34 @@ func main() {
 		log.Fatalf("Error creating table: %v", err)
 	}
 
+	// Upgrade the tables created by older versions before serving
+	if err := migrate.Run(db, migrations); err != nil {
+		log.Fatalf("Error migrating tables: %v", err)
+	}
+
+	// Load the sellers invoices are issued by
+	sellers, defaultSellerID, err = loadSellers()
+	if err != nil {
+		log.Fatalf("Error loading sellers: %v", err)
+	}
+
+	// Load how invoices with inconsistent totals are handled
+	totalsMode, err = tax.ParseMode(os.Getenv("TOTALS_MODE"))
+	if err != nil {
+		log.Fatalf("Error parsing TOTALS_MODE: %v", err)
+	}
+
+	// Load the certificate used to sign invoices
+	signer, err = loadSigner()
+	if err != nil {
+		log.Fatalf("Error loading signing certificate: %v", err)
+	}
+
 	r := chi.NewRouter()
 
 	r.Post("/api/generate-invoice-pdf", GenerateInvoicePDFHandler)
 	r.Get("/api/invoice-pdf/{id}", InvoicePDFByIDHandler)
+	r.Get("/api/invoice-ubl/{id}", InvoiceUBLByIDHandler)
 	r.Post(cbURLPath+"/{id}", CBInvoicePdfHandler)
 
 	srv := &http.Server{
@@ -0,0 +1,111 @@
+package main
+
+import "github.com/arifmahmudrana/invoice/migrate"
+
+// migrations upgrade the pdf_invoices table created by older versions of the
+// service to the schema of createTable, they are run at startup before
+// serving. The columns are added in the order of createTable as the records
+// are read with SELECT *.
+var migrations = []migrate.Migration{
+	{
+		Version:     1,
+		Description: "structured addresses",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "address_lines", "VARCHAR(255) NOT NULL DEFAULT '' AFTER name"),
+			migrate.AddColumn("pdf_invoices", "city", "VARCHAR(255) NOT NULL DEFAULT '' AFTER address_lines"),
+			migrate.AddColumn("pdf_invoices", "region", "VARCHAR(255) NOT NULL DEFAULT '' AFTER city"),
+			migrate.AddColumn("pdf_invoices", "postal_code", "VARCHAR(32) NOT NULL DEFAULT '' AFTER region"),
+			migrate.AddColumn("pdf_invoices", "country", "CHAR(2) NOT NULL DEFAULT '' AFTER postal_code"),
+			migrate.SplitAddress("pdf_invoices"),
+			migrate.DropColumn("pdf_invoices", "address"),
+			migrate.ModifyColumn("pdf_invoices", "address_lines", "VARCHAR(255) NOT NULL"),
+			migrate.ModifyColumn("pdf_invoices", "city", "VARCHAR(255) NOT NULL"),
+			migrate.ModifyColumn("pdf_invoices", "country", "CHAR(2) NOT NULL"),
+		},
+	},
+	{
+		Version:     2,
+		Description: "sellers",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "seller_id", "VARCHAR(255) NOT NULL DEFAULT '' AFTER invoice_id"),
+		},
+	},
+	{
+		Version:     3,
+		Description: "buyer VAT IDs and reverse charge",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "buyer_vat_id", "VARCHAR(32) NOT NULL DEFAULT '' AFTER contact"),
+			migrate.AddColumn("pdf_invoices", "reverse_charge", "BOOLEAN NOT NULL DEFAULT FALSE AFTER tax"),
+			migrate.AddColumn("pdf_invoices", "tax_exemption_reason", "VARCHAR(255) NOT NULL DEFAULT '' AFTER reverse_charge"),
+		},
+	},
+	{
+		Version:     4,
+		Description: "decimal and compound tax rates",
+		Steps: []migrate.Step{
+			migrate.ModifyColumn("pdf_invoices", "tax", "DECIMAL(7, 4) NOT NULL"),
+			migrate.AddColumn("pdf_invoices", "tax_inclusive", "BOOLEAN NOT NULL DEFAULT FALSE AFTER tax"),
+			migrate.AddColumn("pdf_invoices", "taxes", "TEXT AFTER tax_inclusive"),
+		},
+	},
+	{
+		Version:     5,
+		Description: "exact amounts",
+		Steps: []migrate.Step{
+			// price_per_unit was nullable, records without one get the price of a unit
+			migrate.Exec("UPDATE pdf_invoices SET price_per_unit = IF(unit > 0, price / unit, price) WHERE price_per_unit IS NULL"),
+			migrate.ModifyColumn("pdf_invoices", "price_per_unit", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("pdf_invoices", "price", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("pdf_invoices", "sub_total", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("pdf_invoices", "tax_amount", "DECIMAL(19, 4) NOT NULL"),
+			migrate.ModifyColumn("pdf_invoices", "grand_total", "DECIMAL(19, 4) NOT NULL"),
+		},
+	},
+	{
+		Version:     6,
+		Description: "discounts",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "discount", "DECIMAL(19, 4) NOT NULL DEFAULT 0 AFTER currency_symbol"),
+			migrate.AddColumn("pdf_invoices", "discount_description", "VARCHAR(255) NOT NULL DEFAULT '' AFTER discount"),
+		},
+	},
+	{
+		Version:     7,
+		Description: "metered usage",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "usage_lines", "TEXT AFTER discount_description"),
+		},
+	},
+	{
+		Version:     8,
+		Description: "billing contacts",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "recipients", "TEXT AFTER email_to"),
+		},
+	},
+	{
+		Version:     9,
+		Description: "email template context",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "period_start", "VARCHAR(255) NOT NULL DEFAULT '' AFTER invoice_date"),
+			migrate.AddColumn("pdf_invoices", "period_end", "VARCHAR(255) NOT NULL DEFAULT '' AFTER period_start"),
+			migrate.AddColumn("pdf_invoices", "due_date", "VARCHAR(255) NOT NULL DEFAULT '' AFTER period_end"),
+			migrate.AddColumn("pdf_invoices", "pay_url", "VARCHAR(255) NOT NULL DEFAULT '' AFTER due_date"),
+		},
+	},
+	{
+		Version:     10,
+		Description: "email locales",
+		Steps: []migrate.Step{
+			migrate.AddColumn("pdf_invoices", "locale", "VARCHAR(35) NOT NULL DEFAULT '' AFTER pay_url"),
+		},
+	},
+	{
+		Version:     11,
+		Description: "effective tax rates",
+		Steps: []migrate.Step{
+			// compound rates such as 15.47375% need more than 4 decimals
+			migrate.ModifyColumn("pdf_invoices", "tax", "DECIMAL(9, 6) NOT NULL"),
+		},
+	},
+}
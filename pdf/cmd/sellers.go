@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/pdf"
+)
+
+// sellers holds the legal entities invoices are issued by, keyed by seller ID
+var sellers map[string]Seller
+
+// defaultSellerID is the seller of invoices which have no seller ID
+var defaultSellerID string
+
+// Seller represents a legal entity invoices are issued by and its branding
+type Seller struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	CompanyNo   string          `json:"companyNo"`
+	VATID       string          `json:"vatID"`
+	Address     address.Address `json:"address"`
+	Contact     string          `json:"contact"`
+	Email       string          `json:"email"`
+	LogoPath    string          `json:"logoPath"`
+	LogoImgType string          `json:"logoImgType"`
+	AccentColor string          `json:"accentColor,omitempty"`
+	TitleColor  string          `json:"titleColor,omitempty"`
+	Footer      string          `json:"footer,omitempty"`
+	PaymentCode string          `json:"paymentCode,omitempty"`
+	Bank        pdf.BankDetails `json:"bank"`
+}
+
+// loadSellers loads the sellers from the JSON file at SELLERS_PATH, without it
+// the single seller is configured by the COMPANY_* and BANK_* environment variables
+func loadSellers() (map[string]Seller, string, error) {
+	path := os.Getenv("SELLERS_PATH")
+	if path == "" {
+		s := getEnvSeller()
+		if err := s.validate(); err != nil {
+			return nil, "", fmt.Errorf("invalid seller: %v", err)
+		}
+		return map[string]Seller{s.ID: s}, s.ID, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening sellers file: %v", err)
+	}
+	defer f.Close()
+
+	var list []Seller
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("error parsing sellers file: %v", err)
+	}
+
+	m := make(map[string]Seller, len(list))
+	for _, s := range list {
+		if s.ID == "" {
+			return nil, "", errors.New("seller with empty ID")
+		}
+		if _, ok := m[s.ID]; ok {
+			return nil, "", fmt.Errorf("duplicate seller ID: %s", s.ID)
+		}
+		if err := s.validate(); err != nil {
+			return nil, "", fmt.Errorf("invalid seller %s: %v", s.ID, err)
+		}
+		m[s.ID] = s
+	}
+
+	id := os.Getenv("DEFAULT_SELLER_ID")
+	if _, ok := m[id]; id != "" && !ok {
+		return nil, "", fmt.Errorf("unknown default seller ID: %s", id)
+	}
+
+	return m, id, nil
+}
+
+// getEnvSeller returns the seller configured by environment variables
+func getEnvSeller() Seller {
+	return Seller{
+		Name:        os.Getenv("COMPANY_NAME"),
+		CompanyNo:   os.Getenv("COMPANY_NO"),
+		VATID:       os.Getenv("COMPANY_VAT_ID"),
+		Address:     getCompanyAddress(),
+		Contact:     os.Getenv("COMPANY_CONTACT"),
+		Email:       os.Getenv("COMPANY_EMAIL"),
+		LogoPath:    os.Getenv("COMPANY_LOGO_PATH"),
+		LogoImgType: os.Getenv("COMPANY_LOGO_IMG_TYPE"),
+		PaymentCode: os.Getenv("PAYMENT_CODE"),
+		Bank:        getBankDetails(),
+	}
+}
+
+// getSeller returns the seller of the invoice
+func getSeller(id string) (Seller, error) {
+	if id == "" {
+		id = defaultSellerID
+	}
+
+	s, ok := sellers[id]
+	if !ok {
+		return Seller{}, fmt.Errorf("unknown seller ID: %s", id)
+	}
+
+	return s, nil
+}
+
+// validate checks the seller can be printed on an invoice
+func (s Seller) validate() error {
+	if s.Name == "" {
+		return errors.New("empty name")
+	}
+
+	if err := s.Address.Validate(); err != nil {
+		return fmt.Errorf("invalid address: %v", err)
+	}
+
+	if s.LogoPath == "" {
+		return errors.New("empty logo path")
+	}
+
+	if _, err := s.paymentCode(); err != nil {
+		return err
+	}
+
+	if _, _, err := s.colors(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// paymentCode returns the payment code printed on the seller's invoices
+func (s Seller) paymentCode() (pdf.PaymentCode, error) {
+	return pdf.ParsePaymentCode(s.PaymentCode)
+}
+
+// colors returns the accent and title colours, unset colours keep the defaults
+func (s Seller) colors() (*pdf.Color, *pdf.Color, error) {
+	var accent, title *pdf.Color
+	if s.AccentColor != "" {
+		c, err := pdf.ParseColor(s.AccentColor)
+		if err != nil {
+			return nil, nil, err
+		}
+		accent = &c
+	}
+
+	if s.TitleColor != "" {
+		c, err := pdf.ParseColor(s.TitleColor)
+		if err != nil {
+			return nil, nil, err
+		}
+		title = &c
+	}
+
+	return accent, title, nil
+}
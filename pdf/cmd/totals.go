@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/arifmahmudrana/invoice/tax"
+)
+
+// totalsMode decides whether invoices with inconsistent totals are rejected or corrected
+var totalsMode tax.Mode
+
+// invoiceTotals returns the totals of the invoice, the rates are taken from the
+// tax breakdown or else from the tax rate
+func invoiceTotals(inv Invoice) tax.Totals {
+	rates := inv.Taxes.Rates()
+	if len(rates) == 0 && inv.Tax != 0 {
+		rates = []tax.Rate{{Name: "Tax", Percent: inv.Tax}}
+	}
+
+	return tax.Totals{
+		UnitPrice:  inv.PricePerUnit,
+		Quantity:   inv.Unit,
+		Usage:      inv.UsageLines.Amounts(),
+		Rates:      rates,
+		Inclusive:  inv.TaxInclusive,
+		Discount:   inv.Discount,
+		Tax:        inv.Tax,
+		Price:      inv.Price,
+		SubTotal:   inv.SubTotal,
+		Taxes:      inv.Taxes,
+		TaxAmount:  inv.TaxAmount,
+		GrandTotal: inv.GrandTotal,
+	}
+}
+
+// checkTotals recalculates the totals of the invoice from the unit price, quantity,
+// usage, discount and taxes. In strict mode totals which differ are rejected with a
+// *tax.MismatchError, in correct mode they are replaced by the recalculated ones.
+func checkTotals(inv *Invoice) error {
+	want, err := tax.Check(invoiceTotals(*inv))
+	if err != nil {
+		var mErr *tax.MismatchError
+		if totalsMode != tax.Correct || !errors.As(err, &mErr) {
+			return err
+		}
+		log.Printf("Correcting totals of invoice %s: %v\n", inv.InvoiceID, err)
+	}
+
+	inv.Tax = want.Tax
+	inv.Price = want.Price
+	inv.SubTotal = want.SubTotal
+	inv.Taxes = want.Taxes
+	inv.TaxAmount = want.TaxAmount
+	inv.GrandTotal = want.GrandTotal
+	return nil
+}
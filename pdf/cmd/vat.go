@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/arifmahmudrana/invoice/address"
+)
+
+// vatIDRe matches a VAT identification number with its country prefix
+var vatIDRe = regexp.MustCompile(`^[A-Z]{2}[0-9A-Z+*.]{2,12}$`)
+
+// validateVATID checks the format of the VAT identification number and that its
+// prefix matches the country, Greek numbers use the EL prefix.
+func validateVATID(vatID, country string) error {
+	if !vatIDRe.MatchString(vatID) {
+		return fmt.Errorf("invalid VAT ID: %s", vatID)
+	}
+
+	prefix := country
+	if prefix == "GR" {
+		prefix = "EL"
+	}
+	if address.InEU(country) && vatID[:2] != prefix {
+		return fmt.Errorf("VAT ID %s does not match country %s", vatID, country)
+	}
+
+	return nil
+}
@@ -0,0 +1,427 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	allKidsRe  = regexp.MustCompile(`/Kids \[([^\]]*)\]`)
+	refRe      = regexp.MustCompile(`(\d+) 0 R`)
+	contentsRe = regexp.MustCompile(`/Contents (\d+) 0 R`)
+	mediaBoxRe = regexp.MustCompile(`/MediaBox \[([^\]]*)\]`)
+	lengthRe   = regexp.MustCompile(`/Length (\d+)`)
+	fontRefRe  = regexp.MustCompile(`/(F[0-9a-f]+) (\d+) 0 R`)
+	baseFontRe = regexp.MustCompile(`/BaseFont /(\S+)`)
+)
+
+// pdfFile gives access to the objects of a PDF written by fpdf
+type pdfFile struct {
+	data    []byte
+	offsets []int
+}
+
+// parsePDF reads the cross-reference table of the document.
+func parsePDF(data []byte) (*pdfFile, error) {
+	m := startXrefRe.FindSubmatch(data)
+	if m == nil {
+		return nil, fmt.Errorf("startxref not found")
+	}
+	start, _ := strconv.Atoi(string(m[1]))
+
+	offsets, err := readXref(data, start)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pdfFile{data: data, offsets: offsets}, nil
+}
+
+// object returns the dictionary and the decoded stream of an object.
+func (f *pdfFile) object(n int) (string, []byte, error) {
+	obj, err := readObject(f.data, f.offsets, n)
+	if err != nil {
+		return "", nil, err
+	}
+
+	s := bytes.Index(obj, []byte("stream\n"))
+	if s < 0 {
+		return string(obj), nil, nil
+	}
+
+	dict := string(obj[:s])
+	m := lengthRe.FindStringSubmatch(dict)
+	if m == nil {
+		return "", nil, fmt.Errorf("object %d has a stream without length", n)
+	}
+	length, _ := strconv.Atoi(m[1])
+
+	// slice the stream from the document as compressed data may contain endobj
+	start := f.offsets[n] + bytes.Index(f.data[f.offsets[n]:], []byte("stream\n")) + len("stream\n")
+	stream := f.data[start : start+length]
+
+	if strings.Contains(dict, "/FlateDecode") {
+		r, err := zlib.NewReader(bytes.NewReader(stream))
+		if err != nil {
+			return "", nil, fmt.Errorf("error inflating object %d: %v", n, err)
+		}
+		defer r.Close()
+		stream, err = io.ReadAll(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("error inflating object %d: %v", n, err)
+		}
+	}
+
+	return dict, stream, nil
+}
+
+// fonts maps the font resource names to their base font.
+func (f *pdfFile) fonts() map[string]string {
+	fonts := map[string]string{}
+	for _, m := range fontRefRe.FindAllSubmatch(f.data, -1) {
+		n, _ := strconv.Atoi(string(m[2]))
+		dict, _, err := f.object(n)
+		if err != nil {
+			continue
+		}
+		if bf := baseFontRe.FindStringSubmatch(dict); bf != nil {
+			fonts[string(m[1])] = bf[1]
+		}
+	}
+	return fonts
+}
+
+// extractLayout renders the text, lines, rectangles and images of every page as
+// lines of text, positions are in PDF points from the bottom left corner.
+func extractLayout(data []byte) (string, error) {
+	f, err := parsePDF(data)
+	if err != nil {
+		return "", err
+	}
+
+	t := trailerRe.FindSubmatch(data)
+	if t == nil {
+		return "", fmt.Errorf("trailer not found")
+	}
+	m := rootRe.FindStringSubmatch(string(t[1]))
+	if m == nil {
+		return "", fmt.Errorf("trailer has no root")
+	}
+	root, _ := strconv.Atoi(m[1])
+	catalog, _, err := f.object(root)
+	if err != nil {
+		return "", err
+	}
+
+	if m = pagesRe.FindStringSubmatch(catalog); m == nil {
+		return "", fmt.Errorf("catalog has no page tree")
+	}
+	pagesID, _ := strconv.Atoi(m[1])
+	pages, _, err := f.object(pagesID)
+	if err != nil {
+		return "", err
+	}
+
+	if m = allKidsRe.FindStringSubmatch(pages); m == nil {
+		return "", fmt.Errorf("page tree has no kids")
+	}
+	kids := refRe.FindAllStringSubmatch(m[1], -1)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "size %d\n", len(data))
+	fmt.Fprintf(&out, "pages %d\n", len(kids))
+
+	fonts := f.fonts()
+	for i, kid := range kids {
+		n, _ := strconv.Atoi(kid[1])
+		page, _, err := f.object(n)
+		if err != nil {
+			return "", err
+		}
+
+		box := mediaBoxRe.FindStringSubmatch(page)
+		if box == nil {
+			box = mediaBoxRe.FindStringSubmatch(pages)
+		}
+		if box == nil {
+			return "", fmt.Errorf("page %d has no media box", i+1)
+		}
+		fmt.Fprintf(&out, "\npage %d [%s]\n", i+1, strings.TrimSpace(box[1]))
+
+		c := contentsRe.FindStringSubmatch(page)
+		if c == nil {
+			continue
+		}
+		n, _ = strconv.Atoi(c[1])
+		_, content, err := f.object(n)
+		if err != nil {
+			return "", err
+		}
+
+		if err := writeContentLayout(&out, content, fonts); err != nil {
+			return "", fmt.Errorf("page %d: %v", i+1, err)
+		}
+	}
+
+	return out.String(), nil
+}
+
+// writeContentLayout interprets the drawing operators of a content stream which
+// affect the layout, filled rectangles such as QR code modules are summarised
+// by their count and bounding box.
+func writeContentLayout(w io.Writer, content []byte, fonts map[string]string) error {
+	tokens, err := tokenize(content)
+	if err != nil {
+		return err
+	}
+
+	var (
+		operands   []string
+		font       string
+		size       float64
+		x, y       float64
+		path       [][4]float64
+		lineStart  [2]float64
+		lines      [][4]float64
+		fills      int
+		minX, minY float64
+		maxX, maxY float64
+		color      = "0.000 g"
+		colors     []string
+	)
+	num := func(i int) float64 {
+		v, _ := strconv.ParseFloat(operands[len(operands)-i], 64)
+		return v
+	}
+
+	for _, tok := range tokens {
+		if !isOperator(tok) {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch tok {
+		case "q":
+			colors = append(colors, color)
+		case "Q":
+			if len(colors) > 0 {
+				color, colors = colors[len(colors)-1], colors[:len(colors)-1]
+			}
+		case "g", "rg":
+			color = strings.Join(append(operands, tok), " ")
+		case "Tf":
+			if len(operands) >= 2 {
+				font = fonts[strings.TrimPrefix(operands[len(operands)-2], "/")]
+				size = num(1)
+			}
+		case "Td":
+			if len(operands) >= 2 {
+				x, y = num(2), num(1)
+			}
+		case "Tj", "TJ":
+			if len(operands) >= 1 {
+				fmt.Fprintf(w, "text %.2f %.2f %s %.2f %q%s\n", x, y, font, size, textOperand(operands[len(operands)-1]), colorSuffix(color))
+			}
+		case "cm":
+			if len(operands) >= 6 {
+				fmt.Fprintf(w, "image %.2f %.2f %.2f %.2f\n", num(2), num(1), num(6), num(3))
+			}
+		case "m":
+			if len(operands) >= 2 {
+				lineStart = [2]float64{num(2), num(1)}
+			}
+		case "l":
+			if len(operands) >= 2 {
+				lines = append(lines, [4]float64{lineStart[0], lineStart[1], num(2), num(1)})
+				lineStart = [2]float64{num(2), num(1)}
+			}
+		case "re":
+			if len(operands) >= 4 {
+				path = append(path, [4]float64{num(4), num(3), num(2), num(1)})
+			}
+		case "S", "s", "B", "B*", "b", "b*":
+			for _, r := range path {
+				fmt.Fprintf(w, "rect %.2f %.2f %.2f %.2f %s%s\n", r[0], r[1], r[2], r[3], tok, colorSuffix(color))
+			}
+			for _, l := range lines {
+				fmt.Fprintf(w, "line %.2f %.2f %.2f %.2f\n", l[0], l[1], l[2], l[3])
+			}
+			path, lines = nil, nil
+		case "f", "F", "f*":
+			for _, r := range path {
+				x0, y0 := r[0], r[1]+r[3]
+				x1, y1 := r[0]+r[2], r[1]
+				if fills == 0 || x0 < minX {
+					minX = x0
+				}
+				if fills == 0 || y0 < minY {
+					minY = y0
+				}
+				if fills == 0 || x1 > maxX {
+					maxX = x1
+				}
+				if fills == 0 || y1 > maxY {
+					maxY = y1
+				}
+				fills++
+			}
+			path, lines = nil, nil
+		case "n":
+			path, lines = nil, nil
+		}
+		operands = operands[:0]
+	}
+
+	if fills > 0 {
+		fmt.Fprintf(w, "fills %d [%.2f %.2f %.2f %.2f]\n", fills, minX, minY, maxX, maxY)
+	}
+
+	return nil
+}
+
+// colorSuffix returns the fill colour for the layout line, black is left out.
+func colorSuffix(color string) string {
+	if color == "0.000 g" {
+		return ""
+	}
+	return " [" + color + "]"
+}
+
+// tokenize splits a content stream into operands and operators, strings keep
+// their parentheses and arrays are returned as a single token.
+func tokenize(content []byte) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == ' ' || c == '\n' || c == '\r' || c == '\t':
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			end, err := stringEnd(content, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, string(content[i:end]))
+			i = end
+		case c == '[':
+			depth, j := 0, i
+			for ; j < len(content); j++ {
+				if content[j] == '(' {
+					end, err := stringEnd(content, j)
+					if err != nil {
+						return nil, err
+					}
+					j = end - 1
+					continue
+				}
+				if content[j] == '[' {
+					depth++
+				} else if content[j] == ']' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if j == len(content) {
+				return nil, fmt.Errorf("unterminated array at %d", i)
+			}
+			tokens = append(tokens, string(content[i:j+1]))
+			i = j + 1
+		default:
+			j := i + 1
+			for j < len(content) && !strings.ContainsRune(" \n\r\t([/%", rune(content[j])) {
+				j++
+			}
+			tokens = append(tokens, string(content[i:j]))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// stringEnd returns the index after the literal string starting at i.
+func stringEnd(content []byte, i int) (int, error) {
+	depth := 0
+	for j := i; j < len(content); j++ {
+		switch content[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return j + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated string at %d", i)
+}
+
+// isOperator reports whether the token is a content stream operator.
+func isOperator(tok string) bool {
+	c := tok[0]
+	return (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '\'' || c == '"') && tok != "true" && tok != "false" && tok != "null"
+}
+
+// textOperand decodes the strings of a Tj or TJ operand.
+func textOperand(tok string) string {
+	var out strings.Builder
+	for i := 0; i < len(tok); i++ {
+		if tok[i] != '(' {
+			continue
+		}
+		end, err := stringEnd([]byte(tok), i)
+		if err != nil {
+			break
+		}
+		out.WriteString(unescape(tok[i+1 : end-1]))
+		i = end - 1
+	}
+	return out.String()
+}
+
+// unescape decodes the escape sequences of a literal string.
+func unescape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(s[i:j], 8, 8)
+			out.WriteByte(byte(v))
+			i = j - 1
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,416 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/boombuler/barcode/qr"
+)
+
+// PaymentCode represents the kind of payment code printed on the invoice
+type PaymentCode int
+
+// Define constants for the supported payment codes.
+const (
+	PaymentCodeNone PaymentCode = iota
+	PaymentCodeEPC
+	PaymentCodeSwissQRBill
+)
+
+// ParsePaymentCode returns the payment code for its configuration name.
+func ParsePaymentCode(s string) (PaymentCode, error) {
+	switch s {
+	case "", "none":
+		return PaymentCodeNone, nil
+	case "epc":
+		return PaymentCodeEPC, nil
+	case "swiss-qr-bill":
+		return PaymentCodeSwissQRBill, nil
+	default:
+		return PaymentCodeNone, fmt.Errorf("unknown payment code: %s", s)
+	}
+}
+
+// BankDetails represents the seller's bank account the payment codes are made for.
+// The creditor address is only used by the Swiss QR-bill.
+type BankDetails struct {
+	AccountHolder  string `json:"accountHolder"`
+	IBAN           string `json:"iban"`
+	BIC            string `json:"bic"`
+	Street         string `json:"street"`
+	BuildingNumber string `json:"buildingNumber"`
+	PostalCode     string `json:"postalCode"`
+	Town           string `json:"town"`
+	Country        string `json:"country"`
+}
+
+// epcPayload builds the EPC069-12 SEPA credit transfer payload.
+func epcPayload(bank BankDetails, amount money.Amount, remittance string) string {
+	return strings.Join([]string{
+		"BCD",
+		"002",
+		"1",
+		"SCT",
+		bank.BIC,
+		truncate(bank.AccountHolder, 70),
+		compactIBAN(bank.IBAN),
+		"EUR" + amount.Decimal(),
+		"",
+		"",
+		truncate(remittance, 140),
+	}, "\n")
+}
+
+// swissQRPayload builds the Swiss Payments Code payload of the QR-bill.
+func swissQRPayload(bank BankDetails, amount money.Amount, currency, refType, ref, message string) string {
+	lines := []string{
+		"SPC",
+		"0200",
+		"1",
+		compactIBAN(bank.IBAN),
+		"S",
+		truncate(bank.AccountHolder, 70),
+		truncate(bank.Street, 70),
+		truncate(bank.BuildingNumber, 16),
+		truncate(bank.PostalCode, 16),
+		truncate(bank.Town, 35),
+		bank.Country,
+	}
+	// ultimate creditor, reserved for future use
+	lines = append(lines, "", "", "", "", "", "", "")
+	lines = append(lines, amount.Decimal(), currency)
+	// ultimate debtor, left empty so it can be filled in by hand
+	lines = append(lines, "", "", "", "", "", "", "")
+	lines = append(lines, refType, ref, truncate(message, 140), "EPD")
+
+	return strings.Join(lines, "\n")
+}
+
+// validateBankDetails checks the bank details required by the payment code.
+func validateBankDetails(code PaymentCode, bank BankDetails) error {
+	if bank.AccountHolder == "" {
+		return errors.New("empty bank account holder")
+	}
+
+	if !validIBAN(bank.IBAN) {
+		return fmt.Errorf("invalid IBAN: %s", bank.IBAN)
+	}
+
+	if code == PaymentCodeSwissQRBill {
+		iban := compactIBAN(bank.IBAN)
+		if !strings.HasPrefix(iban, "CH") && !strings.HasPrefix(iban, "LI") {
+			return fmt.Errorf("IBAN of a Swiss QR-bill must be a CH or LI IBAN: %s", bank.IBAN)
+		}
+		if bank.PostalCode == "" || bank.Town == "" || len(bank.Country) != 2 {
+			return errors.New("creditor postal code, town and country are required for a Swiss QR-bill")
+		}
+	}
+
+	return nil
+}
+
+// compactIBAN removes spaces and upper cases the IBAN.
+func compactIBAN(iban string) string {
+	return strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+}
+
+// formatIBAN formats the IBAN in groups of four characters.
+func formatIBAN(iban string) string {
+	return groupRunes(compactIBAN(iban), 4)
+}
+
+// validIBAN validates the length and the ISO 7064 mod 97-10 check digits of the IBAN.
+func validIBAN(iban string) bool {
+	iban = compactIBAN(iban)
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			digits.WriteString(strconv.Itoa(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// isQRIBAN reports whether the IBAN is a QR-IBAN, which requires a QR reference.
+func isQRIBAN(iban string) bool {
+	iban = compactIBAN(iban)
+	if len(iban) < 9 {
+		return false
+	}
+	iid, err := strconv.Atoi(iban[4:9])
+	return err == nil && iid >= 30000 && iid <= 31999
+}
+
+// qrReference builds the 27 digit QR reference from the digits of the invoice number.
+func qrReference(invoiceNo string) string {
+	var digits strings.Builder
+	for _, r := range invoiceNo {
+		if unicode.IsDigit(r) {
+			digits.WriteRune(r)
+		}
+	}
+
+	ref := digits.String()
+	if len(ref) > 26 {
+		ref = ref[len(ref)-26:]
+	}
+	ref = strings.Repeat("0", 26-len(ref)) + ref
+
+	return ref + strconv.Itoa(mod10Recursive(ref))
+}
+
+// mod10Recursive calculates the check digit of a QR reference.
+func mod10Recursive(s string) int {
+	table := [10]int{0, 9, 4, 6, 8, 2, 7, 1, 3, 5}
+	carry := 0
+	for _, r := range s {
+		carry = table[(carry+int(r-'0'))%10]
+	}
+	return (10 - carry) % 10
+}
+
+// formatQRReference formats the QR reference as shown on the payment slip.
+func formatQRReference(ref string) string {
+	if len(ref) != 27 {
+		return ref
+	}
+	return ref[:2] + " " + groupRunes(ref[2:], 5)
+}
+
+// groupRunes splits s in space separated groups of n runes.
+func groupRunes(s string, n int) string {
+	var b strings.Builder
+	for i, r := range []rune(s) {
+		if i > 0 && i%n == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// formatSwissAmount formats the amount with a space as thousands separator.
+func formatSwissAmount(amount money.Amount) string {
+	s := amount.Decimal()
+	intPart, frac := s[:len(s)-3], s[len(s)-3:]
+
+	var b strings.Builder
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + frac
+}
+
+// truncate limits s to n runes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// drawQRCode draws the payload as a vector QR code of the given size.
+func (ig *InvoiceGenerator) drawQRCode(payload string, x, y, size float64) error {
+	code, err := qr.Encode(payload, qr.M, qr.Unicode)
+	if err != nil {
+		return fmt.Errorf("error encoding QR code: %v", err)
+	}
+
+	bounds := code.Bounds()
+	modules := bounds.Dx()
+	module := size / float64(modules)
+
+	ig.pdf.SetFillColor(0, 0, 0)
+	for row := 0; row < modules; row++ {
+		for col := 0; col < modules; col++ {
+			if code.At(bounds.Min.X+col, bounds.Min.Y+row) == color.Black {
+				ig.pdf.Rect(x+float64(col)*module, y+float64(row)*module, module, module, "F")
+			}
+		}
+	}
+	ig.pdf.SetFillColor(255, 255, 255)
+
+	return nil
+}
+
+// drawEPCCode draws the EPC QR code with the bank details next to it.
+func (ig *InvoiceGenerator) drawEPCCode(data SubscriptionInfo, x, y float64) error {
+	const size = 30.0
+
+	if err := ig.drawQRCode(epcPayload(ig.Bank, data.GrandTotal, ig.InvoiceNo), x, y, size); err != nil {
+		return err
+	}
+
+	tr := ig.pdf.UnicodeTranslatorFromDescriptor("")
+	ig.pdf.SetXY(x+size+5, y)
+	ig.pdf.SetFont("Arial", "B", 10)
+	ig.pdf.Cell(80, 5, "Scan to pay with your banking app")
+	ig.pdf.SetFont("Arial", "", 10)
+	for _, line := range []string{
+		ig.Bank.AccountHolder,
+		"IBAN: " + formatIBAN(ig.Bank.IBAN),
+		"BIC: " + ig.Bank.BIC,
+		"Reference: " + ig.InvoiceNo,
+	} {
+		ig.pdf.Ln(5)
+		ig.pdf.SetX(x + size + 5)
+		ig.pdf.Cell(80, 5, tr(line))
+	}
+
+	return nil
+}
+
+// drawSwissQRBill draws the receipt and the payment part of the Swiss QR-bill at the
+// bottom of a new page.
+func (ig *InvoiceGenerator) drawSwissQRBill(data SubscriptionInfo) error {
+	refType, ref := "NON", ""
+	if isQRIBAN(ig.Bank.IBAN) {
+		refType, ref = "QRR", qrReference(ig.InvoiceNo)
+	}
+
+	ig.pdf.SetAutoPageBreak(false, 0)
+	defer ig.pdf.SetAutoPageBreak(true, 20)
+	ig.pdf.AddPage()
+	// the payment part must be left blank around the slip
+	ig.slipPage = ig.pdf.PageNo()
+
+	pageW, pageH := ig.pdf.GetPageSize()
+	top := pageH - 105
+	tr := ig.pdf.UnicodeTranslatorFromDescriptor("")
+
+	// separation lines
+	ig.pdf.SetLineWidth(0.2)
+	ig.pdf.SetDashPattern([]float64{1, 1}, 0)
+	ig.pdf.Line(0, top, pageW, top)
+	ig.pdf.Line(62, top, 62, pageH)
+	ig.pdf.SetDashPattern([]float64{}, 0)
+	ig.pdf.SetFont("Arial", "", 7)
+	ig.pdf.SetXY(0, top-4)
+	ig.pdf.CellFormat(pageW, 4, "Separate before paying in", "", 0, "CM", false, 0, "")
+
+	creditor := []string{
+		formatIBAN(ig.Bank.IBAN),
+		ig.Bank.AccountHolder,
+		strings.TrimSpace(ig.Bank.Street + " " + ig.Bank.BuildingNumber),
+		strings.TrimSpace(ig.Bank.PostalCode + " " + ig.Bank.Town),
+	}
+	amount := formatSwissAmount(data.GrandTotal)
+
+	// receipt
+	x, y := 5.0, top+5
+	ig.pdf.SetFont("Arial", "B", 11)
+	ig.pdf.SetXY(x, y)
+	ig.pdf.Cell(52, 5, "Receipt")
+	y += 7
+	y = ig.slipSection(tr, x, y, 52, "Account / Payable to", creditor, 6, 8)
+	if ref != "" {
+		y = ig.slipSection(tr, x, y, 52, "Reference", []string{formatQRReference(ref)}, 6, 8)
+	}
+	ig.slipSection(tr, x, y, 52, "Payable by (name/address)", nil, 6, 8)
+	ig.slipCornerBox(x, y+3.5, 52, 20)
+	ig.slipAmount(tr, x, top+68, 17, data.Currency, amount, 6, 8)
+	ig.pdf.SetFont("Arial", "B", 6)
+	ig.pdf.SetXY(x, top+82)
+	ig.pdf.CellFormat(52, 3, "Acceptance point", "", 0, "RM", false, 0, "")
+
+	// payment part
+	x, y = 67.0, top+5
+	ig.pdf.SetFont("Arial", "B", 11)
+	ig.pdf.SetXY(x, y)
+	ig.pdf.Cell(46, 5, "Payment part")
+	payload := swissQRPayload(ig.Bank, data.GrandTotal, data.Currency, refType, ref, ig.InvoiceNo)
+	if err := ig.drawQRCode(payload, x, top+17, 46); err != nil {
+		return err
+	}
+	ig.drawSwissCross(x+23, top+17+23)
+	ig.slipAmount(tr, x, top+68, 20, data.Currency, amount, 8, 10)
+
+	x, y = 118.0, top+5
+	y = ig.slipSection(tr, x, y, 87, "Account / Payable to", creditor, 8, 10)
+	if ref != "" {
+		y = ig.slipSection(tr, x, y, 87, "Reference", []string{formatQRReference(ref)}, 8, 10)
+	}
+	y = ig.slipSection(tr, x, y, 87, "Additional information", []string{ig.InvoiceNo}, 8, 10)
+	ig.slipSection(tr, x, y, 87, "Payable by (name/address)", nil, 8, 10)
+	ig.slipCornerBox(x, y+4, 65, 25)
+
+	return nil
+}
+
+// slipSection draws a heading and its values, returning the Y position after it.
+func (ig *InvoiceGenerator) slipSection(tr func(string) string, x, y, w float64, heading string, values []string, headingSize, valueSize float64) float64 {
+	headingH := headingSize * 0.45
+	valueH := valueSize * 0.45
+
+	ig.pdf.SetFont("Arial", "B", headingSize)
+	ig.pdf.SetXY(x, y)
+	ig.pdf.Cell(w, headingH, heading)
+	y += headingH
+
+	ig.pdf.SetFont("Arial", "", valueSize)
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		ig.pdf.SetXY(x, y)
+		ig.pdf.Cell(w, valueH, tr(v))
+		y += valueH
+	}
+
+	return y + valueH
+}
+
+// slipAmount draws the currency and amount section of the slip.
+func (ig *InvoiceGenerator) slipAmount(tr func(string) string, x, y, amountX float64, currency, amount string, headingSize, valueSize float64) {
+	ig.slipSection(tr, x, y, amountX, "Currency", []string{currency}, headingSize, valueSize)
+	ig.slipSection(tr, x+amountX, y, 40, "Amount", []string{amount}, headingSize, valueSize)
+}
+
+// slipCornerBox draws the corner marks of a field to be filled in by hand.
+func (ig *InvoiceGenerator) slipCornerBox(x, y, w, h float64) {
+	const l = 3.0
+	ig.pdf.SetLineWidth(0.25)
+	ig.pdf.Line(x, y, x+l, y)
+	ig.pdf.Line(x, y, x, y+l)
+	ig.pdf.Line(x+w-l, y, x+w, y)
+	ig.pdf.Line(x+w, y, x+w, y+l)
+	ig.pdf.Line(x, y+h, x+l, y+h)
+	ig.pdf.Line(x, y+h-l, x, y+h)
+	ig.pdf.Line(x+w-l, y+h, x+w, y+h)
+	ig.pdf.Line(x+w, y+h-l, x+w, y+h)
+}
+
+// drawSwissCross draws the 7x7 mm Swiss cross centred on the QR code.
+func (ig *InvoiceGenerator) drawSwissCross(cx, cy float64) {
+	ig.pdf.SetFillColor(255, 255, 255)
+	ig.pdf.Rect(cx-3.5, cy-3.5, 7, 7, "F")
+	ig.pdf.SetFillColor(0, 0, 0)
+	ig.pdf.Rect(cx-3, cy-3, 6, 6, "F")
+	ig.pdf.SetFillColor(255, 255, 255)
+	ig.pdf.Rect(cx-0.5625, cy-1.875, 1.125, 3.75, "F")
+	ig.pdf.Rect(cx-1.875, cy-0.5625, 3.75, 1.125, "F")
+}
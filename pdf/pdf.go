@@ -3,8 +3,13 @@ package pdf
 import (
 	"fmt"
 	"io"
-	"strings"
+	"strconv"
+	"time"
 
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
 	"github.com/go-pdf/fpdf"
 )
 
@@ -16,32 +21,88 @@ type InvoiceGenerator struct {
 	InvoiceNo   string
 	InvoiceDate string
 	CompanyNo   string
+	FromVATID   string
 	FromName    string
-	FromAddress string
+	FromAddress address.Address
 	FromContact string
 	ToName      string
-	ToAddress   string
+	ToVATID     string
+	ToAddress   address.Address
 	ToContact   string
+	PaymentCode PaymentCode
+	Bank        BankDetails
+
+	// ReverseCharge prints the reverse charge note, the buyer accounts for the VAT
+	ReverseCharge bool
+	// ExemptionReason explains why no tax is charged
+	ExemptionReason string
+
+	// CreationDate is written as the document creation and modification date,
+	// the current time is used when it is zero.
+	CreationDate time.Time
+
+	// Branding
+	AccentColor Color
+	TitleColor  Color
+	Footer      string
+
+	// slipPage is the page of the Swiss QR-bill, which has no footer
+	slipPage int
+}
+
+// Color represents an RGB colour
+type Color struct {
+	R, G, B int
+}
+
+// ParseColor parses a colour in the #rrggbb form.
+func ParseColor(s string) (Color, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return Color{}, fmt.Errorf("invalid colour: %s", s)
+	}
+
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid colour: %s", s)
+	}
+
+	return Color{R: int(v >> 16 & 0xff), G: int(v >> 8 & 0xff), B: int(v & 0xff)}, nil
 }
 
 // SubscriptionInfo represents the information used to generate the invoice
 type SubscriptionInfo struct {
 	ProductDescription string
 	Quantity           int
-	UnitPrice          float64
-	Price              float64
-	SubTotal           float64
-	Tax                int
-	TaxAmount          float64
-	GrandTotal         float64
-	Currency           string
-	CurrencySymbol     string
+	UnitPrice          money.Amount
+	Price              money.Amount
+	// Discount is taken off the price, the price is printed as the subtotal
+	// before the discount when it is not zero
+	Discount            money.Amount
+	DiscountDescription string
+	// UsageLines are the metered usage charged on top of the price, each in a
+	// row of its own below the product
+	UsageLines metering.Lines
+	SubTotal   money.Amount
+	Tax        float64
+	// TaxInclusive prices include the taxes, the subtotal does not
+	TaxInclusive bool
+	// Taxes is the tax breakdown, printed below the totals when given
+	Taxes          tax.Breakdown
+	TaxAmount      money.Amount
+	GrandTotal     money.Amount
+	Currency       string
+	CurrencySymbol string
 }
 
 // NewInvoiceGenerator creates a new instance of InvoiceGenerator.
 func NewInvoiceGenerator() *InvoiceGenerator {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	// write fonts and images in a stable order so equal input gives equal output
+	pdf.SetCatalogSort(true)
+
 	return &InvoiceGenerator{
-		pdf: fpdf.New("P", "mm", "A4", ""),
+		pdf:         pdf,
+		AccentColor: Color{200, 200, 200},
 	}
 }
 
@@ -50,7 +111,14 @@ func (ig *InvoiceGenerator) GenerateInvoice(data SubscriptionInfo, w io.Writer,
 	marginX := 10.0
 	marginY := 20.0
 	gapY := 2.0
+	if !ig.CreationDate.IsZero() {
+		// fpdf writes no document ID for unencrypted documents, so the dates are
+		// the only values which change between runs
+		ig.pdf.SetCreationDate(ig.CreationDate)
+		ig.pdf.SetModificationDate(ig.CreationDate)
+	}
 	ig.pdf.SetMargins(marginX, marginY, marginX)
+	ig.pdf.SetFooterFunc(ig.drawFooter)
 	ig.pdf.AddPage()
 	pageW, _ := ig.pdf.GetPageSize()
 	safeAreaW := pageW - 2*marginX
@@ -69,12 +137,21 @@ func (ig *InvoiceGenerator) GenerateInvoice(data SubscriptionInfo, w io.Writer,
 		ig.pdf.Cell(40, 10, fmt.Sprintf("Company No : %v", ig.CompanyNo))
 	}
 
+	if ig.FromVATID != "" {
+		ig.pdf.SetFont("Arial", "BI", 12)
+		_, lineHeight = ig.pdf.GetFontSize()
+		ig.pdf.SetXY(marginX, ig.pdf.GetY()+lineHeight+gapY)
+		ig.pdf.Cell(40, 10, fmt.Sprintf("VAT No : %v", ig.FromVATID))
+	}
+
 	leftY := ig.pdf.GetY() + lineHeight + gapY
 	// Build invoice word on right
 	ig.pdf.SetFont("Arial", "B", 32)
 	_, lineHeight = ig.pdf.GetFontSize()
 	ig.pdf.SetXY(130, currentY-lineHeight)
+	ig.pdf.SetTextColor(ig.TitleColor.R, ig.TitleColor.G, ig.TitleColor.B)
 	ig.pdf.Cell(100, 40, "INVOICE")
+	ig.pdf.SetTextColor(0, 0, 0)
 
 	newY := leftY
 	if (ig.pdf.GetY() + gapY) > newY {
@@ -89,8 +166,7 @@ func (ig *InvoiceGenerator) GenerateInvoice(data SubscriptionInfo, w io.Writer,
 	lineBreak := lineHeight + float64(1)
 
 	// Left hand info
-	splittedFromAddress := ig.breakAddress(ig.FromAddress)
-	for _, add := range splittedFromAddress {
+	for _, add := range ig.FromAddress.Format() {
 		ig.pdf.Cell(safeAreaW/2, lineHeight, add)
 		ig.pdf.Ln(lineBreak)
 	}
@@ -107,8 +183,11 @@ func (ig *InvoiceGenerator) GenerateInvoice(data SubscriptionInfo, w io.Writer,
 	ig.pdf.Cell(safeAreaW/2, lineHeight, ig.ToName)
 	ig.pdf.SetFontStyle("")
 	ig.pdf.Ln(lineBreak)
-	splittedToAddress := ig.breakAddress(ig.ToAddress)
-	for _, add := range splittedToAddress {
+	if ig.ToVATID != "" {
+		ig.pdf.Cell(safeAreaW/2, lineHeight, fmt.Sprintf("VAT No: %s", ig.ToVATID))
+		ig.pdf.Ln(lineBreak)
+	}
+	for _, add := range ig.ToAddress.Format() {
 		ig.pdf.Cell(safeAreaW/2, lineHeight, add)
 		ig.pdf.Ln(lineBreak)
 	}
@@ -136,38 +215,89 @@ func (ig *InvoiceGenerator) GenerateInvoice(data SubscriptionInfo, w io.Writer,
 	ig.pdf.Ln(lineBreak)
 	ig.pdf.Cell(safeAreaW, lineHeight, "Note: The tax invoice is computer generated and no signature is required.")
 
-	return ig.pdf.Output(w)
-}
+	if data.TaxInclusive {
+		ig.pdf.Ln(lineBreak)
+		ig.pdf.Cell(safeAreaW, lineHeight, "Prices include tax.")
+	}
 
-// SetFromAddress sets the 'From' address.
-func (ig *InvoiceGenerator) SetFromAddress(address string) {
-	ig.FromAddress = address
+	if !data.Discount.IsZero() && data.DiscountDescription != "" {
+		ig.pdf.Ln(lineBreak)
+		ig.pdf.Cell(safeAreaW, lineHeight, fmt.Sprintf("Discount: %s", data.DiscountDescription))
+	}
+
+	if ig.ReverseCharge {
+		ig.pdf.Ln(lineBreak)
+		ig.pdf.SetFontStyle("B")
+		ig.pdf.MultiCell(safeAreaW, lineHeight, "Reverse charge: VAT to be accounted for by the recipient (Article 196, Council Directive 2006/112/EC).", "", "L", false)
+		ig.pdf.SetFontStyle("")
+	}
+
+	if ig.ExemptionReason != "" {
+		if !ig.ReverseCharge {
+			ig.pdf.Ln(lineBreak)
+		}
+		ig.pdf.MultiCell(safeAreaW, lineHeight, fmt.Sprintf("VAT exemption: %s", ig.ExemptionReason), "", "L", false)
+	}
+
+	if err := ig.drawPaymentCode(data, marginX, ig.pdf.GetY()+2*lineBreak); err != nil {
+		return err
+	}
+
+	return ig.pdf.Output(w)
 }
 
-// SetToAddress sets the 'To' address.
-func (ig *InvoiceGenerator) SetToAddress(address string) {
-	ig.ToAddress = address
-}
-
-// breakAddress breaks the address string into lines for formatting.
-func (ig *InvoiceGenerator) breakAddress(input string) []string {
-	var address []string
-	splitted := strings.Split(input, ",")
-	prevAddress := ""
-	for _, add := range splitted {
-		if len(add) < 10 {
-			prevAddress = add
-			continue
+// drawPaymentCode draws the configured payment code, invoices in a currency the
+// payment code does not support are left without one.
+func (ig *InvoiceGenerator) drawPaymentCode(data SubscriptionInfo, x, y float64) error {
+	switch ig.PaymentCode {
+	case PaymentCodeEPC:
+		if data.Currency != "EUR" {
+			return nil
+		}
+		if err := validateBankDetails(ig.PaymentCode, ig.Bank); err != nil {
+			return err
+		}
+		_, pageH := ig.pdf.GetPageSize()
+		if y+30 > pageH-20 {
+			ig.pdf.AddPage()
+			y = ig.pdf.GetY()
 		}
-		currentAdd := strings.TrimSpace(add)
-		if prevAddress != "" {
-			currentAdd = prevAddress + ", " + currentAdd
+		return ig.drawEPCCode(data, x, y)
+	case PaymentCodeSwissQRBill:
+		if data.Currency != "CHF" && data.Currency != "EUR" {
+			return nil
 		}
-		address = append(address, currentAdd)
-		prevAddress = ""
+		if err := validateBankDetails(ig.PaymentCode, ig.Bank); err != nil {
+			return err
+		}
+		return ig.drawSwissQRBill(data)
+	}
+
+	return nil
+}
+
+// drawFooter draws the footer text at the bottom of every page but the Swiss
+// QR-bill.
+func (ig *InvoiceGenerator) drawFooter() {
+	if ig.Footer == "" || ig.pdf.PageNo() == ig.slipPage {
+		return
 	}
 
-	return address
+	ig.pdf.SetY(-15)
+	ig.pdf.SetFont("Arial", "I", 8)
+	ig.pdf.SetTextColor(128, 128, 128)
+	ig.pdf.MultiCell(0, 4, ig.Footer, "", "C", false)
+	ig.pdf.SetTextColor(0, 0, 0)
+}
+
+// SetFromAddress sets the 'From' address.
+func (ig *InvoiceGenerator) SetFromAddress(addr address.Address) {
+	ig.FromAddress = addr
+}
+
+// SetToAddress sets the 'To' address.
+func (ig *InvoiceGenerator) SetToAddress(addr address.Address) {
+	ig.ToAddress = addr
 }
 
 // drawTable draws the table with invoice data.
@@ -179,7 +309,7 @@ func (ig *InvoiceGenerator) drawTable(data SubscriptionInfo, marginX, startY, li
 
 	// Headers
 	ig.pdf.SetFontStyle("B")
-	ig.pdf.SetFillColor(200, 200, 200)
+	ig.pdf.SetFillColor(ig.AccentColor.R, ig.AccentColor.G, ig.AccentColor.B)
 	for colJ := 0; colJ < colNumber; colJ++ {
 		ig.pdf.CellFormat(colWidth[colJ], lineHeight, header[colJ], "1", 0, "CM", true, 0, "")
 	}
@@ -193,29 +323,92 @@ func (ig *InvoiceGenerator) drawTable(data SubscriptionInfo, marginX, startY, li
 	ig.pdf.CellFormat(colWidth[0], lineHeight, fmt.Sprintf("%d", 1), "1", 0, "CM", true, 0, "")
 	ig.pdf.CellFormat(colWidth[1], lineHeight, data.ProductDescription, "1", 0, "LM", true, 0, "")
 	ig.pdf.CellFormat(colWidth[2], lineHeight, fmt.Sprintf("%d", data.Quantity), "1", 0, "CM", true, 0, "")
-	ig.pdf.CellFormat(colWidth[3], lineHeight, fmt.Sprintf("%.2f", data.UnitPrice), "1", 0, "CM", true, 0, "")
-	ig.pdf.CellFormat(colWidth[4], lineHeight, fmt.Sprintf("%.2f", data.Price), "1", 0, "CM", true, 0, "")
+	ig.pdf.CellFormat(colWidth[3], lineHeight, data.UnitPrice.Decimal(), "1", 0, "CM", true, 0, "")
+	ig.pdf.CellFormat(colWidth[4], lineHeight, data.Price.Decimal(), "1", 0, "CM", true, 0, "")
 	ig.pdf.Ln(-1)
 
+	// usage is priced by its tiers, so it has no single unit price
+	charged := data.Price
+	for i, l := range data.UsageLines {
+		ig.pdf.CellFormat(colWidth[0], lineHeight, fmt.Sprintf("%d", i+2), "1", 0, "CM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[1], lineHeight, l.Description, "1", 0, "LM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[2], lineHeight, fmt.Sprintf("%d", l.Quantity), "1", 0, "CM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[3], lineHeight, "", "1", 0, "CM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[4], lineHeight, l.Amount.Decimal(), "1", 0, "CM", true, 0, "")
+		ig.pdf.Ln(-1)
+		charged, _ = charged.Add(l.Amount)
+	}
+
 	ig.pdf.SetFontStyle("B")
 	leftIndent := 0.0
 	for i := 0; i < 3; i++ {
 		leftIndent += colWidth[i]
 	}
+	subTotalLabel := "Subtotal"
+	if !data.Discount.IsZero() {
+		ig.pdf.SetX(marginX + leftIndent)
+		ig.pdf.CellFormat(colWidth[3], lineHeight, "Subtotal", "1", 0, "CM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[4], lineHeight, charged.Decimal(), "1", 0, "CM", true, 0, "")
+		ig.pdf.Ln(-1)
+
+		ig.pdf.SetX(marginX + leftIndent)
+		ig.pdf.CellFormat(colWidth[3], lineHeight, "Discount", "1", 0, "CM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[4], lineHeight, data.Discount.Neg().Decimal(), "1", 0, "CM", true, 0, "")
+		ig.pdf.Ln(-1)
+		subTotalLabel = "Net amount"
+	}
+
 	ig.pdf.SetX(marginX + leftIndent)
-	ig.pdf.CellFormat(colWidth[3], lineHeight, "Subtotal", "1", 0, "CM", true, 0, "")
-	ig.pdf.CellFormat(colWidth[4], lineHeight, fmt.Sprintf("%.2f", data.SubTotal), "1", 0, "CM", true, 0, "")
+	ig.pdf.CellFormat(colWidth[3], lineHeight, subTotalLabel, "1", 0, "CM", true, 0, "")
+	ig.pdf.CellFormat(colWidth[4], lineHeight, data.SubTotal.Decimal(), "1", 0, "CM", true, 0, "")
 	ig.pdf.Ln(-1)
 
 	ig.pdf.SetX(marginX + leftIndent)
 	ig.pdf.CellFormat(colWidth[3], lineHeight, "Tax Amount", "1", 0, "CM", true, 0, "")
-	ig.pdf.CellFormat(colWidth[4], lineHeight, fmt.Sprintf("%.2f", data.TaxAmount), "1", 0, "CM", true, 0, "")
+	ig.pdf.CellFormat(colWidth[4], lineHeight, data.TaxAmount.Decimal(), "1", 0, "CM", true, 0, "")
 	ig.pdf.Ln(-1)
 
 	ig.pdf.SetX(marginX + leftIndent)
 	ig.pdf.CellFormat(colWidth[3], lineHeight, "Grand total", "1", 0, "CM", true, 0, "")
-	ig.pdf.CellFormat(colWidth[4], lineHeight, fmt.Sprintf("%.2f", data.GrandTotal), "1", 0, "CM", true, 0, "")
+	ig.pdf.CellFormat(colWidth[4], lineHeight, data.GrandTotal.Decimal(), "1", 0, "CM", true, 0, "")
 	ig.pdf.Ln(-1)
+
+	if len(data.Taxes) > 0 {
+		ig.drawTaxBreakdown(data, marginX, lineHeight)
+	}
+}
+
+// drawTaxBreakdown draws the table with the taxable amount and the tax of every
+// tax charged.
+func (ig *InvoiceGenerator) drawTaxBreakdown(data SubscriptionInfo, marginX, lineHeight float64) {
+	const colNumber = 4
+	header := [colNumber]string{"Tax", "Rate", fmt.Sprintf("Taxable (%s)", data.CurrencySymbol), fmt.Sprintf("Tax (%s)", data.CurrencySymbol)}
+	colWidth := [colNumber]float64{60.0, 30.0, 50.0, 50.0}
+
+	ig.pdf.SetY(ig.pdf.GetY() + lineHeight/2)
+	ig.pdf.SetX(marginX)
+	ig.pdf.SetFontStyle("B")
+	ig.pdf.SetFillColor(ig.AccentColor.R, ig.AccentColor.G, ig.AccentColor.B)
+	for colJ := 0; colJ < colNumber; colJ++ {
+		ig.pdf.CellFormat(colWidth[colJ], lineHeight, header[colJ], "1", 0, "CM", true, 0, "")
+	}
+
+	ig.pdf.Ln(-1)
+	ig.pdf.SetFillColor(255, 255, 255)
+	ig.pdf.SetFontStyle("")
+
+	for _, t := range data.Taxes {
+		name := t.Name
+		if t.Compound {
+			name += " (compound)"
+		}
+		ig.pdf.SetX(marginX)
+		ig.pdf.CellFormat(colWidth[0], lineHeight, name, "1", 0, "LM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[1], lineHeight, strconv.FormatFloat(t.Percent, 'f', -1, 64)+"%", "1", 0, "CM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[2], lineHeight, t.Taxable.Decimal(), "1", 0, "CM", true, 0, "")
+		ig.pdf.CellFormat(colWidth[3], lineHeight, t.Amount.Decimal(), "1", 0, "CM", true, 0, "")
+		ig.pdf.Ln(-1)
+	}
 }
 
 // SetInvoiceNo sets the invoice number.
@@ -252,3 +445,53 @@ func (ig *InvoiceGenerator) SetToName(toName string) {
 func (ig *InvoiceGenerator) SetToContact(contact string) {
 	ig.ToContact = contact
 }
+
+// SetPaymentCode sets the payment code printed on the invoice.
+func (ig *InvoiceGenerator) SetPaymentCode(code PaymentCode) {
+	ig.PaymentCode = code
+}
+
+// SetBankDetails sets the bank details used for the payment code.
+func (ig *InvoiceGenerator) SetBankDetails(bank BankDetails) {
+	ig.Bank = bank
+}
+
+// SetCreationDate sets the creation date of the document.
+func (ig *InvoiceGenerator) SetCreationDate(t time.Time) {
+	ig.CreationDate = t
+}
+
+// SetAccentColor sets the fill colour of the table header.
+func (ig *InvoiceGenerator) SetAccentColor(c Color) {
+	ig.AccentColor = c
+}
+
+// SetTitleColor sets the colour of the invoice title.
+func (ig *InvoiceGenerator) SetTitleColor(c Color) {
+	ig.TitleColor = c
+}
+
+// SetFooter sets the text printed at the bottom of every page.
+func (ig *InvoiceGenerator) SetFooter(footer string) {
+	ig.Footer = footer
+}
+
+// SetFromVATID sets the seller's VAT identification number.
+func (ig *InvoiceGenerator) SetFromVATID(vatID string) {
+	ig.FromVATID = vatID
+}
+
+// SetToVATID sets the buyer's VAT identification number.
+func (ig *InvoiceGenerator) SetToVATID(vatID string) {
+	ig.ToVATID = vatID
+}
+
+// SetReverseCharge sets whether the buyer accounts for the VAT.
+func (ig *InvoiceGenerator) SetReverseCharge(reverseCharge bool) {
+	ig.ReverseCharge = reverseCharge
+}
+
+// SetExemptionReason sets the reason no tax is charged.
+func (ig *InvoiceGenerator) SetExemptionReason(reason string) {
+	ig.ExemptionReason = reason
+}
@@ -0,0 +1,592 @@
+package pdf
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/metering"
+	"github.com/arifmahmudrana/invoice/money"
+	"github.com/arifmahmudrana/invoice/tax"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// creationDate is used for every fixture so the rendered documents are stable
+var creationDate = time.Date(2024, time.March, 18, 9, 30, 0, 0, time.UTC)
+
+// fixture is an invoice rendered by the golden file tests
+type fixture struct {
+	name  string
+	setup func(ig *InvoiceGenerator)
+	data  SubscriptionInfo
+}
+
+var fixtures = []fixture{
+	{
+		name: "basic",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:1:CUST001:PROD001:1")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetCompanyNo("12345678")
+			ig.SetFromName("Example Ltd")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"1 Market Street"},
+				City:       "Anytown",
+				Region:     "NY",
+				PostalCode: "12345",
+				Country:    "US",
+			})
+			ig.SetFromContact("+1 555 0100")
+			ig.SetToName("John Doe")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"123 Main St", "Apt 4B"},
+				City:       "Anycity",
+				Region:     "CA",
+				PostalCode: "90210",
+				Country:    "US",
+			})
+			ig.SetToContact("+1 555 0199")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Monthly subscription",
+			Quantity:           2,
+			UnitPrice:          money.MustParse("50.00", "USD"),
+			Price:              money.MustParse("100.00", "USD"),
+			SubTotal:           money.MustParse("100.00", "USD"),
+			Tax:                10,
+			TaxAmount:          money.MustParse("10.00", "USD"),
+			GrandTotal:         money.MustParse("110.00", "USD"),
+			Currency:           "USD",
+			CurrencySymbol:     "$",
+		},
+	},
+	{
+		name: "no-company-no",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:2:CUST002:PROD002:7")
+			ig.SetInvoiceDate("Dec 31, 2024")
+			ig.SetFromName("Example Ltd")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"10 Downing Street"},
+				City:       "London",
+				PostalCode: "SW1A 2AA",
+				Country:    "GB",
+			})
+			ig.SetFromContact("+44 20 7946 0000")
+			ig.SetToName("Jane Roe")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"Flat 3", "22 Baker Street"},
+				City:       "London",
+				PostalCode: "NW1 6XE",
+				Country:    "GB",
+			})
+			ig.SetToContact("+44 20 7946 0999")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Annual support plan with priority response",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("1234.50", "GBP"),
+			Price:              money.MustParse("1234.50", "GBP"),
+			SubTotal:           money.MustParse("1234.50", "GBP"),
+			Tax:                20,
+			TaxAmount:          money.MustParse("246.90", "GBP"),
+			GrandTotal:         money.MustParse("1481.40", "GBP"),
+			Currency:           "GBP",
+			CurrencySymbol:     "£",
+		},
+	},
+	{
+		name: "branded",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:5:CUST005:PROD001:3")
+			ig.SetInvoiceDate("Jun 01, 2024")
+			ig.SetCompanyNo("87654321")
+			ig.SetFromName("Example Holdings Inc")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"500 Commerce Way", "Suite 200"},
+				City:       "Anyville",
+				Region:     "TX",
+				PostalCode: "73301",
+				Country:    "US",
+			})
+			ig.SetFromContact("+1 555 0142")
+			ig.SetToName("Acme Corp")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"1 Industrial Park"},
+				City:       "Anystate",
+				Region:     "WA",
+				PostalCode: "98101",
+				Country:    "US",
+			})
+			ig.SetToContact("+1 555 0177")
+			ig.SetAccentColor(Color{R: 0x1f, G: 0x6f, B: 0xb2})
+			ig.SetTitleColor(Color{R: 0x1f, G: 0x6f, B: 0xb2})
+			ig.SetFooter("Example Holdings Inc is registered in Texas under no. 87654321.\nThank you for your business.")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Enterprise licence",
+			Quantity:           5,
+			UnitPrice:          money.MustParse("199.00", "USD"),
+			Price:              money.MustParse("995.00", "USD"),
+			SubTotal:           money.MustParse("995.00", "USD"),
+			Tax:                0,
+			TaxAmount:          money.MustParse("0.00", "USD"),
+			GrandTotal:         money.MustParse("995.00", "USD"),
+			Currency:           "USD",
+			CurrencySymbol:     "$",
+		},
+	},
+	{
+		name: "epc",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:3:CUST003:PROD001:12")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetCompanyNo("HRB 12345")
+			ig.SetFromName("Beispiel GmbH")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"Hauptstrasse 1"},
+				City:       "Berlin",
+				PostalCode: "10115",
+				Country:    "DE",
+			})
+			ig.SetFromContact("+49 30 123456")
+			ig.SetToName("Max Mustermann")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"Marktplatz 5"},
+				City:       "Hamburg",
+				PostalCode: "20095",
+				Country:    "DE",
+			})
+			ig.SetToContact("+49 40 654321")
+			ig.SetPaymentCode(PaymentCodeEPC)
+			ig.SetBankDetails(BankDetails{
+				AccountHolder: "Beispiel GmbH",
+				IBAN:          "DE89370400440532013000",
+				BIC:           "COBADEFFXXX",
+			})
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Monthly subscription",
+			Quantity:           3,
+			UnitPrice:          money.MustParse("19.99", "EUR"),
+			Price:              money.MustParse("59.97", "EUR"),
+			SubTotal:           money.MustParse("59.97", "EUR"),
+			Tax:                19,
+			TaxAmount:          money.MustParse("11.39", "EUR"),
+			GrandTotal:         money.MustParse("71.36", "EUR"),
+			Currency:           "EUR",
+			CurrencySymbol:     "€",
+		},
+	},
+	{
+		name: "reverse-charge",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:6:CUST006:PROD001:2")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetCompanyNo("HRB 12345")
+			ig.SetFromVATID("DE123456789")
+			ig.SetFromName("Beispiel GmbH")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"Hauptstrasse 1"},
+				City:       "Berlin",
+				PostalCode: "10115",
+				Country:    "DE",
+			})
+			ig.SetFromContact("+49 30 123456")
+			ig.SetToName("Exemple SARL")
+			ig.SetToVATID("FR40303265045")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"12 rue de la Paix"},
+				City:       "Paris",
+				PostalCode: "75002",
+				Country:    "FR",
+			})
+			ig.SetToContact("+33 1 23 45 67 89")
+			ig.SetReverseCharge(true)
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Monthly subscription",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("250.00", "EUR"),
+			Price:              money.MustParse("250.00", "EUR"),
+			SubTotal:           money.MustParse("250.00", "EUR"),
+			Tax:                0,
+			TaxAmount:          money.MustParse("0.00", "EUR"),
+			GrandTotal:         money.MustParse("250.00", "EUR"),
+			Currency:           "EUR",
+			CurrencySymbol:     "€",
+		},
+	},
+	{
+		name: "exempt",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:7:CUST007:PROD004:1")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetFromVATID("DE123456789")
+			ig.SetFromName("Beispiel GmbH")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"Hauptstrasse 1"},
+				City:       "Berlin",
+				PostalCode: "10115",
+				Country:    "DE",
+			})
+			ig.SetFromContact("+49 30 123456")
+			ig.SetToName("Max Mustermann")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"Marktplatz 5"},
+				City:       "Hamburg",
+				PostalCode: "20095",
+				Country:    "DE",
+			})
+			ig.SetToContact("+49 40 654321")
+			ig.SetExemptionReason("Exempt training services under section 4 no. 21 UStG")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Training course",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("400.00", "EUR"),
+			Price:              money.MustParse("400.00", "EUR"),
+			SubTotal:           money.MustParse("400.00", "EUR"),
+			Tax:                0,
+			TaxAmount:          money.MustParse("0.00", "EUR"),
+			GrandTotal:         money.MustParse("400.00", "EUR"),
+			Currency:           "EUR",
+			CurrencySymbol:     "€",
+		},
+	},
+	{
+		name: "swiss-qr-bill",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:4:CUST004:PROD003:5")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetFromName("Beispiel AG")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"Bahnhofstrasse 1"},
+				City:       "Zurich",
+				PostalCode: "8001",
+				Country:    "CH",
+			})
+			ig.SetFromContact("+41 44 123 45 67")
+			ig.SetToName("Pia Muster")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"Musterweg 7"},
+				City:       "Bern",
+				PostalCode: "3000",
+				Country:    "CH",
+			})
+			ig.SetToContact("+41 31 765 43 21")
+			ig.SetPaymentCode(PaymentCodeSwissQRBill)
+			ig.SetBankDetails(BankDetails{
+				AccountHolder:  "Beispiel AG",
+				IBAN:           "CH9300762011623852957",
+				Street:         "Bahnhofstrasse",
+				BuildingNumber: "1",
+				PostalCode:     "8001",
+				Town:           "Zurich",
+				Country:        "CH",
+			})
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Quarterly subscription",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("450.00", "CHF"),
+			Price:              money.MustParse("450.00", "CHF"),
+			SubTotal:           money.MustParse("450.00", "CHF"),
+			Tax:                8,
+			TaxAmount:          money.MustParse("36.00", "CHF"),
+			GrandTotal:         money.MustParse("486.00", "CHF"),
+			Currency:           "CHF",
+			CurrencySymbol:     "CHF",
+		},
+	},
+	{
+		name: "zero-decimal-currency",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:10:CUST010:PROD001:10")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetFromName("Example KK")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"1-1 Marunouchi"},
+				City:       "Chiyoda-ku, Tokyo",
+				PostalCode: "100-0005",
+				Country:    "JP",
+			})
+			ig.SetFromContact("+81 3 1234 5678")
+			ig.SetToName("Taro Yamada")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"2-2 Umeda"},
+				City:       "Kita-ku, Osaka",
+				PostalCode: "530-0001",
+				Country:    "JP",
+			})
+			ig.SetToContact("+81 6 1234 5678")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Monthly subscription",
+			Quantity:           3,
+			UnitPrice:          money.MustParse("1980", "JPY"),
+			Price:              money.MustParse("5940", "JPY"),
+			SubTotal:           money.MustParse("5940", "JPY"),
+			Tax:                10,
+			TaxAmount:          money.MustParse("594", "JPY"),
+			GrandTotal:         money.MustParse("6534", "JPY"),
+			Currency:           "JPY",
+			CurrencySymbol:     "¥",
+		},
+	},
+	{
+		name: "tax-breakdown",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:8:CUST008:PROD001:8")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetFromName("Exemple Inc.")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"100 rue Notre-Dame Ouest"},
+				City:       "Montréal",
+				Region:     "QC",
+				PostalCode: "H2Y 1T6",
+				Country:    "CA",
+			})
+			ig.SetFromContact("+1 514 555 0100")
+			ig.SetToName("Sophie Tremblay")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"350 rue Saint-Paul Est"},
+				City:       "Montréal",
+				Region:     "QC",
+				PostalCode: "H2Y 1H2",
+				Country:    "CA",
+			})
+			ig.SetToContact("+1 514 555 0142")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Monthly subscription",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("103.00", "CAD"),
+			Price:              money.MustParse("103.00", "CAD"),
+			SubTotal:           money.MustParse("103.00", "CAD"),
+			Tax:                14.975,
+			Taxes: tax.Breakdown{
+				{Name: "GST", Percent: 5, Taxable: money.MustParse("103.00", "CAD"), Amount: money.MustParse("5.15", "CAD")},
+				{Name: "QST", Percent: 9.975, Taxable: money.MustParse("103.00", "CAD"), Amount: money.MustParse("10.27", "CAD")},
+			},
+			TaxAmount:      money.MustParse("15.42", "CAD"),
+			GrandTotal:     money.MustParse("118.42", "CAD"),
+			Currency:       "CAD",
+			CurrencySymbol: "$",
+		},
+	},
+	{
+		name: "tax-inclusive",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:9:CUST009:PROD001:9")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetCompanyNo("12345678")
+			ig.SetFromName("Example Ltd")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"10 Downing Street"},
+				City:       "London",
+				PostalCode: "SW1A 2AA",
+				Country:    "GB",
+			})
+			ig.SetFromContact("+44 20 7946 0000")
+			ig.SetToName("Jane Smith")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"221B Baker Street"},
+				City:       "London",
+				PostalCode: "NW1 6XE",
+				Country:    "GB",
+			})
+			ig.SetToContact("+44 20 7946 0999")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Monthly subscription",
+			Quantity:           1,
+			UnitPrice:          money.MustParse("12.60", "GBP"),
+			Price:              money.MustParse("12.60", "GBP"),
+			SubTotal:           money.MustParse("10.50", "GBP"),
+			Tax:                20,
+			TaxInclusive:       true,
+			Taxes: tax.Breakdown{
+				{Name: "VAT", Percent: 20, Taxable: money.MustParse("10.50", "GBP"), Amount: money.MustParse("2.10", "GBP")},
+			},
+			TaxAmount:      money.MustParse("2.10", "GBP"),
+			GrandTotal:     money.MustParse("12.60", "GBP"),
+			Currency:       "GBP",
+			CurrencySymbol: "£",
+		},
+	},
+	{
+		name: "discount",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:12:CUST012:PROD001:12")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetCompanyNo("12345678")
+			ig.SetFromName("Example Ltd")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"1 Market Street"},
+				City:       "Anytown",
+				Region:     "NY",
+				PostalCode: "12345",
+				Country:    "US",
+			})
+			ig.SetFromContact("+1 555 0100")
+			ig.SetToName("John Doe")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"123 Main St", "Apt 4B"},
+				City:       "Anycity",
+				Region:     "CA",
+				PostalCode: "90210",
+				Country:    "US",
+			})
+			ig.SetToContact("+1 555 0199")
+		},
+		data: SubscriptionInfo{
+			ProductDescription:  "Monthly subscription",
+			Quantity:            2,
+			UnitPrice:           money.MustParse("50.00", "USD"),
+			Price:               money.MustParse("100.00", "USD"),
+			Discount:            money.MustParse("10.00", "USD"),
+			DiscountDescription: "WELCOME10, 10% off the first 3 months",
+			SubTotal:            money.MustParse("90.00", "USD"),
+			Tax:                 8.875,
+			TaxAmount:           money.MustParse("7.99", "USD"),
+			GrandTotal:          money.MustParse("97.99", "USD"),
+			Currency:            "USD",
+			CurrencySymbol:      "$",
+		},
+	},
+	{
+		name: "usage",
+		setup: func(ig *InvoiceGenerator) {
+			ig.SetInvoiceNo("INV:11:CUST011:PROD002:11")
+			ig.SetInvoiceDate("Mar 18, 2024")
+			ig.SetCompanyNo("12345678")
+			ig.SetFromName("Example Ltd")
+			ig.SetFromAddress(address.Address{
+				Lines:      []string{"1 Market Street"},
+				City:       "Anytown",
+				Region:     "NY",
+				PostalCode: "12345",
+				Country:    "US",
+			})
+			ig.SetFromContact("+1 555 0100")
+			ig.SetToName("John Doe")
+			ig.SetToAddress(address.Address{
+				Lines:      []string{"123 Main St", "Apt 4B"},
+				City:       "Anycity",
+				Region:     "CA",
+				PostalCode: "90210",
+				Country:    "US",
+			})
+			ig.SetToContact("+1 555 0199")
+		},
+		data: SubscriptionInfo{
+			ProductDescription: "Product 2",
+			Quantity:           2,
+			UnitPrice:          money.MustParse("10.50", "USD"),
+			Price:              money.MustParse("21.00", "USD"),
+			UsageLines: metering.Lines{
+				{Metric: "api_calls", Description: "API calls per 1,000", Quantity: 12500, Amount: money.MustParse("5.00", "USD")},
+				{Metric: "storage_gb", Description: "Storage (GB)", Quantity: 150, Amount: money.MustParse("32.50", "USD")},
+			},
+			SubTotal: money.MustParse("58.50", "USD"),
+			Tax:      8.25,
+			Taxes: tax.Breakdown{
+				{Name: "State sales tax", Percent: 6.25, Taxable: money.MustParse("58.50", "USD"), Amount: money.MustParse("3.66", "USD")},
+				{Name: "Local sales tax", Percent: 2, Taxable: money.MustParse("58.50", "USD"), Amount: money.MustParse("1.17", "USD")},
+			},
+			TaxAmount:      money.MustParse("4.83", "USD"),
+			GrandTotal:     money.MustParse("63.33", "USD"),
+			Currency:       "USD",
+			CurrencySymbol: "$",
+		},
+	},
+}
+
+// render generates the fixture invoice.
+func render(t *testing.T, f fixture) []byte {
+	t.Helper()
+
+	ig := NewInvoiceGenerator()
+	f.setup(ig)
+	ig.SetCreationDate(creationDate)
+
+	var b bytes.Buffer
+	if err := ig.GenerateInvoice(f.data, &b, filepath.Join("testdata", "logo.png"), "png"); err != nil {
+		t.Fatalf("error generating invoice: %v", err)
+	}
+
+	return b.Bytes()
+}
+
+// TestGenerateInvoiceGolden compares the layout of the fixture invoices with the
+// golden files in testdata, run with -update to refresh them.
+func TestGenerateInvoiceGolden(t *testing.T) {
+	for _, f := range fixtures {
+		f := f
+		t.Run(f.name, func(t *testing.T) {
+			doc := render(t, f)
+			if !bytes.Equal(doc, render(t, f)) {
+				t.Fatal("rendering the same invoice twice gave different documents")
+			}
+
+			got, err := extractLayout(doc)
+			if err != nil {
+				t.Fatalf("error extracting layout: %v", err)
+			}
+
+			golden := filepath.Join("testdata", f.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatalf("error writing golden file: %v", err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("error reading golden file: %v", err)
+			}
+
+			if got != string(want) {
+				t.Errorf("layout differs from %s, run go test -update after checking the change\n%s", golden, diff(string(want), got))
+			}
+		})
+	}
+}
+
+// diff returns the first lines which differ between want and got.
+func diff(want, got string) string {
+	wl := bytes.Split([]byte(want), []byte("\n"))
+	gl := bytes.Split([]byte(got), []byte("\n"))
+
+	var (
+		out   bytes.Buffer
+		shown int
+	)
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g []byte
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if bytes.Equal(w, g) {
+			continue
+		}
+		fmt.Fprintf(&out, "line %d:\n- %s\n+ %s\n", i+1, w, g)
+		if shown++; shown == 10 {
+			out.WriteString("...\n")
+			break
+		}
+	}
+
+	return out.String()
+}
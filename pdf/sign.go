@@ -0,0 +1,427 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// signatureSize is the number of bytes reserved for the DER encoded signature
+const signatureSize = 8192
+
+// byteRangePlaceholder is wide enough to hold the final byte range
+const byteRangePlaceholder = "/ByteRange [0 0000000000 0000000000 0000000000]"
+
+// ErrNotSigned is returned by VerifySignature when the document has no signature.
+var ErrNotSigned = errors.New("pdf is not signed")
+
+var (
+	startXrefRe = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+	xrefRe      = regexp.MustCompile(`^xref\s+0\s+(\d+)\s+`)
+	trailerRe   = regexp.MustCompile(`(?s)trailer\s*<<(.*?)>>\s*startxref`)
+	rootRe      = regexp.MustCompile(`/Root\s+(\d+)\s+0\s+R`)
+	infoRe      = regexp.MustCompile(`/Info\s+(\d+)\s+0\s+R`)
+	pagesRe     = regexp.MustCompile(`/Pages\s+(\d+)\s+0\s+R`)
+	kidsRe      = regexp.MustCompile(`/Kids\s*\[\s*(\d+)\s+0\s+R`)
+	byteRangeRe = regexp.MustCompile(`/ByteRange\s*\[\s*(\d+)\s+(\d+)\s+(\d+)\s+(\d+)\s*\]`)
+)
+
+// Signer holds the certificate and key used to sign generated invoices.
+type Signer struct {
+	key   crypto.Signer
+	cert  *x509.Certificate
+	chain []*x509.Certificate
+
+	Name     string
+	Reason   string
+	Location string
+}
+
+// NewSigner creates a signer from a certificate, its private key and the optional
+// chain of intermediate certificates.
+func NewSigner(cert *x509.Certificate, key crypto.PrivateKey, chain []*x509.Certificate) (*Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return &Signer{
+		key:    signer,
+		cert:   cert,
+		chain:  chain,
+		Name:   cert.Subject.CommonName,
+		Reason: "Invoice issued by " + cert.Subject.CommonName,
+	}, nil
+}
+
+// LoadSignerPEM loads the signer from PEM encoded files. The certificate file may
+// contain the chain of intermediate certificates after the signing certificate.
+func LoadSignerPEM(certFile, keyFile string) (*Signer, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate file: %v", err)
+	}
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found in certificate file")
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM block found in key file")
+	}
+
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(certs[0], key, certs[1:])
+}
+
+// LoadSignerPKCS12 loads the signer from a PKCS#12 (.p12/.pfx) file.
+func LoadSignerPKCS12(file, password string) (*Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PKCS#12 file: %v", err)
+	}
+
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding PKCS#12 file: %v", err)
+	}
+
+	return NewSigner(cert, key, chain)
+}
+
+// parsePrivateKey parses a PKCS#8, PKCS#1 or SEC 1 DER encoded private key.
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+// Sign appends an incremental update to the PDF document containing an invisible
+// signature field with a detached PKCS#7 signature covering the whole document.
+// The document must have a classic cross-reference table as written by fpdf.
+func (s *Signer) Sign(doc []byte) ([]byte, error) {
+	m := startXrefRe.FindSubmatch(doc)
+	if m == nil {
+		return nil, errors.New("startxref not found")
+	}
+	prevXref, _ := strconv.Atoi(string(m[1]))
+
+	offsets, err := readXref(doc, prevXref)
+	if err != nil {
+		return nil, err
+	}
+
+	t := trailerRe.FindSubmatch(doc[prevXref:])
+	if t == nil {
+		return nil, errors.New("trailer not found")
+	}
+	trailer := t[1]
+
+	r := rootRe.FindSubmatch(trailer)
+	if r == nil {
+		return nil, errors.New("document catalog not found")
+	}
+	rootID, _ := strconv.Atoi(string(r[1]))
+
+	catalog, err := readObject(doc, offsets, rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	pm := pagesRe.FindSubmatch(catalog)
+	if pm == nil {
+		return nil, errors.New("page tree not found")
+	}
+	pagesID, _ := strconv.Atoi(string(pm[1]))
+
+	pages, err := readObject(doc, offsets, pagesID)
+	if err != nil {
+		return nil, err
+	}
+
+	km := kidsRe.FindSubmatch(pages)
+	if km == nil {
+		return nil, errors.New("first page not found")
+	}
+	pageID, _ := strconv.Atoi(string(km[1]))
+
+	page, err := readObject(doc, offsets, pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	sigID := len(offsets)
+	fieldID := sigID + 1
+
+	var buf bytes.Buffer
+	buf.Write(doc)
+	if !bytes.HasSuffix(doc, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+
+	newOffsets := map[int]int{}
+
+	// signature dictionary, the byte range and contents are filled in below
+	newOffsets[sigID] = buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n<<\n/Type /Sig\n/Filter /Adobe.PPKLite\n/SubFilter /adbe.pkcs7.detached\n", sigID)
+	byteRangeOffset := buf.Len()
+	buf.WriteString(byteRangePlaceholder + "\n")
+	buf.WriteString("/Contents ")
+	contentsStart := buf.Len()
+	buf.WriteString("<" + strings.Repeat("0", signatureSize*2) + ">")
+	contentsEnd := buf.Len()
+	fmt.Fprintf(&buf, "\n/M %s\n", pdfString(pdfDate(time.Now())))
+	if s.Name != "" {
+		fmt.Fprintf(&buf, "/Name %s\n", pdfString(s.Name))
+	}
+	if s.Reason != "" {
+		fmt.Fprintf(&buf, "/Reason %s\n", pdfString(s.Reason))
+	}
+	if s.Location != "" {
+		fmt.Fprintf(&buf, "/Location %s\n", pdfString(s.Location))
+	}
+	buf.WriteString(">>\nendobj\n")
+
+	// invisible signature field and widget annotation
+	newOffsets[fieldID] = buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n<<\n/Type /Annot\n/Subtype /Widget\n/FT /Sig\n/Rect [0 0 0 0]\n/F 132\n/T (Signature1)\n/V %d 0 R\n/P %d 0 R\n>>\nendobj\n", fieldID, sigID, pageID)
+
+	// page with the widget annotation
+	newOffsets[pageID] = buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", pageID, addAnnotation(page, fieldID))
+
+	// catalog with the signature form
+	newOffsets[rootID] = buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n%s\n/AcroForm << /Fields [%d 0 R] /SigFlags 3 >>\n>>\nendobj\n",
+		rootID, bytes.TrimSuffix(bytes.TrimSpace(catalog), []byte(">>")), fieldID)
+
+	xrefOffset := buf.Len()
+	writeXref(&buf, newOffsets)
+
+	buf.WriteString("trailer\n<<\n")
+	fmt.Fprintf(&buf, "/Size %d\n/Root %d 0 R\n", fieldID+1, rootID)
+	if im := infoRe.FindSubmatch(trailer); im != nil {
+		fmt.Fprintf(&buf, "/Info %s 0 R\n", im[1])
+	}
+	fmt.Fprintf(&buf, "/Prev %d\n>>\nstartxref\n%d\n%%%%EOF\n", prevXref, xrefOffset)
+
+	out := buf.Bytes()
+
+	byteRange := fmt.Sprintf("/ByteRange [0 %d %d %d]", contentsStart, contentsEnd, len(out)-contentsEnd)
+	if len(byteRange) > len(byteRangePlaceholder) {
+		return nil, errors.New("document too large to sign")
+	}
+	copy(out[byteRangeOffset:], byteRange+strings.Repeat(" ", len(byteRangePlaceholder)-len(byteRange)))
+
+	signed := make([]byte, 0, len(out)-(contentsEnd-contentsStart))
+	signed = append(signed, out[:contentsStart]...)
+	signed = append(signed, out[contentsEnd:]...)
+
+	signature, err := s.sign(signed)
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) > signatureSize {
+		return nil, fmt.Errorf("signature of %d bytes exceeds the reserved %d bytes", len(signature), signatureSize)
+	}
+	copy(out[contentsStart+1:], strings.ToUpper(hex.EncodeToString(signature)))
+
+	return out, nil
+}
+
+// sign creates the detached PKCS#7 signature of the data.
+func (s *Signer) sign(data []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(data)
+	if err != nil {
+		return nil, fmt.Errorf("error creating signed data: %v", err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	if err := sd.AddSignerChain(s.cert, s.key, s.chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("error adding signer: %v", err)
+	}
+	sd.Detach()
+
+	return sd.Finish()
+}
+
+// VerifySignature checks that the last signature of the PDF document covers the
+// whole document and matches its content. It returns the signing certificate, or
+// ErrNotSigned when the document has no signature.
+func VerifySignature(doc []byte) (*x509.Certificate, error) {
+	all := byteRangeRe.FindAllSubmatch(doc, -1)
+	if len(all) == 0 {
+		return nil, ErrNotSigned
+	}
+	m := all[len(all)-1]
+
+	var br [4]int
+	for i := range br {
+		br[i], _ = strconv.Atoi(string(m[i+1]))
+	}
+
+	if br[0] != 0 || br[1] >= br[2] || br[2]+br[3] != len(doc) {
+		return nil, errors.New("signature does not cover the whole document")
+	}
+	if doc[br[1]] != '<' || doc[br[2]-1] != '>' {
+		return nil, errors.New("invalid signature contents")
+	}
+
+	raw, err := hex.DecodeString(string(doc[br[1]+1 : br[2]-1]))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding signature contents: %v", err)
+	}
+
+	// the contents are zero padded, keep only the DER encoded signature
+	var v asn1.RawValue
+	rest, err := asn1.Unmarshal(raw, &v)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signature contents: %v", err)
+	}
+	raw = raw[:len(raw)-len(rest)]
+
+	p7, err := pkcs7.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signature: %v", err)
+	}
+
+	p7.Content = make([]byte, 0, br[1]+br[3])
+	p7.Content = append(p7.Content, doc[:br[1]]...)
+	p7.Content = append(p7.Content, doc[br[2]:]...)
+
+	if err := p7.Verify(); err != nil {
+		return nil, fmt.Errorf("signature does not match the document: %v", err)
+	}
+
+	return p7.GetOnlySigner(), nil
+}
+
+// readXref reads the object offsets of the cross-reference table at offset.
+func readXref(doc []byte, offset int) ([]int, error) {
+	if offset <= 0 || offset >= len(doc) {
+		return nil, errors.New("invalid startxref offset")
+	}
+
+	m := xrefRe.FindSubmatchIndex(doc[offset:])
+	if m == nil {
+		return nil, errors.New("unsupported cross-reference table")
+	}
+	size, _ := strconv.Atoi(string(doc[offset+m[2] : offset+m[3]]))
+	entries := doc[offset+m[1]:]
+
+	offsets := make([]int, size)
+	for i := 0; i < size; i++ {
+		if len(entries) < (i+1)*20 {
+			return nil, errors.New("truncated cross-reference table")
+		}
+		entry := entries[i*20 : (i+1)*20]
+		offsets[i], _ = strconv.Atoi(string(entry[:10]))
+	}
+
+	return offsets, nil
+}
+
+// readObject returns the body of the object id, without the obj and endobj keywords.
+func readObject(doc []byte, offsets []int, id int) ([]byte, error) {
+	if id <= 0 || id >= len(offsets) {
+		return nil, fmt.Errorf("object %d not found", id)
+	}
+
+	obj := doc[offsets[id]:]
+	header := fmt.Sprintf("%d 0 obj", id)
+	if !bytes.HasPrefix(obj, []byte(header)) {
+		return nil, fmt.Errorf("object %d not found at offset %d", id, offsets[id])
+	}
+
+	end := bytes.Index(obj, []byte("endobj"))
+	if end < 0 {
+		return nil, fmt.Errorf("object %d is not terminated", id)
+	}
+
+	return bytes.TrimSpace(obj[len(header):end]), nil
+}
+
+// addAnnotation adds the annotation reference to the page dictionary.
+func addAnnotation(page []byte, annotID int) []byte {
+	ref := fmt.Sprintf("%d 0 R", annotID)
+	if i := bytes.Index(page, []byte("/Annots [")); i >= 0 {
+		i += len("/Annots [")
+		return []byte(string(page[:i]) + ref + " " + string(page[i:]))
+	}
+	return []byte(string(bytes.TrimSuffix(page, []byte(">>"))) + "\n/Annots [" + ref + "]>>")
+}
+
+// writeXref writes a cross-reference section for the updated objects.
+func writeXref(buf *bytes.Buffer, offsets map[int]int) {
+	ids := make([]int, 0, len(offsets))
+	for id := range offsets {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	buf.WriteString("xref\n")
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		fmt.Fprintf(buf, "%d %d\n", ids[i], j-i+1)
+		for _, id := range ids[i : j+1] {
+			fmt.Fprintf(buf, "%010d 00000 n \n", offsets[id])
+		}
+		i = j + 1
+	}
+}
+
+// pdfDate formats the time as a PDF date string.
+func pdfDate(t time.Time) string {
+	return t.UTC().Format("D:20060102150405Z")
+}
+
+// pdfString escapes the value as a PDF literal string.
+func pdfString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+	return "(" + r.Replace(s) + ")"
+}
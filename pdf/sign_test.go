@@ -0,0 +1,140 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestSigner writes a self-signed certificate and its key as PEM files and
+// loads the signer from them
+func newTestSigner(t *testing.T) (*Signer, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Example Ltd", Organization: []string{"Example Ltd"}},
+		NotBefore:    creationDate.Add(-time.Hour),
+		NotAfter:     creationDate.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadSignerPEM(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadSignerPEM() error = %v", err)
+	}
+	return s, cert
+}
+
+// signedInvoice returns the first fixture invoice signed by the signer
+func signedInvoice(t *testing.T, s *Signer) []byte {
+	t.Helper()
+
+	doc := render(t, fixtures[0])
+	signed, err := s.Sign(doc)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if !bytes.HasPrefix(signed, doc) {
+		t.Fatal("Sign() changed the document instead of appending an update")
+	}
+	return signed
+}
+
+func TestSignVerify(t *testing.T) {
+	s, cert := newTestSigner(t)
+	if s.Name != "Example Ltd" {
+		t.Errorf("signer name = %q, want the common name of the certificate", s.Name)
+	}
+
+	if _, err := VerifySignature(render(t, fixtures[0])); !errors.Is(err, ErrNotSigned) {
+		t.Errorf("VerifySignature() of an unsigned invoice error = %v, want ErrNotSigned", err)
+	}
+
+	got, err := VerifySignature(signedInvoice(t, s))
+	if err != nil {
+		t.Fatalf("VerifySignature() error = %v", err)
+	}
+	if !got.Equal(cert) {
+		t.Errorf("VerifySignature() = %s, want the signing certificate", got.Subject)
+	}
+}
+
+func TestVerifySignatureTampered(t *testing.T) {
+	s, _ := newTestSigner(t)
+	signed := signedInvoice(t, s)
+
+	m := byteRangeRe.FindSubmatch(signed)
+	if m == nil {
+		t.Fatal("signed invoice has no byte range")
+	}
+	// the signature contents are between the two signed ranges
+	contentsStart, _ := strconv.Atoi(string(m[2]))
+	contentsEnd, _ := strconv.Atoi(string(m[3]))
+
+	tests := []struct {
+		name   string
+		tamper func(doc []byte) []byte
+	}{
+		{"byte of the invoice", func(doc []byte) []byte {
+			doc[contentsStart/2] ^= 0x01
+			return doc
+		}},
+		{"byte of the update before the signature", func(doc []byte) []byte {
+			doc[contentsStart-2] ^= 0x01
+			return doc
+		}},
+		{"byte of the update after the signature", func(doc []byte) []byte {
+			doc[contentsEnd+(len(doc)-contentsEnd)/2] ^= 0x01
+			return doc
+		}},
+		{"bytes appended", func(doc []byte) []byte {
+			return append(doc, "\n% appended\n"...)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := tt.tamper(bytes.Clone(signed))
+			if _, err := VerifySignature(doc); err == nil || errors.Is(err, ErrNotSigned) {
+				t.Errorf("VerifySignature() error = %v, want a signature error", err)
+			}
+		})
+	}
+}
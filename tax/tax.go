@@ -0,0 +1,247 @@
+package tax
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// Rate is a named tax charged at a percentage of the taxable amount
+type Rate struct {
+	Name    string  `json:"name"`
+	Percent float64 `json:"percent"`
+	// Compound taxes are charged on the net amount plus the taxes before them
+	Compound bool `json:"compound,omitempty"`
+}
+
+// Amount is the tax charged for a rate
+type Amount struct {
+	Name     string       `json:"name"`
+	Percent  float64      `json:"percent"`
+	Compound bool         `json:"compound,omitempty"`
+	Taxable  money.Amount `json:"taxable"`
+	Amount   money.Amount `json:"amount"`
+}
+
+// Breakdown lists the taxes charged, it is stored as JSON in the database
+type Breakdown []Amount
+
+// Value implements the driver.Valuer interface.
+func (b Breakdown) Value() (driver.Value, error) {
+	if b == nil {
+		return "[]", nil
+	}
+
+	v, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(v), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (b *Breakdown) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*b = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, b)
+	case string:
+		return json.Unmarshal([]byte(v), b)
+	default:
+		return fmt.Errorf("unsupported type for Breakdown: %T", value)
+	}
+}
+
+// Line is a priced invoice line with the taxes charged on it
+type Line struct {
+	Price money.Amount
+	// Discount is taken off the price before the taxes are charged, it includes
+	// the taxes when the price does
+	Discount money.Amount
+	Rates    []Rate
+	// Inclusive prices include the taxes
+	Inclusive bool
+}
+
+// Result holds the net amount, the taxes and the total of one or more lines
+type Result struct {
+	Net   money.Amount
+	Taxes Breakdown
+	Tax   money.Amount
+	Total money.Amount
+}
+
+// Validate checks the rates can be charged together on a line.
+func Validate(rates []Rate) error {
+	seen := make(map[string]bool, len(rates))
+	for _, r := range rates {
+		if r.Name == "" {
+			return errors.New("empty tax name")
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("duplicate tax: %s", r.Name)
+		}
+		seen[r.Name] = true
+
+		if r.Percent < 0 || r.Percent > 100 || math.IsNaN(r.Percent) {
+			return fmt.Errorf("invalid percent of tax %s: %v", r.Name, r.Percent)
+		}
+	}
+
+	return nil
+}
+
+// EffectiveRate returns the percentage of the net amount charged by the rates
+// together, GST 5% plus a 9.975% tax compounded on it is 15.47375%.
+func EffectiveRate(rates []Rate) float64 {
+	f, _ := new(big.Rat).Mul(effectiveRate(rates), big.NewRat(100, 1)).Float64()
+	return f
+}
+
+// effectiveRate returns the fraction of the net amount charged by the rates.
+func effectiveRate(rates []Rate) *big.Rat {
+	total := new(big.Rat)
+	for _, r := range rates {
+		base := big.NewRat(1, 1)
+		if r.Compound {
+			base.Add(base, total)
+		}
+		total.Add(total, base.Mul(base, fraction(r.Percent)))
+	}
+
+	return total
+}
+
+// fraction returns the percent as an exact fraction, the percent is read as the
+// shortest decimal that prints it so 9.975 is 9975/1000 and not its binary value.
+func fraction(percent float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(percent, 'f', -1, 64))
+	return r.Quo(r, big.NewRat(100, 1))
+}
+
+// Calculate calculates the taxes of the line. Every tax is rounded half up to
+// the minor units of the currency, for inclusive prices the rounding difference
+// is added to the last tax so the total equals the discounted price.
+func Calculate(line Line) (Result, error) {
+	price, err := line.Price.Sub(line.Discount)
+	if err != nil {
+		return Result{}, err
+	}
+	if line.Discount.Sign() < 0 || price.Sign() < 0 {
+		return Result{}, fmt.Errorf("invalid discount %s of price %s", line.Discount, line.Price)
+	}
+
+	net := price
+	if line.Inclusive {
+		if net, err = Net(price, line.Rates); err != nil {
+			return Result{}, err
+		}
+	}
+
+	// a zero tax in the currency of the line
+	zero, err := net.Mul(0)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Net: net, Tax: zero}
+	for _, r := range line.Rates {
+		taxable := net
+		if r.Compound {
+			if taxable, err = net.Add(res.Tax); err != nil {
+				return Result{}, err
+			}
+		}
+
+		amount, err := taxable.MulRat(fraction(r.Percent), money.HalfUp)
+		if err != nil {
+			return Result{}, err
+		}
+		res.Taxes = append(res.Taxes, Amount{Name: r.Name, Percent: r.Percent, Compound: r.Compound, Taxable: taxable, Amount: amount})
+		if res.Tax, err = res.Tax.Add(amount); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if line.Inclusive && len(res.Taxes) > 0 {
+		diff, err := price.Sub(net)
+		if err == nil {
+			diff, err = diff.Sub(res.Tax)
+		}
+		if err != nil {
+			return Result{}, err
+		}
+
+		last := &res.Taxes[len(res.Taxes)-1]
+		if last.Amount, err = last.Amount.Add(diff); err != nil {
+			return Result{}, err
+		}
+		if res.Tax, err = res.Tax.Add(diff); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if res.Total, err = net.Add(res.Tax); err != nil {
+		return Result{}, err
+	}
+
+	return res, nil
+}
+
+// Net returns the amount without the taxes of the rates it includes, rounded
+// half up to the minor units of the currency.
+func Net(amount money.Amount, rates []Rate) (money.Amount, error) {
+	factor := new(big.Rat).Add(big.NewRat(1, 1), effectiveRate(rates))
+	return amount.MulRat(factor.Inv(factor), money.HalfUp)
+}
+
+// Sum calculates the lines and sums them, the taxes of equal name and percent
+// are merged into a single breakdown entry in the order they first appear.
+// The lines must have the same currency.
+func Sum(lines ...Line) (Result, error) {
+	var res Result
+	index := make(map[Rate]int)
+	for _, line := range lines {
+		r, err := Calculate(line)
+		if err != nil {
+			return Result{}, err
+		}
+
+		if res.Net, err = res.Net.Add(r.Net); err != nil {
+			return Result{}, err
+		}
+		if res.Tax, err = res.Tax.Add(r.Tax); err != nil {
+			return Result{}, err
+		}
+		if res.Total, err = res.Total.Add(r.Total); err != nil {
+			return Result{}, err
+		}
+
+		for _, a := range r.Taxes {
+			key := Rate{Name: a.Name, Percent: a.Percent, Compound: a.Compound}
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(res.Taxes)
+				res.Taxes = append(res.Taxes, a)
+				continue
+			}
+			if res.Taxes[i].Taxable, err = res.Taxes[i].Taxable.Add(a.Taxable); err != nil {
+				return Result{}, err
+			}
+			if res.Taxes[i].Amount, err = res.Taxes[i].Amount.Add(a.Amount); err != nil {
+				return Result{}, err
+			}
+		}
+	}
+
+	return res, nil
+}
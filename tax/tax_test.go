@@ -0,0 +1,306 @@
+package tax
+
+import (
+	"math"
+	"testing"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// gstQST are the Québec rates, the QST is charged on the price plus the GST
+var gstQST = []Rate{
+	{Name: "GST", Percent: 5},
+	{Name: "QST", Percent: 9.975, Compound: true},
+}
+
+func TestEffectiveRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rates []Rate
+		want  float64
+	}{
+		{"none", nil, 0},
+		{"single", []Rate{{Name: "VAT", Percent: 19}}, 19},
+		{"decimal", []Rate{{Name: "Sales tax", Percent: 8.875}}, 8.875},
+		{"simple rates add up", []Rate{{Name: "GST", Percent: 5}, {Name: "PST", Percent: 7}}, 12},
+		{"compound", gstQST, 15.47375},
+		{"compound on every rate before it", []Rate{
+			{Name: "A", Percent: 5},
+			{Name: "B", Percent: 5},
+			{Name: "C", Percent: 10, Compound: true},
+		}, 21},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EffectiveRate(tt.rates); got != tt.want {
+				t.Errorf("EffectiveRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rates   []Rate
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"compound", gstQST, false},
+		{"zero and full percent", []Rate{{Name: "A", Percent: 0}, {Name: "B", Percent: 100}}, false},
+		{"empty name", []Rate{{Percent: 5}}, true},
+		{"duplicate", []Rate{{Name: "VAT", Percent: 5}, {Name: "VAT", Percent: 7}}, true},
+		{"negative", []Rate{{Name: "VAT", Percent: -1}}, true},
+		{"above 100", []Rate{{Name: "VAT", Percent: 100.5}}, true},
+		{"NaN", []Rate{{Name: "VAT", Percent: math.NaN()}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.rates); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      Line
+		wantNet   string
+		wantTaxes []string
+		wantTax   string
+		wantTotal string
+	}{
+		{
+			name:      "exclusive",
+			line:      Line{Price: money.MustParse("100.00", "USD"), Rates: []Rate{{Name: "Sales tax", Percent: 8.875}}},
+			wantNet:   "100.00",
+			wantTaxes: []string{"8.88"},
+			wantTax:   "8.88",
+			wantTotal: "108.88",
+		},
+		{
+			name:      "no rates",
+			line:      Line{Price: money.MustParse("100.00", "USD")},
+			wantNet:   "100.00",
+			wantTax:   "0.00",
+			wantTotal: "100.00",
+		},
+		{
+			name:      "compound",
+			line:      Line{Price: money.MustParse("100.00", "CAD"), Rates: gstQST},
+			wantNet:   "100.00",
+			wantTaxes: []string{"5.00", "10.47"},
+			wantTax:   "15.47",
+			wantTotal: "115.47",
+		},
+		{
+			name:      "inclusive",
+			line:      Line{Price: money.MustParse("119.00", "EUR"), Rates: []Rate{{Name: "VAT", Percent: 19}}, Inclusive: true},
+			wantNet:   "100.00",
+			wantTaxes: []string{"19.00"},
+			wantTax:   "19.00",
+			wantTotal: "119.00",
+		},
+		{
+			// 9.99 / 1.19 is 8.39 and 19% of it 1.59, the cent lost to rounding
+			// goes to the tax so the total is the price
+			name:      "inclusive rounding difference",
+			line:      Line{Price: money.MustParse("9.99", "EUR"), Rates: []Rate{{Name: "VAT", Percent: 19}}, Inclusive: true},
+			wantNet:   "8.39",
+			wantTaxes: []string{"1.60"},
+			wantTax:   "1.60",
+			wantTotal: "9.99",
+		},
+		{
+			name:      "inclusive compound",
+			line:      Line{Price: money.MustParse("115.47", "CAD"), Rates: gstQST, Inclusive: true},
+			wantNet:   "100.00",
+			wantTaxes: []string{"5.00", "10.47"},
+			wantTax:   "15.47",
+			wantTotal: "115.47",
+		},
+		{
+			// each 2.5% of 10.10 is 0.2525 and rounds to 0.25, 5% at once would be 0.51
+			name:      "rounded per rate",
+			line:      Line{Price: money.MustParse("10.10", "USD"), Rates: []Rate{{Name: "A", Percent: 2.5}, {Name: "B", Percent: 2.5}}},
+			wantNet:   "10.10",
+			wantTaxes: []string{"0.25", "0.25"},
+			wantTax:   "0.50",
+			wantTotal: "10.60",
+		},
+		{
+			name:      "rounded half up",
+			line:      Line{Price: money.MustParse("1005", "JPY"), Rates: []Rate{{Name: "Consumption tax", Percent: 10}}},
+			wantNet:   "1005",
+			wantTaxes: []string{"101"},
+			wantTax:   "101",
+			wantTotal: "1106",
+		},
+		{
+			name:      "discount before tax",
+			line:      Line{Price: money.MustParse("100.00", "EUR"), Discount: money.MustParse("10.00", "EUR"), Rates: []Rate{{Name: "VAT", Percent: 20}}},
+			wantNet:   "90.00",
+			wantTaxes: []string{"18.00"},
+			wantTax:   "18.00",
+			wantTotal: "108.00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Calculate(tt.line)
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+
+			if got := res.Net.Decimal(); got != tt.wantNet {
+				t.Errorf("net = %s, want %s", got, tt.wantNet)
+			}
+			if len(res.Taxes) != len(tt.wantTaxes) {
+				t.Fatalf("got %d taxes, want %d", len(res.Taxes), len(tt.wantTaxes))
+			}
+			for i, want := range tt.wantTaxes {
+				if got := res.Taxes[i].Amount.Decimal(); got != want {
+					t.Errorf("taxes[%d] = %s, want %s", i, got, want)
+				}
+			}
+			if got := res.Tax.Decimal(); got != tt.wantTax {
+				t.Errorf("tax = %s, want %s", got, tt.wantTax)
+			}
+			if got := res.Total.Decimal(); got != tt.wantTotal {
+				t.Errorf("total = %s, want %s", got, tt.wantTotal)
+			}
+			if res.Total.Currency() != tt.line.Price.Currency() {
+				t.Errorf("currency = %s, want %s", res.Total.Currency(), tt.line.Price.Currency())
+			}
+		})
+	}
+}
+
+func TestCalculateCompoundTaxable(t *testing.T) {
+	res, err := Calculate(Line{Price: money.MustParse("100.00", "CAD"), Rates: gstQST})
+	if err != nil {
+		t.Fatalf("Calculate() error = %v", err)
+	}
+
+	if got := res.Taxes[0].Taxable.Decimal(); got != "100.00" {
+		t.Errorf("taxable of GST = %s, want 100.00", got)
+	}
+	if got := res.Taxes[1].Taxable.Decimal(); got != "105.00" {
+		t.Errorf("taxable of QST = %s, want 105.00", got)
+	}
+}
+
+func TestCalculateInvalidDiscount(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount money.Amount
+	}{
+		{"above price", money.MustParse("100.01", "EUR")},
+		{"negative", money.MustParse("-1.00", "EUR")},
+		{"other currency", money.MustParse("1.00", "USD")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Calculate(Line{Price: money.MustParse("100.00", "EUR"), Discount: tt.discount})
+			if err == nil {
+				t.Error("Calculate() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestNet(t *testing.T) {
+	tests := []struct {
+		amount money.Amount
+		rates  []Rate
+		want   string
+	}{
+		{money.MustParse("119.00", "EUR"), []Rate{{Name: "VAT", Percent: 19}}, "100.00"},
+		{money.MustParse("9.99", "EUR"), []Rate{{Name: "VAT", Percent: 19}}, "8.39"},
+		{money.MustParse("115.47", "CAD"), gstQST, "100.00"},
+		{money.MustParse("50.00", "USD"), nil, "50.00"},
+	}
+
+	for _, tt := range tests {
+		got, err := Net(tt.amount, tt.rates)
+		if err != nil {
+			t.Fatalf("Net(%s) error = %v", tt.amount, err)
+		}
+		if got.Decimal() != tt.want || got.Currency() != tt.amount.Currency() {
+			t.Errorf("Net(%s) = %s, want %s %s", tt.amount, got, tt.amount.Currency(), tt.want)
+		}
+	}
+}
+
+func TestSum(t *testing.T) {
+	vat := []Rate{{Name: "VAT", Percent: 10}}
+	res, err := Sum(
+		Line{Price: money.MustParse("100.00", "EUR"), Rates: vat},
+		Line{Price: money.MustParse("50.00", "EUR"), Rates: []Rate{{Name: "Reduced VAT", Percent: 5}}},
+		Line{Price: money.MustParse("50.00", "EUR"), Rates: vat},
+	)
+	if err != nil {
+		t.Fatalf("Sum() error = %v", err)
+	}
+
+	if got := res.Net.String(); got != "EUR 200.00" {
+		t.Errorf("net = %s, want EUR 200.00", got)
+	}
+	if got := res.Tax.String(); got != "EUR 17.50" {
+		t.Errorf("tax = %s, want EUR 17.50", got)
+	}
+	if got := res.Total.String(); got != "EUR 217.50" {
+		t.Errorf("total = %s, want EUR 217.50", got)
+	}
+
+	// taxes of the same rate are merged in the order they first appear
+	want := []struct{ name, taxable, amount string }{
+		{"VAT", "150.00", "15.00"},
+		{"Reduced VAT", "50.00", "2.50"},
+	}
+	if len(res.Taxes) != len(want) {
+		t.Fatalf("got %d taxes, want %d", len(res.Taxes), len(want))
+	}
+	for i, w := range want {
+		a := res.Taxes[i]
+		if a.Name != w.name || a.Taxable.Decimal() != w.taxable || a.Amount.Decimal() != w.amount {
+			t.Errorf("taxes[%d] = %s %s %s, want %s %s %s", i, a.Name, a.Taxable.Decimal(), a.Amount.Decimal(), w.name, w.taxable, w.amount)
+		}
+	}
+}
+
+func TestSumCurrencyMismatch(t *testing.T) {
+	_, err := Sum(
+		Line{Price: money.MustParse("100.00", "EUR")},
+		Line{Price: money.MustParse("100.00", "USD")},
+	)
+	if err == nil {
+		t.Error("Sum() error = nil, want an error")
+	}
+}
+
+func TestBreakdownScan(t *testing.T) {
+	b := Breakdown{{Name: "VAT", Percent: 19, Taxable: money.MustParse("100.00", "EUR"), Amount: money.MustParse("19.00", "EUR")}}
+	v, err := b.Value()
+	if err != nil {
+		t.Fatalf("Value() error = %v", err)
+	}
+
+	var got Breakdown
+	if err := got.Scan(v); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "VAT" || !got[0].Amount.Equal(b[0].Amount) || !got[0].Taxable.Equal(b[0].Taxable) {
+		t.Errorf("Scan() = %+v, want %+v", got, b)
+	}
+
+	if v, _ := Breakdown(nil).Value(); v != "[]" {
+		t.Errorf("Value() of nil = %v, want []", v)
+	}
+}
@@ -0,0 +1,192 @@
+package tax
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// Mode decides what happens to totals which differ from the recalculated ones
+type Mode int
+
+const (
+	// Strict rejects totals which differ from the recalculated ones
+	Strict Mode = iota
+	// Correct replaces totals which differ with the recalculated ones
+	Correct
+)
+
+// ParseMode parses "strict" or "correct", an empty string is Strict.
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "", "strict":
+		return Strict, nil
+	case "correct":
+		return Correct, nil
+	}
+
+	return Strict, fmt.Errorf("unknown totals mode: %q", s)
+}
+
+// String returns the name of the mode.
+func (m Mode) String() string {
+	if m == Correct {
+		return "correct"
+	}
+	return "strict"
+}
+
+// Totals holds the amounts of an invoice with a priced line and the amounts of
+// its usage lines. Tax is the effective rate of the rates in percent, the
+// discount is taken off the price and the usage together.
+type Totals struct {
+	UnitPrice  money.Amount
+	Quantity   int
+	Usage      []money.Amount
+	Rates      []Rate
+	Inclusive  bool
+	Discount   money.Amount
+	Tax        float64
+	Price      money.Amount
+	SubTotal   money.Amount
+	Taxes      Breakdown
+	TaxAmount  money.Amount
+	GrandTotal money.Amount
+}
+
+// Recalculate returns the totals calculated from the unit price, quantity,
+// usage, discount and rates of t.
+func (t Totals) Recalculate() (Totals, error) {
+	price, err := t.UnitPrice.Mul(int64(t.Quantity))
+	if err != nil {
+		return Totals{}, err
+	}
+
+	charged, err := money.Sum(price.Currency(), append([]money.Amount{price}, t.Usage...)...)
+	if err != nil {
+		return Totals{}, err
+	}
+
+	res, err := Calculate(Line{Price: charged, Discount: t.Discount, Rates: t.Rates, Inclusive: t.Inclusive})
+	if err != nil {
+		return Totals{}, err
+	}
+
+	t.Tax = EffectiveRate(t.Rates)
+	t.Price = price
+	t.SubTotal = res.Net
+	t.Taxes = res.Taxes
+	t.TaxAmount = res.Tax
+	t.GrandTotal = res.Total
+	return t, nil
+}
+
+// ZeroRated returns the totals without tax, such as those of a reverse charged
+// invoice. Inclusive unit price, usage and discount are reduced to their net
+// amounts first, so the tax they include is not charged either.
+func (t Totals) ZeroRated() (Totals, error) {
+	if t.Inclusive {
+		var err error
+		if t.UnitPrice, err = Net(t.UnitPrice, t.Rates); err != nil {
+			return Totals{}, err
+		}
+		usage := make([]money.Amount, len(t.Usage))
+		for i, a := range t.Usage {
+			if usage[i], err = Net(a, t.Rates); err != nil {
+				return Totals{}, err
+			}
+		}
+		t.Usage = usage
+		if !t.Discount.IsZero() {
+			if t.Discount, err = Net(t.Discount, t.Rates); err != nil {
+				return Totals{}, err
+			}
+		}
+	}
+
+	t.Rates = nil
+	t.Inclusive = false
+	return t.Recalculate()
+}
+
+// Mismatch is a field whose value differs from the recalculated value, fields
+// are named as in the JSON contracts
+type Mismatch struct {
+	Field string `json:"field"`
+	Got   string `json:"got"`
+	Want  string `json:"want"`
+}
+
+// MismatchError lists every field which differs from the recalculated totals
+type MismatchError struct {
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// Error implements the error interface.
+func (e *MismatchError) Error() string {
+	msgs := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		msgs = append(msgs, fmt.Sprintf("%s is %s, want %s", m.Field, m.Got, m.Want))
+	}
+	return "inconsistent totals: " + strings.Join(msgs, "; ")
+}
+
+// Check recalculates the totals and compares them with t. It returns the
+// recalculated totals and a *MismatchError listing every field which differs,
+// the tax breakdown is only compared and returned when t has one.
+func Check(t Totals) (Totals, error) {
+	want, err := t.Recalculate()
+	if err != nil {
+		return Totals{}, err
+	}
+
+	var mismatches []Mismatch
+	amount := func(field string, got, want money.Amount) {
+		if !got.Equal(want) {
+			mismatches = append(mismatches, Mismatch{Field: field, Got: got.String(), Want: want.String()})
+		}
+	}
+
+	if math.Abs(t.Tax-want.Tax) > 1e-9 {
+		mismatches = append(mismatches, Mismatch{Field: "tax", Got: formatPercent(t.Tax), Want: formatPercent(want.Tax)})
+	}
+	amount("price", t.Price, want.Price)
+	amount("subTotal", t.SubTotal, want.SubTotal)
+
+	if len(t.Taxes) == 0 {
+		want.Taxes = nil
+	} else if len(t.Taxes) != len(want.Taxes) {
+		mismatches = append(mismatches, Mismatch{Field: "taxes", Got: fmt.Sprintf("%d taxes", len(t.Taxes)), Want: fmt.Sprintf("%d taxes", len(want.Taxes))})
+	} else {
+		for i := range t.Taxes {
+			amount(fmt.Sprintf("taxes[%d].taxable", i), t.Taxes[i].Taxable, want.Taxes[i].Taxable)
+			amount(fmt.Sprintf("taxes[%d].amount", i), t.Taxes[i].Amount, want.Taxes[i].Amount)
+		}
+	}
+
+	amount("taxAmount", t.TaxAmount, want.TaxAmount)
+	amount("grandTotal", t.GrandTotal, want.GrandTotal)
+
+	if len(mismatches) > 0 {
+		return want, &MismatchError{Mismatches: mismatches}
+	}
+	return want, nil
+}
+
+// Rates returns the rates the taxes of the breakdown are charged at.
+func (b Breakdown) Rates() []Rate {
+	rates := make([]Rate, 0, len(b))
+	for _, a := range b {
+		rates = append(rates, Rate{Name: a.Name, Percent: a.Percent, Compound: a.Compound})
+	}
+
+	return rates
+}
+
+// formatPercent formats the percent with as few decimals as needed.
+func formatPercent(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64) + "%"
+}
@@ -0,0 +1,256 @@
+package tax
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Mode
+		wantErr bool
+	}{
+		{"", Strict, false},
+		{"strict", Strict, false},
+		{"CORRECT", Correct, false},
+		{"lenient", Strict, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMode(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMode(%q) = %v, %v, want %v, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// salesTaxTotals are the correct totals of two units of 50.00 USD with 10.00 USD
+// of usage at 8.875% sales tax
+func salesTaxTotals() Totals {
+	return Totals{
+		UnitPrice:  money.MustParse("50.00", "USD"),
+		Quantity:   2,
+		Usage:      []money.Amount{money.MustParse("10.00", "USD")},
+		Rates:      []Rate{{Name: "Sales tax", Percent: 8.875}},
+		Discount:   money.MustParse("0.00", "USD"),
+		Tax:        8.875,
+		Price:      money.MustParse("100.00", "USD"),
+		SubTotal:   money.MustParse("110.00", "USD"),
+		TaxAmount:  money.MustParse("9.76", "USD"),
+		GrandTotal: money.MustParse("119.76", "USD"),
+	}
+}
+
+func TestRecalculate(t *testing.T) {
+	want := salesTaxTotals()
+	in := want
+	in.Tax, in.Price, in.SubTotal, in.TaxAmount, in.GrandTotal = 0, money.Amount{}, money.Amount{}, money.Amount{}, money.Amount{}
+
+	got, err := in.Recalculate()
+	if err != nil {
+		t.Fatalf("Recalculate() error = %v", err)
+	}
+
+	if got.Tax != want.Tax {
+		t.Errorf("tax = %v, want %v", got.Tax, want.Tax)
+	}
+	for _, c := range []struct {
+		field     string
+		got, want money.Amount
+	}{
+		{"price", got.Price, want.Price},
+		{"subTotal", got.SubTotal, want.SubTotal},
+		{"taxAmount", got.TaxAmount, want.TaxAmount},
+		{"grandTotal", got.GrandTotal, want.GrandTotal},
+	} {
+		if !c.got.Equal(c.want) {
+			t.Errorf("%s = %s, want %s", c.field, c.got, c.want)
+		}
+	}
+	if len(got.Taxes) != 1 || got.Taxes[0].Taxable.Decimal() != "110.00" {
+		t.Errorf("taxes = %+v, want one tax on 110.00", got.Taxes)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(t *Totals)
+		wantFields []string
+	}{
+		{
+			name:   "consistent",
+			change: func(t *Totals) {},
+		},
+		{
+			name: "consistent with breakdown",
+			change: func(t *Totals) {
+				t.Taxes = Breakdown{{Name: "Sales tax", Percent: 8.875, Taxable: money.MustParse("110.00", "USD"), Amount: money.MustParse("9.76", "USD")}}
+			},
+		},
+		{
+			// 8.875% of 110.00 is 9.7625, rounded up rather than half up
+			name: "tax amount and grand total",
+			change: func(t *Totals) {
+				t.TaxAmount = money.MustParse("9.77", "USD")
+				t.GrandTotal = money.MustParse("119.77", "USD")
+			},
+			wantFields: []string{"taxAmount", "grandTotal"},
+		},
+		{
+			name:       "rate",
+			change:     func(t *Totals) { t.Tax = 9 },
+			wantFields: []string{"tax"},
+		},
+		{
+			name:       "price",
+			change:     func(t *Totals) { t.Price = money.MustParse("110.00", "USD") },
+			wantFields: []string{"price"},
+		},
+		{
+			name: "breakdown amount",
+			change: func(t *Totals) {
+				t.Taxes = Breakdown{{Name: "Sales tax", Percent: 8.875, Taxable: money.MustParse("110.00", "USD"), Amount: money.MustParse("9.70", "USD")}}
+			},
+			wantFields: []string{"taxes[0].amount"},
+		},
+		{
+			name: "breakdown length",
+			change: func(t *Totals) {
+				t.Taxes = Breakdown{{}, {}}
+			},
+			wantFields: []string{"taxes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := salesTaxTotals()
+			tt.change(&in)
+
+			got, err := Check(in)
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("Check() error = %v", err)
+				}
+			} else {
+				var mErr *MismatchError
+				if !errors.As(err, &mErr) {
+					t.Fatalf("Check() error = %v, want a *MismatchError", err)
+				}
+				if len(mErr.Mismatches) != len(tt.wantFields) {
+					t.Fatalf("mismatches = %+v, want fields %v", mErr.Mismatches, tt.wantFields)
+				}
+				for i, f := range tt.wantFields {
+					if mErr.Mismatches[i].Field != f {
+						t.Errorf("mismatches[%d] = %s, want %s", i, mErr.Mismatches[i].Field, f)
+					}
+				}
+			}
+
+			// the recalculated totals are returned either way, the correct mode
+			// invoices them in place of the totals which differ
+			want := salesTaxTotals()
+			if !got.GrandTotal.Equal(want.GrandTotal) || !got.TaxAmount.Equal(want.TaxAmount) || got.Tax != want.Tax {
+				t.Errorf("Check() = %s %s %v, want %s %s %v", got.TaxAmount, got.GrandTotal, got.Tax, want.TaxAmount, want.GrandTotal, want.Tax)
+			}
+			if len(in.Taxes) == 0 && got.Taxes != nil {
+				t.Errorf("Check() returned taxes %+v for totals without a breakdown", got.Taxes)
+			}
+		})
+	}
+}
+
+func TestMismatchError(t *testing.T) {
+	_, err := Check(Totals{
+		UnitPrice:  money.MustParse("10.00", "EUR"),
+		Quantity:   1,
+		Rates:      []Rate{{Name: "VAT", Percent: 19}},
+		Tax:        19,
+		Price:      money.MustParse("10.00", "EUR"),
+		SubTotal:   money.MustParse("10.00", "EUR"),
+		TaxAmount:  money.MustParse("1.90", "EUR"),
+		GrandTotal: money.MustParse("12.00", "EUR"),
+	})
+
+	want := "inconsistent totals: grandTotal is EUR 12.00, want EUR 11.90"
+	if err == nil || err.Error() != want {
+		t.Errorf("Check() error = %v, want %s", err, want)
+	}
+}
+
+func TestZeroRated(t *testing.T) {
+	tests := []struct {
+		name          string
+		totals        Totals
+		wantUnitPrice string
+		wantTotal     string
+	}{
+		{
+			name: "exclusive",
+			totals: Totals{
+				UnitPrice: money.MustParse("50.00", "EUR"),
+				Quantity:  2,
+				Rates:     []Rate{{Name: "VAT", Percent: 19}},
+				Discount:  money.MustParse("10.00", "EUR"),
+			},
+			wantUnitPrice: "50.00",
+			wantTotal:     "90.00",
+		},
+		{
+			name: "inclusive prices are reduced to net",
+			totals: Totals{
+				UnitPrice: money.MustParse("119.00", "EUR"),
+				Quantity:  2,
+				Usage:     []money.Amount{money.MustParse("11.90", "EUR")},
+				Rates:     []Rate{{Name: "VAT", Percent: 19}},
+				Inclusive: true,
+				Discount:  money.MustParse("23.80", "EUR"),
+			},
+			wantUnitPrice: "100.00",
+			wantTotal:     "190.00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.totals.ZeroRated()
+			if err != nil {
+				t.Fatalf("ZeroRated() error = %v", err)
+			}
+
+			if got.Tax != 0 || len(got.Rates) != 0 || got.Inclusive || len(got.Taxes) != 0 || !got.TaxAmount.IsZero() {
+				t.Errorf("ZeroRated() charges tax: %v%% %+v %s", got.Tax, got.Taxes, got.TaxAmount)
+			}
+			if d := got.UnitPrice.Decimal(); d != tt.wantUnitPrice {
+				t.Errorf("unit price = %s, want %s", d, tt.wantUnitPrice)
+			}
+			if d := got.GrandTotal.Decimal(); d != tt.wantTotal {
+				t.Errorf("grand total = %s, want %s", d, tt.wantTotal)
+			}
+			if !got.SubTotal.Equal(got.GrandTotal) {
+				t.Errorf("sub total %s is not the grand total %s", got.SubTotal, got.GrandTotal)
+			}
+		})
+	}
+}
+
+func TestBreakdownRates(t *testing.T) {
+	res, err := Calculate(Line{Price: money.MustParse("100.00", "CAD"), Rates: gstQST})
+	if err != nil {
+		t.Fatalf("Calculate() error = %v", err)
+	}
+
+	rates := res.Taxes.Rates()
+	if len(rates) != len(gstQST) {
+		t.Fatalf("Rates() = %+v, want %+v", rates, gstQST)
+	}
+	for i := range rates {
+		if rates[i] != gstQST[i] {
+			t.Errorf("Rates()[%d] = %+v, want %+v", i, rates[i], gstQST[i])
+		}
+	}
+}
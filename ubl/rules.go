@@ -0,0 +1,202 @@
+package ubl
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	dateRe     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	currencyRe = regexp.MustCompile(`^[A-Z]{3}$`)
+	countryRe  = regexp.MustCompile(`^[A-Z]{2}$`)
+	decimalRe  = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+)
+
+// Violation is a single failed business rule
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// RuleError holds every business rule the document violates
+type RuleError struct {
+	Violations []Violation `json:"violations"`
+}
+
+// Error implements the error interface.
+func (e *RuleError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("[%s] %s", v.Rule, v.Message))
+	}
+	return "UBL invoice breaks business rules: " + strings.Join(msgs, "; ")
+}
+
+// CheckRules checks the document against a subset of the EN 16931 and Peppol
+// BIS Billing 3.0 business rules, the ones that apply to the invoices we issue.
+// It is not a Peppol validation: the XSD and the official schematron are not
+// evaluated, so a document passing it may still be rejected by an access point.
+// It returns a *RuleError listing every violated rule, or nil when none is.
+func (inv *Invoice) CheckRules() error {
+	v := &validator{}
+
+	v.check(inv.CustomizationID != "", "BR-01", "specification identifier is missing")
+	v.check(inv.ProfileID != "", "PEPPOL-EN16931-R001", "business process is missing")
+	v.check(inv.ID != "", "BR-02", "invoice number is missing")
+	v.check(dateRe.MatchString(inv.IssueDate), "BR-03", "issue date is missing or not in YYYY-MM-DD format")
+	v.check(inv.DueDate == "" || dateRe.MatchString(inv.DueDate), "PEPPOL-EN16931-F001", "due date is not in YYYY-MM-DD format")
+	v.check(inv.InvoiceTypeCode != "", "BR-04", "invoice type code is missing")
+	v.check(currencyRe.MatchString(inv.DocumentCurrencyCode), "BR-05", "document currency code is missing or not ISO 4217")
+	v.check(inv.BuyerReference != "", "PEPPOL-EN16931-R003", "buyer reference is missing")
+
+	seller := inv.AccountingSupplierParty.Party
+	v.check(seller.PartyLegalEntity.RegistrationName != "", "BR-06", "seller name is missing")
+	v.check(seller.PostalAddress.StreetName != "" || seller.PostalAddress.CityName != "", "BR-08", "seller postal address is missing")
+	v.check(countryRe.MatchString(seller.PostalAddress.Country.IdentificationCode), "BR-09", "seller country code is missing or not ISO 3166-1 alpha-2")
+	v.check(seller.EndpointID.Value != "", "PEPPOL-EN16931-R020", "seller electronic address is missing")
+
+	buyer := inv.AccountingCustomerParty.Party
+	v.check(buyer.PartyLegalEntity.RegistrationName != "", "BR-07", "buyer name is missing")
+	v.check(buyer.PostalAddress.StreetName != "" || buyer.PostalAddress.CityName != "", "BR-10", "buyer postal address is missing")
+	v.check(countryRe.MatchString(buyer.PostalAddress.Country.IdentificationCode), "BR-11", "buyer country code is missing or not ISO 3166-1 alpha-2")
+	v.check(buyer.EndpointID.Value != "", "PEPPOL-EN16931-R010", "buyer electronic address is missing")
+
+	v.check(len(inv.InvoiceLines) > 0, "BR-16", "invoice has no lines")
+
+	lineTotal := new(big.Rat)
+	for _, line := range inv.InvoiceLines {
+		lineAmount := v.amount(line.LineExtensionAmount, inv.DocumentCurrencyCode, "BR-24", "line "+line.ID+" net amount")
+		lineTotal.Add(lineTotal, lineAmount)
+
+		v.check(line.ID != "", "BR-21", "line identifier is missing")
+		qty, err := strconv.Atoi(line.InvoicedQuantity.Value)
+		v.check(err == nil && qty > 0, "BR-22", fmt.Sprintf("line %s invoiced quantity is missing or invalid", line.ID))
+		v.check(line.Item.Name != "", "BR-25", fmt.Sprintf("line %s item name is missing", line.ID))
+		unitPrice := v.amount(line.Price.PriceAmount, inv.DocumentCurrencyCode, "BR-26", "line "+line.ID+" item net price")
+		v.check(unitPrice.Sign() >= 0, "BR-27", fmt.Sprintf("line %s item net price is negative", line.ID))
+		v.check(equal(new(big.Rat).Mul(unitPrice, big.NewRat(int64(qty), 1)), lineAmount), "PEPPOL-EN16931-R120",
+			fmt.Sprintf("line %s net amount must equal quantity times item net price", line.ID))
+		v.check(line.Item.ClassifiedTaxCategory.ID != "", "BR-CO-04", fmt.Sprintf("line %s VAT category is missing", line.ID))
+	}
+
+	totals := inv.LegalMonetaryTotal
+	currency := inv.DocumentCurrencyCode
+	lineExtension := v.amount(totals.LineExtensionAmount, currency, "BR-12", "sum of invoice line net amount")
+	taxExclusive := v.amount(totals.TaxExclusiveAmount, currency, "BR-13", "invoice total amount without VAT")
+	taxInclusive := v.amount(totals.TaxInclusiveAmount, currency, "BR-14", "invoice total amount with VAT")
+	payable := v.amount(totals.PayableAmount, currency, "BR-15", "amount due for payment")
+	taxTotal := v.amount(inv.TaxTotal.TaxAmount, currency, "BR-CO-14", "invoice total VAT amount")
+
+	allowanceTotal := new(big.Rat)
+	for _, ac := range inv.AllowanceCharges {
+		if ac.ChargeIndicator {
+			v.add("BR-CL-18", "document level charges are not supported")
+			continue
+		}
+		allowanceTotal.Add(allowanceTotal, v.amount(ac.Amount, currency, "BR-31", "document level allowance amount"))
+		v.check(ac.AllowanceChargeReason != "", "BR-33", "document level allowance reason is missing")
+		v.check(ac.TaxCategory.ID != "", "BR-32", "document level allowance VAT category is missing")
+	}
+	documentAllowance := new(big.Rat)
+	if totals.AllowanceTotalAmount != nil {
+		documentAllowance = v.amount(*totals.AllowanceTotalAmount, currency, "BR-CO-11", "sum of allowances on document level")
+	}
+
+	v.check(equal(lineExtension, lineTotal), "BR-CO-10", "sum of invoice line net amount must equal the sum of the line net amounts")
+	v.check(equal(documentAllowance, allowanceTotal), "BR-CO-11", "sum of allowances on document level must equal the sum of the document level allowance amounts")
+	v.check(equal(taxExclusive, new(big.Rat).Sub(lineExtension, documentAllowance)), "BR-CO-13", "invoice total amount without VAT must equal the sum of invoice line net amount minus the sum of allowances on document level")
+	v.check(equal(taxInclusive, new(big.Rat).Add(taxExclusive, taxTotal)), "BR-CO-15", "invoice total amount with VAT must equal the total without VAT plus the total VAT")
+	v.check(equal(payable, taxInclusive), "BR-CO-16", "amount due for payment must equal the invoice total amount with VAT")
+	v.check(payable.Sign() <= 0 || inv.DueDate != "" || inv.PaymentTerms != nil, "BR-CO-25", "due date or payment terms must be present when the amount due is positive")
+
+	v.check(len(inv.TaxTotal.TaxSubtotals) > 0, "BR-CO-18", "invoice has no VAT breakdown")
+	subtotalTax := new(big.Rat)
+	for _, st := range inv.TaxTotal.TaxSubtotals {
+		taxable := v.amount(st.TaxableAmount, currency, "BR-45", "VAT category taxable amount")
+		tax := v.amount(st.TaxAmount, currency, "BR-46", "VAT category tax amount")
+		subtotalTax.Add(subtotalTax, tax)
+
+		percent, ok := parseDecimal(st.TaxCategory.Percent)
+		v.check(ok, "BR-48", "VAT category rate is missing or invalid")
+		if !ok {
+			percent = new(big.Rat)
+		}
+
+		switch st.TaxCategory.ID {
+		case TaxCategoryStandard:
+			v.check(percent.Sign() > 0, "BR-S-05", "standard rated VAT rate must be greater than zero")
+			v.check(equal(tax, new(big.Rat).Quo(new(big.Rat).Mul(taxable, percent), big.NewRat(100, 1))), "BR-S-09", "standard rated VAT amount must equal the taxable amount multiplied by the rate")
+			v.check(seller.PartyTaxScheme != nil && seller.PartyTaxScheme.CompanyID != "", "BR-S-02", "seller VAT identifier is missing for a standard rated invoice")
+		case TaxCategoryZero:
+			v.check(percent.Sign() == 0, "BR-Z-05", "zero rated VAT rate must be zero")
+			v.check(tax.Sign() == 0, "BR-Z-09", "zero rated VAT amount must be zero")
+		case TaxCategoryExempt:
+			v.check(percent.Sign() == 0, "BR-E-05", "exempt VAT rate must be zero")
+			v.check(tax.Sign() == 0, "BR-E-09", "exempt VAT amount must be zero")
+			v.check(seller.PartyTaxScheme != nil && seller.PartyTaxScheme.CompanyID != "", "BR-E-02", "seller VAT identifier is missing for an exempt invoice")
+			v.check(st.TaxCategory.TaxExemptionReason != "" || st.TaxCategory.TaxExemptionReasonCode != "", "BR-E-10", "exempt VAT breakdown has no exemption reason")
+		case TaxCategoryReverseCharge:
+			v.check(percent.Sign() == 0, "BR-AE-05", "reverse charge VAT rate must be zero")
+			v.check(tax.Sign() == 0, "BR-AE-09", "reverse charge VAT amount must be zero")
+			v.check(seller.PartyTaxScheme != nil && seller.PartyTaxScheme.CompanyID != "", "BR-AE-02", "seller VAT identifier is missing for a reverse charge invoice")
+			v.check(buyer.PartyTaxScheme != nil && buyer.PartyTaxScheme.CompanyID != "", "BR-AE-02", "buyer VAT identifier is missing for a reverse charge invoice")
+			v.check(st.TaxCategory.TaxExemptionReason != "" || st.TaxCategory.TaxExemptionReasonCode != "", "BR-AE-10", "reverse charge VAT breakdown has no exemption reason")
+		default:
+			v.add("BR-CL-18", fmt.Sprintf("unsupported VAT category code %q", st.TaxCategory.ID))
+		}
+	}
+	v.check(equal(taxTotal, subtotalTax), "BR-CO-14", "invoice total VAT amount must equal the sum of the VAT category tax amounts")
+
+	if len(v.violations) > 0 {
+		return &RuleError{Violations: v.violations}
+	}
+	return nil
+}
+
+// validator accumulates rule violations
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) add(rule, msg string) {
+	v.violations = append(v.violations, Violation{Rule: rule, Message: msg})
+}
+
+func (v *validator) check(ok bool, rule, msg string) {
+	if !ok {
+		v.add(rule, msg)
+	}
+}
+
+// amount parses the amount, reporting a violation of rule when it is missing,
+// malformed or in another currency than the document.
+func (v *validator) amount(a Amount, currency, rule, name string) *big.Rat {
+	r, ok := parseDecimal(a.Value)
+	if !ok {
+		v.add(rule, name+" is missing or invalid")
+		return new(big.Rat)
+	}
+	if a.CurrencyID != currency {
+		v.add("PEPPOL-EN16931-R051", name+" currency must match the document currency")
+	}
+	if i := strings.IndexByte(a.Value, '.'); i >= 0 && len(a.Value)-i-1 > 2 {
+		v.add("BR-DEC", name+" must not have more than two decimals")
+	}
+	return r
+}
+
+// parseDecimal parses a decimal number such as "-12.50" exactly.
+func parseDecimal(s string) (*big.Rat, bool) {
+	if !decimalRe.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// equal compares two amounts rounded half away from zero to two decimals.
+func equal(a, b *big.Rat) bool {
+	return a.FloatString(2) == b.FloatString(2)
+}
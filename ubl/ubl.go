@@ -0,0 +1,437 @@
+package ubl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+const (
+	// CustomizationID identifies the Peppol BIS Billing 3.0 specification.
+	CustomizationID = "urn:cen.eu:en16931:2017#compliant#urn:fdc:peppol.eu:2017:poacc:billing:3.0"
+	// ProfileID identifies the Peppol billing business process.
+	ProfileID = "urn:fdc:peppol.eu:2017:poacc:billing:01:1.0"
+
+	// InvoiceTypeCommercial is the UNCL1001 code for a commercial invoice.
+	InvoiceTypeCommercial = "380"
+
+	// SchemeEmail is the EAS code for an email address used as electronic address.
+	SchemeEmail = "EM"
+
+	// UnitCodeOne is the UN/ECE rec 20 code for "one" (a unit with no dimension).
+	UnitCodeOne = "C62"
+
+	// TaxCategoryStandard is the UNCL5305 code for the standard rate.
+	TaxCategoryStandard = "S"
+	// TaxCategoryZero is the UNCL5305 code for zero rated goods.
+	TaxCategoryZero = "Z"
+	// TaxCategoryExempt is the UNCL5305 code for supplies exempt from VAT.
+	TaxCategoryExempt = "E"
+	// TaxCategoryReverseCharge is the UNCL5305 code for VAT reverse charge.
+	TaxCategoryReverseCharge = "AE"
+
+	// ExemptionReverseCharge is the VATEX code for the EU reverse charge.
+	ExemptionReverseCharge = "VATEX-EU-AE"
+
+	dateLayout = "2006-01-02"
+)
+
+// Party represents the seller or the buyer of an invoice
+type Party struct {
+	Name           string
+	EndpointID     string
+	EndpointScheme string
+	CompanyID      string
+	VATID          string
+	Address        address.Address
+	Telephone      string
+	Email          string
+}
+
+// Line represents a single invoice line
+type Line struct {
+	Description string
+	Quantity    int
+	UnitPrice   money.Amount
+	Price       money.Amount
+}
+
+// InvoiceInfo represents the information used to build the UBL invoice
+type InvoiceInfo struct {
+	InvoiceNo      string
+	IssueDate      time.Time
+	DueDate        time.Time
+	BuyerReference string
+	Note           string
+	PaymentTerms   string
+	Currency       string
+	Seller         Party
+	Buyer          Party
+	Lines          []Line
+	Tax            float64
+	// ReverseCharge makes the buyer liable for the VAT, the tax must be zero
+	ReverseCharge bool
+	// ExemptionReason explains why no VAT is charged on a zero tax invoice
+	ExemptionReason string
+	// Discount is the document level allowance taken off the sum of the line
+	// amounts, the subtotal is the sum after the discount
+	Discount       money.Amount
+	DiscountReason string
+	SubTotal       money.Amount
+	TaxAmount      money.Amount
+	GrandTotal     money.Amount
+}
+
+// Amount is a monetary amount with its currency
+type Amount struct {
+	Value      string `xml:",chardata"`
+	CurrencyID string `xml:"currencyID,attr"`
+}
+
+// Identifier is an identifier with an optional identification scheme
+type Identifier struct {
+	Value    string `xml:",chardata"`
+	SchemeID string `xml:"schemeID,attr,omitempty"`
+}
+
+// Quantity is a quantity with its unit of measure
+type Quantity struct {
+	Value    string `xml:",chardata"`
+	UnitCode string `xml:"unitCode,attr"`
+}
+
+// TaxScheme identifies the tax scheme, always VAT for Peppol BIS
+type TaxScheme struct {
+	ID string `xml:"cbc:ID"`
+}
+
+// TaxCategory represents a VAT category with its rate
+type TaxCategory struct {
+	ID                     string    `xml:"cbc:ID"`
+	Percent                string    `xml:"cbc:Percent"`
+	TaxExemptionReasonCode string    `xml:"cbc:TaxExemptionReasonCode,omitempty"`
+	TaxExemptionReason     string    `xml:"cbc:TaxExemptionReason,omitempty"`
+	TaxScheme              TaxScheme `xml:"cac:TaxScheme"`
+}
+
+// Country holds the ISO 3166-1 alpha-2 country code
+type Country struct {
+	IdentificationCode string `xml:"cbc:IdentificationCode"`
+}
+
+// PostalAddress represents the UBL postal address aggregate
+type PostalAddress struct {
+	StreetName           string  `xml:"cbc:StreetName,omitempty"`
+	AdditionalStreetName string  `xml:"cbc:AdditionalStreetName,omitempty"`
+	CityName             string  `xml:"cbc:CityName,omitempty"`
+	PostalZone           string  `xml:"cbc:PostalZone,omitempty"`
+	CountrySubentity     string  `xml:"cbc:CountrySubentity,omitempty"`
+	Country              Country `xml:"cac:Country"`
+}
+
+// PartyName holds the trading name of a party
+type PartyName struct {
+	Name string `xml:"cbc:Name"`
+}
+
+// PartyTaxScheme holds the VAT identifier of a party
+type PartyTaxScheme struct {
+	CompanyID string    `xml:"cbc:CompanyID"`
+	TaxScheme TaxScheme `xml:"cac:TaxScheme"`
+}
+
+// PartyLegalEntity holds the legal registration of a party
+type PartyLegalEntity struct {
+	RegistrationName string `xml:"cbc:RegistrationName"`
+	CompanyID        string `xml:"cbc:CompanyID,omitempty"`
+}
+
+// Contact holds the contact details of a party
+type Contact struct {
+	Telephone      string `xml:"cbc:Telephone,omitempty"`
+	ElectronicMail string `xml:"cbc:ElectronicMail,omitempty"`
+}
+
+// PartyDetail represents the UBL party aggregate
+type PartyDetail struct {
+	EndpointID       Identifier       `xml:"cbc:EndpointID"`
+	PartyName        *PartyName       `xml:"cac:PartyName,omitempty"`
+	PostalAddress    PostalAddress    `xml:"cac:PostalAddress"`
+	PartyTaxScheme   *PartyTaxScheme  `xml:"cac:PartyTaxScheme,omitempty"`
+	PartyLegalEntity PartyLegalEntity `xml:"cac:PartyLegalEntity"`
+	Contact          *Contact         `xml:"cac:Contact,omitempty"`
+}
+
+// PartyWrapper wraps a party the way UBL supplier and customer parties do
+type PartyWrapper struct {
+	Party PartyDetail `xml:"cac:Party"`
+}
+
+// PaymentTerms holds the free text payment terms
+type PaymentTerms struct {
+	Note string `xml:"cbc:Note"`
+}
+
+// TaxSubtotal represents the VAT breakdown for a single category
+type TaxSubtotal struct {
+	TaxableAmount Amount      `xml:"cbc:TaxableAmount"`
+	TaxAmount     Amount      `xml:"cbc:TaxAmount"`
+	TaxCategory   TaxCategory `xml:"cac:TaxCategory"`
+}
+
+// TaxTotal represents the total VAT of the invoice
+type TaxTotal struct {
+	TaxAmount    Amount        `xml:"cbc:TaxAmount"`
+	TaxSubtotals []TaxSubtotal `xml:"cac:TaxSubtotal"`
+}
+
+// AllowanceCharge represents a document level allowance or charge
+type AllowanceCharge struct {
+	ChargeIndicator       bool        `xml:"cbc:ChargeIndicator"`
+	AllowanceChargeReason string      `xml:"cbc:AllowanceChargeReason,omitempty"`
+	Amount                Amount      `xml:"cbc:Amount"`
+	TaxCategory           TaxCategory `xml:"cac:TaxCategory"`
+}
+
+// MonetaryTotal represents the document level totals
+type MonetaryTotal struct {
+	LineExtensionAmount  Amount  `xml:"cbc:LineExtensionAmount"`
+	TaxExclusiveAmount   Amount  `xml:"cbc:TaxExclusiveAmount"`
+	TaxInclusiveAmount   Amount  `xml:"cbc:TaxInclusiveAmount"`
+	AllowanceTotalAmount *Amount `xml:"cbc:AllowanceTotalAmount,omitempty"`
+	PayableAmount        Amount  `xml:"cbc:PayableAmount"`
+}
+
+// Item describes the invoiced item of a line
+type Item struct {
+	Name                  string      `xml:"cbc:Name"`
+	ClassifiedTaxCategory TaxCategory `xml:"cac:ClassifiedTaxCategory"`
+}
+
+// Price holds the net unit price of a line
+type Price struct {
+	PriceAmount Amount `xml:"cbc:PriceAmount"`
+}
+
+// InvoiceLine represents the UBL invoice line aggregate
+type InvoiceLine struct {
+	ID                  string   `xml:"cbc:ID"`
+	InvoicedQuantity    Quantity `xml:"cbc:InvoicedQuantity"`
+	LineExtensionAmount Amount   `xml:"cbc:LineExtensionAmount"`
+	Item                Item     `xml:"cac:Item"`
+	Price               Price    `xml:"cac:Price"`
+}
+
+// Invoice is the UBL 2.1 invoice document. Field order follows the UBL schema.
+type Invoice struct {
+	XMLName                 xml.Name          `xml:"Invoice"`
+	Xmlns                   string            `xml:"xmlns,attr"`
+	XmlnsCac                string            `xml:"xmlns:cac,attr"`
+	XmlnsCbc                string            `xml:"xmlns:cbc,attr"`
+	CustomizationID         string            `xml:"cbc:CustomizationID"`
+	ProfileID               string            `xml:"cbc:ProfileID"`
+	ID                      string            `xml:"cbc:ID"`
+	IssueDate               string            `xml:"cbc:IssueDate"`
+	DueDate                 string            `xml:"cbc:DueDate,omitempty"`
+	InvoiceTypeCode         string            `xml:"cbc:InvoiceTypeCode"`
+	Note                    string            `xml:"cbc:Note,omitempty"`
+	DocumentCurrencyCode    string            `xml:"cbc:DocumentCurrencyCode"`
+	BuyerReference          string            `xml:"cbc:BuyerReference,omitempty"`
+	AccountingSupplierParty PartyWrapper      `xml:"cac:AccountingSupplierParty"`
+	AccountingCustomerParty PartyWrapper      `xml:"cac:AccountingCustomerParty"`
+	PaymentTerms            *PaymentTerms     `xml:"cac:PaymentTerms,omitempty"`
+	AllowanceCharges        []AllowanceCharge `xml:"cac:AllowanceCharge"`
+	TaxTotal                TaxTotal          `xml:"cac:TaxTotal"`
+	LegalMonetaryTotal      MonetaryTotal     `xml:"cac:LegalMonetaryTotal"`
+	InvoiceLines            []InvoiceLine     `xml:"cac:InvoiceLine"`
+}
+
+// New maps the invoice information to a UBL invoice document.
+func New(info InvoiceInfo) *Invoice {
+	taxCategory := taxCategory(info)
+	// the exemption reason is only given in the VAT breakdown
+	lineTaxCategory := TaxCategory{ID: taxCategory.ID, Percent: taxCategory.Percent, TaxScheme: taxCategory.TaxScheme}
+
+	inv := &Invoice{
+		Xmlns:                   "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2",
+		XmlnsCac:                "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2",
+		XmlnsCbc:                "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2",
+		CustomizationID:         CustomizationID,
+		ProfileID:               ProfileID,
+		ID:                      info.InvoiceNo,
+		IssueDate:               formatDate(info.IssueDate),
+		DueDate:                 formatDate(info.DueDate),
+		InvoiceTypeCode:         InvoiceTypeCommercial,
+		Note:                    info.Note,
+		DocumentCurrencyCode:    info.Currency,
+		BuyerReference:          info.BuyerReference,
+		AccountingSupplierParty: PartyWrapper{Party: newParty(info.Seller)},
+		AccountingCustomerParty: PartyWrapper{Party: newParty(info.Buyer)},
+		TaxTotal: TaxTotal{
+			TaxAmount: newAmount(info.TaxAmount),
+			TaxSubtotals: []TaxSubtotal{
+				{
+					TaxableAmount: newAmount(info.SubTotal),
+					TaxAmount:     newAmount(info.TaxAmount),
+					TaxCategory:   taxCategory,
+				},
+			},
+		},
+		LegalMonetaryTotal: MonetaryTotal{
+			LineExtensionAmount: newAmount(info.SubTotal),
+			TaxExclusiveAmount:  newAmount(info.SubTotal),
+			TaxInclusiveAmount:  newAmount(info.GrandTotal),
+			PayableAmount:       newAmount(info.GrandTotal),
+		},
+	}
+
+	if info.PaymentTerms != "" {
+		inv.PaymentTerms = &PaymentTerms{Note: info.PaymentTerms}
+	}
+
+	if !info.Discount.IsZero() {
+		reason := info.DiscountReason
+		if reason == "" {
+			reason = "Discount"
+		}
+		inv.AllowanceCharges = append(inv.AllowanceCharges, AllowanceCharge{
+			ChargeIndicator:       false,
+			AllowanceChargeReason: reason,
+			Amount:                newAmount(info.Discount),
+			TaxCategory:           lineTaxCategory,
+		})
+
+		// the line amounts are the amounts before the discount
+		lineExtension, err := info.SubTotal.Add(info.Discount)
+		if err == nil {
+			inv.LegalMonetaryTotal.LineExtensionAmount = newAmount(lineExtension)
+		}
+		allowance := newAmount(info.Discount)
+		inv.LegalMonetaryTotal.AllowanceTotalAmount = &allowance
+	}
+
+	for i, line := range info.Lines {
+		inv.InvoiceLines = append(inv.InvoiceLines, InvoiceLine{
+			ID:                  strconv.Itoa(i + 1),
+			InvoicedQuantity:    Quantity{Value: strconv.Itoa(line.Quantity), UnitCode: UnitCodeOne},
+			LineExtensionAmount: newAmount(line.Price),
+			Item: Item{
+				Name:                  line.Description,
+				ClassifiedTaxCategory: lineTaxCategory,
+			},
+			Price: Price{PriceAmount: newAmount(line.UnitPrice)},
+		})
+	}
+
+	return inv
+}
+
+// Encode writes the invoice as an indented XML document to w.
+func (inv *Invoice) Encode(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(inv); err != nil {
+		return fmt.Errorf("error encoding UBL invoice: %v", err)
+	}
+	return enc.Flush()
+}
+
+// newParty maps a party to the UBL party aggregate.
+func newParty(p Party) PartyDetail {
+	scheme := p.EndpointScheme
+	if scheme == "" {
+		scheme = SchemeEmail
+	}
+
+	party := PartyDetail{
+		EndpointID:    Identifier{Value: p.EndpointID, SchemeID: scheme},
+		PostalAddress: newPostalAddress(p.Address),
+		PartyLegalEntity: PartyLegalEntity{
+			RegistrationName: p.Name,
+			CompanyID:        p.CompanyID,
+		},
+	}
+
+	if p.Name != "" {
+		party.PartyName = &PartyName{Name: p.Name}
+	}
+
+	if p.VATID != "" {
+		party.PartyTaxScheme = &PartyTaxScheme{CompanyID: p.VATID, TaxScheme: TaxScheme{ID: "VAT"}}
+	}
+
+	if p.Telephone != "" || p.Email != "" {
+		party.Contact = &Contact{Telephone: p.Telephone, ElectronicMail: p.Email}
+	}
+
+	return party
+}
+
+// newPostalAddress maps the address to the UBL postal address, the first line is
+// the street name and the remaining lines the additional street name.
+func newPostalAddress(a address.Address) PostalAddress {
+	pa := PostalAddress{
+		CityName:         a.City,
+		PostalZone:       a.PostalCode,
+		CountrySubentity: a.Region,
+		Country:          Country{IdentificationCode: a.Country},
+	}
+
+	lines := address.SplitLines(a.JoinLines())
+	if len(lines) > 0 {
+		pa.StreetName = lines[0]
+	}
+	if len(lines) > 1 {
+		pa.AdditionalStreetName = strings.Join(lines[1:], ", ")
+	}
+
+	return pa
+}
+
+// taxCategory returns the VAT category of the invoice.
+func taxCategory(info InvoiceInfo) TaxCategory {
+	c := TaxCategory{ID: TaxCategoryStandard, Percent: strconv.FormatFloat(info.Tax, 'f', -1, 64), TaxScheme: TaxScheme{ID: "VAT"}}
+	switch {
+	case info.ReverseCharge:
+		c.ID = TaxCategoryReverseCharge
+		c.TaxExemptionReasonCode = ExemptionReverseCharge
+		c.TaxExemptionReason = info.ExemptionReason
+		if c.TaxExemptionReason == "" {
+			c.TaxExemptionReason = "Reverse charge"
+		}
+	case info.Tax == 0 && info.ExemptionReason != "":
+		c.ID = TaxCategoryExempt
+		c.TaxExemptionReason = info.ExemptionReason
+	case info.Tax == 0:
+		c.ID = TaxCategoryZero
+	}
+	return c
+}
+
+// newAmount formats the amount with at most two decimals as required by Peppol BIS,
+// amounts of currencies with more minor units are rounded half away from zero.
+func newAmount(a money.Amount) Amount {
+	units, _ := money.MinorUnits(a.Currency())
+	if units > 2 {
+		return Amount{Value: a.Rat().FloatString(2), CurrencyID: a.Currency()}
+	}
+	return Amount{Value: a.Decimal(), CurrencyID: a.Currency()}
+}
+
+// formatDate formats the date as YYYY-MM-DD, a zero date yields an empty string.
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(dateLayout)
+}
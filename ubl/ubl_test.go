@@ -0,0 +1,342 @@
+package ubl
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arifmahmudrana/invoice/address"
+	"github.com/arifmahmudrana/invoice/money"
+)
+
+// invoiceInfo is a standard rated invoice of two units of 50.00 EUR at 19% VAT
+func invoiceInfo() InvoiceInfo {
+	return InvoiceInfo{
+		InvoiceNo:      "INV:1:CUST001:PROD001:1",
+		IssueDate:      time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC),
+		DueDate:        time.Date(2024, 4, 17, 0, 0, 0, 0, time.UTC),
+		BuyerReference: "PO-4711",
+		PaymentTerms:   "Net 30 days",
+		Currency:       "EUR",
+		Seller: Party{
+			Name:       "Example GmbH",
+			EndpointID: "billing@example.de",
+			VATID:      "DE123456789",
+			Address:    address.Address{Lines: []string{"Hauptstraße 1", "2. OG"}, City: "Berlin", PostalCode: "10115", Country: "DE"},
+		},
+		Buyer: Party{
+			Name:       "Client BV",
+			EndpointID: "ap@client.nl",
+			VATID:      "NL123456789B01",
+			Address:    address.Address{Lines: []string{"Damrak 1"}, City: "Amsterdam", PostalCode: "1012 LG", Country: "NL"},
+		},
+		Lines: []Line{{
+			Description: "Pro plan",
+			Quantity:    2,
+			UnitPrice:   money.MustParse("50.00", "EUR"),
+			Price:       money.MustParse("100.00", "EUR"),
+		}},
+		Tax:        19,
+		SubTotal:   money.MustParse("100.00", "EUR"),
+		TaxAmount:  money.MustParse("19.00", "EUR"),
+		GrandTotal: money.MustParse("119.00", "EUR"),
+	}
+}
+
+func TestNew(t *testing.T) {
+	inv := New(invoiceInfo())
+
+	if inv.CustomizationID != CustomizationID || inv.ProfileID != ProfileID || inv.InvoiceTypeCode != InvoiceTypeCommercial {
+		t.Errorf("specification = %s %s %s", inv.CustomizationID, inv.ProfileID, inv.InvoiceTypeCode)
+	}
+	if inv.IssueDate != "2024-03-18" || inv.DueDate != "2024-04-17" {
+		t.Errorf("dates = %s %s, want 2024-03-18 2024-04-17", inv.IssueDate, inv.DueDate)
+	}
+
+	seller := inv.AccountingSupplierParty.Party
+	if seller.EndpointID != (Identifier{Value: "billing@example.de", SchemeID: SchemeEmail}) {
+		t.Errorf("seller endpoint = %+v", seller.EndpointID)
+	}
+	if seller.PostalAddress.StreetName != "Hauptstraße 1" || seller.PostalAddress.AdditionalStreetName != "2. OG" {
+		t.Errorf("seller street = %q %q", seller.PostalAddress.StreetName, seller.PostalAddress.AdditionalStreetName)
+	}
+	if seller.PartyTaxScheme == nil || seller.PartyTaxScheme.CompanyID != "DE123456789" {
+		t.Errorf("seller VAT ID = %+v", seller.PartyTaxScheme)
+	}
+	if seller.Contact != nil {
+		t.Errorf("seller contact = %+v, want none", seller.Contact)
+	}
+
+	if len(inv.InvoiceLines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(inv.InvoiceLines))
+	}
+	line := inv.InvoiceLines[0]
+	if line.ID != "1" || line.InvoicedQuantity != (Quantity{Value: "2", UnitCode: UnitCodeOne}) || line.LineExtensionAmount != (Amount{Value: "100.00", CurrencyID: "EUR"}) {
+		t.Errorf("line = %+v", line)
+	}
+	if c := line.Item.ClassifiedTaxCategory; c.ID != TaxCategoryStandard || c.Percent != "19" {
+		t.Errorf("line VAT category = %+v", c)
+	}
+
+	if got := inv.LegalMonetaryTotal.PayableAmount.Value; got != "119.00" {
+		t.Errorf("payable = %s, want 119.00", got)
+	}
+	if inv.LegalMonetaryTotal.AllowanceTotalAmount != nil || len(inv.AllowanceCharges) != 0 {
+		t.Error("invoice without a discount has allowances")
+	}
+}
+
+func TestNewTaxCategory(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(info *InvoiceInfo)
+		wantID     string
+		wantCode   string
+		wantReason string
+	}{
+		{"standard", func(info *InvoiceInfo) {}, TaxCategoryStandard, "", ""},
+		{"zero", func(info *InvoiceInfo) { info.Tax = 0 }, TaxCategoryZero, "", ""},
+		{"exempt", func(info *InvoiceInfo) { info.Tax, info.ExemptionReason = 0, "Small business" }, TaxCategoryExempt, "", "Small business"},
+		{"reverse charge", func(info *InvoiceInfo) { info.Tax, info.ReverseCharge = 0, true }, TaxCategoryReverseCharge, ExemptionReverseCharge, "Reverse charge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := invoiceInfo()
+			tt.change(&info)
+			inv := New(info)
+
+			c := inv.TaxTotal.TaxSubtotals[0].TaxCategory
+			if c.ID != tt.wantID || c.TaxExemptionReasonCode != tt.wantCode || c.TaxExemptionReason != tt.wantReason {
+				t.Errorf("VAT category = %+v, want %s %q %q", c, tt.wantID, tt.wantCode, tt.wantReason)
+			}
+			// the exemption reason is only given in the VAT breakdown
+			if l := inv.InvoiceLines[0].Item.ClassifiedTaxCategory; l.ID != tt.wantID || l.TaxExemptionReason != "" {
+				t.Errorf("line VAT category = %+v", l)
+			}
+		})
+	}
+}
+
+func TestNewDiscount(t *testing.T) {
+	info := invoiceInfo()
+	info.Discount = money.MustParse("10.00", "EUR")
+	info.SubTotal = money.MustParse("90.00", "EUR")
+	info.TaxAmount = money.MustParse("17.10", "EUR")
+	info.GrandTotal = money.MustParse("107.10", "EUR")
+	inv := New(info)
+
+	if len(inv.AllowanceCharges) != 1 {
+		t.Fatalf("got %d allowances, want 1", len(inv.AllowanceCharges))
+	}
+	if ac := inv.AllowanceCharges[0]; ac.ChargeIndicator || ac.AllowanceChargeReason != "Discount" || ac.Amount.Value != "10.00" {
+		t.Errorf("allowance = %+v", ac)
+	}
+	totals := inv.LegalMonetaryTotal
+	if totals.LineExtensionAmount.Value != "100.00" || totals.TaxExclusiveAmount.Value != "90.00" || totals.AllowanceTotalAmount == nil || totals.AllowanceTotalAmount.Value != "10.00" {
+		t.Errorf("totals = %+v", totals)
+	}
+	if err := inv.CheckRules(); err != nil {
+		t.Errorf("CheckRules() error = %v", err)
+	}
+}
+
+func TestNewAmountDecimals(t *testing.T) {
+	tests := []struct {
+		amount money.Amount
+		want   string
+	}{
+		{money.MustParse("10.5", "EUR"), "10.50"},
+		{money.MustParse("1005", "JPY"), "1005"},
+		// Peppol BIS allows two decimals, amounts with more are rounded
+		{money.MustParse("1.235", "BHD"), "1.24"},
+		{money.MustParse("1.234", "BHD"), "1.23"},
+	}
+
+	for _, tt := range tests {
+		if got := newAmount(tt.amount); got.Value != tt.want || got.CurrencyID != tt.amount.Currency() {
+			t.Errorf("newAmount(%s) = %+v, want %s", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	valid := []struct {
+		name   string
+		change func(info *InvoiceInfo)
+	}{
+		{"standard", func(info *InvoiceInfo) {}},
+		{"reverse charge", func(info *InvoiceInfo) {
+			info.Tax, info.ReverseCharge = 0, true
+			info.TaxAmount, info.GrandTotal = money.MustParse("0.00", "EUR"), money.MustParse("100.00", "EUR")
+		}},
+		{"exempt", func(info *InvoiceInfo) {
+			info.Tax, info.ExemptionReason = 0, "Small business"
+			info.TaxAmount, info.GrandTotal = money.MustParse("0.00", "EUR"), money.MustParse("100.00", "EUR")
+		}},
+		{"payment terms without due date", func(info *InvoiceInfo) { info.DueDate = time.Time{} }},
+	}
+
+	for _, tt := range valid {
+		t.Run(tt.name, func(t *testing.T) {
+			info := invoiceInfo()
+			tt.change(&info)
+			if err := New(info).CheckRules(); err != nil {
+				t.Errorf("CheckRules() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckRulesViolations(t *testing.T) {
+	tests := []struct {
+		rule   string
+		change func(inv *Invoice)
+	}{
+		{"BR-01", func(inv *Invoice) { inv.CustomizationID = "" }},
+		{"PEPPOL-EN16931-R001", func(inv *Invoice) { inv.ProfileID = "" }},
+		{"BR-02", func(inv *Invoice) { inv.ID = "" }},
+		{"BR-03", func(inv *Invoice) { inv.IssueDate = "18.03.2024" }},
+		{"PEPPOL-EN16931-F001", func(inv *Invoice) { inv.DueDate = "17.04.2024" }},
+		{"BR-04", func(inv *Invoice) { inv.InvoiceTypeCode = "" }},
+		{"BR-05", func(inv *Invoice) { inv.DocumentCurrencyCode = "euro" }},
+		{"PEPPOL-EN16931-R003", func(inv *Invoice) { inv.BuyerReference = "" }},
+		{"BR-06", func(inv *Invoice) { inv.AccountingSupplierParty.Party.PartyLegalEntity.RegistrationName = "" }},
+		{"BR-08", func(inv *Invoice) {
+			inv.AccountingSupplierParty.Party.PostalAddress = PostalAddress{Country: Country{"DE"}}
+		}},
+		{"BR-09", func(inv *Invoice) { inv.AccountingSupplierParty.Party.PostalAddress.Country.IdentificationCode = "DEU" }},
+		{"PEPPOL-EN16931-R020", func(inv *Invoice) { inv.AccountingSupplierParty.Party.EndpointID.Value = "" }},
+		{"BR-07", func(inv *Invoice) { inv.AccountingCustomerParty.Party.PartyLegalEntity.RegistrationName = "" }},
+		{"BR-10", func(inv *Invoice) {
+			inv.AccountingCustomerParty.Party.PostalAddress = PostalAddress{Country: Country{"NL"}}
+		}},
+		{"BR-11", func(inv *Invoice) { inv.AccountingCustomerParty.Party.PostalAddress.Country.IdentificationCode = "" }},
+		{"PEPPOL-EN16931-R010", func(inv *Invoice) { inv.AccountingCustomerParty.Party.EndpointID.Value = "" }},
+		{"BR-16", func(inv *Invoice) { inv.InvoiceLines = nil }},
+		{"BR-21", func(inv *Invoice) { inv.InvoiceLines[0].ID = "" }},
+		{"BR-22", func(inv *Invoice) { inv.InvoiceLines[0].InvoicedQuantity.Value = "0" }},
+		{"BR-24", func(inv *Invoice) { inv.InvoiceLines[0].LineExtensionAmount.Value = "" }},
+		{"BR-25", func(inv *Invoice) { inv.InvoiceLines[0].Item.Name = "" }},
+		{"BR-26", func(inv *Invoice) { inv.InvoiceLines[0].Price.PriceAmount.Value = "fifty" }},
+		{"BR-27", func(inv *Invoice) { inv.InvoiceLines[0].Price.PriceAmount.Value = "-50.00" }},
+		{"PEPPOL-EN16931-R120", func(inv *Invoice) { inv.InvoiceLines[0].Price.PriceAmount.Value = "49.00" }},
+		{"BR-CO-04", func(inv *Invoice) { inv.InvoiceLines[0].Item.ClassifiedTaxCategory.ID = "" }},
+		{"BR-CO-10", func(inv *Invoice) { inv.LegalMonetaryTotal.LineExtensionAmount.Value = "99.00" }},
+		{"BR-CO-11", func(inv *Invoice) {
+			inv.LegalMonetaryTotal.AllowanceTotalAmount = &Amount{Value: "1.00", CurrencyID: "EUR"}
+		}},
+		{"BR-CO-13", func(inv *Invoice) { inv.LegalMonetaryTotal.TaxExclusiveAmount.Value = "99.00" }},
+		{"BR-CO-15", func(inv *Invoice) { inv.LegalMonetaryTotal.TaxInclusiveAmount.Value = "118.00" }},
+		{"BR-CO-16", func(inv *Invoice) { inv.LegalMonetaryTotal.PayableAmount.Value = "118.00" }},
+		{"BR-CO-25", func(inv *Invoice) { inv.DueDate, inv.PaymentTerms = "", nil }},
+		{"BR-CO-18", func(inv *Invoice) { inv.TaxTotal.TaxSubtotals = nil }},
+		{"BR-CO-14", func(inv *Invoice) { inv.TaxTotal.TaxAmount.Value = "18.00" }},
+		{"BR-48", func(inv *Invoice) { inv.TaxTotal.TaxSubtotals[0].TaxCategory.Percent = "" }},
+		{"BR-S-05", func(inv *Invoice) { inv.TaxTotal.TaxSubtotals[0].TaxCategory.Percent = "0" }},
+		{"BR-S-09", func(inv *Invoice) { inv.TaxTotal.TaxSubtotals[0].TaxAmount.Value = "18.00" }},
+		{"BR-S-02", func(inv *Invoice) { inv.AccountingSupplierParty.Party.PartyTaxScheme = nil }},
+		{"BR-Z-09", func(inv *Invoice) {
+			inv.TaxTotal.TaxSubtotals[0].TaxCategory.ID, inv.TaxTotal.TaxSubtotals[0].TaxCategory.Percent = TaxCategoryZero, "0"
+		}},
+		{"BR-E-10", func(inv *Invoice) {
+			inv.TaxTotal.TaxSubtotals[0].TaxCategory.ID, inv.TaxTotal.TaxSubtotals[0].TaxCategory.Percent = TaxCategoryExempt, "0"
+		}},
+		{"BR-AE-02", func(inv *Invoice) {
+			inv.TaxTotal.TaxSubtotals[0].TaxCategory.ID, inv.TaxTotal.TaxSubtotals[0].TaxCategory.Percent = TaxCategoryReverseCharge, "0"
+			inv.AccountingCustomerParty.Party.PartyTaxScheme = nil
+		}},
+		{"BR-AE-10", func(inv *Invoice) {
+			inv.TaxTotal.TaxSubtotals[0].TaxCategory.ID, inv.TaxTotal.TaxSubtotals[0].TaxCategory.Percent = TaxCategoryReverseCharge, "0"
+		}},
+		{"BR-CL-18", func(inv *Invoice) { inv.TaxTotal.TaxSubtotals[0].TaxCategory.ID = "K" }},
+		{"BR-DEC", func(inv *Invoice) { inv.LegalMonetaryTotal.PayableAmount.Value = "119.000" }},
+		{"PEPPOL-EN16931-R051", func(inv *Invoice) { inv.LegalMonetaryTotal.PayableAmount.CurrencyID = "USD" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			inv := New(invoiceInfo())
+			tt.change(inv)
+
+			err := inv.CheckRules()
+			var rErr *RuleError
+			if !errors.As(err, &rErr) {
+				t.Fatalf("CheckRules() error = %v, want a *RuleError", err)
+			}
+			for _, v := range rErr.Violations {
+				if v.Rule == tt.rule {
+					return
+				}
+			}
+			t.Errorf("CheckRules() = %v, want a violation of %s", err, tt.rule)
+		})
+	}
+}
+
+func TestRuleError(t *testing.T) {
+	err := &RuleError{Violations: []Violation{
+		{Rule: "BR-02", Message: "invoice number is missing"},
+		{Rule: "BR-16", Message: "invoice has no lines"},
+	}}
+
+	want := "UBL invoice breaks business rules: [BR-02] invoice number is missing; [BR-16] invoice has no lines"
+	if err.Error() != want {
+		t.Errorf("Error() = %s, want %s", err.Error(), want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	var b bytes.Buffer
+	if err := New(invoiceInfo()).Encode(&b); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	out := b.String()
+
+	if !strings.HasPrefix(out, `<?xml version="1.0" encoding="UTF-8"?>`) {
+		t.Errorf("document does not start with the XML header: %.60s", out)
+	}
+	for _, want := range []string{
+		`<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2" xmlns:cac="urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2" xmlns:cbc="urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2">`,
+		`<cbc:EndpointID schemeID="EM">billing@example.de</cbc:EndpointID>`,
+		`<cbc:InvoicedQuantity unitCode="C62">2</cbc:InvoicedQuantity>`,
+		`<cbc:PayableAmount currencyID="EUR">119.00</cbc:PayableAmount>`,
+		`<cbc:StreetName>Hauptstraße 1</cbc:StreetName>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("document does not contain %s", want)
+		}
+	}
+
+	// the elements follow the order of the UBL schema
+	order := []string{
+		"<cbc:CustomizationID>", "<cbc:ProfileID>", "<cbc:ID>", "<cbc:IssueDate>", "<cbc:DueDate>",
+		"<cbc:InvoiceTypeCode>", "<cbc:DocumentCurrencyCode>", "<cbc:BuyerReference>",
+		"<cac:AccountingSupplierParty>", "<cac:AccountingCustomerParty>", "<cac:PaymentTerms>",
+		"<cac:TaxTotal>", "<cac:LegalMonetaryTotal>", "<cac:InvoiceLine>",
+	}
+	last := -1
+	for _, el := range order {
+		i := strings.Index(out, el)
+		if i < 0 {
+			t.Errorf("document has no %s", el)
+			continue
+		}
+		if i < last {
+			t.Errorf("%s is out of order", el)
+		}
+		last = i
+	}
+
+	// empty optional elements are left out, the only note is the one of the payment terms
+	if n := strings.Count(out, "<cbc:Note>"); n != 1 {
+		t.Errorf("document has %d notes, want 1", n)
+	}
+	for _, absent := range []string{"<cac:AllowanceCharge>", "<cac:Contact>", "<cbc:TaxExemptionReason>"} {
+		if strings.Contains(out, absent) {
+			t.Errorf("document contains %s", absent)
+		}
+	}
+}